@@ -8,9 +8,12 @@ package acquisition
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,35 +22,105 @@ import (
 	"github.com/google/uuid"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/assets"
+	"github.com/mvt-project/androidqf/config"
+	"github.com/mvt-project/androidqf/indicators"
 	"github.com/mvt-project/androidqf/log"
 	"github.com/mvt-project/androidqf/utils"
 )
 
 // Acquisition is the main object containing all phone information
 type Acquisition struct {
-	UUID             string         `json:"uuid"`
-	AndroidQFVersion string         `json:"androidqf_version"`
-	StoragePath      string         `json:"storage_path"`
-	Started          time.Time      `json:"started"`
-	Completed        time.Time      `json:"completed"`
-	Collector        *adb.Collector `json:"collector"`
-	TmpDir           string         `json:"tmp_dir"`
-	SdCard           string         `json:"sdcard"`
-	Cpu              string         `json:"cpu"`
+	UUID             string                     `json:"uuid"`
+	AndroidQFVersion string                     `json:"androidqf_version"`
+	Tooling          *Tooling                   `json:"tooling"`
+	StoragePath      string                     `json:"storage_path"`
+	Started          time.Time                  `json:"started"`
+	Completed        time.Time                  `json:"completed"`
+	Collector        *adb.Collector             `json:"collector"`
+	TmpDir           string                     `json:"tmp_dir"`
+	TmpDirExecutable bool                       `json:"tmp_dir_executable"`
+	SdCard           string                     `json:"sdcard"`
+	Device           *DeviceInfo                `json:"device"`
+	Clock            *DeviceClock               `json:"clock"`
+	Capabilities     *adb.Capabilities          `json:"capabilities"`
+	Emulator         *adb.EmulatorInfo          `json:"emulator"`
+	Cleanup          *adb.CleanupReport         `json:"cleanup"`
+	Power            *adb.PowerState            `json:"power"`
+	Reboots          []RebootRecord             `json:"reboots,omitempty"`
+	Budget           *TimeBudget                `json:"time_budget,omitempty"`
+	AdbKey           *adb.Key                   `json:"adb_key,omitempty"`
+	StaleFiles       *adb.StaleFiles            `json:"stale_files"`
+	SystemBaseline   string                     `json:"system_baseline"`
+	PackageBaseline  string                     `json:"package_baseline"`
+	RecheckBaseline  string                     `json:"recheck_baseline"`
+	RecheckDays      int                        `json:"recheck_days"`
+	Profile          string                     `json:"profile"`
+	HashOnly         bool                       `json:"hash_only"`
+	Policy           *Policy                    `json:"policy,omitempty"`
+	Consents         []ConsentDecision          `json:"consents"`
+	CaseID           string                     `json:"case_id,omitempty"`
+	Anonymized       bool                       `json:"anonymized"`
+	Config           *config.Config             `json:"-"`
+	Indicators       []indicators.IndicatorFile `json:"indicators"`
+	IOCs             []indicators.Indicator     `json:"-"`
+
+	detections   []Detection
+	explanations map[string]Explanation
+	checkpoint   Checkpoint
+	sink         *encryptedSink
+	encFilePath  string
+	caseFolder   string
+	caseEntry    CaseAcquisition
+	metrics      *Metrics
+}
+
+// Options configures a new acquisition.
+type Options struct {
+	// Folder in which the acquisition is stored. By default, a folder named
+	// after the acquisition UUID is created next to the executable, or in
+	// the case folder.
+	Path string
+	// Temporary folder preferred on the device.
+	DeviceTmp string
+	// Encrypt all outputs as they are written.
+	EncryptAtWrite bool
+	// Case which the acquisition belongs to.
+	Case *Case
+	// Replace device and account identifiers with pseudonyms derived from
+	// the salt stored at SaltPath (by default salt.txt next to the
+	// executable).
+	Anonymize bool
+	SaltPath  string
+	// Resume the interrupted acquisition stored in Path, skipping the
+	// modules recorded as completed in its checkpoint.
+	Resume bool
+	// Do not upload the collector, and use shell commands instead.
+	NoCollector bool
+	// Keep the device awake while connected over USB, restoring the
+	// setting at the end of the acquisition.
+	KeepAwake bool
 }
 
 // New returns a new Acquisition instance.
-func New(path string) (*Acquisition, error) {
+func New(opts Options) (*Acquisition, error) {
 	acq := Acquisition{
 		UUID:             uuid.New().String(),
 		Started:          time.Now().UTC(),
 		AndroidQFVersion: utils.Version,
+		Tooling:          getTooling(),
+		Power:            &adb.PowerState{},
+	}
+	if opts.Case != nil {
+		acq.CaseID = opts.Case.ID
+		acq.caseFolder = opts.Case.Path
 	}
 
-	if path == "" {
-		acq.StoragePath = filepath.Join(rt.GetExecutableDirectory(), acq.UUID)
+	if opts.Path != "" {
+		acq.StoragePath = opts.Path
+	} else if opts.Case != nil {
+		acq.StoragePath = filepath.Join(opts.Case.Path, acq.UUID)
 	} else {
-		acq.StoragePath = path
+		acq.StoragePath = filepath.Join(rt.GetExecutableDirectory(), acq.UUID)
 	}
 	// Check if the path exist
 	stat, err := os.Stat(acq.StoragePath)
@@ -61,23 +134,109 @@ func New(path string) (*Acquisition, error) {
 			return nil, fmt.Errorf("path exist and is not a folder")
 		}
 	}
+	if opts.Resume {
+		if opts.EncryptAtWrite {
+			return nil, errors.New("acquisitions encrypted as they are written cannot be resumed")
+		}
+		err = acq.loadCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	adb.Client.OnPull = acq.filePulled
+	adb.Client.OnPullFailed = acq.pullFailed
+	adb.Client.OnBatteryPause = acq.batteryPaused
+
+	// The state of the device is recorded before androidqf changes it. A
+	// resumed acquisition keeps the snapshot taken when it started.
+	var state *DeviceState
+	if !opts.Resume {
+		log.Info("Recording the state of the device...")
+		state = captureDeviceState()
+	}
 
 	// Get system information first to get tmp folder
 	err = acq.GetSystemInformation()
 	if err != nil {
 		return nil, err
 	}
+	acq.selectTmpDir(opts.DeviceTmp)
+	// Files left by crashed runs could be mistaken for this acquisition's.
+	// They are removed before the collector is uploaded again, and recorded
+	// in the report of the forensic_tooling module.
+	acq.StaleFiles = adb.Client.RemoveStaleFiles(acq.staleFileDirs(), acq.SdCard)
+	if opts.Anonymize {
+		err = acq.enableAnonymization(opts.SaltPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Case != nil {
+		// The device is no longer reachable when the case index is updated.
+		acq.caseEntry = newCaseAcquisition()
+	}
 
-	coll, err := adb.Client.GetCollector(acq.TmpDir, acq.Cpu)
-	if err != nil {
-		// Collector install failed, will use find instead
-		log.Debugf("failed to upload collector: %v", err)
+	acq.Capabilities = adb.Client.ProbeCapabilities()
+	acq.Emulator = adb.Client.DetectEmulator()
+	if acq.Emulator.Detected {
+		log.Warningf("The device appears to be an emulator or a virtual device (%s)",
+			strings.Join(acq.Emulator.Evidence, ", "))
+	}
+
+	if level, _, err := adb.Client.Battery(); err == nil {
+		if !opts.Resume {
+			acq.Power.BatteryStart = level
+		}
+		if level < adb.Client.MinBattery {
+			log.Warningf("The battery of the device is at %d%%, connect it to a charger", level)
+		}
+	}
+	if opts.KeepAwake {
+		acq.keepAwake()
+	}
+
+	if opts.NoCollector {
+		log.Debug("Not uploading the collector to the device")
+	} else if acq.TmpDirExecutable {
+		coll, err := adb.Client.GetCollector(acq.TmpDir, acq.Device.ABI)
+		if err != nil {
+			// Collector install failed, will use find instead
+			log.Debugf("failed to upload collector: %v", err)
+		}
+		acq.Collector = coll
+	} else {
+		log.Warning("No temporary folder on the device allows to execute the collector, falling back to shell commands")
 	}
-	acq.Collector = coll
 
 	// Init logging file
 	logPath := filepath.Join(acq.StoragePath, "command.log")
-	log.EnableFileLog(log.DEBUG, logPath)
+	if opts.EncryptAtWrite {
+		err = acq.enableEncryptAtWrite()
+		if err != nil {
+			return nil, err
+		}
+		logFile, err := utils.CreateBufferedOutput(logPath)
+		if err != nil {
+			return nil, err
+		}
+		log.EnableWriterLog(log.DEBUG, logFile)
+	} else if opts.Anonymize {
+		// Like the file log, appended to when the acquisition is resumed.
+		logFile, err := utils.AppendOutput(logPath)
+		if err != nil {
+			return nil, err
+		}
+		log.EnableWriterLog(log.DEBUG, logFile)
+	} else {
+		log.EnableFileLog(log.DEBUG, logPath)
+	}
+
+	if state != nil {
+		err = acq.storeDeviceState(state)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &acq, nil
 }
@@ -85,26 +244,39 @@ func New(path string) (*Acquisition, error) {
 func (a *Acquisition) Complete() {
 	a.Completed = time.Now().UTC()
 
+	a.restorePower()
 	if a.Collector != nil {
 		a.Collector.Clean()
 	}
 
+	a.Cleanup = adb.Client.VerifyCleanup()
+	if a.Cleanup.Verified {
+		log.Debug("Verified that no files or processes were left on the device")
+	} else {
+		log.Warningf("Some files or processes could not be removed from the device: %s",
+			strings.Join(a.Cleanup.Residue, ", "))
+	}
+
 	// Stop ADB server before trying to remove extracted assets
 	adb.Client.KillServer()
 	assets.CleanAssets()
 }
 
 func (a *Acquisition) GetSystemInformation() error {
-	// Get architecture information
-	out, err := adb.Client.Shell("getprop ro.product.cpu.abi")
+	var err error
+	a.Device, err = getDeviceInfo()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get device information: %v", err)
 	}
-	a.Cpu = out
-	log.Debugf("CPU architecture: %s", a.Cpu)
+	log.Debugf("Device: %s %s, Android %s (API level %d), CPU architecture: %s",
+		a.Device.Manufacturer, a.Device.Model, a.Device.AndroidVersion, a.Device.APILevel, a.Device.ABI)
+
+	a.Clock = getDeviceClock()
+	log.Debugf("Device timezone: %s (UTC%s), clock offset: %ds", a.Clock.Timezone,
+		a.Clock.UTCOffset, a.Clock.ClockOffset)
 
 	// Get tmp folder
-	out, err = adb.Client.Shell("env")
+	out, err := adb.Client.Shell("env")
 	if err != nil {
 		return fmt.Errorf("failed to run `adb shell env`: %v", err)
 	}
@@ -131,10 +303,100 @@ func (a *Acquisition) GetSystemInformation() error {
 	return nil
 }
 
+// checkTmpDir checks whether a folder on the device is writable, and whether
+// files in it can be executed (it might be mounted noexec).
+func checkTmpDir(dir string) (bool, bool) {
+	testPath := dir + ".androidqf_test"
+	adb.Client.TrackDeviceFile(testPath)
+	out, err := adb.Client.Shell(fmt.Sprintf(
+		"(printf '#!/system/bin/sh\\necho ok\\n' > %s && echo writable && chmod 755 %s && %s); rm -f %s",
+		testPath, testPath, testPath, testPath))
+	if err != nil && out == "" {
+		return false, false
+	}
+	return strings.Contains(out, "writable"), strings.Contains(out, "\nok")
+}
+
+// tmpDirCandidates returns the folders of the device which can be used as
+// temporary folder, in order of preference.
+func (a *Acquisition) tmpDirCandidates(override string) []string {
+	candidates := []string{}
+	if override != "" {
+		if !strings.HasSuffix(override, "/") {
+			override = override + "/"
+		}
+		candidates = append(candidates, override)
+	}
+	seen := map[string]bool{}
+	for _, dir := range candidates {
+		seen[dir] = true
+	}
+	for _, dir := range []string{a.TmpDir, "/data/local/tmp/", a.SdCard} {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			candidates = append(candidates, dir)
+		}
+	}
+	return candidates
+}
+
+// staleFileDirs returns the folders of the device from which the files left
+// by previous runs are removed: the selected temporary folder and
+// /data/local/tmp/, but never the shared storage, whose files might belong to
+// the user.
+func (a *Acquisition) staleFileDirs() []string {
+	dirs := []string{"/data/local/tmp/"}
+	if a.TmpDir != "" && a.TmpDir != dirs[0] && a.TmpDir != a.SdCard {
+		dirs = append(dirs, a.TmpDir)
+	}
+	return dirs
+}
+
+// selectTmpDir picks the temporary folder used on the device, falling back to
+// other locations when TMPDIR is not writable or is mounted noexec. When no
+// folder allows execution, the first writable one is used for data only.
+func (a *Acquisition) selectTmpDir(override string) {
+	writableDir := ""
+	for _, dir := range a.tmpDirCandidates(override) {
+		writable, executable := checkTmpDir(dir)
+		log.Debugf("Temporary folder %s: writable %t, executable %t", dir, writable, executable)
+		if writable && executable {
+			a.TmpDir = dir
+			a.TmpDirExecutable = true
+			return
+		}
+		if writable && writableDir == "" {
+			writableDir = dir
+		}
+	}
+
+	if writableDir != "" {
+		a.TmpDir = writableDir
+	}
+	a.TmpDirExecutable = false
+}
+
+// hashesRow returns a line of hashes.csv. Paths are relative to the
+// acquisition folder and use forward slashes, so that the list can be
+// verified on any system after the folder is moved.
+func (a *Acquisition) hashesRow(filePath, sha256 string, size int64, modTime time.Time) []string {
+	relPath, err := filepath.Rel(a.StoragePath, filePath)
+	if err != nil {
+		relPath = filePath
+	}
+	return []string{
+		filepath.ToSlash(relPath),
+		sha256,
+		strconv.FormatInt(size, 10),
+		modTime.UTC().Format(time.RFC3339),
+	}
+}
+
 func (a *Acquisition) HashFiles() error {
 	log.Info("Generating list of files hashes...")
 
-	csvFile, err := os.Create(filepath.Join(a.StoragePath, "hashes.csv"))
+	hashesPath := filepath.Join(a.StoragePath, "hashes.csv")
+	csvFile, err := utils.CreateOutput(hashesPath)
 	if err != nil {
 		return err
 	}
@@ -143,12 +405,28 @@ func (a *Acquisition) HashFiles() error {
 	csvWriter := csv.NewWriter(csvFile)
 	defer csvWriter.Flush()
 
+	if entries := utils.OutputEntries(); entries != nil {
+		paths := make([]string, 0, len(entries))
+		for filePath := range entries {
+			paths = append(paths, filePath)
+		}
+		sort.Strings(paths)
+		for _, filePath := range paths {
+			entry := entries[filePath]
+			err = csvWriter.Write(a.hashesRow(filePath, entry.SHA256, entry.Size, entry.ModTime))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	_ = filepath.Walk(a.StoragePath, func(filePath string, fileInfo os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if fileInfo.IsDir() {
+		if fileInfo.IsDir() || filePath == hashesPath {
 			return nil
 		}
 
@@ -157,7 +435,7 @@ func (a *Acquisition) HashFiles() error {
 			return err
 		}
 
-		err = csvWriter.Write([]string{filePath, sha256})
+		err = csvWriter.Write(a.hashesRow(filePath, sha256, fileInfo.Size(), fileInfo.ModTime()))
 		if err != nil {
 			return err
 		}
@@ -168,6 +446,34 @@ func (a *Acquisition) HashFiles() error {
 	return nil
 }
 
+// RenamedFilesFile maps the files stored under a sanitized name, because
+// their name on the device cannot be used on every operating system, to their
+// original path.
+const RenamedFilesFile = "renamed_files.json"
+
+// StoreRenamedFiles writes the original paths of the files stored under a
+// sanitized name, if any.
+func (a *Acquisition) StoreRenamedFiles() error {
+	renamed := utils.RenamedFiles()
+	if len(renamed) == 0 {
+		return nil
+	}
+
+	files := map[string]string{}
+	for localPath, remotePath := range renamed {
+		relPath, err := filepath.Rel(a.StoragePath, localPath)
+		if err != nil {
+			relPath = localPath
+		}
+		files[filepath.ToSlash(relPath)] = remotePath
+	}
+	data, err := json.MarshalIndent(files, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the renamed files: %v", err)
+	}
+	return utils.WriteOutput(filepath.Join(a.StoragePath, RenamedFilesFile), data)
+}
+
 func (a *Acquisition) StoreInfo() error {
 	log.Info("Saving details about acquisition and device...")
 
@@ -179,7 +485,7 @@ func (a *Acquisition) StoreInfo() error {
 
 	infoPath := filepath.Join(a.StoragePath, "acquisition.json")
 
-	err = os.WriteFile(infoPath, info, 0o644)
+	err = utils.WriteOutput(infoPath, info)
 	if err != nil {
 		return fmt.Errorf("failed to write acquisition details to file: %v",
 			err)
@@ -187,3 +493,16 @@ func (a *Acquisition) StoreInfo() error {
 
 	return nil
 }
+
+// StoreCommands writes the adb commands executed during the acquisition as
+// a script, before the files are hashed so that it is listed in hashes.csv.
+func (a *Acquisition) StoreCommands() error {
+	header := fmt.Sprintf("Commands executed by androidqf %s for acquisition %s",
+		a.AndroidQFVersion, a.UUID)
+	err := utils.WriteOutput(filepath.Join(a.StoragePath, "commands.sh"),
+		[]byte(adb.Client.Script(header)))
+	if err != nil {
+		return fmt.Errorf("failed to write list of commands to file: %v", err)
+	}
+	return nil
+}
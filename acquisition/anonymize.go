@@ -0,0 +1,142 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	rt "github.com/botherder/go-savetime/runtime"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+var (
+	accountRegexp      = regexp.MustCompile(`Account \{name=([^,]+), type=`)
+	deviceIDRegexp     = regexp.MustCompile(`Device ID\s*=\s*(\d{14,16})`)
+	parcelStringRegexp = regexp.MustCompile(`'([^']*)'`)
+	imeiRegexp         = regexp.MustCompile(`^\d{14,16}$`)
+)
+
+// loadSalt reads the salt used to derive pseudonyms, or generates a new one.
+// The salt must be kept separately from the acquisitions, otherwise the
+// pseudonyms of known identifiers could be recomputed.
+func loadSalt(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		salt := strings.TrimSpace(string(data))
+		if salt == "" {
+			return nil, fmt.Errorf("salt file %s is empty", path)
+		}
+		return []byte(salt), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read salt file: %v", err)
+	}
+
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	salt := hex.EncodeToString(buf)
+	err = os.WriteFile(path, []byte(salt+"\n"), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write salt file: %v", err)
+	}
+	log.Warningf("Generated a new salt for pseudonyms at %s: keep it separately from the acquisitions", path)
+
+	return []byte(salt), nil
+}
+
+// parseParcelString extracts the string returned by `service call`, which
+// is printed as a hex dump of the parcel.
+func parseParcelString(out string) string {
+	var builder strings.Builder
+	for _, match := range parcelStringRegexp.FindAllStringSubmatch(out, -1) {
+		builder.WriteString(strings.ReplaceAll(match[1], ".", ""))
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+// deviceIdentifiers collects the identifiers of the device and of its owner
+// which are replaced by pseudonyms.
+func deviceIdentifiers() map[string][]string {
+	ids := map[string][]string{}
+
+	ids["serial"] = append(ids["serial"], adb.Client.Serial)
+	for _, prop := range []string{"ro.serialno", "ro.boot.serialno"} {
+		out, _ := adb.Client.Shell("getprop", prop)
+		ids["serial"] = append(ids["serial"], out)
+	}
+
+	// The IMEI is only readable by the shell on older versions of Android.
+	for slot := 0; slot < 2; slot++ {
+		out, _ := adb.Client.Shell(fmt.Sprintf("service call iphonesubinfo 4 i32 %d s16 com.android.shell", slot))
+		if imei := parseParcelString(out); imeiRegexp.MatchString(imei) {
+			ids["imei"] = append(ids["imei"], imei)
+		}
+	}
+	out, _ := adb.Client.Shell("service call iphonesubinfo 1 s16 com.android.shell")
+	if imei := parseParcelString(out); imeiRegexp.MatchString(imei) {
+		ids["imei"] = append(ids["imei"], imei)
+	}
+	out, _ = adb.Client.Shell("dumpsys iphonesubinfo")
+	for _, match := range deviceIDRegexp.FindAllStringSubmatch(out, -1) {
+		ids["imei"] = append(ids["imei"], match[1])
+	}
+
+	out, _ = adb.Client.Shell("dumpsys account")
+	for _, match := range accountRegexp.FindAllStringSubmatch(out, -1) {
+		ids["account"] = append(ids["account"], match[1])
+	}
+
+	out, _ = adb.Client.Shell("settings get secure android_id")
+	ids["android_id"] = append(ids["android_id"], out)
+
+	return ids
+}
+
+// enableAnonymization replaces the identifiers of the device and of its
+// owner with stable pseudonyms in all the text outputs of the acquisition.
+func (a *Acquisition) enableAnonymization(saltPath string) error {
+	if saltPath == "" {
+		saltPath = filepath.Join(rt.GetExecutableDirectory(), "salt.txt")
+	}
+	absSalt, _ := filepath.Abs(saltPath)
+	absStorage, _ := filepath.Abs(a.StoragePath)
+	if strings.HasPrefix(absSalt, absStorage+string(filepath.Separator)) {
+		return errors.New("the salt must not be stored in the acquisition folder")
+	}
+
+	salt, err := loadSalt(saltPath)
+	if err != nil {
+		return err
+	}
+
+	p := utils.NewPseudonymizer(salt)
+	for kind, values := range deviceIdentifiers() {
+		for _, value := range values {
+			if value == "null" || value == "unknown" {
+				continue
+			}
+			p.Add(kind, value)
+		}
+	}
+	utils.SetPseudonymizer(p)
+	a.Anonymized = true
+
+	log.Infof("Replacing %d device and account identifiers with pseudonyms", p.Count())
+
+	return nil
+}
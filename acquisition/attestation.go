@@ -0,0 +1,40 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"os"
+
+	"github.com/botherder/go-savetime/hashes"
+	"github.com/mvt-project/androidqf/assets"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Tooling records the hashes of the androidqf executable and of its embedded
+// assets, so that the exact tools used for an acquisition can be verified.
+type Tooling struct {
+	ExecutablePath   string            `json:"executable_path"`
+	ExecutableSHA256 string            `json:"executable_sha256"`
+	Assets           map[string]string `json:"assets"`
+}
+
+func getTooling() *Tooling {
+	tooling := &Tooling{Assets: assets.Hashes()}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Debugf("Impossible to find the path of the executable: %v", err)
+		return tooling
+	}
+	tooling.ExecutablePath = exePath
+
+	tooling.ExecutableSHA256, err = hashes.FileSHA256(exePath)
+	if err != nil {
+		log.Debugf("Impossible to hash the executable: %v", err)
+	}
+
+	return tooling
+}
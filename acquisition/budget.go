@@ -0,0 +1,91 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"time"
+
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Actions taken on a module to fit the acquisition in its time budget.
+const (
+	BudgetSkipped  = "skipped"
+	BudgetHashOnly = "hash_only"
+	BudgetFast     = "fast"
+)
+
+// BudgetCut records a module, or the reboot of the device, which was skipped
+// or degraded because the time budget of the acquisition was running out.
+type BudgetCut struct {
+	Module string `json:"module"`
+	// BudgetSkipped, BudgetHashOnly or BudgetFast.
+	Action           string    `json:"action"`
+	Reason           string    `json:"reason"`
+	Time             time.Time `json:"time"`
+	RemainingSeconds float64   `json:"remaining_seconds"`
+}
+
+// TimeBudget limits the overall duration of the acquisition, for when the
+// device must be returned to its owner quickly.
+type TimeBudget struct {
+	Seconds  float64     `json:"seconds"`
+	Started  time.Time   `json:"started"`
+	Deadline time.Time   `json:"deadline"`
+	Cuts     []BudgetCut `json:"cuts"`
+}
+
+// StartTimeBudget starts counting the time budget of the acquisition. The
+// cuts of an interrupted acquisition are kept, but a resumed acquisition
+// gets the whole budget again.
+func (a *Acquisition) StartTimeBudget(limit time.Duration) {
+	cuts := []BudgetCut{}
+	if a.Budget != nil {
+		cuts = a.Budget.Cuts
+	}
+	started := time.Now().UTC()
+	a.Budget = &TimeBudget{
+		Seconds:  limit.Seconds(),
+		Started:  started,
+		Deadline: started.Add(limit),
+		Cuts:     cuts,
+	}
+	log.Infof("The acquisition must complete by %s", a.Budget.Deadline.Local().Format("15:04:05"))
+}
+
+// BudgetRemaining returns the time left before the deadline, which is
+// negative once it passed.
+func (a *Acquisition) BudgetRemaining() time.Duration {
+	if a.Budget == nil {
+		return 0
+	}
+	return time.Until(a.Budget.Deadline)
+}
+
+// CutFromBudget records that a module was skipped or degraded to fit the
+// acquisition in its time budget.
+func (a *Acquisition) CutFromBudget(module, action, reason string) {
+	if a.Budget == nil {
+		return
+	}
+	remaining := a.BudgetRemaining()
+	switch action {
+	case BudgetSkipped:
+		log.Warningf("Skipping %s, %s", module, reason)
+	case BudgetHashOnly:
+		log.Warningf("Only hashing the files of %s, %s", module, reason)
+	default:
+		log.Warningf("Running %s in fast mode, %s", module, reason)
+	}
+	a.Budget.Cuts = append(a.Budget.Cuts, BudgetCut{
+		Module:           module,
+		Action:           action,
+		Reason:           reason,
+		Time:             time.Now().UTC(),
+		RemainingSeconds: remaining.Round(time.Second).Seconds(),
+	})
+	a.storeCheckpoint()
+}
@@ -0,0 +1,196 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	rt "github.com/botherder/go-savetime/runtime"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+const (
+	caseFileName = "case.json"
+	// Lock file preventing concurrent acquisitions from overwriting each
+	// other's changes to the case index.
+	caseLockName = "case.json.lock"
+	// Locks older than this were left behind by a crashed acquisition.
+	caseLockStale = time.Minute
+)
+
+// CaseAcquisition is an acquisition recorded in a case.
+type CaseAcquisition struct {
+	UUID      string    `json:"uuid"`
+	Folder    string    `json:"folder,omitempty"`
+	Encrypted string    `json:"encrypted,omitempty"`
+	Serial    string    `json:"serial"`
+	Model     string    `json:"model"`
+	Started   time.Time `json:"started"`
+	Completed time.Time `json:"completed"`
+}
+
+// Case groups multiple acquisitions, for example of different devices
+// belonging to the same investigation, in a single folder.
+type Case struct {
+	ID           string            `json:"id"`
+	Created      time.Time         `json:"created"`
+	Updated      time.Time         `json:"updated"`
+	Acquisitions []CaseAcquisition `json:"acquisitions"`
+
+	Path string `json:"-"`
+}
+
+// OpenCase loads the case with the given ID from the parent folder, or
+// creates it if it does not exist yet. If parent is empty, cases are stored
+// next to the androidqf executable.
+func OpenCase(parent, id string) (*Case, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid case ID %q", id)
+	}
+	if parent == "" {
+		parent = rt.GetExecutableDirectory()
+	}
+
+	c := &Case{
+		ID:           id,
+		Created:      time.Now().UTC(),
+		Acquisitions: []CaseAcquisition{},
+		Path:         filepath.Join(parent, id),
+	}
+
+	err := os.MkdirAll(c.Path, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create case folder: %v", err)
+	}
+
+	err = c.update(func() {})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load reads the case index from case.json, if it exists.
+func (c *Case) load() error {
+	data, err := os.ReadFile(filepath.Join(c.Path, caseFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read case index: %v", err)
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return fmt.Errorf("failed to parse case index: %v", err)
+	}
+	if c.Acquisitions == nil {
+		c.Acquisitions = []CaseAcquisition{}
+	}
+	return nil
+}
+
+// lock acquires the lock on the case index, waiting for other acquisitions
+// to release it.
+func (c *Case) lock() (func(), error) {
+	lockPath := filepath.Join(c.Path, caseLockName)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		} else if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock case index: %v", err)
+		}
+
+		info, err := os.Stat(lockPath)
+		if err == nil && time.Since(info.ModTime()) > caseLockStale {
+			os.Remove(lockPath)
+			continue
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// update applies a change to the latest version of the case index and
+// stores it, while holding the lock, so that acquisitions running at the
+// same time in the same case do not lose each other's changes.
+func (c *Case) update(change func()) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = c.load()
+	if err != nil {
+		return err
+	}
+	change()
+
+	return c.store()
+}
+
+// store writes the case index to case.json, replacing it atomically.
+func (c *Case) store() error {
+	c.Updated = time.Now().UTC()
+
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the case index: %v", err)
+	}
+
+	tmpPath := filepath.Join(c.Path, caseFileName+".tmp")
+	err = os.WriteFile(tmpPath, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write case index: %v", err)
+	}
+	return os.Rename(tmpPath, filepath.Join(c.Path, caseFileName))
+}
+
+// newCaseAcquisition collects the details identifying the device.
+func newCaseAcquisition() CaseAcquisition {
+	entry := CaseAcquisition{Serial: adb.Client.Serial}
+	if entry.Serial == "" {
+		entry.Serial, _ = adb.Client.Shell("getprop", "ro.serialno")
+	}
+	entry.Serial = utils.Anonymize(entry.Serial)
+	entry.Model, _ = adb.Client.Shell("getprop", "ro.product.model")
+	return entry
+}
+
+// AddAcquisition records a completed acquisition in the case index.
+func (c *Case) AddAcquisition(a *Acquisition) error {
+	entry := a.caseEntry
+	entry.UUID = a.UUID
+	entry.Started = a.Started
+	entry.Completed = a.Completed
+
+	if a.encFilePath != "" {
+		entry.Encrypted = c.relPath(a.encFilePath)
+	} else {
+		entry.Folder = c.relPath(a.StoragePath)
+	}
+
+	return c.update(func() {
+		c.Acquisitions = append(c.Acquisitions, entry)
+	})
+}
+
+func (c *Case) relPath(path string) string {
+	relPath, err := filepath.Rel(c.Path, path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return path
+	}
+	return filepath.ToSlash(relPath)
+}
@@ -0,0 +1,266 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/adb"
+)
+
+const (
+	CheckError   = "error"
+	CheckWarning = "warning"
+)
+
+// CheckIssue is a problem found in a finished acquisition.
+type CheckIssue struct {
+	Level   string `json:"level"`
+	Module  string `json:"module,omitempty"`
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// CheckReport lists the problems found in a finished acquisition.
+type CheckReport struct {
+	Modules int          `json:"modules"`
+	Files   int          `json:"files"`
+	Issues  []CheckIssue `json:"issues"`
+}
+
+// Errors returns the number of issues which make the acquisition
+// incomplete.
+func (r *CheckReport) Errors() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Level == CheckError {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *CheckReport) add(level, module, file, format string, args ...any) {
+	r.Issues = append(r.Issues, CheckIssue{level, module, file, fmt.Sprintf(format, args...)})
+}
+
+// Check validates the completeness of the acquisition stored in folder: the
+// files listed in the manifests of the modules which succeeded and in
+// hashes.csv exist with the recorded size, the JSON outputs parse, and the
+// copies of the apps match packages.json. It is meant to be run before
+// giving the device back, while missing data can still be collected.
+func Check(folder string) (*CheckReport, error) {
+	stat, err := os.Stat(folder)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not an acquisition folder", folder)
+	}
+
+	report := &CheckReport{Issues: []CheckIssue{}}
+	a := &Acquisition{}
+	a.StoragePath = folder
+	err = a.readJSON("acquisition.json", a)
+	if err != nil {
+		report.add(CheckError, "", "acquisition.json", "cannot be read: %v", err)
+	} else if a.Completed.IsZero() {
+		report.add(CheckError, "", "acquisition.json", "the acquisition did not complete")
+	}
+	a.StoragePath = folder
+
+	var failed []FailedItem
+	if a.readJSON(FailedItemsFile, &failed) == nil {
+		for _, item := range failed {
+			name := item.Remote
+			if item.Kind == FailedModule {
+				name = "module " + item.Module
+			}
+			report.add(CheckError, item.Module, FailedItemsFile, "%s failed: %s", name, item.Error)
+		}
+	}
+
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != ParquetFolder {
+			checkModule(report, folder, entry.Name())
+		}
+	}
+	checkPackages(report, folder)
+	checkHashes(report, folder)
+
+	err = filepath.Walk(folder, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		report.Files++
+		relPath, _ := filepath.Rel(folder, filePath)
+		checkJSONFile(report, filePath, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// checkModule checks that the files listed in the manifest of a module which
+// succeeded are all present, with the size they had when it completed.
+func checkModule(report *CheckReport, folder, module string) {
+	var manifest ModuleManifest
+	data, err := os.ReadFile(filepath.Join(folder, module, "manifest.json"))
+	if os.IsNotExist(err) {
+		report.add(CheckWarning, module, "", "the folder has no manifest, the module might not have completed")
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		report.add(CheckError, module, module+"/manifest.json", "cannot be read: %v", err)
+		return
+	}
+	report.Modules++
+	if manifest.Error != "" {
+		report.add(CheckWarning, module, "", "the module failed: %s", manifest.Error)
+		return
+	}
+	for _, file := range manifest.Files {
+		relPath := module + "/" + file.Path
+		info, err := os.Stat(filepath.Join(folder, module, filepath.FromSlash(file.Path)))
+		if err != nil {
+			report.add(CheckError, module, relPath, "is listed in the manifest but missing")
+		} else if info.Size() != file.Size {
+			report.add(CheckError, module, relPath, "has size %d instead of %d recorded in the manifest",
+				info.Size(), file.Size)
+		}
+	}
+}
+
+// checkPackages compares the copies of the apps with those listed in
+// packages.json.
+func checkPackages(report *CheckReport, folder string) {
+	data, err := os.ReadFile(filepath.Join(folder, "packages", "packages.json"))
+	if err != nil {
+		return
+	}
+	var packages []adb.Package
+	if json.Unmarshal(data, &packages) != nil {
+		// Reported with the other JSON files.
+		return
+	}
+	if len(packages) == 0 {
+		report.add(CheckError, "packages", "packages/packages.json", "lists no installed packages")
+	}
+
+	listed := map[string]bool{}
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			if file.LocalName == "" {
+				continue
+			}
+			listed[file.LocalName] = true
+			// Copies of apps signed with a trusted certificate can be
+			// removed on purpose.
+			if file.TrustedCertificate {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(folder, "packages", filepath.FromSlash(file.LocalName))); err != nil {
+				report.add(CheckError, "packages", "packages/"+file.LocalName,
+					"copy of %s of package %s is missing", file.Path, pkg.Name)
+			}
+		}
+	}
+
+	apks, _ := filepath.Glob(filepath.Join(folder, "packages", "apks", "*.apk"))
+	sort.Strings(apks)
+	for _, apk := range apks {
+		if !listed["apks/"+filepath.Base(apk)] {
+			report.add(CheckWarning, "packages", "packages/apks/"+filepath.Base(apk),
+				"is not listed in packages.json, which might be truncated")
+		}
+	}
+}
+
+// checkHashes checks that the files listed in hashes.csv have the recorded
+// size.
+func checkHashes(report *CheckReport, folder string) {
+	file, err := os.Open(filepath.Join(folder, "hashes.csv"))
+	if err != nil {
+		report.add(CheckError, "", "hashes.csv", "cannot be read: %v", err)
+		return
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		report.add(CheckError, "", "hashes.csv", "cannot be parsed: %v", err)
+		return
+	}
+	for _, row := range rows {
+		// The log is still written after the hashes are computed.
+		if len(row) < 3 || row[0] == "command.log" {
+			continue
+		}
+		size, _ := strconv.ParseInt(row[2], 10, 64)
+		info, err := os.Stat(filepath.Join(folder, filepath.FromSlash(row[0])))
+		if err != nil {
+			report.add(CheckError, "", row[0], "is listed in hashes.csv but missing")
+		} else if info.Size() != size {
+			report.add(CheckError, "", row[0], "has size %d instead of %d recorded in hashes.csv", info.Size(), size)
+		}
+	}
+}
+
+// checkJSONFile checks that a JSON or JSON lines output parses.
+func checkJSONFile(report *CheckReport, filePath, relPath string) {
+	module := ""
+	if dir, _, ok := strings.Cut(relPath, "/"); ok {
+		module = dir
+	}
+
+	switch filepath.Ext(filePath) {
+	case ".json":
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			report.add(CheckError, module, relPath, "cannot be read: %v", err)
+		} else if !json.Valid(data) {
+			report.add(CheckError, module, relPath, "is not valid JSON, it might be truncated")
+		}
+	case ".jsonl":
+		file, err := os.Open(filePath)
+		if err != nil {
+			report.add(CheckError, module, relPath, "cannot be read: %v", err)
+			return
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) > 0 && !json.Valid(scanner.Bytes()) {
+				report.add(CheckError, module, relPath, "line %d is not valid JSON, it might be truncated", line)
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			report.add(CheckError, module, relPath, "cannot be read: %v", err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+const (
+	// CheckpointFile records the progress of the acquisition, so that it
+	// can be resumed if androidqf crashes.
+	CheckpointFile = "checkpoint.json"
+	// The checkpoint is also written every time this many files are pulled.
+	checkpointPullInterval = 100
+)
+
+// Checkpoint is the state of the acquisition, written after every module
+// and periodically while files are pulled. The output of the module in
+// progress when androidqf stopped is incomplete.
+type Checkpoint struct {
+	AcquisitionUUID  string       `json:"acquisition_uuid"`
+	Started          time.Time    `json:"started"`
+	Updated          time.Time    `json:"updated"`
+	CompletedModules []string     `json:"completed_modules"`
+	CurrentModule    string       `json:"current_module,omitempty"`
+	PulledFiles      int          `json:"pulled_files"`
+	Detections       []Detection  `json:"detections"`
+	FailedItems      []FailedItem `json:"failed_items"`
+	// Decisions of the owner of the device about the content copied.
+	Consents []ConsentDecision `json:"consents"`
+	// Stay awake setting to restore at the end of the acquisition.
+	Power *adb.PowerState `json:"power"`
+	// Reboots of the device between the phases of the acquisition.
+	Reboots []RebootRecord `json:"reboots"`
+	// Time budget, and the modules cut to fit in it.
+	Budget *TimeBudget `json:"time_budget,omitempty"`
+}
+
+// storeCheckpoint writes the checkpoint, replacing the previous one
+// atomically so that a crash never leaves a partial file behind.
+func (a *Acquisition) storeCheckpoint() {
+	// Outputs are only readable once the encrypted archive is complete,
+	// so the acquisition could not be resumed anyway.
+	if utils.OutputSinkEnabled() {
+		return
+	}
+
+	a.checkpoint.AcquisitionUUID = a.UUID
+	a.checkpoint.Started = a.Started
+	a.checkpoint.Updated = time.Now().UTC()
+	a.checkpoint.Detections = a.detections
+	a.checkpoint.Consents = a.Consents
+	a.checkpoint.Power = a.Power
+	a.checkpoint.Reboots = a.Reboots
+	a.checkpoint.Budget = a.Budget
+	if a.checkpoint.CompletedModules == nil {
+		a.checkpoint.CompletedModules = []string{}
+	}
+	if a.checkpoint.Detections == nil {
+		a.checkpoint.Detections = []Detection{}
+	}
+	if a.checkpoint.FailedItems == nil {
+		a.checkpoint.FailedItems = []FailedItem{}
+	}
+
+	data, err := json.MarshalIndent(&a.checkpoint, "", "    ")
+	if err != nil {
+		log.Debugf("Failed to json marshal the checkpoint: %v", err)
+		return
+	}
+	tmpPath := filepath.Join(a.StoragePath, CheckpointFile+".tmp")
+	err = os.WriteFile(tmpPath, data, 0o644)
+	if err == nil {
+		err = os.Rename(tmpPath, filepath.Join(a.StoragePath, CheckpointFile))
+	}
+	if err != nil {
+		log.Debugf("Failed to store checkpoint: %v", err)
+	}
+}
+
+// loadCheckpoint restores the state of an interrupted acquisition stored in
+// the acquisition folder, and discards the incomplete output of the module
+// which was running when it stopped.
+func (a *Acquisition) loadCheckpoint() error {
+	data, err := os.ReadFile(filepath.Join(a.StoragePath, CheckpointFile))
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	err = json.Unmarshal(data, &a.checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+
+	a.UUID = a.checkpoint.AcquisitionUUID
+	a.Started = a.checkpoint.Started
+	a.detections = a.checkpoint.Detections
+	a.Consents = a.checkpoint.Consents
+	if a.checkpoint.Power != nil {
+		a.Power = a.checkpoint.Power
+	}
+	a.Reboots = a.checkpoint.Reboots
+	a.Budget = a.checkpoint.Budget
+
+	if a.checkpoint.CurrentModule != "" {
+		log.Infof("Discarding the incomplete output of module %s", a.checkpoint.CurrentModule)
+		err = os.RemoveAll(a.ModulePath(a.checkpoint.CurrentModule))
+		if err != nil {
+			return fmt.Errorf("failed to remove incomplete output of module %s: %v",
+				a.checkpoint.CurrentModule, err)
+		}
+		a.checkpoint.CurrentModule = ""
+	}
+
+	log.Infof("Resuming acquisition %s after %d completed modules", a.UUID,
+		len(a.checkpoint.CompletedModules))
+	return nil
+}
+
+// filePulled counts the files pulled from the device, storing the
+// checkpoint at regular intervals.
+func (a *Acquisition) filePulled() {
+	a.checkpoint.PulledFiles++
+	if a.checkpoint.PulledFiles%checkpointPullInterval == 0 {
+		a.storeCheckpoint()
+	}
+}
+
+// StartModule records that the given module is running.
+func (a *Acquisition) StartModule(module string) {
+	a.checkpoint.CurrentModule = module
+	a.storeCheckpoint()
+}
+
+// CompleteModule records that the given module completed, with its output
+// and manifest stored.
+func (a *Acquisition) CompleteModule(module string) {
+	a.checkpoint.CurrentModule = ""
+	// Modules run again to retry their failures are only recorded once.
+	if !a.ModuleCompleted(module) {
+		a.checkpoint.CompletedModules = append(a.checkpoint.CompletedModules, module)
+	}
+	a.storeCheckpoint()
+}
+
+// ModuleCompleted returns whether the given module completed before the
+// acquisition was resumed.
+func (a *Acquisition) ModuleCompleted(module string) bool {
+	for _, completed := range a.checkpoint.CompletedModules {
+		if completed == module {
+			return true
+		}
+	}
+	return false
+}
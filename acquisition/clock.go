@@ -0,0 +1,138 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Not available on Windows.
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// TimestampsFile documents the timezone of the timestamps in each output,
+// and how to convert device local times to UTC.
+const TimestampsFile = "timestamps.json"
+
+// DeviceClock records the timezone, locale and clock of the device at the
+// time of acquisition.
+type DeviceClock struct {
+	Timezone  string `json:"timezone"`
+	Locale    string `json:"locale"`
+	UTCOffset string `json:"utc_offset"`
+	// Difference between the clock of the device and of the computer
+	// running androidqf, in seconds. Positive if the device is ahead.
+	ClockOffset int64 `json:"clock_offset"`
+
+	location *time.Location
+}
+
+// getDeviceClock reads the timezone and locale settings of the device, and
+// compares its clock with the local one.
+func getDeviceClock() *DeviceClock {
+	clock := &DeviceClock{}
+	clock.Timezone, _ = adb.Client.Shell("getprop", "persist.sys.timezone")
+	clock.Locale, _ = adb.Client.Shell("getprop", "persist.sys.locale")
+	if clock.Locale == "" {
+		clock.Locale, _ = adb.Client.Shell("getprop", "ro.product.locale")
+	}
+	clock.UTCOffset, _ = adb.Client.Shell("date", "+%z")
+
+	before := time.Now().Unix()
+	out, err := adb.Client.Shell("date", "+%s")
+	if seconds, parseErr := strconv.ParseInt(out, 10, 64); err == nil && parseErr == nil {
+		clock.ClockOffset = seconds - (before+time.Now().Unix())/2
+	}
+
+	clock.location = clock.loadLocation()
+	return clock
+}
+
+// loadLocation returns the timezone of the device, falling back to its
+// current offset from UTC, and to UTC if neither is known.
+func (c *DeviceClock) loadLocation() *time.Location {
+	if c.Timezone != "" {
+		if loc, err := time.LoadLocation(c.Timezone); err == nil {
+			return loc
+		}
+	}
+	if t, err := time.Parse("-0700", c.UTCOffset); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(c.UTCOffset, offset)
+	}
+	log.Debugf("Unknown timezone of the device (%q, %q), assuming UTC", c.Timezone, c.UTCOffset)
+	return time.UTC
+}
+
+// Location returns the timezone in which the device reports local times.
+func (c *DeviceClock) Location() *time.Location {
+	if c == nil || c.location == nil {
+		return time.UTC
+	}
+	return c.location
+}
+
+// DeviceTimeToUTC converts a time reported by the device without timezone,
+// in the given layout, to UTC in RFC 3339 format. It returns an empty string
+// if the value cannot be parsed.
+func (a *Acquisition) DeviceTimeToUTC(layout, value string) string {
+	t, err := time.ParseInLocation(layout, strings.TrimSpace(value), a.Clock.Location())
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// TimestampConvention describes the timestamps of a field of an output.
+type TimestampConvention struct {
+	File  string `json:"file"`
+	Field string `json:"field"`
+	// "utc", "device" (local time of the device) or "epoch" (seconds since
+	// the Unix epoch).
+	Timezone string `json:"timezone"`
+	// Field with the same timestamp converted to UTC, if any.
+	NormalizedField string `json:"normalized_field,omitempty"`
+}
+
+type timestampsReport struct {
+	Clock       *DeviceClock          `json:"clock"`
+	Conventions []TimestampConvention `json:"conventions"`
+}
+
+// StoreTimestamps writes the timezone of the device and the conventions of
+// the timestamps of each output, so that local and UTC times are not mixed.
+func (a *Acquisition) StoreTimestamps() error {
+	report := timestampsReport{
+		Clock: a.Clock,
+		Conventions: []TimestampConvention{
+			{"packages/packages.json", "first_install_time", "device", "first_install_time_utc"},
+			{"packages/packages.json", "last_update_time", "device", "last_update_time_utc"},
+			{"logcat/logcat.jsonl", "timestamp", "device", "timestamp_utc"},
+			{"logcat/logcat_old.jsonl", "timestamp", "device", "timestamp_utc"},
+			{"files/files.json", "modified_time", "epoch", ""},
+			{"files/files.json", "changed_time", "epoch", ""},
+			{"files/files.json", "access_time", "epoch", ""},
+			{"running_services/running_services.json", "started", "utc", ""},
+			{"privacy_dashboard/privacy_dashboard.json", "time", "device", "time_utc"},
+			{"media_usage/media_usage.json", "time", "device", "time_utc"},
+			{"clipboard/clipboard.json", "time", "device", "time_utc"},
+			{TimelineFile, "datetime", "utc", ""},
+			{"acquisition.json", "started", "utc", ""},
+		},
+	}
+
+	data, err := json.MarshalIndent(&report, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the timestamp conventions: %v", err)
+	}
+	return utils.WriteOutput(filepath.Join(a.StoragePath, TimestampsFile), data)
+}
@@ -0,0 +1,45 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"time"
+
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// ConsentDecision records whether the owner of the device agreed to the copy
+// of the content collected by a module.
+type ConsentDecision struct {
+	Module      string    `json:"module"`
+	Description string    `json:"description"`
+	Granted     bool      `json:"granted"`
+	Time        time.Time `json:"time"`
+}
+
+// RequestConsent describes the content the module is about to copy, asks
+// whether the owner of the device agrees, and records the decision. The
+// decision taken before the acquisition was resumed, or before a module is
+// retried, is reused.
+func (a *Acquisition) RequestConsent(module, description string) bool {
+	for _, decision := range a.Consents {
+		if decision.Module == module {
+			return decision.Granted
+		}
+	}
+
+	log.Infof("Module %s is about to copy: %s", module, description)
+	granted := utils.Confirm("consent_"+module, "Does the owner of the device agree to copy it?")
+	a.Consents = append(a.Consents, ConsentDecision{
+		Module:      module,
+		Description: description,
+		Granted:     granted,
+		Time:        time.Now().UTC(),
+	})
+	a.storeCheckpoint()
+	return granted
+}
@@ -0,0 +1,246 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/sqlite"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// DatabaseFile is the name of the SQLite database with the parsed results of
+// the acquisition. Its schema is documented in the README.
+const DatabaseFile = "acquisition.db"
+
+type dbTable struct {
+	t *sqlite.Table
+}
+
+// insert adds a row, replacing identifiers in text values when the
+// acquisition is anonymized.
+func (d dbTable) insert(values ...any) error {
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			values[i] = utils.Anonymize(s)
+		}
+	}
+	return d.t.Insert(values...)
+}
+
+func createTable(db *sqlite.Writer, name string, columns ...string) (dbTable, error) {
+	cols := make([]sqlite.Column, len(columns))
+	for i, column := range columns {
+		parts := strings.SplitN(column, " ", 2)
+		cols[i] = sqlite.Column{Name: parts[0], Type: parts[1]}
+	}
+	t, err := db.CreateTable(name, cols)
+	return dbTable{t: t}, err
+}
+
+func (a *Acquisition) dbAcquisition(db *sqlite.Writer) error {
+	t, err := createTable(db, "acquisition",
+		"uuid TEXT", "androidqf_version TEXT", "started TEXT", "case_id TEXT",
+		"profile TEXT", "hash_only INTEGER", "anonymized INTEGER")
+	if err != nil {
+		return err
+	}
+	return t.insert(a.UUID, a.AndroidQFVersion, a.Started.Format(time.RFC3339),
+		a.CaseID, a.Profile, a.HashOnly, a.Anonymized)
+}
+
+func (a *Acquisition) dbPackages(db *sqlite.Writer) error {
+	var packages []adb.Package
+	_ = a.readJSON("packages/packages.json", &packages)
+
+	t, err := createTable(db, "packages",
+		"name TEXT", "installer TEXT", "uid INTEGER", "disabled INTEGER",
+		"system INTEGER", "third_party INTEGER", "first_install_time TEXT",
+		"last_update_time TEXT", "partition TEXT", "flags TEXT")
+	if err != nil {
+		return err
+	}
+	for _, pkg := range packages {
+		err = t.insert(pkg.Name, pkg.Installer, pkg.UID, pkg.Disabled, pkg.System,
+			pkg.ThirdParty, pkg.FirstInstallTime, pkg.LastUpdateTime, pkg.Partition,
+			strings.Join(pkg.Flags, ","))
+		if err != nil {
+			return err
+		}
+	}
+
+	t, err = createTable(db, "package_files",
+		"package TEXT", "path TEXT", "local_name TEXT", "md5 TEXT", "sha1 TEXT",
+		"sha256 TEXT", "sha512 TEXT", "verified_certificate INTEGER",
+		"trusted_certificate INTEGER", "quarantined INTEGER", "error TEXT")
+	if err != nil {
+		return err
+	}
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			err = t.insert(pkg.Name, file.Path, file.LocalName, file.MD5, file.SHA1,
+				file.SHA256, file.SHA512, file.VerifiedCertificate, file.TrustedCertificate,
+				file.Quarantined, file.Error)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *Acquisition) dbProcesses(db *sqlite.Writer) error {
+	processes, _ := a.readProcesses()
+
+	t, err := createTable(db, "processes",
+		"pid INTEGER", "ppid INTEGER", "uid INTEGER", "filename TEXT", "path TEXT",
+		"context TEXT", "command_line TEXT", "cwd TEXT")
+	if err != nil {
+		return err
+	}
+	for _, p := range processes {
+		err = t.insert(p.Pid, p.Ppid, p.Uid, p.Filename, p.Path, p.Context,
+			strings.Join(p.CommandLine, " "), p.WorkingDirectory)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Acquisition) dbFiles(db *sqlite.Writer) error {
+	var files []adb.FileInfo
+	_ = a.readJSON("files/files.json", &files)
+
+	t, err := createTable(db, "files",
+		"path TEXT", "size INTEGER", "mode TEXT", "user_name TEXT", "group_name TEXT",
+		"modified_time INTEGER", "changed_time INTEGER", "access_time INTEGER",
+		"sha256 TEXT", "context TEXT")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err = t.insert(f.Path, f.Size, f.Mode, f.UserName, f.GroupName, f.ModifiedTime,
+			f.ChangeTime, f.AccessTime, f.SHA256, f.Context)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Acquisition) dbSettings(db *sqlite.Writer) error {
+	t, err := createTable(db, "settings", "namespace TEXT", "name TEXT", "value TEXT")
+	if err != nil {
+		return err
+	}
+	for _, namespace := range []string{"system", "secure", "global"} {
+		data, err := utils.ReadOutput(filepath.Join(a.StoragePath, "settings",
+			fmt.Sprintf("settings_%s.txt", namespace)))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if !ok {
+				continue
+			}
+			err = t.insert(namespace, name, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Acquisition) dbDetections(db *sqlite.Writer) error {
+	t, err := createTable(db, "detections",
+		"engine TEXT", "severity TEXT", "title TEXT", "source TEXT", "file TEXT",
+		"line INTEGER", "value TEXT", "package TEXT")
+	if err != nil {
+		return err
+	}
+	for _, d := range a.detections {
+		err = t.insert(d.Engine, d.Severity, d.Title, d.Source, d.File, d.Line, d.Value, d.Package)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Acquisition) dbTimeline(db *sqlite.Writer) error {
+	t, err := createTable(db, "timeline",
+		"datetime TEXT", "timestamp INTEGER", "timestamp_desc TEXT", "source TEXT",
+		"file TEXT", "message TEXT")
+	if err != nil {
+		return err
+	}
+
+	file, err := utils.OpenOutput(filepath.Join(a.StoragePath, TimelineFile))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var event TimelineEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		err = t.insert(event.Datetime, event.Timestamp, event.TimestampDesc, event.Source,
+			event.File, event.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// StoreDatabase writes the parsed results of the acquisition in a single
+// SQLite database, which can be queried with SQL.
+func (a *Acquisition) StoreDatabase() error {
+	if utils.OutputSinkEnabled() {
+		log.Info("Skipping the results database when encrypting outputs as they are written")
+		return nil
+	}
+
+	log.Info("Storing results in SQLite database...")
+
+	db, err := sqlite.Create(filepath.Join(a.StoragePath, DatabaseFile))
+	if err != nil {
+		return fmt.Errorf("failed to create database: %v", err)
+	}
+
+	for _, store := range []func(*sqlite.Writer) error{
+		a.dbAcquisition,
+		a.dbPackages,
+		a.dbProcesses,
+		a.dbFiles,
+		a.dbSettings,
+		a.dbDetections,
+		a.dbTimeline,
+	} {
+		err = store(db)
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("failed to store results in database: %v", err)
+		}
+	}
+
+	return db.Close()
+}
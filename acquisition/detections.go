@@ -0,0 +1,306 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/indicators"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+	"github.com/mvt-project/androidqf/yara"
+)
+
+const (
+	EngineIOC        = "ioc"
+	EngineYara       = "yara"
+	EngineHeuristic  = "heuristic"
+	EngineVirusTotal = "virustotal"
+
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// Detection is a single finding, with a pointer to the evidence supporting it
+// in the acquisition folder.
+type Detection struct {
+	Engine   string `json:"engine"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Source   string `json:"source"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Value    string `json:"value"`
+	// Package is the installed package the finding is about, if any.
+	Package string `json:"package,omitempty"`
+	// Plain-language explanation of the finding and what to do about it.
+	Explanation string `json:"explanation,omitempty"`
+	NextStep    string `json:"next_step,omitempty"`
+}
+
+type DetectionsReport struct {
+	Found      bool        `json:"found"`
+	Count      int         `json:"count"`
+	Detections []Detection `json:"detections"`
+}
+
+// AddDetection records a finding to be stored in detections.json.
+func (a *Acquisition) AddDetection(d Detection) {
+	a.explain(&d)
+	a.detections = append(a.detections, d)
+}
+
+// ScanYara scans all the files collected so far with the given YARA rules.
+func (a *Acquisition) ScanYara(rules []string) error {
+	if utils.OutputSinkEnabled() {
+		log.Warning("YARA scanning is not available when encrypting outputs as they are written")
+		return nil
+	}
+	if !yara.Enabled() {
+		log.Warning("YARA rules were configured, but this build of androidqf does not support YARA")
+		return nil
+	}
+
+	log.Info("Scanning collected files with YARA rules. This might take a while...")
+
+	matches, err := yara.ScanFolder(rules, a.StoragePath)
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		log.Warningf("YARA rule %s matched on %s", match.Rule, match.File)
+		a.AddDetection(Detection{
+			Engine:   EngineYara,
+			Severity: SeverityHigh,
+			Title:    fmt.Sprintf("YARA rule %s matched", match.Rule),
+			Source:   match.Namespace,
+			File:     match.File,
+			Value:    match.Rule,
+		})
+	}
+
+	return nil
+}
+
+func (a *Acquisition) readJSON(name string, v any) error {
+	data, err := utils.ReadOutput(filepath.Join(a.StoragePath, name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (a *Acquisition) addIOCDetection(ioc indicators.Indicator, file string, line int, pkg string) {
+	title := fmt.Sprintf("Match for indicator %s", ioc.Value)
+	if ioc.Name != "" {
+		title = fmt.Sprintf("Match for indicator %s of %s", ioc.Value, ioc.Name)
+	}
+	log.Warningf("%s in %s", title, file)
+	a.AddDetection(Detection{
+		Engine:   EngineIOC,
+		Severity: SeverityCritical,
+		Title:    title,
+		Source:   ioc.Source,
+		File:     file,
+		Line:     line,
+		Value:    ioc.Value,
+		Package:  pkg,
+	})
+}
+
+// matchTextFiles looks for domain indicators in the text outputs of the
+// acquisition, such as logcat and dumpsys.
+func (a *Acquisition) matchTextFiles(domains []indicators.Indicator) {
+	if len(domains) == 0 {
+		return
+	}
+
+	paths := []string{}
+	if entries := utils.OutputEntries(); entries != nil {
+		for path := range entries {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+	} else {
+		_ = filepath.Walk(a.StoragePath, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+	}
+
+	for _, path := range paths {
+		if filepath.Ext(path) != ".txt" {
+			continue
+		}
+		a.matchTextFile(domains, path)
+	}
+}
+
+func (a *Acquisition) matchTextFile(domains []indicators.Indicator, path string) {
+	file, err := utils.OpenOutput(path)
+	if err != nil {
+		log.Debugf("Unable to check %s against indicators: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	relPath, _ := filepath.Rel(a.StoragePath, path)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.ToLower(scanner.Text())
+		for _, ioc := range domains {
+			if strings.Contains(line, strings.ToLower(ioc.Value)) {
+				a.addIOCDetection(ioc, filepath.ToSlash(relPath), lineNumber, "")
+			}
+		}
+	}
+}
+
+// MatchIndicators checks the collected data against the loaded indicators of
+// compromise.
+func (a *Acquisition) MatchIndicators() {
+	if len(a.IOCs) == 0 {
+		return
+	}
+
+	log.Info("Checking collected data against indicators of compromise...")
+
+	byType := map[string][]indicators.Indicator{}
+	for _, ioc := range a.IOCs {
+		byType[ioc.Type] = append(byType[ioc.Type], ioc)
+	}
+
+	var packages []adb.Package
+	if err := a.readJSON("packages/packages.json", &packages); err == nil {
+		for _, pkg := range packages {
+			for _, ioc := range byType[indicators.TypeAppID] {
+				if pkg.Name == ioc.Value {
+					a.addIOCDetection(ioc, "packages/packages.json", 0, pkg.Name)
+				}
+			}
+			for _, file := range pkg.Files {
+				for _, ioc := range byType[indicators.TypeFileSHA256] {
+					if file.SHA256 != "" && strings.EqualFold(file.SHA256, ioc.Value) {
+						a.addIOCDetection(ioc, "packages/packages.json", 0, pkg.Name)
+					}
+				}
+			}
+		}
+	}
+
+	if processes, err := a.readProcesses(); err == nil {
+		for _, process := range processes {
+			for _, ioc := range byType[indicators.TypeProcess] {
+				if process.Filename == ioc.Value || process.Filename == "("+ioc.Value+")" {
+					a.addIOCDetection(ioc, ProcessesFile, 0, "")
+				}
+			}
+		}
+	}
+
+	var files []adb.FileInfo
+	if err := a.readJSON("files/files.json", &files); err == nil {
+		for _, file := range files {
+			for _, ioc := range byType[indicators.TypeFilePath] {
+				if file.Path == ioc.Value {
+					a.addIOCDetection(ioc, "files/files.json", 0, "")
+				}
+			}
+			for _, ioc := range byType[indicators.TypeFileName] {
+				if filepath.Base(file.Path) == ioc.Value {
+					a.addIOCDetection(ioc, "files/files.json", 0, "")
+				}
+			}
+		}
+	}
+
+	// Hashes of files computed on the device in hash-only mode.
+	for _, name := range []string{"temp/tmp_hashes.json", "logs/logs_hashes.json"} {
+		var fileHashes map[string]string
+		if err := a.readJSON(name, &fileHashes); err != nil {
+			continue
+		}
+		paths := make([]string, 0, len(fileHashes))
+		for path := range fileHashes {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			for _, ioc := range byType[indicators.TypeFileSHA256] {
+				if strings.EqualFold(fileHashes[path], ioc.Value) {
+					a.addIOCDetection(ioc, name, 0, "")
+				}
+			}
+		}
+	}
+
+	a.matchTextFiles(byType[indicators.TypeDomain])
+}
+
+// FlaggedPackages returns the installed packages which were flagged by
+// indicators of compromise, VirusTotal or heuristics.
+func (a *Acquisition) FlaggedPackages() []string {
+	var packages []adb.Package
+	if err := a.readJSON("packages/packages.json", &packages); err != nil {
+		return []string{}
+	}
+
+	detected := map[string]bool{}
+	for _, d := range a.detections {
+		if d.Package != "" {
+			detected[d.Package] = true
+		}
+	}
+
+	flagged := []string{}
+	for _, pkg := range packages {
+		if len(pkg.Flags) > 0 || detected[pkg.Name] {
+			flagged = append(flagged, pkg.Name)
+		}
+	}
+
+	return flagged
+}
+
+// StoreDetections writes all findings to detections.json.
+func (a *Acquisition) StoreDetections() error {
+	report := DetectionsReport{
+		Found:      len(a.detections) > 0,
+		Count:      len(a.detections),
+		Detections: a.detections,
+	}
+	if report.Detections == nil {
+		report.Detections = []Detection{}
+	}
+
+	data, err := json.MarshalIndent(&report, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the detections: %v", err)
+	}
+
+	if report.Found {
+		log.Warningf("Found %d detections, see detections.json for details", report.Count)
+	} else {
+		log.Info("No detections found.")
+	}
+
+	return utils.WriteOutput(filepath.Join(a.StoragePath, "detections.json"), data)
+}
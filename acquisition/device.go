@@ -0,0 +1,62 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/adb"
+)
+
+// DeviceInfo identifies the device and its software.
+type DeviceInfo struct {
+	Manufacturer   string `json:"manufacturer"`
+	Model          string `json:"model"`
+	Fingerprint    string `json:"fingerprint"`
+	AndroidVersion string `json:"android_version"`
+	APILevel       int    `json:"api_level"`
+	PatchLevel     string `json:"patch_level"`
+	Serial         string `json:"serial"`
+	// Primary ABI, used to pick the collector binary.
+	ABI  string   `json:"abi"`
+	ABIs []string `json:"abis"`
+}
+
+// getDeviceInfo reads the details of the device from its system properties.
+func getDeviceInfo() (*DeviceInfo, error) {
+	abi, err := adb.Client.Shell("getprop", "ro.product.cpu.abi")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &DeviceInfo{ABI: abi, ABIs: []string{}}
+	info.Manufacturer, _ = adb.Client.Shell("getprop", "ro.product.manufacturer")
+	info.Model, _ = adb.Client.Shell("getprop", "ro.product.model")
+	info.Fingerprint, _ = adb.Client.Shell("getprop", "ro.build.fingerprint")
+	info.AndroidVersion, _ = adb.Client.Shell("getprop", "ro.build.version.release")
+	info.PatchLevel, _ = adb.Client.Shell("getprop", "ro.build.version.security_patch")
+
+	sdk, _ := adb.Client.Shell("getprop", "ro.build.version.sdk")
+	info.APILevel, _ = strconv.Atoi(sdk)
+
+	info.Serial = adb.Client.Serial
+	if info.Serial == "" {
+		info.Serial, _ = adb.Client.Shell("getprop", "ro.serialno")
+	}
+
+	abis, _ := adb.Client.Shell("getprop", "ro.product.cpu.abilist")
+	for _, entry := range strings.Split(abis, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			info.ABIs = append(info.ABIs, entry)
+		}
+	}
+	if len(info.ABIs) == 0 && abi != "" {
+		info.ABIs = append(info.ABIs, abi)
+	}
+
+	return info, nil
+}
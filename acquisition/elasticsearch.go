@@ -0,0 +1,176 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/config"
+	"github.com/mvt-project/androidqf/elasticsearch"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type esExporter struct {
+	acq     *Acquisition
+	indexer *elasticsearch.Indexer
+	prefix  string
+}
+
+// add indexes v in the index of the given artifact, together with the
+// details identifying the acquisition.
+func (e *esExporter) add(artifact string, timestamp time.Time, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	doc := map[string]any{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	doc["@timestamp"] = timestamp.UTC().Format(time.RFC3339Nano)
+	doc["acquisition_uuid"] = e.acq.UUID
+	doc["case_id"] = e.acq.CaseID
+	doc["artifact"] = artifact
+
+	return e.indexer.Add(fmt.Sprintf("%s-%s", e.prefix, artifact), doc)
+}
+
+func (e *esExporter) packages() error {
+	var packages []adb.Package
+	if err := e.acq.readJSON("packages/packages.json", &packages); err != nil {
+		log.Infof("Not exporting packages to Elasticsearch: %v", err)
+		return nil
+	}
+	for _, pkg := range packages {
+		if err := e.add("packages", e.acq.Started, &pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *esExporter) processes() error {
+	processes, err := e.acq.readProcesses()
+	if err != nil {
+		log.Infof("Not exporting processes to Elasticsearch: %v", err)
+		return nil
+	}
+	for _, process := range processes {
+		if err := e.add("processes", e.acq.Started, &process); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *esExporter) settings() error {
+	for _, namespace := range []string{"system", "secure", "global"} {
+		data, err := utils.ReadOutput(filepath.Join(e.acq.StoragePath, "settings",
+			fmt.Sprintf("settings_%s.txt", namespace)))
+		if err != nil {
+			log.Infof("Not exporting %s settings to Elasticsearch: %v", namespace, err)
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if !ok {
+				continue
+			}
+			err = e.add("settings", e.acq.Started, map[string]string{
+				"namespace": namespace,
+				"name":      name,
+				"value":     value,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *esExporter) detections() error {
+	for _, detection := range e.acq.detections {
+		if err := e.add("detections", e.acq.Started, &detection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *esExporter) timeline() error {
+	file, err := utils.OpenOutput(filepath.Join(e.acq.StoragePath, TimelineFile))
+	if err != nil {
+		log.Infof("Not exporting the timeline to Elasticsearch: %v", err)
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var event TimelineEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		if err := e.add("timeline", time.UnixMicro(event.Timestamp), &event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ExportToElasticsearch bulk-indexes the parsed artifacts of the acquisition
+// (packages, processes, settings, detections and timeline events) into an
+// Elasticsearch or OpenSearch endpoint, one index per artifact.
+func (a *Acquisition) ExportToElasticsearch(cfg *config.ElasticsearchConfig) error {
+	if cfg.URL == "" {
+		return errors.New("no Elasticsearch URL configured")
+	}
+
+	e := &esExporter{
+		acq:     a,
+		indexer: elasticsearch.New(cfg.URL, cfg.Username, cfg.Password, cfg.APIKey),
+		prefix:  cfg.IndexPrefix,
+	}
+	if e.prefix == "" {
+		e.prefix = "androidqf"
+	}
+
+	log.Infof("Exporting results to Elasticsearch at %s...", cfg.URL)
+
+	for _, export := range []func() error{
+		e.packages,
+		e.processes,
+		e.settings,
+		e.detections,
+		e.timeline,
+	} {
+		err := export()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := e.indexer.Flush()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Exported %d documents to Elasticsearch", e.indexer.Count())
+
+	return nil
+}
@@ -0,0 +1,300 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// encryptedSink writes all outputs of the acquisition into a zip archive
+// which is encrypted with age as it is written, so that no plaintext is ever
+// stored on disk.
+//
+// Outputs which need to be read again later on, e.g. to check them against
+// indicators of compromise, and outputs which cannot be written to the
+// archive yet, are kept in a spool folder, encrypted with a key which only
+// exists in memory for the duration of the acquisition.
+type encryptedSink struct {
+	mu        sync.Mutex
+	root      string
+	prefix    string
+	file      *os.File
+	age       io.WriteCloser
+	zip       *zip.Writer
+	streaming bool
+	pending   []*sinkWriter
+	entries   map[string]utils.OutputEntry
+	spoolDir  string
+	spoolKey  *age.X25519Identity
+	spooled   map[string]string
+}
+
+func newEncryptedSink(root, encPath string, recipient age.Recipient) (*encryptedSink, error) {
+	spoolKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate spool key: %v", err)
+	}
+	spoolDir, err := os.MkdirTemp("", "androidqf-spool-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool folder: %v", err)
+	}
+
+	file, err := os.OpenFile(encPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		os.RemoveAll(spoolDir)
+		return nil, fmt.Errorf("unable to create encrypted file: %v", err)
+	}
+
+	w, err := age.Encrypt(file, recipient)
+	if err != nil {
+		file.Close()
+		os.RemoveAll(spoolDir)
+		return nil, fmt.Errorf("failed to create encrypted file: %v", err)
+	}
+
+	return &encryptedSink{
+		root:     root,
+		prefix:   filepath.Base(root),
+		file:     file,
+		age:      w,
+		zip:      zip.NewWriter(w),
+		entries:  map[string]utils.OutputEntry{},
+		spoolDir: spoolDir,
+		spoolKey: spoolKey,
+		spooled:  map[string]string{},
+	}, nil
+}
+
+// readBack returns true for the outputs which are read again during the
+// acquisition: text, JSON and CSV files, and files without extension such as
+// the hosts files.
+func readBack(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case "", ".txt", ".json", ".jsonl", ".csv":
+		return true
+	}
+	return false
+}
+
+func (s *encryptedSink) entryName(path string) string {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return s.prefix + "/" + filepath.ToSlash(rel)
+}
+
+func (s *encryptedSink) createSpool(w *sinkWriter) error {
+	file, err := os.CreateTemp(s.spoolDir, "spool-")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %v", err)
+	}
+	spool, err := age.Encrypt(file, s.spoolKey.Recipient())
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to encrypt spool file: %v", err)
+	}
+	w.spoolFile = file
+	w.spool = spool
+	return nil
+}
+
+func (s *encryptedSink) openSpool(spoolPath string) (io.ReadCloser, error) {
+	file, err := os.Open(spoolPath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(file, s.spoolKey)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decrypt spool file: %v", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, file}, nil
+}
+
+func (s *encryptedSink) Create(path string, buffered bool) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := &sinkWriter{
+		sink:     s,
+		path:     path,
+		hash:     sha256.New(),
+		readBack: readBack(path),
+	}
+
+	// Only one entry at a time can be streamed to the archive, the others
+	// are spooled until they can be written.
+	if !buffered && !s.streaming {
+		zw, err := s.zip.Create(s.entryName(path))
+		if err != nil {
+			return nil, err
+		}
+		w.zw = zw
+		s.streaming = true
+	}
+
+	if w.zw == nil || w.readBack {
+		err := s.createSpool(w)
+		if err != nil {
+			if w.zw != nil {
+				s.streaming = false
+			}
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (s *encryptedSink) Open(path string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spoolPath, ok := s.spooled[path]
+	if !ok {
+		return nil, fmt.Errorf("%s is not available in encrypt-at-write mode", path)
+	}
+	return s.openSpool(spoolPath)
+}
+
+func (s *encryptedSink) Entries() map[string]utils.OutputEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make(map[string]utils.OutputEntry, len(s.entries))
+	for path, entry := range s.entries {
+		entries[path] = entry
+	}
+	return entries
+}
+
+// flushPending writes the spooled entries once no entry is being streamed.
+func (s *encryptedSink) flushPending() error {
+	for len(s.pending) > 0 && !s.streaming {
+		w := s.pending[0]
+		s.pending = s.pending[1:]
+
+		zw, err := s.zip.Create(s.entryName(w.path))
+		if err != nil {
+			return err
+		}
+		spoolPath := w.spoolFile.Name()
+		r, err := s.openSpool(spoolPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(zw, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if !w.readBack {
+			os.Remove(spoolPath)
+		}
+	}
+	return nil
+}
+
+// Close writes the remaining spooled entries, finalizes the archive and
+// deletes the spool folder.
+func (s *encryptedSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer os.RemoveAll(s.spoolDir)
+
+	s.streaming = false
+	err := s.flushPending()
+	if err != nil {
+		return err
+	}
+	err = s.zip.Close()
+	if err != nil {
+		return err
+	}
+	err = s.age.Close()
+	if err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+type sinkWriter struct {
+	sink      *encryptedSink
+	path      string
+	zw        io.Writer
+	spool     io.WriteCloser
+	spoolFile *os.File
+	readBack  bool
+	hash      hash.Hash
+	size      int64
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	w.hash.Write(p)
+	w.size += int64(len(p))
+
+	if w.spool != nil {
+		_, err := w.spool.Write(p)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if w.zw != nil {
+		return w.zw.Write(p)
+	}
+	return len(p), nil
+}
+
+func (w *sinkWriter) Close() error {
+	if w.spool != nil {
+		err := w.spool.Close()
+		if err == nil {
+			err = w.spoolFile.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write spool file: %v", err)
+		}
+	}
+
+	s := w.sink
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[w.path] = utils.OutputEntry{
+		Size:    w.size,
+		SHA256:  hex.EncodeToString(w.hash.Sum(nil)),
+		ModTime: time.Now(),
+	}
+	if w.readBack {
+		s.spooled[w.path] = w.spoolFile.Name()
+	}
+
+	if w.zw != nil {
+		s.streaming = false
+	} else {
+		s.pending = append(s.pending, w)
+	}
+
+	return s.flushPending()
+}
@@ -0,0 +1,187 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// Explanation describes a kind of finding in plain language, for support
+// staff to relay it to the owner of the device.
+type Explanation struct {
+	Text     string `json:"explanation"`
+	NextStep string `json:"next_step"`
+}
+
+// Explanations of the findings, by engine, or by module for the heuristics.
+var defaultExplanations = map[string]Explanation{
+	EngineIOC: {
+		"Something on the phone matches a known trace of spyware or stalkerware collected by researchers.",
+		"Treat the phone as compromised. Do not reset it, keep it away from sensitive conversations and contact a forensic expert.",
+	},
+	EngineYara: {
+		"A file collected from the phone matches a rule written by analysts to recognize malicious or suspicious content.",
+		"Share the metadata bundle with a forensic expert to confirm whether the file is malicious.",
+	},
+	EngineVirusTotal: {
+		"Antivirus engines consider one of the installed apps malicious.",
+		"Check with the owner whether they installed the app. If they did not, ask a forensic expert before removing it.",
+	},
+	"broadcast_receivers": {
+		"An app starts by itself when the phone is turned on and when messages or calls arrive, which spyware does to watch communications.",
+		"Ask the owner whether they know the app and what it is for.",
+	},
+	"carrier_apps": {
+		"An app not installed with the phone has special privileges granted by the SIM card.",
+		"Check whether the app belongs to the mobile operator of the owner.",
+	},
+	"checks": {
+		"A security setting of the phone is weaker than recommended.",
+		"Help the owner change the setting back, unless they need it.",
+	},
+	"clipboard": {
+		"An app read what the owner copied, which can include passwords and messages.",
+		"Ask the owner whether they know the app, and advise against copying sensitive information.",
+	},
+	"device_policy": {
+		"An app can manage the phone like a company would, for example to lock or wipe it, or to watch its use.",
+		"Ask the owner whether the phone is managed by their employer. If not, contact a forensic expert.",
+	},
+	"dns": {
+		"The phone sends the names of the websites it visits to a server which is not the default one.",
+		"Ask the owner whether they set this up themselves, for example for an ad blocker.",
+	},
+	"forensic_tooling": {
+		"Traces of tools used to extract data from phones were found.",
+		"Ask the owner whether the phone was taken from them or examined, for example at a border or by the police.",
+	},
+	"hosts": {
+		"The file deciding where some websites are reached was changed, which can redirect the owner to fake websites.",
+		"Contact a forensic expert.",
+	},
+	"install_unknown_apps": {
+		"An app is allowed to install other apps from outside the app store.",
+		"Ask the owner whether they need this. If not, turn the permission off.",
+	},
+	"lock_posture": {
+		"The phone is easier to unlock or to read than it should be, for example without a screen lock.",
+		"Help the owner set a strong screen lock.",
+	},
+	"media_usage": {
+		"An app used the camera or the microphone while it was not on screen.",
+		"Ask the owner whether they know the app and why it would record in the background.",
+	},
+	"network": {
+		"The traffic of the phone goes through a tunnel such as a VPN.",
+		"Ask the owner whether they use a VPN app.",
+	},
+	"overlays": {
+		"An app can show content on top of other apps, which can be used to steal passwords.",
+		"Ask the owner whether they know the app. If not, turn the permission off.",
+	},
+	"package_baseline": {
+		"An app was found among the system apps, but the phone is not sold with it.",
+		"Contact a forensic expert.",
+	},
+	"package_relationships": {
+		"An app shares its identity or its privileges with another app, which can hide what it does.",
+		"Ask the owner whether they know both apps.",
+	},
+	"packages": {
+		"An installed app has a suspicious property, for example it was not installed from an app store.",
+		"Ask the owner whether they installed the app themselves.",
+	},
+	"persistence": {
+		"An app keeps running in the background for a long time or wakes up at precise times.",
+		"Ask the owner whether they know the app.",
+	},
+	"recheck": {
+		"Something changed on the phone since the previous check.",
+		"Ask the owner whether they installed or updated apps since then.",
+	},
+	"risk_matrix": {
+		"An app has many powerful permissions at the same time, such as reading messages, location and the microphone.",
+		"Review the app with the owner and remove permissions it does not need.",
+	},
+	"root_frameworks": {
+		"The phone appears to be rooted, which removes some of the protections of Android.",
+		"Ask the owner whether they rooted the phone themselves. If not, contact a forensic expert.",
+	},
+	"search": {
+		"A word of interest to the investigation was found on the phone.",
+		"Share the finding with the analyst who configured the search.",
+	},
+	"security_patch": {
+		"The phone misses security updates against attacks used in the wild.",
+		"Help the owner install the latest updates, or advise a phone which still receives them.",
+	},
+	"sensors": {
+		"An app is reading the motion sensors, which can reveal the activity of the owner.",
+		"Ask the owner whether they know the app, for example a fitness app.",
+	},
+	"system_integrity": {
+		"A file of the operating system differs from the one shipped by the manufacturer.",
+		"Do not reset the phone and contact a forensic expert.",
+	},
+	"trackers": {
+		"The phone warned about an unknown tracking device, such as an AirTag, moving with the owner.",
+		"Help the owner look for the tracker in their belongings and car, and consider contacting a support organization.",
+	},
+	"usage_access": {
+		"An app can see which apps the owner uses and when.",
+		"Ask the owner whether they know the app. If not, turn the permission off.",
+	},
+	"webview": {
+		"The component used by apps to show web pages is not the standard one, and could see what the owner does online.",
+		"Contact a forensic expert.",
+	},
+}
+
+// LoadExplanations replaces the explanations of the findings with those in
+// the JSON file at location, for example translated ones. Findings missing
+// from the file keep their default explanation.
+func (a *Acquisition) LoadExplanations(location string) error {
+	data, err := utils.ReadLocation(location)
+	if err != nil {
+		return err
+	}
+	var explanations map[string]Explanation
+	err = json.Unmarshal(data, &explanations)
+	if err != nil {
+		return fmt.Errorf("failed to parse explanations: %v", err)
+	}
+	a.explanations = explanations
+	return nil
+}
+
+// DefaultExplanations returns the default explanations in the format of the
+// file loaded by LoadExplanations, to be translated.
+func DefaultExplanations() ([]byte, error) {
+	return json.MarshalIndent(defaultExplanations, "", "    ")
+}
+
+// explain fills the explanation of the detection, which depends on the
+// engine, or on the module for the heuristics.
+func (a *Acquisition) explain(d *Detection) {
+	if d.Explanation != "" {
+		return
+	}
+	key := d.Engine
+	if d.Engine == EngineHeuristic {
+		key = d.Source
+	}
+	explanation, ok := a.explanations[key]
+	if !ok {
+		explanation, ok = defaultExplanations[key]
+	}
+	if ok {
+		d.Explanation = explanation.Text
+		d.NextStep = explanation.NextStep
+	}
+}
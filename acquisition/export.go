@@ -0,0 +1,108 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// metadataFiles are the files of an acquisition which are included in a
+// metadata bundle. They describe the device and the findings, but contain
+// no file content and no messages.
+var metadataFiles = []string{
+	"acquisition.json",
+	"detections.json",
+	"hashes.csv",
+	"*/manifest.json",
+	"*/*_hashes.json",
+	"packages/packages.json",
+	"settings/*.txt",
+}
+
+func isMetadataFile(relPath string) bool {
+	for _, pattern := range metadataFiles {
+		if ok, _ := path.Match(pattern, relPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportMetadata creates a zip archive at dest with only the metadata of the
+// acquisition stored in folder, small enough to be shared with a remote
+// expert for a second opinion. It returns the number of files exported.
+func ExportMetadata(folder, dest string) (int, error) {
+	stat, err := os.Stat(folder)
+	if err != nil {
+		return 0, err
+	}
+	if !stat.IsDir() {
+		return 0, fmt.Errorf("%s is not an acquisition folder", folder)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create bundle: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	count := 0
+	err = filepath.Walk(folder, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(folder, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !isMetadataFile(relPath) {
+			return nil
+		}
+
+		src, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+		header.Method = zip.Deflate
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, src)
+		if err != nil {
+			return err
+		}
+
+		count++
+		return nil
+	})
+	if err != nil {
+		archive.Close()
+		return 0, fmt.Errorf("failed to add files to bundle: %v", err)
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to write bundle: %v", err)
+	}
+
+	return count, nil
+}
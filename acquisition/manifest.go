@@ -0,0 +1,151 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/botherder/go-savetime/hashes"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// ManifestFile is a file produced by a module.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ModuleManifest describes the output of a single module, so that module
+// folders can be shared or ingested independently.
+type ModuleManifest struct {
+	Module           string         `json:"module"`
+	AndroidQFVersion string         `json:"androidqf_version"`
+	AcquisitionUUID  string         `json:"acquisition_uuid"`
+	Started          time.Time      `json:"started"`
+	Completed        time.Time      `json:"completed"`
+	Error            string         `json:"error,omitempty"`
+	Commands         []string       `json:"commands"`
+	Files            []ManifestFile `json:"files"`
+}
+
+// ModulePath returns the folder in which the given module stores its output.
+func (a *Acquisition) ModulePath(module string) string {
+	return filepath.Join(a.StoragePath, module)
+}
+
+// StoreModuleManifest writes manifest.json in the folder of the given module,
+// listing the files produced and the adb commands which produced them.
+func (a *Acquisition) StoreModuleManifest(module string, started time.Time, commands []string, runErr error) error {
+	modulePath := a.ModulePath(module)
+	manifest := ModuleManifest{
+		Module:           module,
+		AndroidQFVersion: a.AndroidQFVersion,
+		AcquisitionUUID:  a.UUID,
+		Started:          started,
+		Completed:        time.Now().UTC(),
+		Commands:         commands,
+		Files:            []ManifestFile{},
+	}
+	if manifest.Commands == nil {
+		manifest.Commands = []string{}
+	}
+	if runErr != nil {
+		manifest.Error = runErr.Error()
+	}
+
+	var err error
+	manifest.Files, err = moduleFiles(modulePath)
+	if err != nil {
+		return fmt.Errorf("failed to list files of module %s: %v", module, err)
+	}
+	a.recordModuleMetrics(&manifest)
+
+	return storeManifest(modulePath, &manifest)
+}
+
+// UpdateModuleManifest refreshes the list of files in the manifest of a
+// module, after its output was changed once the module completed.
+func (a *Acquisition) UpdateModuleManifest(module string) error {
+	modulePath := a.ModulePath(module)
+	data, err := utils.ReadOutput(filepath.Join(modulePath, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of module %s: %v", module, err)
+	}
+	var manifest ModuleManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return fmt.Errorf("failed to parse manifest of module %s: %v", module, err)
+	}
+
+	manifest.Files, err = moduleFiles(modulePath)
+	if err != nil {
+		return fmt.Errorf("failed to list files of module %s: %v", module, err)
+	}
+
+	return storeManifest(modulePath, &manifest)
+}
+
+func storeManifest(modulePath string, manifest *ModuleManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the module manifest: %v", err)
+	}
+
+	return utils.WriteOutput(filepath.Join(modulePath, "manifest.json"), data)
+}
+
+// moduleFiles lists the files in the folder of a module, except the
+// manifest itself.
+func moduleFiles(modulePath string) ([]ManifestFile, error) {
+	files := []ManifestFile{}
+
+	if entries := utils.OutputEntries(); entries != nil {
+		for filePath, entry := range entries {
+			relPath, err := filepath.Rel(modulePath, filePath)
+			if err != nil || strings.HasPrefix(relPath, "..") || relPath == "manifest.json" {
+				continue
+			}
+			files = append(files, ManifestFile{
+				Path:   filepath.ToSlash(relPath),
+				Size:   entry.Size,
+				SHA256: entry.SHA256,
+			})
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+		return files, nil
+	}
+
+	err := filepath.Walk(modulePath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Base(filePath) == "manifest.json" {
+			return nil
+		}
+
+		sha256, err := hashes.FileSHA256(filePath)
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(modulePath, filePath)
+		files = append(files, ManifestFile{
+			Path:   filepath.ToSlash(relPath),
+			Size:   info.Size(),
+			SHA256: sha256,
+		})
+		return nil
+	})
+	return files, err
+}
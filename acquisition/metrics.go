@@ -0,0 +1,294 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetricsFile records how the acquisition went, for quality assurance. It
+// contains no data from the device.
+const MetricsFile = "metrics.json"
+
+// ModuleMetrics records how a module went. A module run again to retry its
+// failure is counted twice in Runs.
+type ModuleMetrics struct {
+	Module      string  `json:"module"`
+	Runs        int     `json:"runs"`
+	Seconds     float64 `json:"seconds"`
+	Files       int     `json:"files"`
+	Bytes       int64   `json:"bytes"`
+	Commands    int     `json:"commands"`
+	FailedPulls int     `json:"failed_pulls"`
+	Failed      bool    `json:"failed"`
+	ErrorClass  string  `json:"error_class,omitempty"`
+}
+
+// Metrics records the duration of the modules, the data transferred and the
+// failures of an acquisition.
+type Metrics struct {
+	AcquisitionUUID  string          `json:"acquisition_uuid"`
+	AndroidQFVersion string          `json:"androidqf_version"`
+	Profile          string          `json:"profile"`
+	APILevel         int             `json:"api_level"`
+	Resumed          bool            `json:"resumed"`
+	Started          time.Time       `json:"started"`
+	Seconds          float64         `json:"seconds"`
+	Bytes            int64           `json:"bytes"`
+	FilesPulled      int             `json:"files_pulled"`
+	Retried          int             `json:"retried"`
+	StillFailed      int             `json:"still_failed"`
+	BatteryPauses    int             `json:"battery_pauses"`
+	ErrorClasses     map[string]int  `json:"error_classes"`
+	Modules          []ModuleMetrics `json:"modules"`
+}
+
+// errorClass groups error messages by their likely cause, without keeping
+// the paths or other details they might contain.
+func errorClass(message string) string {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "timed out") || strings.Contains(message, "timeout") ||
+		strings.Contains(message, "deadline exceeded") || strings.Contains(message, "killed"):
+		return "timeout"
+	case strings.Contains(message, "device offline") || strings.Contains(message, "device not found") ||
+		strings.Contains(message, "no devices") || strings.Contains(message, "unauthorized") ||
+		strings.Contains(message, "connection reset") || strings.Contains(message, "broken pipe"):
+		return "disconnected"
+	case strings.Contains(message, "permission denied") || strings.Contains(message, "not permitted"):
+		return "permission_denied"
+	case strings.Contains(message, "no such file") || strings.Contains(message, "does not exist") ||
+		strings.Contains(message, "not found"):
+		return "not_found"
+	case strings.Contains(message, "no space left") || strings.Contains(message, "disk full"):
+		return "no_space"
+	case strings.Contains(message, "parse") || strings.Contains(message, "unmarshal") ||
+		strings.Contains(message, "invalid character"):
+		return "parse_error"
+	}
+	return "other"
+}
+
+// EnableMetrics records the metrics of the acquisition, stored with
+// StoreMetrics.
+func (a *Acquisition) EnableMetrics(resumed bool) {
+	a.metrics = &Metrics{
+		AcquisitionUUID:  a.UUID,
+		AndroidQFVersion: a.AndroidQFVersion,
+		Resumed:          resumed,
+		Started:          time.Now().UTC(),
+		ErrorClasses:     map[string]int{},
+		Modules:          []ModuleMetrics{},
+	}
+}
+
+// recordModuleMetrics adds the metrics of a module which completed, from its
+// manifest and the files it failed to pull.
+func (a *Acquisition) recordModuleMetrics(manifest *ModuleManifest) {
+	if a.metrics == nil {
+		return
+	}
+
+	var entry *ModuleMetrics
+	for i := range a.metrics.Modules {
+		if a.metrics.Modules[i].Module == manifest.Module {
+			entry = &a.metrics.Modules[i]
+		}
+	}
+	if entry == nil {
+		a.metrics.Modules = append(a.metrics.Modules, ModuleMetrics{Module: manifest.Module})
+		entry = &a.metrics.Modules[len(a.metrics.Modules)-1]
+	}
+
+	entry.Runs++
+	entry.Seconds += manifest.Completed.Sub(manifest.Started).Seconds()
+	entry.Commands += len(manifest.Commands)
+	entry.Files = len(manifest.Files)
+	entry.Bytes = 0
+	for _, file := range manifest.Files {
+		entry.Bytes += file.Size
+	}
+	entry.Failed = manifest.Error != ""
+	entry.ErrorClass = ""
+	if entry.Failed {
+		entry.ErrorClass = errorClass(manifest.Error)
+	}
+	entry.FailedPulls = 0
+	for _, item := range a.checkpoint.FailedItems {
+		if item.Kind == FailedPull && item.Module == manifest.Module {
+			entry.FailedPulls++
+		}
+	}
+}
+
+// StoreMetrics writes the metrics of the acquisition, if enabled. They are
+// stored in the acquisition folder or, if the acquisition was encrypted,
+// next to the encrypted file, so that they can be aggregated with `androidqf
+// stats` without decrypting it.
+func (a *Acquisition) StoreMetrics(retried, stillFailed int) error {
+	if a.metrics == nil {
+		return nil
+	}
+	m := a.metrics
+	m.Profile = a.Profile
+	if a.Device != nil {
+		m.APILevel = a.Device.APILevel
+	}
+	m.Seconds = time.Since(m.Started).Seconds()
+	m.FilesPulled = a.checkpoint.PulledFiles
+	m.Retried = retried
+	m.StillFailed = stillFailed
+	if a.Power != nil {
+		m.BatteryPauses = a.Power.Pauses
+	}
+	m.Bytes = 0
+	for _, module := range m.Modules {
+		m.Bytes += module.Bytes
+	}
+	m.ErrorClasses = map[string]int{}
+	for _, item := range a.checkpoint.FailedItems {
+		m.ErrorClasses[errorClass(item.Error)]++
+	}
+	for _, module := range m.Modules {
+		if module.Failed {
+			m.ErrorClasses[module.ErrorClass]++
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the metrics: %v", err)
+	}
+	path := filepath.Join(a.StoragePath, MetricsFile)
+	if a.encFilePath != "" {
+		path = strings.TrimSuffix(a.encFilePath, ".zip.age") + "." + MetricsFile
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ModuleStats aggregates the metrics of a module across acquisitions.
+type ModuleStats struct {
+	Module        string         `json:"module"`
+	Acquisitions  int            `json:"acquisitions"`
+	Failures      int            `json:"failures"`
+	MedianSeconds float64        `json:"median_seconds"`
+	MaxSeconds    float64        `json:"max_seconds"`
+	Bytes         int64          `json:"bytes"`
+	FailedPulls   int            `json:"failed_pulls"`
+	ErrorClasses  map[string]int `json:"error_classes"`
+	seconds       []float64
+}
+
+// Stats aggregates the metrics of several acquisitions.
+type Stats struct {
+	Acquisitions  int            `json:"acquisitions"`
+	MedianSeconds float64        `json:"median_seconds"`
+	Bytes         int64          `json:"bytes"`
+	Retried       int            `json:"retried"`
+	StillFailed   int            `json:"still_failed"`
+	BatteryPauses int            `json:"battery_pauses"`
+	ErrorClasses  map[string]int `json:"error_classes"`
+	Modules       []*ModuleStats `json:"modules"`
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// AggregateMetrics aggregates the metrics found in the given folders, which
+// can be acquisition folders or folders containing acquisitions.
+func AggregateMetrics(folders []string) (*Stats, error) {
+	stats := &Stats{ErrorClasses: map[string]int{}, Modules: []*ModuleStats{}}
+	modules := map[string]*ModuleStats{}
+	seconds := []float64{}
+	// Acquisitions found through overlapping folders are counted once.
+	seen := map[string]bool{}
+
+	for _, folder := range folders {
+		err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (info.Name() != MetricsFile && !strings.HasSuffix(info.Name(), "."+MetricsFile)) {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var m Metrics
+			if json.Unmarshal(data, &m) != nil || m.AcquisitionUUID == "" || seen[m.AcquisitionUUID] {
+				return nil
+			}
+			seen[m.AcquisitionUUID] = true
+
+			stats.Acquisitions++
+			seconds = append(seconds, m.Seconds)
+			stats.Bytes += m.Bytes
+			stats.Retried += m.Retried
+			stats.StillFailed += m.StillFailed
+			stats.BatteryPauses += m.BatteryPauses
+			for class, count := range m.ErrorClasses {
+				stats.ErrorClasses[class] += count
+			}
+			for _, module := range m.Modules {
+				ms, ok := modules[module.Module]
+				if !ok {
+					ms = &ModuleStats{Module: module.Module, ErrorClasses: map[string]int{}}
+					modules[module.Module] = ms
+					stats.Modules = append(stats.Modules, ms)
+				}
+				ms.Acquisitions++
+				ms.seconds = append(ms.seconds, module.Seconds)
+				if module.Seconds > ms.MaxSeconds {
+					ms.MaxSeconds = module.Seconds
+				}
+				ms.Bytes += module.Bytes
+				ms.FailedPulls += module.FailedPulls
+				if module.Failed {
+					ms.Failures++
+					ms.ErrorClasses[module.ErrorClass]++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stats.MedianSeconds = median(seconds)
+	for _, ms := range stats.Modules {
+		ms.MedianSeconds = median(ms.seconds)
+	}
+	// The modules which fail most, then the slowest, come first.
+	sort.Slice(stats.Modules, func(i, j int) bool {
+		mi, mj := stats.Modules[i], stats.Modules[j]
+		if mi.Failures != mj.Failures {
+			return mi.Failures > mj.Failures
+		}
+		if mi.MedianSeconds != mj.MedianSeconds {
+			return mi.MedianSeconds > mj.MedianSeconds
+		}
+		return mi.Module < mj.Module
+	})
+	return stats, nil
+}
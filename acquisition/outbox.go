@@ -0,0 +1,148 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	saveRuntime "github.com/botherder/go-savetime/runtime"
+	"github.com/mvt-project/androidqf/config"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/upload"
+)
+
+// OutboxEntry is an encrypted acquisition waiting to be uploaded.
+type OutboxEntry struct {
+	UUID      string    `json:"uuid"`
+	Path      string    `json:"path"`
+	Queued    time.Time `json:"queued"`
+	LastError string    `json:"last_error"`
+}
+
+// OutboxFilePath returns the path of the outbox, which lists the encrypted
+// acquisitions which could not be uploaded because the server was
+// unreachable.
+func OutboxFilePath() string {
+	return filepath.Join(saveRuntime.GetExecutableDirectory(), "outbox.json")
+}
+
+func loadOutbox() ([]OutboxEntry, error) {
+	entries := []OutboxEntry{}
+	data, err := os.ReadFile(OutboxFilePath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the outbox: %v", err)
+	}
+	return entries, nil
+}
+
+// storeOutbox writes the outbox, replacing it atomically.
+func storeOutbox(entries []OutboxEntry) error {
+	data, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the outbox: %v", err)
+	}
+	tmpPath := OutboxFilePath() + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write the outbox: %v", err)
+	}
+	return os.Rename(tmpPath, OutboxFilePath())
+}
+
+// Upload uploads the encrypted acquisition to the configured server. If the
+// upload fails, the acquisition is queued in the outbox, to be uploaded
+// later with FlushOutbox.
+func (a *Acquisition) Upload(cfg *config.UploadConfig) error {
+	if a.encFilePath == "" {
+		return errors.New("only encrypted acquisitions are uploaded, an age public key is required in key.txt")
+	}
+	client, err := upload.New(cfg.URL, cfg.Token)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Uploading the encrypted acquisition to %s...", client.URL)
+	uploadErr := client.Upload(a.encFilePath)
+	if uploadErr == nil {
+		log.Info("Acquisition uploaded successfully")
+		return nil
+	}
+	log.Warningf("Failed to upload the acquisition: %v", uploadErr)
+
+	path, err := filepath.Abs(a.encFilePath)
+	if err != nil {
+		return err
+	}
+	entries, err := loadOutbox()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, OutboxEntry{
+		UUID:      a.UUID,
+		Path:      path,
+		Queued:    time.Now().UTC(),
+		LastError: uploadErr.Error(),
+	})
+	err = storeOutbox(entries)
+	if err != nil {
+		return err
+	}
+	log.Info("The acquisition was queued in the outbox, run `androidqf flush-outbox` once online to upload it")
+
+	return nil
+}
+
+// FlushOutbox uploads the acquisitions queued in the outbox, and removes
+// those which were uploaded from it. It stops at the first failure, as the
+// server is most likely still unreachable, and returns the number of
+// acquisitions left in the outbox.
+func FlushOutbox(cfg *config.UploadConfig) (int, error) {
+	entries, err := loadOutbox()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	client, err := upload.New(cfg.URL, cfg.Token)
+	if err != nil {
+		return len(entries), err
+	}
+
+	remaining := []OutboxEntry{}
+	var uploadErr error
+	for _, entry := range entries {
+		if uploadErr != nil {
+			remaining = append(remaining, entry)
+			continue
+		}
+		if _, err := os.Stat(entry.Path); os.IsNotExist(err) {
+			log.Warningf("Removing acquisition %s from the outbox, %s no longer exists", entry.UUID, entry.Path)
+			continue
+		}
+
+		log.Infof("Uploading acquisition %s...", entry.UUID)
+		uploadErr = client.Upload(entry.Path)
+		if uploadErr != nil {
+			entry.LastError = uploadErr.Error()
+			remaining = append(remaining, entry)
+		}
+	}
+
+	err = storeOutbox(remaining)
+	if err != nil {
+		return len(remaining), err
+	}
+	return len(remaining), uploadErr
+}
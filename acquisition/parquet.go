@@ -0,0 +1,185 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/parquet"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// ParquetFolder is the folder, relative to the acquisition folder, in which
+// the Parquet exports are stored.
+const ParquetFolder = "parquet"
+
+type parquetTable struct {
+	w *parquet.Writer
+}
+
+// write adds a row, replacing identifiers in text values when the
+// acquisition is anonymized.
+func (p parquetTable) write(values ...any) error {
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			values[i] = utils.Anonymize(s)
+		}
+	}
+	return p.w.Write(values...)
+}
+
+func (a *Acquisition) createParquet(name string, columns ...parquet.Column) (parquetTable, error) {
+	w, err := parquet.Create(filepath.Join(a.StoragePath, ParquetFolder, name+".parquet"), columns)
+	return parquetTable{w: w}, err
+}
+
+// readJSONL calls fn with each line of the JSONL output at name. A missing
+// output is not an error.
+func (a *Acquisition) readJSONL(name string, fn func(line []byte) error) error {
+	file, err := utils.OpenOutput(filepath.Join(a.StoragePath, name))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		err = fn(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (a *Acquisition) parquetFiles() error {
+	var files []adb.FileInfo
+	_ = a.readJSON("files/files.json", &files)
+
+	t, err := a.createParquet("files",
+		parquet.Column{Name: "path", Type: parquet.String},
+		parquet.Column{Name: "size", Type: parquet.Int64},
+		parquet.Column{Name: "mode", Type: parquet.String},
+		parquet.Column{Name: "user_name", Type: parquet.String},
+		parquet.Column{Name: "group_name", Type: parquet.String},
+		parquet.Column{Name: "modified_time", Type: parquet.Int64},
+		parquet.Column{Name: "changed_time", Type: parquet.Int64},
+		parquet.Column{Name: "access_time", Type: parquet.Int64},
+		parquet.Column{Name: "sha256", Type: parquet.String},
+		parquet.Column{Name: "context", Type: parquet.String})
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err = t.write(f.Path, f.Size, f.Mode, f.UserName, f.GroupName, f.ModifiedTime,
+			f.ChangeTime, f.AccessTime, f.SHA256, f.Context)
+		if err != nil {
+			t.w.Close()
+			return err
+		}
+	}
+	return t.w.Close()
+}
+
+func (a *Acquisition) parquetLogcat() error {
+	t, err := a.createParquet("logcat",
+		parquet.Column{Name: "timestamp", Type: parquet.String},
+		parquet.Column{Name: "pid", Type: parquet.Int64},
+		parquet.Column{Name: "tid", Type: parquet.Int64},
+		parquet.Column{Name: "priority", Type: parquet.String},
+		parquet.Column{Name: "tag", Type: parquet.String},
+		parquet.Column{Name: "message", Type: parquet.String},
+		parquet.Column{Name: "buffer", Type: parquet.String})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"logcat/logcat.jsonl", "logcat/logcat_old.jsonl"} {
+		err = a.readJSONL(name, func(line []byte) error {
+			var entry struct {
+				Timestamp string `json:"timestamp"`
+				PID       int    `json:"pid"`
+				TID       int    `json:"tid"`
+				Priority  string `json:"priority"`
+				Tag       string `json:"tag"`
+				Message   string `json:"message"`
+				Buffer    string `json:"buffer"`
+			}
+			if json.Unmarshal(line, &entry) != nil {
+				return nil
+			}
+			return t.write(entry.Timestamp, entry.PID, entry.TID, entry.Priority, entry.Tag,
+				entry.Message, entry.Buffer)
+		})
+		if err != nil {
+			t.w.Close()
+			return err
+		}
+	}
+	return t.w.Close()
+}
+
+func (a *Acquisition) parquetTimeline() error {
+	t, err := a.createParquet("timeline",
+		parquet.Column{Name: "datetime", Type: parquet.String},
+		parquet.Column{Name: "timestamp", Type: parquet.Int64},
+		parquet.Column{Name: "timestamp_desc", Type: parquet.String},
+		parquet.Column{Name: "source", Type: parquet.String},
+		parquet.Column{Name: "file", Type: parquet.String},
+		parquet.Column{Name: "message", Type: parquet.String})
+	if err != nil {
+		return err
+	}
+	err = a.readJSONL(TimelineFile, func(line []byte) error {
+		var event TimelineEvent
+		if json.Unmarshal(line, &event) != nil {
+			return nil
+		}
+		return t.write(event.Datetime, event.Timestamp, event.TimestampDesc, event.Source,
+			event.File, event.Message)
+	})
+	if err != nil {
+		t.w.Close()
+		return err
+	}
+	return t.w.Close()
+}
+
+// StoreParquet exports the largest tables of the acquisition (file listing,
+// logcat entries and timeline) as Parquet files, which can be loaded
+// efficiently by analytics tools when aggregating many acquisitions.
+func (a *Acquisition) StoreParquet() error {
+	if utils.OutputSinkEnabled() {
+		log.Info("Skipping the Parquet export when encrypting outputs as they are written")
+		return nil
+	}
+
+	log.Info("Exporting results to Parquet files...")
+
+	err := os.MkdirAll(filepath.Join(a.StoragePath, ParquetFolder), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create Parquet folder: %v", err)
+	}
+
+	for _, store := range []func() error{
+		a.parquetFiles,
+		a.parquetLogcat,
+		a.parquetTimeline,
+	} {
+		err = store()
+		if err != nil {
+			return fmt.Errorf("failed to export results to Parquet: %v", err)
+		}
+	}
+
+	return nil
+}
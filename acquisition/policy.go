@@ -0,0 +1,204 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	saveRuntime "github.com/botherder/go-savetime/runtime"
+)
+
+// Policy restricts what androidqf may collect, according to the legal or
+// ethical constraints of an organization.
+type Policy struct {
+	Organization string    `json:"organization"`
+	Issued       time.Time `json:"issued"`
+	// The policy is refused after this date, if set.
+	Expires time.Time `json:"expires"`
+	// If set, only these modules may run.
+	AllowModules []string `json:"allow_modules"`
+	// Modules which may never run.
+	DenyModules []string `json:"deny_modules"`
+	// Never copy the content of files, only record their hashes.
+	HashOnly bool `json:"hash_only"`
+	// Modules which only record the hashes of files, such as sdcard.
+	HashOnlyModules []string `json:"hash_only_modules"`
+	// SHA256 of the public key which verified the signature of the policy.
+	Signer string `json:"signer,omitempty"`
+}
+
+// SignedPolicy is the policy file, with the Ed25519 signature of the compact
+// JSON policy.
+type SignedPolicy struct {
+	Policy    json.RawMessage `json:"policy"`
+	Signature string          `json:"signature"`
+}
+
+// PolicyFilePath returns the path to the policy enforced when none is given
+// on the command line.
+func PolicyFilePath() string {
+	return filepath.Join(saveRuntime.GetExecutableDirectory(), "policy.json")
+}
+
+// PolicyPublicKey is the base64 Ed25519 public key of the organization
+// which must have signed the policy. It is embedded at build time with
+// -ldflags "-X github.com/mvt-project/androidqf/acquisition.PolicyPublicKey=...",
+// so that it cannot be replaced next to the executable. Builds embedding a
+// key refuse to run without a valid policy.
+var PolicyPublicKey string
+
+// PolicyRequired returns true if this build embeds the public key of an
+// organization, and so only runs with a policy signed by it.
+func PolicyRequired() bool {
+	return PolicyPublicKey != ""
+}
+
+func decodeKey(data, name string, size int) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil || len(key) != size {
+		return nil, fmt.Errorf("%s does not contain a valid Ed25519 key", name)
+	}
+	return key, nil
+}
+
+// LoadPolicy loads the policy file at path, and verifies that it was signed
+// with the public key of the organization and has not expired.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var signed SignedPolicy
+	err = json.Unmarshal(data, &signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+
+	if !PolicyRequired() {
+		return nil, errors.New("this build of androidqf does not embed the public key of an organization, " +
+			"with which policies are verified")
+	}
+	publicKey, err := decodeKey(PolicyPublicKey, "the embedded public key", ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	// The policy is signed in its compact form, which does not change when
+	// the file is indented.
+	var raw bytes.Buffer
+	err = json.Compact(&raw, signed.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(publicKey, raw.Bytes(), signature) {
+		return nil, errors.New("the signature of the policy is invalid")
+	}
+
+	var policy Policy
+	err = json.Unmarshal(signed.Policy, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+	if !policy.Expires.IsZero() && time.Now().After(policy.Expires) {
+		return nil, fmt.Errorf("the policy expired on %s", policy.Expires.Format("2006-01-02"))
+	}
+	hash := sha256.Sum256(publicKey)
+	policy.Signer = hex.EncodeToString(hash[:])
+
+	return &policy, nil
+}
+
+// SignPolicy signs the JSON policy at policyPath with the Ed25519 private
+// key at keyPath, and returns the content of the policy file.
+func SignPolicy(policyPath, keyPath string) ([]byte, error) {
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	err = json.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := decodeKey(string(keyData), keyPath, ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(&policy)
+	if err != nil {
+		return nil, err
+	}
+	signed := SignedPolicy{
+		Policy:    raw,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, raw)),
+	}
+	return json.MarshalIndent(&signed, "", "    ")
+}
+
+// GeneratePolicyKey returns a new Ed25519 key pair to sign policies,
+// encoded in base64.
+func GeneratePolicyKey() (string, string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(publicKey), base64.StdEncoding.EncodeToString(privateKey), nil
+}
+
+// Allows returns true if the policy lets the module with the given name run.
+func (p *Policy) Allows(module string) bool {
+	if p == nil {
+		return true
+	}
+	for _, denied := range p.DenyModules {
+		if denied == module {
+			return false
+		}
+	}
+	if len(p.AllowModules) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowModules {
+		if allowed == module {
+			return true
+		}
+	}
+	return false
+}
+
+// HashOnlyFor returns true if the policy only lets the module with the
+// given name record the hashes of files.
+func (p *Policy) HashOnlyFor(module string) bool {
+	if p == nil {
+		return false
+	}
+	if p.HashOnly {
+		return true
+	}
+	for _, name := range p.HashOnlyModules {
+		if name == module {
+			return true
+		}
+	}
+	return false
+}
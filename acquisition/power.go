@@ -0,0 +1,48 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// keepAwake keeps the device awake during the acquisition, recording the
+// setting to restore at the end. When resuming, the setting recorded before
+// the interruption is kept, as the current one was changed by androidqf.
+func (a *Acquisition) keepAwake() {
+	original, err := adb.Client.KeepAwake()
+	if err != nil {
+		log.Warningf("Failed to keep the device awake, it might lock during the acquisition: %v", err)
+		return
+	}
+	if !a.Power.KeptAwake {
+		a.Power.KeptAwake = true
+		a.Power.StayOnWhilePluggedIn = original
+	}
+	a.storeCheckpoint()
+	log.Debug("Keeping the device awake while connected over USB")
+}
+
+// restorePower restores the stay awake setting of the device, and records
+// the battery level at the end of the acquisition.
+func (a *Acquisition) restorePower() {
+	if a.Power.KeptAwake {
+		err := adb.Client.RestoreStayOn(a.Power.StayOnWhilePluggedIn)
+		if err != nil {
+			log.Warningf("Failed to restore the stay awake setting of the device to %s: %v",
+				a.Power.StayOnWhilePluggedIn, err)
+		}
+	}
+	if level, _, err := adb.Client.Battery(); err == nil {
+		a.Power.BatteryEnd = level
+	}
+}
+
+// batteryPaused counts the pauses of the transfers because of a low battery.
+func (a *Acquisition) batteryPaused() {
+	a.Power.Pauses++
+}
@@ -0,0 +1,84 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// ProcessesFile is the output of the processes module, relative to the
+// acquisition folder.
+const ProcessesFile = "processes/processes.txt"
+
+// readProcesses returns the processes collected by the processes module,
+// which are stored as JSON when the collector was used and as the output of
+// `ps -A` otherwise.
+func (a *Acquisition) readProcesses() ([]adb.ProcessInfo, error) {
+	data, err := utils.ReadOutput(filepath.Join(a.StoragePath, ProcessesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []adb.ProcessInfo
+	if json.Unmarshal(data, &processes) == nil {
+		return processes, nil
+	}
+
+	return parsePs(string(data))
+}
+
+// parsePs parses the output of `ps -A`, whose columns are identified by the
+// header line.
+func parsePs(out string) ([]adb.ProcessInfo, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	header := strings.Fields(lines[0])
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	pidCol, hasPid := columns["PID"]
+	nameCol, hasName := columns["NAME"]
+	if !hasPid || !hasName {
+		return nil, errors.New("unrecognized ps output")
+	}
+
+	processes := []adb.ProcessInfo{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < len(header) {
+			continue
+		}
+		pid, err := strconv.ParseUint(fields[pidCol], 10, 32)
+		if err != nil {
+			continue
+		}
+		process := adb.ProcessInfo{
+			Pid: uint32(pid),
+			// The name is the last column and might contain spaces.
+			Filename: strings.Join(fields[nameCol:], " "),
+		}
+		if col, ok := columns["PPID"]; ok {
+			ppid, _ := strconv.ParseUint(fields[col], 10, 32)
+			process.Ppid = uint32(ppid)
+		}
+		if col, ok := columns["S"]; ok {
+			process.State = fields[col]
+		}
+		if col, ok := columns["LABEL"]; ok {
+			process.Context = fields[col]
+		}
+		processes = append(processes, process)
+	}
+
+	return processes, nil
+}
@@ -0,0 +1,76 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// RebootRecord records a reboot of the device between two phases of the
+// acquisition, so that the outputs of both phases can be told apart.
+type RebootRecord struct {
+	Requested    time.Time `json:"requested"`
+	Returned     time.Time `json:"returned"`
+	BootIDBefore string    `json:"boot_id_before"`
+	BootIDAfter  string    `json:"boot_id_after"`
+	// Whether the operator confirmed that the device was unlocked after the
+	// reboot, which is needed to access the data of the user.
+	Unlocked bool   `json:"unlocked"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Reboot reboots the device, waits for it to return, and uploads the
+// collector again, so that modules can collect data after the reboot.
+func (a *Acquisition) Reboot() error {
+	record := RebootRecord{Requested: time.Now().UTC()}
+	record.BootIDBefore, _ = adb.Client.BootID()
+
+	bootID, err := adb.Client.Reboot()
+	if err != nil {
+		record.Error = err.Error()
+		a.Reboots = append(a.Reboots, record)
+		a.storeCheckpoint()
+		return err
+	}
+	record.BootIDAfter = bootID
+	record.Returned = time.Now().UTC()
+	log.Info("The device rebooted")
+
+	// The data of the user is encrypted until the device is unlocked for
+	// the first time after booting.
+	log.Info("Unlock the device, and keep it connected.")
+	record.Unlocked = utils.Confirm("reboot_unlocked", "Is the device unlocked?")
+	if !record.Unlocked {
+		log.Warning("Continuing with a locked device, some data will not be available after the reboot")
+	}
+
+	if a.Power.KeptAwake {
+		// The setting survives the reboot, but the device might have locked
+		// while it booted.
+		if _, err := adb.Client.KeepAwake(); err != nil {
+			log.Debugf("Failed to keep the device awake after the reboot: %v", err)
+		}
+	}
+
+	if a.Collector != nil {
+		timeout := a.Collector.Timeout
+		coll, err := adb.Client.GetCollector(a.TmpDir, a.Device.ABI)
+		if err != nil {
+			log.Debugf("failed to upload collector after the reboot: %v", err)
+		} else {
+			coll.Timeout = timeout
+		}
+		a.Collector = coll
+	}
+
+	a.Reboots = append(a.Reboots, record)
+	a.storeCheckpoint()
+	return nil
+}
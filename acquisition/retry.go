@@ -0,0 +1,140 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+const (
+	// FailedItemsFile lists the files and modules which failed, so that
+	// they can be retried with `androidqf retry`.
+	FailedItemsFile = "failed.json"
+
+	FailedPull   = "pull"
+	FailedModule = "module"
+)
+
+// FailedItem is a file which could not be pulled from the device, or a
+// module which failed to run.
+type FailedItem struct {
+	Kind   string `json:"kind"`
+	Module string `json:"module"`
+	// Path of the file on the device, and where it is stored relative to
+	// the acquisition folder.
+	Remote string `json:"remote,omitempty"`
+	Local  string `json:"local,omitempty"`
+	Error  string `json:"error"`
+}
+
+// pullFailed records a file which could not be pulled by the module running.
+func (a *Acquisition) pullFailed(remotePath, localPath string, err error) {
+	relPath, relErr := filepath.Rel(a.StoragePath, localPath)
+	if relErr != nil {
+		relPath = localPath
+	}
+	a.checkpoint.FailedItems = append(a.checkpoint.FailedItems, FailedItem{
+		Kind:   FailedPull,
+		Module: a.checkpoint.CurrentModule,
+		Remote: remotePath,
+		Local:  filepath.ToSlash(relPath),
+		Error:  err.Error(),
+	})
+}
+
+// ModuleFailed records a module which returned an error.
+func (a *Acquisition) ModuleFailed(module string, err error) {
+	a.checkpoint.FailedItems = append(a.checkpoint.FailedItems, FailedItem{
+		Kind:   FailedModule,
+		Module: module,
+		Error:  err.Error(),
+	})
+}
+
+// FailedItems returns the files and modules which failed so far.
+func (a *Acquisition) FailedItems() []FailedItem {
+	return a.checkpoint.FailedItems
+}
+
+// RetryFailed tries again the files and modules which failed. Failed
+// modules are run again with rerun, which records them again if they still
+// fail, and the files pulled by them are not retried separately. It returns
+// the number of items which still failed.
+func (a *Acquisition) RetryFailed(rerun func(module string)) int {
+	items := a.checkpoint.FailedItems
+	a.checkpoint.FailedItems = []FailedItem{}
+
+	rerunModules := map[string]bool{}
+	for _, item := range items {
+		if item.Kind == FailedModule {
+			rerunModules[item.Module] = true
+		}
+	}
+
+	// Failures are recorded here, as the module running is not known.
+	onPullFailed := adb.Client.OnPullFailed
+	adb.Client.OnPullFailed = nil
+	updated := map[string]bool{}
+	for _, item := range items {
+		if item.Kind != FailedPull || rerunModules[item.Module] {
+			continue
+		}
+		log.Infof("Retrying to pull %s...", item.Remote)
+		localPath := filepath.Join(a.StoragePath, filepath.FromSlash(item.Local))
+		if !utils.OutputSinkEnabled() {
+			_ = os.MkdirAll(utils.LongPath(filepath.Dir(localPath)), 0o755)
+		}
+		_, err := adb.Client.Pull(item.Remote, localPath)
+		if err != nil {
+			log.Debugf("Failed to pull %s again: %v", item.Remote, err)
+			item.Error = err.Error()
+			a.checkpoint.FailedItems = append(a.checkpoint.FailedItems, item)
+			continue
+		}
+		if item.Module != "" {
+			updated[item.Module] = true
+		}
+	}
+	adb.Client.OnPullFailed = onPullFailed
+
+	for module := range updated {
+		err := a.UpdateModuleManifest(module)
+		if err != nil {
+			log.Debugf("Failed to update the manifest of module %s: %v", module, err)
+		}
+	}
+
+	for _, item := range items {
+		if item.Kind == FailedModule && rerunModules[item.Module] {
+			log.Infof("Running module %s again...", item.Module)
+			delete(rerunModules, item.Module)
+			rerun(item.Module)
+		}
+	}
+
+	a.storeCheckpoint()
+	return len(a.checkpoint.FailedItems)
+}
+
+// StoreFailedItems writes the list of the files and modules which failed.
+func (a *Acquisition) StoreFailedItems() error {
+	items := a.FailedItems()
+	if items == nil {
+		items = []FailedItem{}
+	}
+	data, err := json.MarshalIndent(&items, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the failed items: %v", err)
+	}
+	return utils.WriteOutput(filepath.Join(a.StoragePath, FailedItemsFile), data)
+}
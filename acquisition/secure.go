@@ -16,12 +16,91 @@ import (
 	"github.com/botherder/go-savetime/files"
 	saveRuntime "github.com/botherder/go-savetime/runtime"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
+// KeyFilePath returns the path to the age public key used to encrypt the
+// acquisition.
+func KeyFilePath() string {
+	return filepath.Join(saveRuntime.GetExecutableDirectory(), "key.txt")
+}
+
+func loadRecipient() (age.Recipient, error) {
+	publicKey, err := os.ReadFile(KeyFilePath())
+	if err != nil {
+		return nil, err
+	}
+	publicKeyStr := strings.TrimSpace(string(publicKey))
+
+	recipient, err := age.ParseX25519Recipient(publicKeyStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %q: %v", publicKeyStr, err)
+	}
+
+	return recipient, nil
+}
+
+// enableEncryptAtWrite redirects all the outputs of the acquisition to an
+// age encrypted archive, so that no plaintext is ever written to disk.
+func (a *Acquisition) enableEncryptAtWrite() error {
+	recipient, err := loadRecipient()
+	if err != nil {
+		return fmt.Errorf("encrypt-at-write requires a valid age public key in key.txt: %v", err)
+	}
+
+	encFilePath := filepath.Join(a.outputFolder(), fmt.Sprintf("%s.zip.age", a.UUID))
+	sink, err := newEncryptedSink(a.StoragePath, encFilePath, recipient)
+	if err != nil {
+		return err
+	}
+
+	a.sink = sink
+	a.encFilePath = encFilePath
+	utils.SetOutputSink(sink)
+
+	log.Infof("Encrypting all outputs as they are written to %s", encFilePath)
+
+	return nil
+}
+
+// closeEncryptedSink finalizes the encrypted archive. The acquisition folder
+// only contains empty folders, which are removed.
+func (a *Acquisition) closeEncryptedSink() error {
+	log.DisableFileLog()
+
+	utils.SetOutputSink(nil)
+	err := a.sink.Close()
+	a.sink = nil
+	if err != nil {
+		return fmt.Errorf("failed to close encrypted file: %v", err)
+	}
+	log.Infof("Acquisition successfully encrypted at %s", a.encFilePath)
+
+	err = os.RemoveAll(a.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to delete the acquisition folder: %v", err)
+	}
+
+	return nil
+}
+
+// outputFolder returns the folder in which encrypted acquisitions are
+// stored: the case folder, or the folder of the executable.
+func (a *Acquisition) outputFolder() string {
+	if a.caseFolder != "" {
+		return a.caseFolder
+	}
+	return saveRuntime.GetExecutableDirectory()
+}
+
 func (a *Acquisition) StoreSecurely() error {
-	cwd := saveRuntime.GetExecutableDirectory()
+	if a.sink != nil {
+		return a.closeEncryptedSink()
+	}
+
+	cwd := a.outputFolder()
 
-	keyFilePath := filepath.Join(cwd, "key.txt")
+	keyFilePath := KeyFilePath()
 	if _, err := os.Stat(keyFilePath); os.IsNotExist(err) {
 		return nil
 	}
@@ -40,16 +119,10 @@ func (a *Acquisition) StoreSecurely() error {
 
 	log.Info("Encrypting the compressed archive. This might take a while...")
 
-	publicKey, err := os.ReadFile(keyFilePath)
+	recipient, err := loadRecipient()
 	if err != nil {
 		return err
 	}
-	publicKeyStr := strings.TrimSpace(string(publicKey))
-
-	recipient, err := age.ParseX25519Recipient(publicKeyStr)
-	if err != nil {
-		return fmt.Errorf("failed to parse public key %q: %v", publicKeyStr, err)
-	}
 
 	zipFile, err := os.Open(zipFilePath)
 	if err != nil {
@@ -80,6 +153,7 @@ func (a *Acquisition) StoreSecurely() error {
 	}
 
 	log.Infof("Acquisition successfully encrypted at %s", encFilePath)
+	a.encFilePath = encFilePath
 
 	// TODO: we should securely wipe the files.
 	zipFile.Close()
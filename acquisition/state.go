@@ -0,0 +1,177 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// DeviceStateFile records the state of the device when the acquisition
+// started.
+const DeviceStateFile = "device_state.json"
+
+var (
+	wakefulnessRegexp  = regexp.MustCompile(`\bmWakefulness=(\w+)`)
+	displayPowerRegexp = regexp.MustCompile(`Display Power: state=(\w+)`)
+	keyguardRegexp     = regexp.MustCompile(`\b(?:mKeyguardShowing|mShowingLockscreen|isKeyguardShowing)=(true|false)`)
+	// mResumedActivity: ActivityRecord{8a2c1f u0 com.foo/.MainActivity t123}
+	resumedActivityRegexp = regexp.MustCompile(`ResumedActivity[:=]\s*ActivityRecord\{\S+ u\d+ ([\w.]+)/(\S+)`)
+	// 12: wlan0    inet 192.168.1.10/24 brd ...
+	interfaceRegexp = regexp.MustCompile(`^\d+:\s+(\S+)\s+inet\s`)
+)
+
+// DeviceState is a snapshot of the state of the device taken before any
+// module runs, as collecting data wakes the device up, changes the app in
+// the foreground and drains the battery.
+type DeviceState struct {
+	Captured      time.Time `json:"captured"`
+	DeviceTime    time.Time `json:"device_time"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	LastBoot      time.Time `json:"last_boot"`
+	// Awake, Asleep, Dreaming or Dozing.
+	Wakefulness     string `json:"wakefulness"`
+	ScreenOn        *bool  `json:"screen_on"`
+	KeyguardShowing *bool  `json:"keyguard_showing"`
+	ForegroundApp   string `json:"foreground_app"`
+	// Activity of the foreground app.
+	ForegroundActivity string `json:"foreground_activity"`
+	AirplaneMode       *bool  `json:"airplane_mode"`
+	WifiEnabled        *bool  `json:"wifi_enabled"`
+	MobileDataEnabled  *bool  `json:"mobile_data_enabled"`
+	BluetoothEnabled   *bool  `json:"bluetooth_enabled"`
+	// Network interfaces with an IPv4 address, other than loopback.
+	Interfaces   []string `json:"interfaces"`
+	BatteryLevel int      `json:"battery_level"`
+	Charging     bool     `json:"charging"`
+	// Errors of the commands which failed, the state they report is unknown.
+	Errors []string `json:"errors"`
+}
+
+func boolSetting(name string) (*bool, error) {
+	out, err := adb.Client.Shell("settings", "get", "global", name)
+	if err != nil {
+		return nil, err
+	}
+	switch out {
+	case "0":
+		value := false
+		return &value, nil
+	case "1", "2":
+		// Wi-Fi is 2 when it was turned on in airplane mode.
+		value := true
+		return &value, nil
+	}
+	return nil, nil
+}
+
+// captureDeviceState takes a snapshot of the device state. It only runs
+// light commands, and records failures rather than stopping.
+func captureDeviceState() *DeviceState {
+	state := &DeviceState{Captured: time.Now().UTC(), Interfaces: []string{}, Errors: []string{}}
+	fail := func(command string, err error) {
+		state.Errors = append(state.Errors, fmt.Sprintf("%s: %v", command, err))
+	}
+
+	// Read in one command, so that the boot time is not skewed.
+	out, err := adb.Client.Shell("cat /proc/uptime; date +%s")
+	if err != nil {
+		fail("uptime", err)
+	} else if lines := strings.Fields(out); len(lines) >= 3 {
+		uptime, err1 := strconv.ParseFloat(lines[0], 64)
+		seconds, err2 := strconv.ParseInt(lines[2], 10, 64)
+		if err1 == nil && err2 == nil {
+			state.UptimeSeconds = uptime
+			state.DeviceTime = time.Unix(seconds, 0).UTC()
+			state.LastBoot = state.DeviceTime.Add(-time.Duration(uptime * float64(time.Second))).Truncate(time.Second)
+		}
+	}
+
+	out, err = adb.Client.Shell("dumpsys power | grep -E 'mWakefulness=|Display Power: state='")
+	if err != nil && out == "" {
+		fail("dumpsys power", err)
+	} else {
+		if match := wakefulnessRegexp.FindStringSubmatch(out); match != nil {
+			state.Wakefulness = match[1]
+		}
+		if match := displayPowerRegexp.FindStringSubmatch(out); match != nil {
+			screenOn := match[1] == "ON"
+			state.ScreenOn = &screenOn
+		}
+	}
+
+	out, err = adb.Client.Shell("dumpsys activity activities | grep -E 'ResumedActivity|mKeyguardShowing'")
+	if err != nil && out == "" {
+		fail("dumpsys activity activities", err)
+	}
+	if match := resumedActivityRegexp.FindStringSubmatch(out); match != nil {
+		state.ForegroundApp = match[1]
+		state.ForegroundActivity = match[2]
+		if strings.HasPrefix(state.ForegroundActivity, ".") {
+			state.ForegroundActivity = match[1] + state.ForegroundActivity
+		}
+	}
+	if !keyguardRegexp.MatchString(out) {
+		// Older versions only report it in the window manager policy.
+		policy, _ := adb.Client.Shell("dumpsys window policy | grep -E 'mShowingLockscreen|isKeyguardShowing'")
+		out += "\n" + policy
+	}
+	if match := keyguardRegexp.FindStringSubmatch(out); match != nil {
+		showing := match[1] == "true"
+		state.KeyguardShowing = &showing
+	}
+
+	settings := []struct {
+		name  string
+		value **bool
+	}{
+		{"airplane_mode_on", &state.AirplaneMode},
+		{"wifi_on", &state.WifiEnabled},
+		{"mobile_data", &state.MobileDataEnabled},
+		{"bluetooth_on", &state.BluetoothEnabled},
+	}
+	for _, setting := range settings {
+		*setting.value, err = boolSetting(setting.name)
+		if err != nil {
+			fail("settings get global "+setting.name, err)
+		}
+	}
+
+	out, err = adb.Client.Shell("ip", "-o", "-4", "addr", "show")
+	if err != nil && out == "" {
+		fail("ip addr", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if match := interfaceRegexp.FindStringSubmatch(strings.TrimSpace(line)); match != nil && match[1] != "lo" {
+			state.Interfaces = append(state.Interfaces, match[1])
+		}
+	}
+
+	state.BatteryLevel, state.Charging, err = adb.Client.Battery()
+	if err != nil {
+		fail("dumpsys battery", err)
+	}
+
+	return state
+}
+
+// storeDeviceState writes the snapshot of the device state in the
+// acquisition folder.
+func (a *Acquisition) storeDeviceState(state *DeviceState) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the device state: %v", err)
+	}
+	return utils.WriteOutput(filepath.Join(a.StoragePath, DeviceStateFile), data)
+}
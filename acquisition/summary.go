@@ -0,0 +1,246 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// Maximum number of findings listed in the summary, the others are only
+// counted.
+const summaryMaxFindings = 15
+
+var severityOrder = map[string]int{
+	SeverityCritical: 0,
+	SeverityHigh:     1,
+	SeverityMedium:   2,
+	SeverityLow:      3,
+}
+
+// riskySetting is a device setting whose value weakens the protections of
+// the device.
+type riskySetting struct {
+	file        string
+	key         string
+	value       string
+	description string
+}
+
+var riskySettings = []riskySetting{
+	{"settings_global.txt", "package_verifier_enable", "0", "Google Play Protect app verification is disabled"},
+	{"settings_global.txt", "verifier_verify_adb_installs", "0", "Apps installed over USB are not verified"},
+	{"settings_global.txt", "development_settings_enabled", "1", "Developer options are enabled"},
+	{"settings_global.txt", "adb_wifi_enabled", "1", "Wireless debugging is enabled"},
+	{"settings_secure.txt", "install_non_market_apps", "1", "Installing apps from unknown sources is allowed"},
+	{"settings_global.txt", "install_non_market_apps", "1", "Installing apps from unknown sources is allowed"},
+}
+
+// readSettings parses an output of `cmd settings list` into a map.
+func (a *Acquisition) readSettings(name string) map[string]string {
+	settings := map[string]string{}
+	data, err := utils.ReadOutput(filepath.Join(a.StoragePath, "settings", name))
+	if err != nil {
+		return settings
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok {
+			settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return settings
+}
+
+// summaryRiskySettings returns the risky settings, SELinux mode and
+// security patch status of the device.
+func (a *Acquisition) summaryRiskySettings() []string {
+	risks := []string{}
+
+	files := map[string]map[string]string{}
+	for _, setting := range riskySettings {
+		if _, ok := files[setting.file]; !ok {
+			files[setting.file] = a.readSettings(setting.file)
+		}
+		if files[setting.file][setting.key] == setting.value {
+			risks = append(risks, fmt.Sprintf("%s (`%s=%s`)", setting.description, setting.key, setting.value))
+		}
+	}
+	if services := files["settings_secure.txt"]["enabled_accessibility_services"]; services != "" && services != "null" {
+		risks = append(risks, fmt.Sprintf("Accessibility services are enabled: `%s`", services))
+	}
+
+	selinux, err := utils.ReadOutput(filepath.Join(a.StoragePath, "selinux", "selinux.txt"))
+	if err == nil && strings.EqualFold(strings.TrimSpace(string(selinux)), "permissive") {
+		risks = append(risks, "SELinux is in permissive mode")
+	}
+
+	var patch struct {
+		SecurityPatch         string                `json:"security_patch"`
+		DaysSincePatch        int                   `json:"days_since_patch"`
+		Stale                 bool                  `json:"stale"`
+		UnpatchedExploitedCVE []utils.Vulnerability `json:"unpatched_exploited_cves"`
+	}
+	if a.readJSON("security_patch/security_patch.json", &patch) == nil {
+		if patch.Stale {
+			risks = append(risks, fmt.Sprintf("The security patch level (%s) is %d days old",
+				patch.SecurityPatch, patch.DaysSincePatch))
+		}
+		if len(patch.UnpatchedExploitedCVE) > 0 {
+			cves := []string{}
+			for _, vuln := range patch.UnpatchedExploitedCVE {
+				cves = append(cves, vuln.CVE)
+			}
+			risks = append(risks, fmt.Sprintf("Vulnerable to %d actively exploited vulnerabilities: %s",
+				len(cves), strings.Join(cves, ", ")))
+		}
+	}
+
+	return risks
+}
+
+// Summarize produces a short Markdown brief of the acquisition stored in
+// folder, with the device, the notable findings, risky settings and flagged
+// packages, to be pasted in a helpline ticket.
+func Summarize(folder string) (string, error) {
+	stat, err := os.Stat(folder)
+	if err != nil {
+		return "", err
+	}
+	if !stat.IsDir() {
+		return "", fmt.Errorf("%s is not an acquisition folder", folder)
+	}
+
+	a := &Acquisition{}
+	a.StoragePath = folder
+	err = a.readJSON("acquisition.json", a)
+	if err != nil {
+		return "", fmt.Errorf("failed to read acquisition.json: %v", err)
+	}
+	a.StoragePath = folder
+
+	var report DetectionsReport
+	_ = a.readJSON("detections.json", &report)
+	a.detections = report.Detections
+
+	var packages []adb.Package
+	_ = a.readJSON("packages/packages.json", &packages)
+	flags := map[string][]string{}
+	for _, pkg := range packages {
+		flags[pkg.Name] = pkg.Flags
+	}
+
+	var b strings.Builder
+	b.WriteString("# androidqf triage summary\n\n")
+
+	fmt.Fprintf(&b, "- **Acquisition:** %s, started %s UTC\n", a.UUID,
+		a.Started.UTC().Format("2006-01-02 15:04"))
+	if a.Device != nil {
+		fmt.Fprintf(&b, "- **Device:** %s %s, Android %s (API %d)\n", a.Device.Manufacturer,
+			a.Device.Model, a.Device.AndroidVersion, a.Device.APILevel)
+		fmt.Fprintf(&b, "- **Security patch:** %s\n", a.Device.PatchLevel)
+	}
+	fmt.Fprintf(&b, "- **androidqf version:** %s, profile %s\n", a.AndroidQFVersion, a.Profile)
+	if a.Budget != nil && len(a.Budget.Cuts) > 0 {
+		skipped := 0
+		for _, cut := range a.Budget.Cuts {
+			if cut.Action == BudgetSkipped {
+				skipped++
+			}
+		}
+		fmt.Fprintf(&b, "- **Time budget:** %s, %d modules skipped and %d degraded, see `time_budget` in `acquisition.json`\n",
+			time.Duration(a.Budget.Seconds*float64(time.Second)), skipped, len(a.Budget.Cuts)-skipped)
+	}
+
+	b.WriteString("\n## Notable findings\n\n")
+	detections := append([]Detection{}, a.detections...)
+	sort.SliceStable(detections, func(i, j int) bool {
+		return severityOrder[detections[i].Severity] < severityOrder[detections[j].Severity]
+	})
+	if len(detections) == 0 {
+		b.WriteString("No detections.\n")
+	}
+	for i, d := range detections {
+		if i == summaryMaxFindings {
+			fmt.Fprintf(&b, "- ... and %d more, see `detections.json`\n", len(detections)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- **%s** %s (`%s`)\n", d.Severity, d.Title, d.File)
+		if d.Explanation != "" {
+			fmt.Fprintf(&b, "  - %s %s\n", d.Explanation, d.NextStep)
+		}
+	}
+
+	b.WriteString("\n## Risky settings\n\n")
+	risks := a.summaryRiskySettings()
+	if len(risks) == 0 {
+		b.WriteString("None found.\n")
+	}
+	for _, risk := range risks {
+		fmt.Fprintf(&b, "- %s\n", risk)
+	}
+
+	b.WriteString("\n## Flagged packages\n\n")
+	flagged := a.FlaggedPackages()
+	if len(flagged) == 0 {
+		b.WriteString("None.\n")
+	}
+	var appLabels []struct {
+		Package string `json:"package"`
+		Label   string `json:"label"`
+	}
+	_ = a.readJSON("app_labels/app_labels.json", &appLabels)
+	labels := map[string]string{}
+	for _, label := range appLabels {
+		labels[label.Package] = label.Label
+	}
+	for _, pkg := range flagged {
+		name := fmt.Sprintf("`%s`", pkg)
+		if labels[pkg] != "" {
+			name = fmt.Sprintf("`%s` (%s)", pkg, labels[pkg])
+		}
+		if len(flags[pkg]) > 0 {
+			fmt.Fprintf(&b, "- %s: %s\n", name, strings.Join(flags[pkg], ", "))
+		} else {
+			fmt.Fprintf(&b, "- %s\n", name)
+		}
+	}
+
+	b.WriteString("\n## Next steps\n\n")
+	severe := len(detections) > 0 && severityOrder[detections[0].Severity] <= severityOrder[SeverityHigh]
+	switch {
+	case severe:
+		b.WriteString("- Escalate to a forensic expert: share the metadata bundle " +
+			"(`androidqf export-metadata`), not the full acquisition.\n")
+		b.WriteString("- Advise the user to keep the device powered on and not to reset it until the analysis is complete.\n")
+	case len(detections) > 0:
+		b.WriteString("- Review the findings above with the user, some may be legitimate apps or settings.\n")
+	default:
+		b.WriteString("- No indicators of compromise were found. Re-run the acquisition with updated " +
+			"indicators (`androidqf update-indicators`) if the concern persists.\n")
+	}
+	if len(flagged) > 0 {
+		b.WriteString("- Check with the user whether they installed the flagged packages, and how.\n")
+	}
+	if len(risks) > 0 {
+		b.WriteString("- Help the user revert the risky settings and install the latest system update.\n")
+	}
+	if a.AdbKey != nil {
+		fmt.Fprintf(&b, "- Revoke the USB debugging authorization granted to androidqf (key fingerprint `%s`) "+
+			"in the developer options of the device, and turn off USB debugging.\n", a.AdbKey.Fingerprint)
+	}
+
+	return b.String(), nil
+}
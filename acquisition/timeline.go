@@ -0,0 +1,259 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/timesketch"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// TimelineFile is the name of the timeline of the acquisition.
+const TimelineFile = "timeline.jsonl"
+
+// TimelineEvent is a single event of the timeline, with the fields required
+// to import it in Timesketch.
+type TimelineEvent struct {
+	Message       string `json:"message"`
+	Datetime      string `json:"datetime"`
+	Timestamp     int64  `json:"timestamp"`
+	TimestampDesc string `json:"timestamp_desc"`
+	Source        string `json:"source"`
+	File          string `json:"file,omitempty"`
+}
+
+// Formats of the dates reported by dumpsys package and logcat, in the local
+// time of the device.
+const (
+	PackageTimeFormat = "2006-01-02 15:04:05"
+	LogcatTimeFormat  = "2006-01-02T15:04:05.000"
+)
+
+type timelineWriter struct {
+	encoder *json.Encoder
+	count   int
+}
+
+func (w *timelineWriter) add(t time.Time, desc, source, file, message string) error {
+	if t.IsZero() || t.Unix() <= 0 {
+		return nil
+	}
+	w.count++
+	return w.encoder.Encode(&TimelineEvent{
+		Message:       message,
+		Datetime:      t.UTC().Format(time.RFC3339Nano),
+		Timestamp:     t.UnixMicro(),
+		TimestampDesc: desc,
+		Source:        source,
+		File:          file,
+	})
+}
+
+func (a *Acquisition) timelinePackages(w *timelineWriter) error {
+	var packages []adb.Package
+	if err := a.readJSON("packages/packages.json", &packages); err != nil {
+		return nil
+	}
+
+	for _, pkg := range packages {
+		for _, event := range []struct{ value, desc string }{
+			{pkg.FirstInstallTime, "First Install Time"},
+			{pkg.LastUpdateTime, "Last Update Time"},
+		} {
+			t, err := time.ParseInLocation(PackageTimeFormat, event.value, a.Clock.Location())
+			if err != nil {
+				continue
+			}
+			err = w.add(t, event.desc, "packages", "packages/packages.json",
+				fmt.Sprintf("Package %s (installer: %s)", pkg.Name, pkg.Installer))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *Acquisition) timelineFiles(w *timelineWriter) error {
+	var files []adb.FileInfo
+	if err := a.readJSON("files/files.json", &files); err != nil {
+		return nil
+	}
+
+	for _, file := range files {
+		err := w.add(time.Unix(file.ModifiedTime, 0), "Modification Time", "files",
+			"files/files.json", fmt.Sprintf("File %s modified", file.Path))
+		if err != nil {
+			return err
+		}
+		err = w.add(time.Unix(file.ChangeTime, 0), "Change Time", "files",
+			"files/files.json", fmt.Sprintf("File %s changed", file.Path))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Acquisition) timelineServices(w *timelineWriter) error {
+	var services []struct {
+		Package string    `json:"package"`
+		Service string    `json:"service"`
+		Started time.Time `json:"started"`
+	}
+	if err := a.readJSON("running_services/running_services.json", &services); err != nil {
+		return nil
+	}
+
+	for _, service := range services {
+		err := w.add(service.Started, "Service Start Time", "running_services",
+			"running_services/running_services.json",
+			fmt.Sprintf("Service %s of %s started", service.Service, service.Package))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Acquisition) timelinePermissionUsage(w *timelineWriter) error {
+	var usages []struct {
+		Package    string `json:"package"`
+		Op         string `json:"op"`
+		Result     string `json:"result"`
+		State      string `json:"state"`
+		TimeUTC    string `json:"time_utc"`
+		Permission string `json:"permission"`
+	}
+	if err := a.readJSON("privacy_dashboard/privacy_dashboard.json", &usages); err != nil {
+		return nil
+	}
+
+	for _, usage := range usages {
+		t, err := time.Parse(time.RFC3339Nano, usage.TimeUTC)
+		if err != nil {
+			continue
+		}
+		err = w.add(t, "Permission Access Time", "privacy_dashboard",
+			"privacy_dashboard/privacy_dashboard.json",
+			fmt.Sprintf("%s %s %s (%s, %s)", usage.Package, usage.Result, usage.Permission, usage.Op, usage.State))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Acquisition) timelineLogcat(w *timelineWriter, name string) error {
+	file, err := utils.OpenOutput(filepath.Join(a.StoragePath, name))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Timestamp string `json:"timestamp"`
+			PID       int    `json:"pid"`
+			Priority  string `json:"priority"`
+			Tag       string `json:"tag"`
+			Message   string `json:"message"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		t, err := time.ParseInLocation(LogcatTimeFormat, entry.Timestamp, a.Clock.Location())
+		if err != nil {
+			continue
+		}
+		err = w.add(t, "Log Time", "logcat", name,
+			fmt.Sprintf("%s %s (%d): %s", entry.Priority, entry.Tag, entry.PID, entry.Message))
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// StoreTimeline writes timeline.jsonl with the timestamped events found in
+// the outputs of the modules, in a format which can be imported in
+// Timesketch.
+func (a *Acquisition) StoreTimeline() error {
+	log.Info("Generating timeline of events...")
+
+	file, err := utils.CreateOutput(filepath.Join(a.StoragePath, TimelineFile))
+	if err != nil {
+		return fmt.Errorf("failed to create timeline: %v", err)
+	}
+	defer file.Close()
+
+	w := &timelineWriter{encoder: json.NewEncoder(file)}
+	err = w.add(a.Started, "Acquisition Start Time", "androidqf", "acquisition.json",
+		fmt.Sprintf("androidqf acquisition %s started", a.UUID))
+	if err != nil {
+		return err
+	}
+
+	for _, source := range []func(*timelineWriter) error{
+		a.timelinePackages,
+		a.timelineFiles,
+		a.timelineServices,
+		a.timelinePermissionUsage,
+		func(w *timelineWriter) error { return a.timelineLogcat(w, "logcat/logcat.jsonl") },
+		func(w *timelineWriter) error { return a.timelineLogcat(w, "logcat/logcat_old.jsonl") },
+	} {
+		err = source(w)
+		if err != nil {
+			return fmt.Errorf("failed to write timeline: %v", err)
+		}
+	}
+
+	log.Debugf("Stored %d events in the timeline", w.count)
+
+	return nil
+}
+
+// UploadTimeline uploads the timeline to a Timesketch server, in a sketch
+// named after the case, or after the acquisition if it is not part of one.
+func (a *Acquisition) UploadTimeline(url, token string) error {
+	if utils.OutputSinkEnabled() {
+		return errors.New("the timeline cannot be uploaded when encrypting outputs as they are written")
+	}
+
+	sketchName := a.CaseID
+	if sketchName == "" {
+		sketchName = fmt.Sprintf("androidqf %s", a.UUID)
+	}
+
+	log.Infof("Uploading timeline to Timesketch sketch %q...", sketchName)
+
+	client, err := timesketch.New(url, token)
+	if err != nil {
+		return err
+	}
+	sketchID, err := client.Sketch(sketchName)
+	if err != nil {
+		return err
+	}
+
+	return client.Upload(sketchID, fmt.Sprintf("androidqf %s", a.UUID),
+		filepath.Join(a.StoragePath, TimelineFile))
+}
@@ -0,0 +1,86 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package acquisition
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/config"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/virustotal"
+)
+
+// CheckVirusTotal looks up the hashes of the non-system apps on VirusTotal,
+// and adds a detection for each app that antivirus engines flag. Only the
+// hashes are sent, never the apps themselves.
+func (a *Acquisition) CheckVirusTotal(cfg *config.VirusTotalConfig) error {
+	var packages []adb.Package
+	err := a.readJSON("packages/packages.json", &packages)
+	if err != nil {
+		return nil
+	}
+
+	type lookup struct {
+		pkg    string
+		sha256 string
+	}
+	lookups := []lookup{}
+	seen := map[string]bool{}
+	for _, pkg := range packages {
+		if pkg.System {
+			continue
+		}
+		for _, file := range pkg.Files {
+			if file.SHA256 == "" || seen[file.SHA256] {
+				continue
+			}
+			seen[file.SHA256] = true
+			lookups = append(lookups, lookup{pkg: pkg.Name, sha256: file.SHA256})
+		}
+	}
+	if len(lookups) == 0 {
+		return nil
+	}
+
+	log.Infof("Looking up %d apps on VirusTotal. This might take a while...", len(lookups))
+
+	client := virustotal.New(cfg.APIKey, cfg.RequestsPerMinute)
+	for _, l := range lookups {
+		report, err := client.FileReport(l.sha256)
+		if errors.Is(err, virustotal.ErrQuotaExceeded) {
+			return err
+		} else if err != nil {
+			log.Debugf("Failed to look up %s on VirusTotal: %v", l.sha256, err)
+			continue
+		}
+		if report == nil || report.Stats.Malicious+report.Stats.Suspicious == 0 {
+			continue
+		}
+
+		severity := SeverityMedium
+		if report.Stats.Malicious > 0 {
+			severity = SeverityHigh
+		}
+		total := report.Stats.Malicious + report.Stats.Suspicious +
+			report.Stats.Undetected + report.Stats.Harmless
+		title := fmt.Sprintf("Package %s is detected by %d/%d engines on VirusTotal", l.pkg,
+			report.Stats.Malicious+report.Stats.Suspicious, total)
+		log.Warning(title)
+		a.AddDetection(Detection{
+			Engine:   EngineVirusTotal,
+			Severity: severity,
+			Title:    title,
+			Source:   "virustotal",
+			File:     "packages/packages.json",
+			Value:    l.sha256,
+			Package:  l.pkg,
+		})
+	}
+
+	return nil
+}
@@ -6,24 +6,66 @@
 package adb
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	saveSlice "github.com/botherder/go-savetime/slice"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type ADB struct {
 	ExePath string
 	Serial  string
+	// Dedicated key with which the adb server authenticates, if any.
+	Key *Key
+	// Maximum transfer rate of pulls in bytes per second, unlimited if 0.
+	RateLimit int64
+	// Called after each file successfully pulled from the device.
+	OnPull func()
+	// Called after each file which could not be pulled from the device.
+	OnPullFailed func(remotePath, localPath string, err error)
+	// Battery level in percent below which transfers are paused, never
+	// paused if 0.
+	MinBattery int
+	// Called every time transfers are paused because the battery is low.
+	OnBatteryPause func()
+
+	batteryChecked time.Time
+	// Direct connection to the device, when not using the adb server.
+	usb *usbTransport
+
+	history []HistoryEntry
+	// Files created on the device, removed and checked by VerifyCleanup.
+	created []string
+}
+
+// HistoryEntry is an adb command executed during the acquisition.
+type HistoryEntry struct {
+	Time time.Time
+	Args []string
 }
 
 var Client *ADB
 
-// New returns a new ADB instance.
-func New(serial string) (*ADB, error) {
+// New returns a new ADB instance. With dedicatedKey, the adb server is
+// started with the key of androidqf instead of the one of the user. With
+// the USB transport, the device is connected to directly and the adb
+// executable is not used.
+func New(serial string, dedicatedKey bool, transport string) (*ADB, error) {
+	if transport == TransportUSB {
+		return newUSB(serial, dedicatedKey)
+	}
+
 	adb := ADB{}
 	err := adb.findExe()
 	if err != nil {
@@ -35,6 +77,18 @@ func New(serial string) (*ADB, error) {
 	log.Debug("Killing existing ADB server if running")
 	adb.KillServer()
 
+	if dedicatedKey {
+		adb.Key, err = LoadOrCreateKey()
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Using the adb key of androidqf with fingerprint %s", adb.Key.Fingerprint)
+		err = adb.startServer()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Managing devices
 	devices, err := adb.Devices()
 	if err != nil {
@@ -64,8 +118,51 @@ func New(serial string) (*ADB, error) {
 	return &adb, nil
 }
 
+// newUSB returns an ADB instance connecting to the device directly over
+// USB, authenticating with the key of androidqf or the one of the user.
+func newUSB(serial string, dedicatedKey bool) (*ADB, error) {
+	adb := ADB{}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(home, ".android", "adbkey")
+	if dedicatedKey {
+		adb.Key, err = LoadOrCreateKey()
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Using the adb key of androidqf with fingerprint %s", adb.Key.Fingerprint)
+		keyPath = adb.Key.Path
+	}
+	key, err := loadKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the adb key %s: %v", keyPath, err)
+	}
+
+	serial = strings.TrimSpace(serial)
+	device, err := selectUSBDevice(serial)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Connecting directly over USB to the device %s", device.Serial)
+	adb.Serial = serial
+	adb.usb = &usbTransport{serial: device.Serial, key: key}
+	return &adb, nil
+}
+
+// UsesServer returns whether the device is connected to through the adb
+// server, rather than directly over USB.
+func (a *ADB) UsesServer() bool {
+	return a.usb == nil
+}
+
 // List existing devices
 func (a *ADB) Devices() ([]string, error) {
+	if a.usb != nil {
+		return a.usb.devices()
+	}
+
 	var devices []string
 	out, err := exec.Command(a.ExePath, "devices").Output()
 	if err != nil {
@@ -87,7 +184,10 @@ func (a *ADB) Devices() ([]string, error) {
 // Run a command to the given phone using exec
 // Returns string and/or error
 func (a *ADB) Exec(args ...string) ([]byte, error) {
-	if a.Serial == "" {
+	a.record(args...)
+	if a.usb != nil {
+		return a.usb.exec(args)
+	} else if a.Serial == "" {
 		return exec.Command(a.ExePath, args...).Output()
 	} else {
 		var params []string
@@ -97,6 +197,77 @@ func (a *ADB) Exec(args ...string) ([]byte, error) {
 	}
 }
 
+// process is an adb command started with start, whose output is read from
+// Stdout before calling Wait.
+type process struct {
+	Stdout io.Reader
+	stderr *bytes.Buffer
+	wait   func() error
+	kill   func() error
+}
+
+// Wait waits for the command to exit, adding its error output to the
+// error returned.
+func (p *process) Wait() error {
+	err := p.wait()
+	if err != nil && p.stderr.Len() > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(p.stderr.String()))
+	}
+	return err
+}
+
+// Kill stops the command.
+func (p *process) Kill() error {
+	return p.kill()
+}
+
+// start runs an adb command without waiting for it to complete, to stream
+// its output.
+func (a *ADB) start(args ...string) (*process, error) {
+	a.record(args...)
+	if a.usb != nil {
+		return a.usb.start(args)
+	}
+	if a.Serial != "" {
+		args = append([]string{"-s", a.Serial}, args...)
+	}
+
+	p := &process{stderr: &bytes.Buffer{}}
+	cmd := exec.Command(a.ExePath, args...)
+	cmd.Stderr = p.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	p.Stdout = stdout
+	p.wait = cmd.Wait
+	p.kill = cmd.Process.Kill
+	return p, nil
+}
+
+func (a *ADB) record(args ...string) {
+	a.history = append(a.history, HistoryEntry{Time: time.Now().UTC(), Args: args})
+}
+
+// History returns the adb commands executed so far, starting from the
+// given index.
+func (a *ADB) History(from int) []string {
+	commands := []string{}
+	for i := from; i < len(a.history); i++ {
+		commands = append(commands, strings.Join(a.history[i].Args, " "))
+	}
+	return commands
+}
+
+// HistoryLen returns the number of adb commands executed so far.
+func (a *ADB) HistoryLen() int {
+	return len(a.history)
+}
+
 // GetState returns the output of `adb get-state`.
 // It is used to check whether a device is connected. If it is not, adb
 // will exit with status 1.
@@ -127,14 +298,50 @@ func (a *ADB) Shell(cmd ...string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
+// ShellToWriter executes a shell command through adb, streaming its output to
+// the given writer instead of keeping it in memory.
+func (a *ADB) ShellToWriter(w io.Writer, cmd ...string) error {
+	p, err := a.start(append([]string{"shell"}, cmd...)...)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(w, p.Stdout)
+	// Drain the output, so that adb does not block if writing failed.
+	_, _ = io.Copy(io.Discard, p.Stdout)
+	err = p.Wait()
+	if err == nil {
+		err = copyErr
+	}
+	return err
+}
+
 // Pull downloads a file from the device to a local path.
 func (a *ADB) Pull(remotePath, localPath string) (string, error) {
-	out, err := a.Exec("pull", remotePath, localPath)
+	a.waitForBattery()
+
+	var out string
+	var err error
+	// adb cannot write to paths longer than MAX_PATH on Windows.
+	if a.usb != nil {
+		out, err = a.pullUSB(remotePath, localPath)
+	} else if a.RateLimit > 0 || utils.StreamOutputs() || utils.LongPath(localPath) != localPath {
+		out, err = a.pullStream(remotePath, localPath)
+	} else {
+		var data []byte
+		data, err = a.Exec("pull", remotePath, localPath)
+		out = string(data)
+	}
 	if err != nil {
-		return string(out), err
+		if a.OnPullFailed != nil {
+			a.OnPullFailed(remotePath, localPath, err)
+		}
+		return out, err
 	}
 
-	return string(out), nil
+	if a.OnPull != nil {
+		a.OnPull()
+	}
+	return out, nil
 }
 
 // Push a file on the phone
@@ -149,12 +356,20 @@ func (a *ADB) Push(localPath, remotePath string) (string, error) {
 
 // Backup generates a backup of the specified app, or of all.
 func (a *ADB) Backup(arg string) error {
+	if a.usb != nil {
+		return a.backupUSB(arg)
+	}
+	a.record("backup", "-nocompress", arg)
 	cmd := exec.Command(a.ExePath, "backup", "-nocompress", arg)
 	return cmd.Run()
 }
 
 // Bugreport generates a bugreport of the the device
 func (a *ADB) Bugreport() error {
+	a.record("bugreport", "bugreport.zip")
+	if a.usb != nil {
+		return a.usb.bugreport("bugreport.zip")
+	}
 	cmd := exec.Command(a.ExePath, "bugreport", "bugreport.zip")
 	err := cmd.Run()
 	return err
@@ -172,6 +387,26 @@ func (a *ADB) FileExists(path string) (bool, error) {
 	return true, nil
 }
 
+// FreeSpace returns the bytes available on the device filesystem holding
+// the given path, as reported by df.
+func (a *ADB) FreeSpace(path string) (int64, error) {
+	out, err := a.Shell("df", "-k", path)
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(out, "\n")
+	// Filesystem 1K-blocks Used Available Use% Mounted on
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output: %s", out)
+	}
+	available, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output: %s", out)
+	}
+	return available * 1024, nil
+}
+
 // List files in a folder using ls, returns array of strings.
 func (a *ADB) ListFiles(remotePath string, recursive bool) ([]string, error) {
 	var remoteFiles []string
@@ -201,7 +436,30 @@ func (a *ADB) ListFiles(remotePath string, recursive bool) ([]string, error) {
 	return remoteFiles, nil
 }
 
+// startServer starts the adb server with the dedicated key. adb offers the
+// key found in the .android folder of the home directory first, and the
+// ones in ADB_VENDOR_KEYS after it. The home directory cannot be changed on
+// Windows, where the dedicated key is only offered as a vendor key.
+func (a *ADB) startServer() error {
+	cmd := exec.Command(a.ExePath, "start-server")
+	cmd.Env = append(os.Environ(), "ADB_VENDOR_KEYS="+a.Key.Path)
+	if runtime.GOOS != "windows" {
+		cmd.Env = append(cmd.Env, "HOME="+KeyHome())
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start the adb server: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 func (a *ADB) KillServer() (string, error) {
+	if a.usb != nil {
+		// There is no server, but the device is released for other
+		// processes.
+		a.usb.close()
+		return "", nil
+	}
 	log.Debug("Killing adb server")
 	out, err := exec.Command(a.ExePath, "kill-server").Output()
 	if err != nil {
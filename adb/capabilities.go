@@ -0,0 +1,84 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Binaries probed on the device, which modules can check before choosing
+// which variant of a command to run.
+var probedBinaries = []string{
+	"toybox", "toolbox", "busybox", "cmd", "dumpsys", "settings", "appops",
+	"ss", "netstat", "ip", "find", "stat", "sha256sum", "md5sum", "gzip",
+	"tar", "logcat", "su", "tcpdump", "timeout",
+}
+
+// Capabilities describes what the connected device allows us to do.
+type Capabilities struct {
+	APILevel int             `json:"api_level"`
+	UID      int             `json:"uid"`
+	Root     bool            `json:"root"`
+	Binaries map[string]bool `json:"binaries"`
+}
+
+// ProbeCapabilities checks the Android API level, the binaries available in
+// the shell and the privileges of the adb shell.
+func (a *ADB) ProbeCapabilities() *Capabilities {
+	caps := &Capabilities{
+		UID:      -1,
+		Binaries: map[string]bool{},
+	}
+
+	out, err := a.Shell("getprop", "ro.build.version.sdk")
+	if err == nil {
+		caps.APILevel, _ = strconv.Atoi(out)
+	}
+
+	out, err = a.Shell("id", "-u")
+	if err == nil {
+		if uid, err := strconv.Atoi(out); err == nil {
+			caps.UID = uid
+		}
+	}
+	caps.Root = caps.UID == 0
+
+	script := "for b in " + strings.Join(probedBinaries, " ") +
+		"; do command -v $b >/dev/null 2>&1 && echo $b; done"
+	out, _ = a.Shell(script)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			caps.Binaries[line] = true
+		}
+	}
+
+	log.Debugf("Device capabilities: API level %d, uid %d, binaries %v",
+		caps.APILevel, caps.UID, caps.Binaries)
+
+	return caps
+}
+
+// Has returns true if the given binary is available on the device. If the
+// probe failed altogether, it optimistically returns true.
+func (c *Capabilities) Has(binary string) bool {
+	if c == nil || len(c.Binaries) == 0 {
+		return true
+	}
+	return c.Binaries[binary]
+}
+
+// AtLeast returns true if the device runs at least the given API level.
+// If the API level could not be determined, it optimistically returns true.
+func (c *Capabilities) AtLeast(apiLevel int) bool {
+	if c == nil || c.APILevel == 0 {
+		return true
+	}
+	return c.APILevel >= apiLevel
+}
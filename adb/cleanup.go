@@ -0,0 +1,184 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mvt-project/androidqf/log"
+)
+
+// CleanupReport records the verification that androidqf did not leave any
+// file or process behind on the device.
+type CleanupReport struct {
+	Verified        bool     `json:"verified"`
+	KilledProcesses []string `json:"killed_processes"`
+	RemovedFiles    []string `json:"removed_files"`
+	Residue         []string `json:"residue"`
+}
+
+// TrackDeviceFile records a file created by androidqf on the device, so that
+// VerifyCleanup removes it and checks it is gone.
+func (a *ADB) TrackDeviceFile(path string) {
+	for _, created := range a.created {
+		if created == path {
+			return
+		}
+	}
+	a.created = append(a.created, path)
+}
+
+// listResidue returns the given paths which exist.
+func (a *ADB) listResidue(paths []string) []string {
+	residue := []string{}
+	if len(paths) == 0 {
+		return residue
+	}
+	args := append([]string{"ls", "-d"}, paths...)
+	out, _ := a.Shell(append(args, "2>", "/dev/null")...)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.Contains(line, "No such file") {
+			residue = append(residue, line)
+		}
+	}
+	return residue
+}
+
+// CollectorName is the name of the collector on the device, specific to
+// androidqf so that the files of other tools are never taken for its own.
+const CollectorName = "androidqf_collector"
+
+// DeviceFileNames are the names of the files androidqf creates in the
+// temporary folder of the device.
+var DeviceFileNames = []string{CollectorName, "androidqf.pcap", ".androidqf_test"}
+
+// StaleFiles records what previous runs of androidqf which did not complete
+// left on the device.
+type StaleFiles struct {
+	KilledProcesses []string `json:"killed_processes"`
+	RemovedFiles    []string `json:"removed_files"`
+	// Files named like androidqf's on the shared storage, which are kept as
+	// they might belong to the user.
+	SharedStorageFiles []string `json:"shared_storage_files"`
+}
+
+// collectorPIDs returns the processes running one of the given collector
+// executables. Processes of other tools, even with the same name, and those
+// which cannot be inspected, are left out.
+func (a *ADB) collectorPIDs(exePaths []string) []string {
+	pids := []string{}
+	if len(exePaths) == 0 {
+		return pids
+	}
+	patterns := []string{}
+	for _, path := range exePaths {
+		// The executable is marked as deleted once its file is removed.
+		patterns = append(patterns, shellQuote(path), shellQuote(path+" (deleted)"))
+	}
+	script := fmt.Sprintf(`for p in /proc/[0-9]*; do case "$(readlink $p/exe 2>/dev/null)" in %s) echo ${p#/proc/};; esac; done`,
+		strings.Join(patterns, "|"))
+	out, _ := a.Shell(script)
+	return append(pids, strings.Fields(out)...)
+}
+
+// RemoveStaleFiles kills the collector processes and removes the files left
+// in the given temporary folders by previous runs of androidqf which did not
+// complete. Files with the same names on the shared storage are only
+// recorded.
+func (a *ADB) RemoveStaleFiles(tmpDirs []string, sharedStorage string) *StaleFiles {
+	stale := &StaleFiles{
+		KilledProcesses:    []string{},
+		RemovedFiles:       []string{},
+		SharedStorageFiles: []string{},
+	}
+
+	exePaths := []string{}
+	paths := []string{}
+	for _, tmpDir := range tmpDirs {
+		exePaths = append(exePaths, tmpDir+CollectorName)
+		for _, name := range DeviceFileNames {
+			paths = append(paths, tmpDir+name)
+		}
+	}
+
+	for _, pid := range a.collectorPIDs(exePaths) {
+		log.Infof("Killing collector process %s left by a previous run", pid)
+		_, err := a.Shell("kill", "-9", pid)
+		if err == nil {
+			stale.KilledProcesses = append(stale.KilledProcesses, pid)
+		}
+	}
+
+	for _, path := range a.listResidue(paths) {
+		log.Infof("Removing %s left on the device by a previous run", path)
+		_, err := a.Shell("rm", "-f", path)
+		if err != nil {
+			log.Warningf("Failed to remove %s: %v", path, err)
+			continue
+		}
+		stale.RemovedFiles = append(stale.RemovedFiles, path)
+	}
+
+	if sharedStorage != "" {
+		paths = []string{}
+		for _, name := range DeviceFileNames {
+			paths = append(paths, sharedStorage+name)
+		}
+		for _, path := range a.listResidue(paths) {
+			log.Infof("Found %s on the shared storage, it might have been left by a previous run", path)
+			stale.SharedStorageFiles = append(stale.SharedStorageFiles, path)
+		}
+	}
+
+	return stale
+}
+
+// createdCollectors returns the paths of the collectors androidqf uploaded.
+func (a *ADB) createdCollectors() []string {
+	paths := []string{}
+	for _, path := range a.created {
+		if strings.HasSuffix(path, CollectorName) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// VerifyCleanup kills any collector process still running and checks that
+// the files created by androidqf, recorded with TrackDeviceFile, were
+// removed from the device, removing them again if needed.
+func (a *ADB) VerifyCleanup() *CleanupReport {
+	report := &CleanupReport{
+		KilledProcesses: []string{},
+		RemovedFiles:    []string{},
+		Residue:         []string{},
+	}
+
+	for _, pid := range a.collectorPIDs(a.createdCollectors()) {
+		log.Debugf("Killing leftover collector process %s", pid)
+		_, err := a.Shell("kill", "-9", pid)
+		if err == nil {
+			report.KilledProcesses = append(report.KilledProcesses, pid)
+		}
+	}
+
+	for _, path := range a.listResidue(a.created) {
+		_, err := a.Shell("rm", "-f", path)
+		if err == nil {
+			report.RemovedFiles = append(report.RemovedFiles, path)
+		}
+	}
+
+	report.Residue = a.listResidue(a.created)
+	for _, pid := range a.collectorPIDs(a.createdCollectors()) {
+		report.Residue = append(report.Residue, "process:"+pid)
+	}
+	report.Verified = len(report.Residue) == 0
+
+	return report
+}
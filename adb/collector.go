@@ -6,16 +6,23 @@
 package adb
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/mvt-project/androidqf/log"
 
 	"github.com/mvt-project/androidqf/assets"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type Collector struct {
@@ -23,6 +30,11 @@ type Collector struct {
 	Installed    bool
 	Adb          *ADB
 	Architecture string
+	// SHA256 of the collector binary pushed to the device.
+	SHA256 string
+	// Commands producing no output for this long are killed and retried,
+	// disabled if 0.
+	Timeout time.Duration
 }
 
 type FileInfo struct {
@@ -63,9 +75,21 @@ type ProcessInfo struct {
 	WorkingDirectory string   `json:"cwd"`
 }
 
+type SearchMatch struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	String string `json:"string"`
+	Text   string `json:"text"`
+}
+
 // Returns a new Collector instance.
 func (a *ADB) GetCollector(tmpDir string, arch string) (*Collector, error) {
-	c := Collector{ExePath: filepath.Join(tmpDir, "collector"), Adb: a, Architecture: arch}
+	c := Collector{
+		ExePath:      filepath.Join(tmpDir, CollectorName),
+		Adb:          a,
+		Architecture: arch,
+		Timeout:      DefaultCollectorTimeout,
+	}
 
 	err := c.Install()
 	if err != nil {
@@ -118,6 +142,9 @@ func (c *Collector) Install() error {
 		return errors.New("couldn't find the collector binary")
 	}
 
+	sum := sha256.Sum256(collectorBinary)
+	c.SHA256 = hex.EncodeToString(sum[:])
+
 	collectorTemp, _ := os.CreateTemp("", "collector_")
 	if err != nil {
 		return err
@@ -130,6 +157,17 @@ func (c *Collector) Install() error {
 		return err
 	}
 
+	// The collector streams all of its output, so the binary is the only
+	// file it needs on the device.
+	free, err := c.Adb.FreeSpace(filepath.Dir(c.ExePath))
+	if err != nil {
+		log.Debugf("Failed to check the free space on the device: %v", err)
+	} else if free < int64(len(collectorBinary)) {
+		return fmt.Errorf("not enough free space on the device to deploy the collector: %d bytes needed, %d available",
+			len(collectorBinary), free)
+	}
+
+	c.Adb.TrackDeviceFile(c.ExePath)
 	_, err = c.Adb.Push(collectorTemp.Name(), c.ExePath)
 	if err != nil {
 		return err
@@ -145,7 +183,6 @@ func (c *Collector) Install() error {
 // List files on the phone at the given path (no hash).
 func (c *Collector) Find(path string) ([]FileInfo, error) {
 	var results []FileInfo
-	var file FileInfo
 	if !c.isInstalled() {
 		err := c.Install()
 		if err != nil {
@@ -154,24 +191,18 @@ func (c *Collector) Find(path string) ([]FileInfo, error) {
 		}
 	}
 
-	out, err := c.Adb.Shell(c.ExePath, "find", path)
-	if err != nil {
-		return results, err
-	}
-	for _, line := range strings.Split(out, "\n") {
-		err = json.Unmarshal([]byte(line), &file)
-		if err == nil {
+	err := c.stream(func() { results = nil }, func(line []byte) {
+		var file FileInfo
+		if json.Unmarshal(line, &file) == nil {
 			results = append(results, file)
 		}
-	}
-
-	return results, nil
+	}, c.ExePath, "find", shellQuote(path))
+	return results, err
 }
 
 // List files with their hash on the phone at the given path.
 func (c *Collector) FindHash(path string) ([]FileInfo, error) {
 	var results []FileInfo
-	var file FileInfo
 	if !c.isInstalled() {
 		err := c.Install()
 		if err != nil {
@@ -180,18 +211,13 @@ func (c *Collector) FindHash(path string) ([]FileInfo, error) {
 		}
 	}
 
-	out, err := c.Adb.Shell(c.ExePath, "find", "-H", path)
-	if err != nil {
-		return results, err
-	}
-	for _, line := range strings.Split(out, "\n") {
-		err = json.Unmarshal([]byte(line), &file)
-		if err == nil {
+	err := c.stream(func() { results = nil }, func(line []byte) {
+		var file FileInfo
+		if json.Unmarshal(line, &file) == nil {
 			results = append(results, file)
 		}
-	}
-
-	return results, nil
+	}, c.ExePath, "find", "-H", shellQuote(path))
+	return results, err
 }
 
 func (c *Collector) Processes() ([]ProcessInfo, error) {
@@ -205,7 +231,7 @@ func (c *Collector) Processes() ([]ProcessInfo, error) {
 		}
 	}
 
-	out, err := c.Adb.Shell(c.ExePath, "ps")
+	out, err := c.shell(c.ExePath, "ps")
 	if err != nil {
 		return results, err
 	}
@@ -216,3 +242,162 @@ func (c *Collector) Processes() ([]ProcessInfo, error) {
 
 	return results, nil
 }
+
+// Quote an argument for the device shell.
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Search the given strings (case insensitive) in the files on the phone at
+// the given path, stopping after maxMatches results.
+func (c *Collector) Search(path string, needles []string, maxMatches int) ([]SearchMatch, error) {
+	var results []SearchMatch
+	if !c.isInstalled() {
+		err := c.Install()
+		if err != nil {
+			log.Debugf("Impossible to install collector: %v", err)
+			return results, err
+		}
+	}
+
+	args := []string{c.ExePath, "search", "-n", fmt.Sprint(maxMatches)}
+	for _, needle := range needles {
+		args = append(args, "-s", shellQuote(needle))
+	}
+	args = append(args, shellQuote(path))
+
+	err := c.stream(func() { results = nil }, func(line []byte) {
+		var match SearchMatch
+		if json.Unmarshal(line, &match) == nil {
+			results = append(results, match)
+		}
+	}, args...)
+	if err != nil && len(results) == 0 {
+		return results, err
+	}
+
+	return results, nil
+}
+
+// Archive streams a gzip-compressed tar archive of the folder at the given
+// path, created on the phone by the collector, and extracts the files in it
+// to a local folder. The whole archive is read up to the gzip trailer, so
+// that a truncated transfer is reported as an error. It returns the number
+// of files extracted.
+func (c *Collector) Archive(path, localFolder string, exclude []string) (int, error) {
+	if !c.isInstalled() {
+		err := c.Install()
+		if err != nil {
+			log.Debugf("Impossible to install collector: %v", err)
+			return 0, err
+		}
+	}
+
+	c.Adb.waitForBattery()
+
+	command := []string{c.ExePath, "archive"}
+	for _, e := range exclude {
+		command = append(command, "-e", shellQuote(e))
+	}
+	command = append(command, shellQuote(path))
+
+	p, err := c.Adb.start("exec-out", strings.Join(command, " "))
+	if err != nil {
+		return 0, err
+	}
+
+	act := newActivity()
+	stop := make(chan struct{})
+	killed := c.watch(p, act, stop)
+	defer close(stop)
+
+	var reader io.Reader = &activityReader{r: p.Stdout, act: act}
+	if c.Adb.RateLimit > 0 {
+		reader = &rateLimitedReader{r: reader, rate: c.Adb.RateLimit}
+	}
+	count, extractErr := extractArchive(reader, strings.TrimPrefix(path, "/"), localFolder)
+	// Drain the output, so that adb does not block if extraction failed.
+	_, _ = io.Copy(io.Discard, p.Stdout)
+	err = p.Wait()
+	if killed.Load() {
+		return count, fmt.Errorf("%w for %s", errCollectorHung, c.Timeout)
+	}
+	if err != nil {
+		return count, err
+	}
+	if extractErr != nil {
+		return count, fmt.Errorf("invalid archive: %v", extractErr)
+	}
+	return count, nil
+}
+
+// extractArchive extracts the regular files of a gzip-compressed tar
+// archive, whose members are relative to the given prefix, to a local folder.
+func extractArchive(r io.Reader, prefix, localFolder string) (int, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(gr)
+
+	count := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(header.Name, prefix), "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			log.Debugf("Skipping unexpected path %s in archive", header.Name)
+			continue
+		}
+		localPath := utils.LocalPath(localFolder, name)
+
+		err = extractFile(tr, header, localPath)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	// The gzip checksum and size are only verified once the trailer is read.
+	_, err = io.Copy(io.Discard, gr)
+	if err != nil {
+		return count, err
+	}
+	return count, gr.Close()
+}
+
+func extractFile(r io.Reader, header *tar.Header, localPath string) error {
+	if !utils.OutputSinkEnabled() {
+		err := os.MkdirAll(utils.LongPath(filepath.Dir(localPath)), 0o755)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := utils.CreateOutput(localPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	if !utils.OutputSinkEnabled() {
+		_ = os.Chtimes(utils.LongPath(localPath), header.ModTime, header.ModTime)
+	}
+	return nil
+}
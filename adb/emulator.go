@@ -0,0 +1,74 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EmulatorInfo records whether the device appears to be an emulator or a
+// virtual device, and the evidence supporting it.
+type EmulatorInfo struct {
+	Detected bool     `json:"detected"`
+	Evidence []string `json:"evidence"`
+}
+
+// emulatorMarker is a system property value commonly found on emulators.
+// Markers of the same kind are not independent from each other.
+type emulatorMarker struct {
+	kind   string
+	prop   string
+	values []string
+	exact  bool
+}
+
+var emulatorMarkers = []emulatorMarker{
+	{"qemu", "ro.kernel.qemu", []string{"1"}, true},
+	{"qemu", "ro.boot.qemu", []string{"1"}, true},
+	{"hardware", "ro.hardware", []string{"goldfish", "ranchu", "vbox86", "cutf_cvm", "android_x86"}, false},
+	{"hardware", "ro.hardware.virtual", []string{"1"}, true},
+	{"hardware", "ro.boot.hardware.vsock", []string{"1"}, true},
+	{"product", "ro.product.manufacturer", []string{"Genymotion"}, true},
+	{"product", "ro.product.model", []string{"sdk_gphone", "Android SDK built for", "Emulator", "Virtual"}, false},
+	{"product", "ro.product.device", []string{"generic", "emu64", "vsoc", "vbox86"}, false},
+	{"product", "ro.build.characteristics", []string{"emulator"}, false},
+}
+
+// DetectEmulator checks system properties and device files commonly found
+// on emulators (Android SDK, Genymotion, Android-x86, cloud devices). As
+// some markers, such as generic product names, are also found on real
+// devices, it requires markers of at least two independent kinds.
+func (a *ADB) DetectEmulator() *EmulatorInfo {
+	info := &EmulatorInfo{Evidence: []string{}}
+	kinds := map[string]bool{}
+
+	for _, marker := range emulatorMarkers {
+		value, err := a.Shell("getprop", marker.prop)
+		if err != nil || value == "" {
+			continue
+		}
+		for _, expected := range marker.values {
+			if (marker.exact && value == expected) ||
+				(!marker.exact && strings.Contains(strings.ToLower(value), strings.ToLower(expected))) {
+				info.Evidence = append(info.Evidence, fmt.Sprintf("%s=%s", marker.prop, value))
+				kinds[marker.kind] = true
+				break
+			}
+		}
+	}
+
+	for _, path := range []string{"/dev/qemu_pipe", "/dev/goldfish_pipe", "/dev/socket/qemud", "/dev/vboxguest"} {
+		out, err := a.Shell("ls", "-d", path)
+		if err == nil && out == path {
+			info.Evidence = append(info.Evidence, path)
+			kinds["devices"] = true
+		}
+	}
+
+	info.Detected = len(kinds) >= 2
+	return info
+}
@@ -0,0 +1,166 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	saveRuntime "github.com/botherder/go-savetime/runtime"
+)
+
+// Size in bytes of the RSA modulus of adb keys.
+const adbKeyModulusSize = 2048 / 8
+
+// Key is the RSA key pair with which androidqf authenticates to devices,
+// distinct from the one of the user running it, so that the authorization
+// granted for acquisitions can be told apart and revoked.
+type Key struct {
+	Path string `json:"path"`
+	// Fingerprint shown by Android when asking to allow USB debugging.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// KeyHome returns the folder used as home by the adb server, which holds the
+// key of androidqf in .android/adbkey.
+func KeyHome() string {
+	return filepath.Join(saveRuntime.GetExecutableDirectory(), "adb_home")
+}
+
+// KeyPath returns the path of the private key of androidqf.
+func KeyPath() string {
+	return filepath.Join(KeyHome(), ".android", "adbkey")
+}
+
+// androidPublicKey encodes the public key in the format used by Android in
+// adbkey.pub and adb_keys: the size of the modulus in 32-bit words,
+// -1 / n[0] mod 2^32, the modulus and R^2 mod n with R = 2^2048, both little
+// endian, and the public exponent.
+func androidPublicKey(pub *rsa.PublicKey) []byte {
+	le := func(n *big.Int) []byte {
+		b := n.FillBytes(make([]byte, adbKeyModulusSize))
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+		return b
+	}
+
+	word := new(big.Int).Lsh(big.NewInt(1), 32)
+	n0inv := new(big.Int).ModInverse(new(big.Int).Mod(pub.N, word), word)
+	n0inv.Sub(word, n0inv)
+	rr := new(big.Int).Lsh(big.NewInt(1), adbKeyModulusSize*8*2)
+	rr.Mod(rr, pub.N)
+
+	data := binary.LittleEndian.AppendUint32(nil, adbKeyModulusSize/4)
+	data = binary.LittleEndian.AppendUint32(data, uint32(n0inv.Uint64()))
+	data = append(data, le(pub.N)...)
+	data = append(data, le(rr)...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(pub.E))
+	return data
+}
+
+// keyFingerprint returns the MD5 fingerprint of the public key, formatted as
+// on the device.
+func keyFingerprint(pub []byte) string {
+	hash := md5.Sum(pub)
+	parts := make([]string, len(hash))
+	for i, b := range hash {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func loadKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM key", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an RSA key", path)
+	}
+	return key, nil
+}
+
+// LoadOrCreateKey returns the key of androidqf, and generates it the first
+// time, with its public key in adbkey.pub as adb expects.
+func LoadOrCreateKey() (*Key, error) {
+	path := KeyPath()
+	key, err := loadKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = rsa.GenerateKey(rand.Reader, adbKeyModulusSize*8)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the adb key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store the adb key: %v", err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	pub := androidPublicKey(&key.PublicKey)
+	err = os.WriteFile(path+".pub", []byte(base64.StdEncoding.EncodeToString(pub)+" androidqf\n"), 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the adb public key: %v", err)
+	}
+
+	return &Key{Path: path, Fingerprint: keyFingerprint(pub)}, nil
+}
+
+// RemoveKey deletes the key of androidqf, so that the authorizations granted
+// to it on devices can no longer be used. A new key is generated by the next
+// acquisition.
+func RemoveKey() error {
+	for _, path := range []string{KeyPath(), KeyPath() + ".pub"} {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevocationGuidance explains how to revoke the authorization granted to the
+// key on the device once the acquisition is complete.
+func (k *Key) RevocationGuidance() []string {
+	return []string{
+		fmt.Sprintf("The device authorized the adb key of androidqf with fingerprint %s.", k.Fingerprint),
+		"To revoke it, open Settings > System > Developer options on the device, tap \"Revoke USB debugging authorizations\" and turn off USB debugging.",
+		"Run `androidqf adb-key remove` to delete the key from this computer, a new one is generated by the next acquisition.",
+	}
+}
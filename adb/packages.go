@@ -8,11 +8,13 @@ package adb
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/avast/apkverifier"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type PackageFile struct {
@@ -27,16 +29,125 @@ type PackageFile struct {
 	Certificate         apkverifier.CertInfo `json:"certificate"`
 	CertificateError    string               `json:"certificate_error"`
 	TrustedCertificate  bool                 `json:"trusted_certificate"`
+	Quarantined         bool                 `json:"quarantined"`
 }
 
 type Package struct {
-	Name       string        `json:"name"`
-	Files      []PackageFile `json:"files"`
-	Installer  string        `json:"installer"`
-	UID        int           `json:"uid"`
-	Disabled   bool          `json:"disabled"`
-	System     bool          `json:"system"`
-	ThirdParty bool          `json:"third_party"`
+	Name             string        `json:"name"`
+	Files            []PackageFile `json:"files"`
+	Installer        string        `json:"installer"`
+	UID              int           `json:"uid"`
+	Disabled         bool          `json:"disabled"`
+	System           bool          `json:"system"`
+	ThirdParty       bool          `json:"third_party"`
+	FirstInstallTime string        `json:"first_install_time"`
+	LastUpdateTime   string        `json:"last_update_time"`
+	// The install and update times converted from device local time to UTC.
+	FirstInstallTimeUTC string `json:"first_install_time_utc"`
+	LastUpdateTimeUTC   string `json:"last_update_time_utc"`
+	// Partition storing the APKs in use, "data" for updated system apps.
+	Partition string   `json:"partition"`
+	Flags     []string `json:"flags"`
+}
+
+// Flag marks the package as suspicious for the given reason.
+func (p *Package) Flag(reason string) {
+	for _, flag := range p.Flags {
+		if flag == reason {
+			return
+		}
+	}
+	p.Flags = append(p.Flags, reason)
+}
+
+// InstallSource returns whether the package was installed from the Play
+// Store, by the manufacturer or sideloaded.
+func (p *Package) InstallSource() string {
+	return utils.InstallSource(p.Installer, p.System)
+}
+
+// Partitions which can store APKs. The vendor and product partitions are
+// mounted under /system on older devices.
+var packagePartitions = []struct {
+	prefix    string
+	partition string
+}{
+	{"/system/vendor/", "vendor"},
+	{"/system/product/", "product"},
+	{"/system/system_ext/", "system_ext"},
+	{"/system/", "system"},
+	{"/system_ext/", "system_ext"},
+	{"/product/", "product"},
+	{"/vendor/", "vendor"},
+	{"/odm/", "odm"},
+	{"/oem/", "oem"},
+	{"/apex/", "apex"},
+	{"/data/", "data"},
+	{"/mnt/", "data"},
+}
+
+// PackagePartition returns the partition storing the APK at the given path.
+func PackagePartition(path string) string {
+	for _, p := range packagePartitions {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.partition
+		}
+	}
+	return "other"
+}
+
+type packageDetails struct {
+	Installer        string
+	FirstInstallTime string
+	LastUpdateTime   string
+}
+
+// getPackagesDetails parses `dumpsys package packages` to retrieve install
+// times and installer for every package known to the package manager.
+func (a *ADB) getPackagesDetails() (map[string]packageDetails, error) {
+	details := map[string]packageDetails{}
+
+	out, err := a.Shell("dumpsys", "package", "packages")
+	if err != nil && out == "" {
+		return details, fmt.Errorf("failed to run `dumpsys package packages`: %v", err)
+	}
+
+	var current string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Package [") {
+			end := strings.Index(line, "]")
+			if end == -1 {
+				current = ""
+				continue
+			}
+			current = line[len("Package ["):end]
+			// Updated system packages are listed again under "Hidden system
+			// packages", we keep the details of the active one.
+			if _, ok := details[current]; !ok {
+				details[current] = packageDetails{}
+			}
+			continue
+		}
+		if current == "" {
+			continue
+		}
+
+		d := details[current]
+		// On recent Android versions the times are listed once per user,
+		// we only keep the first ones we find.
+		switch {
+		case strings.HasPrefix(line, "installerPackageName=") && d.Installer == "":
+			d.Installer = strings.TrimPrefix(line, "installerPackageName=")
+		case strings.HasPrefix(line, "firstInstallTime=") && d.FirstInstallTime == "":
+			d.FirstInstallTime = strings.TrimPrefix(line, "firstInstallTime=")
+		case strings.HasPrefix(line, "lastUpdateTime=") && d.LastUpdateTime == "":
+			d.LastUpdateTime = strings.TrimPrefix(line, "lastUpdateTime=")
+		}
+		details[current] = d
+	}
+
+	return details, nil
 }
 
 func (a *ADB) getPackageFiles(packageName string, fast bool) []PackageFile {
@@ -124,11 +235,31 @@ func (a *ADB) GetPackages(fast bool) ([]Package, error) {
 			System:     false,
 			ThirdParty: false,
 			Files:      a.getPackageFiles(packageName, fast),
+			Flags:      []string{},
+		}
+		if len(newPackage.Files) > 0 {
+			newPackage.Partition = PackagePartition(newPackage.Files[0].Path)
 		}
 
 		packages = append(packages, newPackage)
 	}
 
+	details, err := a.getPackagesDetails()
+	if err != nil {
+		log.Debugf("Failed to get packages details: %v", err)
+	}
+	for pIndex, p := range packages {
+		d, ok := details[p.Name]
+		if !ok {
+			continue
+		}
+		if p.Installer == "" || p.Installer == "null" {
+			packages[pIndex].Installer = d.Installer
+		}
+		packages[pIndex].FirstInstallTime = d.FirstInstallTime
+		packages[pIndex].LastUpdateTime = d.LastUpdateTime
+	}
+
 	cmds := []map[string]string{
 		{"field": "Disabled", "arg": "-d"},
 		{"field": "System", "arg": "-s"},
@@ -164,6 +295,12 @@ func (a *ADB) GetPackages(fast bool) ([]Package, error) {
 		}
 	}
 
+	// pm lists packages in the order of an internal hash table, which
+	// changes between runs.
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+
 	return packages, nil
 }
 
@@ -0,0 +1,115 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	// The battery level is checked before transfers at most this often.
+	batteryCheckInterval = 30 * time.Second
+	// Transfers are paused this long before checking the battery again.
+	batteryPauseInterval = time.Minute
+)
+
+// PowerState records how androidqf kept the device awake, and the battery
+// level during the acquisition.
+type PowerState struct {
+	KeptAwake bool `json:"kept_awake"`
+	// Value of stay_on_while_plugged_in before it was changed, restored at
+	// the end of the acquisition.
+	StayOnWhilePluggedIn string `json:"stay_on_while_plugged_in"`
+	BatteryStart         int    `json:"battery_start"`
+	BatteryEnd           int    `json:"battery_end"`
+	// Number of times transfers were paused because the battery was low.
+	Pauses int `json:"pauses"`
+}
+
+// Battery returns the battery level of the device in percent, and whether
+// it is charging.
+func (a *ADB) Battery() (int, bool, error) {
+	out, err := a.Shell("dumpsys", "battery")
+	if err != nil {
+		return 0, false, err
+	}
+	level := -1
+	charging := false
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "level":
+			level, _ = strconv.Atoi(value)
+		case "AC powered", "USB powered", "Wireless powered":
+			charging = charging || value == "true"
+		}
+	}
+	if level < 0 {
+		return 0, false, errors.New("no battery level in `dumpsys battery`")
+	}
+	return level, charging, nil
+}
+
+// KeepAwake keeps the screen of the device on while it is connected over
+// USB, so that it does not lock or sleep during the acquisition. It returns
+// the previous value of the setting, to restore it with RestoreStayOn.
+func (a *ADB) KeepAwake() (string, error) {
+	original, err := a.Shell("settings", "get", "global", "stay_on_while_plugged_in")
+	if err != nil {
+		return "", err
+	}
+	if original == "null" || original == "" {
+		original = "0"
+	}
+	_, err = a.Shell("svc", "power", "stayon", "usb")
+	if err != nil {
+		return "", err
+	}
+	return original, nil
+}
+
+// RestoreStayOn restores the value of stay_on_while_plugged_in changed by
+// KeepAwake.
+func (a *ADB) RestoreStayOn(original string) error {
+	_, err := a.Shell("settings", "put", "global", "stay_on_while_plugged_in", original)
+	return err
+}
+
+// waitForBattery pauses transfers while the battery of the device is below
+// MinBattery percent, so that it does not shut down in the middle of the
+// acquisition. It returns immediately if the level cannot be read.
+func (a *ADB) waitForBattery() {
+	if a.MinBattery <= 0 || time.Since(a.batteryChecked) < batteryCheckInterval {
+		return
+	}
+	for {
+		a.batteryChecked = time.Now()
+		level, charging, err := a.Battery()
+		if err != nil || level >= a.MinBattery {
+			return
+		}
+		if a.OnBatteryPause != nil {
+			a.OnBatteryPause()
+		}
+		if charging {
+			log.Warningf("The battery of the device is at %d%%, pausing transfers until it charges to %d%%...",
+				level, a.MinBattery)
+		} else {
+			log.Warningf("The battery of the device is at %d%%, connect it to a charger: pausing transfers until it reaches %d%%...",
+				level, a.MinBattery)
+		}
+		time.Sleep(batteryPauseInterval)
+	}
+}
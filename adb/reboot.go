@@ -0,0 +1,59 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	// The device is given this long to reboot and complete its boot.
+	rebootTimeout = 10 * time.Minute
+	// The device is polled this often while it reboots.
+	rebootPollInterval = 5 * time.Second
+)
+
+// BootID returns the identifier of the current boot of the device, which
+// changes every time it boots.
+func (a *ADB) BootID() (string, error) {
+	return a.Shell("cat", "/proc/sys/kernel/random/boot_id")
+}
+
+// Reboot reboots the device and waits until it has booted again, returning
+// the identifier of the new boot.
+func (a *ADB) Reboot() (string, error) {
+	before, err := a.BootID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the boot ID: %v", err)
+	}
+
+	out, err := a.Exec("reboot")
+	if err != nil {
+		return "", fmt.Errorf("failed to reboot the device: %v: %s", err, out)
+	}
+
+	log.Info("Waiting for the device to reboot...")
+	deadline := time.Now().Add(rebootTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(rebootPollInterval)
+
+		// Commands fail while the device is offline.
+		after, err := a.BootID()
+		if err != nil || after == "" || after == before {
+			continue
+		}
+		completed, err := a.Shell("getprop", "sys.boot_completed")
+		if err != nil || completed != "1" {
+			continue
+		}
+		return after, nil
+	}
+
+	return "", fmt.Errorf("the device did not boot again within %s", rebootTimeout)
+}
@@ -0,0 +1,48 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Script returns a shell script listing, in order and with timestamps, all
+// the adb commands executed so far, so that they can be reviewed or
+// executed again manually.
+func (a *ADB) Script(header string) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	for _, line := range strings.Split(header, "\n") {
+		script.WriteString("# " + line + "\n")
+	}
+	script.WriteString("\n")
+
+	adb := "adb"
+	if a.Serial != "" {
+		adb = fmt.Sprintf("adb -s %s", shellQuote(a.Serial))
+	}
+
+	for _, entry := range a.history {
+		script.WriteString(fmt.Sprintf("# %s\n", entry.Time.Format(time.RFC3339Nano)))
+		if len(entry.Args) > 1 && entry.Args[0] == "shell" {
+			// adb joins the arguments of shell commands, which are then
+			// interpreted by the shell on the device.
+			script.WriteString(fmt.Sprintf("%s shell %s\n\n", adb,
+				shellQuote(strings.Join(entry.Args[1:], " "))))
+			continue
+		}
+
+		quoted := make([]string, len(entry.Args))
+		for i, arg := range entry.Args {
+			quoted[i] = shellQuote(arg)
+		}
+		script.WriteString(fmt.Sprintf("%s %s\n\n", adb, strings.Join(quoted, " ")))
+	}
+
+	return script.String()
+}
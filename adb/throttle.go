@@ -0,0 +1,101 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// rateLimitedReader limits the average throughput of the wrapped reader to
+// the given number of bytes per second.
+type rateLimitedReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	// Read at most a tenth of a second worth of data at a time.
+	if chunk := l.rate / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	expected := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+	if elapsed := time.Since(l.start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+
+	return n, err
+}
+
+// pullStream downloads a file streaming it with `adb exec-out cat`, so that
+// the transfer rate can be limited and the content can be written to the
+// output sink. It returns messages similar to those of `adb pull` on failure.
+func (a *ADB) pullStream(remotePath, localPath string) (string, error) {
+	quoted := shellQuote(remotePath)
+	check, _ := a.Shell(fmt.Sprintf(
+		"if [ -d %s ]; then echo dir; elif [ ! -e %s ]; then echo missing; elif [ ! -r %s ]; then echo denied; fi",
+		quoted, quoted, quoted))
+	switch check {
+	case "dir":
+		// Files in folders are pulled one by one by the callers.
+		return "", os.MkdirAll(utils.LongPath(localPath), 0o755)
+	case "missing":
+		msg := fmt.Sprintf("adb: error: remote object '%s' does not exist", remotePath)
+		return msg, errors.New(msg)
+	case "denied":
+		msg := fmt.Sprintf("adb: error: failed to stat remote object '%s': Permission denied", remotePath)
+		return msg, errors.New(msg)
+	}
+
+	if !utils.OutputSinkEnabled() {
+		err := os.MkdirAll(utils.LongPath(filepath.Dir(localPath)), 0o755)
+		if err != nil {
+			return "", err
+		}
+	}
+	file, err := utils.CreateOutput(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	p, err := a.start("exec-out", "cat "+quoted)
+	if err != nil {
+		return "", err
+	}
+
+	var reader io.Reader = p.Stdout
+	if a.RateLimit > 0 {
+		reader = &rateLimitedReader{r: p.Stdout, rate: a.RateLimit}
+	}
+	_, copyErr := io.Copy(file, reader)
+	_, _ = io.Copy(io.Discard, p.Stdout)
+	err = p.Wait()
+	if err == nil {
+		err = copyErr
+	}
+	if err != nil {
+		return strings.TrimSpace(p.stderr.String()), err
+	}
+
+	return fmt.Sprintf("%s: 1 file pulled", remotePath), nil
+}
@@ -0,0 +1,955 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// Transports with which androidqf talks to the device.
+const (
+	// TransportServer runs the adb executable, which talks to the device
+	// through the adb server.
+	TransportServer = "server"
+	// TransportUSB talks to the device directly over USB, for computers on
+	// which the adb server cannot be started.
+	TransportUSB = "usb"
+)
+
+const (
+	// Commands of the adb protocol, their ASCII names in little endian.
+	adbCNXN = 0x4e584e43
+	adbAUTH = 0x48545541
+	adbOPEN = 0x4e45504f
+	adbOKAY = 0x59414b4f
+	adbCLSE = 0x45534c43
+	adbWRTE = 0x45545257
+
+	adbVersion    = 0x01000001
+	adbMaxPayload = 256 * 1024
+	adbHeaderSize = 24
+
+	authToken        = 1
+	authSignature    = 2
+	authRSAPublicKey = 3
+
+	// How long the owner of the device has to allow USB debugging.
+	usbAuthTimeout = 60 * time.Second
+	// How often the reader checks whether the connection was closed.
+	usbReadPoll = 500 * time.Millisecond
+
+	// Size of the data chunks of the sync protocol.
+	syncMaxData = 64 * 1024
+	// Path to which APKs are pushed before being installed.
+	usbInstallPath = "/data/local/tmp/androidqf_install.apk"
+)
+
+var (
+	errUSBClosed  = errors.New("the USB connection to the device was closed")
+	errUSBTimeout = errors.New("the USB transfer timed out")
+)
+
+// usbDeviceInfo describes the adb interface of a device connected over USB.
+type usbDeviceInfo struct {
+	Serial string
+	// Path of the device node, e.g. /dev/bus/usb/001/004.
+	Path      string
+	Interface uint8
+	In        uint8
+	Out       uint8
+}
+
+// usbDevice is the opened adb interface of a device.
+type usbDevice interface {
+	// read receives a bulk transfer, waiting at most timeout if not zero.
+	read(p []byte, timeout time.Duration) (int, error)
+	write(p []byte) error
+	close() error
+}
+
+type usbMessage struct {
+	command uint32
+	arg0    uint32
+	arg1    uint32
+	data    []byte
+}
+
+// usbConn is a connection to adbd over USB, on which streams are opened to
+// run services on the device.
+type usbConn struct {
+	dev      usbDevice
+	maxData  int
+	features map[string]bool
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	streams map[uint32]*usbStream
+	nextID  uint32
+	err     error
+	done    chan struct{}
+}
+
+func (c *usbConn) send(command, arg0, arg1 uint32, data []byte) error {
+	header := make([]byte, adbHeaderSize)
+	var check uint32
+	for _, b := range data {
+		check += uint32(b)
+	}
+	binary.LittleEndian.PutUint32(header[0:], command)
+	binary.LittleEndian.PutUint32(header[4:], arg0)
+	binary.LittleEndian.PutUint32(header[8:], arg1)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[16:], check)
+	binary.LittleEndian.PutUint32(header[20:], command^0xffffffff)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	err := c.dev.write(header)
+	if err == nil && len(data) > 0 {
+		err = c.dev.write(data)
+	}
+	return err
+}
+
+// receive reads a message, waiting at most timeout for it to start.
+func (c *usbConn) receive(timeout time.Duration) (*usbMessage, error) {
+	header := make([]byte, adbHeaderSize)
+	n, err := c.dev.read(header, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if n != adbHeaderSize {
+		return nil, fmt.Errorf("invalid adb message header of %d bytes", n)
+	}
+	msg := &usbMessage{
+		command: binary.LittleEndian.Uint32(header[0:]),
+		arg0:    binary.LittleEndian.Uint32(header[4:]),
+		arg1:    binary.LittleEndian.Uint32(header[8:]),
+	}
+	if binary.LittleEndian.Uint32(header[20:]) != msg.command^0xffffffff {
+		return nil, errors.New("invalid adb message header")
+	}
+
+	msg.data = make([]byte, binary.LittleEndian.Uint32(header[12:]))
+	for read := 0; read < len(msg.data); {
+		n, err := c.dev.read(msg.data[read:], 0)
+		if err != nil {
+			return nil, err
+		}
+		read += n
+	}
+	return msg, nil
+}
+
+// handshake connects to adbd, authenticating with the given key. If the
+// device does not know the key yet, its owner is asked to allow it.
+func (c *usbConn) handshake(key *rsa.PrivateKey) error {
+	err := c.send(adbCNXN, adbVersion, adbMaxPayload, []byte("host::features=shell_v2\x00"))
+	if err != nil {
+		return err
+	}
+
+	signed := false
+	deadline := time.Now().Add(usbAuthTimeout)
+	for time.Now().Before(deadline) {
+		msg, err := c.receive(usbReadPoll)
+		if errors.Is(err, errUSBTimeout) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		switch msg.command {
+		case adbCNXN:
+			c.maxData = int(msg.arg1)
+			if c.maxData <= 0 || c.maxData > adbMaxPayload {
+				c.maxData = adbMaxPayload
+			}
+			c.features = map[string]bool{}
+			// device::ro.product.name=...;features=shell_v2,cmd,...
+			for _, prop := range strings.Split(strings.TrimRight(string(msg.data), "\x00"), ";") {
+				if features, ok := strings.CutPrefix(prop, "features="); ok {
+					for _, feature := range strings.Split(features, ",") {
+						c.features[feature] = true
+					}
+				}
+			}
+			return nil
+		case adbAUTH:
+			if msg.arg0 != authToken {
+				continue
+			}
+			if !signed {
+				signed = true
+				signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA1, msg.data)
+				if err != nil {
+					return fmt.Errorf("failed to sign the authentication token: %v", err)
+				}
+				err = c.send(adbAUTH, authSignature, 0, signature)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// The signature was refused, the device does not know the key.
+			pub := androidPublicKey(&key.PublicKey)
+			log.Infof("Allow USB debugging on the device for the key with fingerprint %s", keyFingerprint(pub))
+			err = c.send(adbAUTH, authRSAPublicKey, 0,
+				[]byte(base64.StdEncoding.EncodeToString(pub)+" androidqf\x00"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return errors.New("the device did not authorize USB debugging")
+}
+
+// run dispatches the messages received to the streams, until the
+// connection fails or is closed.
+func (c *usbConn) run() {
+	for {
+		msg, err := c.receive(usbReadPoll)
+		if errors.Is(err, errUSBTimeout) {
+			select {
+			case <-c.done:
+				return
+			default:
+				continue
+			}
+		} else if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		stream := c.streams[msg.arg1]
+		c.mu.Unlock()
+		if stream == nil {
+			if msg.command == adbWRTE {
+				// Let the device close streams which were abandoned.
+				_ = c.send(adbCLSE, msg.arg1, msg.arg0, nil)
+			}
+			continue
+		}
+
+		switch msg.command {
+		case adbOKAY:
+			stream.acknowledged(msg.arg0)
+		case adbWRTE:
+			stream.received(msg.data)
+		case adbCLSE:
+			stream.closed(nil)
+			c.mu.Lock()
+			delete(c.streams, stream.localID)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// fail closes the connection and all of its streams with an error.
+func (c *usbConn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+	streams := c.streams
+	c.streams = map[uint32]*usbStream{}
+	c.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.closed(err)
+	}
+}
+
+func (c *usbConn) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *usbConn) close() {
+	c.fail(errUSBClosed)
+	_ = c.dev.close()
+}
+
+// open opens a stream running the given service, such as "shell:ls".
+func (c *usbConn) open(service string) (*usbStream, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	stream := &usbStream{
+		conn:    c,
+		localID: c.nextID,
+		opened:  make(chan struct{}),
+		acks:    make(chan struct{}, 1),
+	}
+	stream.cond = sync.NewCond(&stream.mu)
+	c.streams[stream.localID] = stream
+	c.mu.Unlock()
+
+	err := c.send(adbOPEN, stream.localID, 0, []byte(service+"\x00"))
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+
+	<-stream.opened
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.remoteID == 0 {
+		if stream.err != nil {
+			return nil, stream.err
+		}
+		return nil, fmt.Errorf("the device refused to run %s", strings.SplitN(service, ":", 2)[0])
+	}
+	return stream, nil
+}
+
+// usbStream is a stream between androidqf and a service on the device.
+type usbStream struct {
+	conn     *usbConn
+	localID  uint32
+	remoteID uint32
+	opened   chan struct{}
+	acks     chan struct{}
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Data received and not read yet. The device only sends more once the
+	// previous data was acknowledged, which happens when it is read.
+	pending [][]byte
+	buf     []byte
+	eof     bool
+	err     error
+}
+
+func (s *usbStream) acknowledged(remoteID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remoteID == 0 {
+		s.remoteID = remoteID
+		close(s.opened)
+		return
+	}
+	select {
+	case s.acks <- struct{}{}:
+	default:
+	}
+}
+
+func (s *usbStream) received(data []byte) {
+	s.mu.Lock()
+	s.pending = append(s.pending, data)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *usbStream) closed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.eof {
+		return
+	}
+	s.eof = true
+	s.err = err
+	if s.remoteID == 0 {
+		close(s.opened)
+	}
+	close(s.acks)
+	s.cond.Broadcast()
+}
+
+func (s *usbStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 {
+		if len(s.pending) > 0 {
+			s.buf = s.pending[0]
+			s.pending = s.pending[1:]
+			if !s.eof {
+				s.mu.Unlock()
+				_ = s.conn.send(adbOKAY, s.localID, s.remoteID, nil)
+				s.mu.Lock()
+			}
+			continue
+		}
+		if s.eof {
+			err := s.err
+			s.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		s.cond.Wait()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	s.mu.Unlock()
+	return n, nil
+}
+
+func (s *usbStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > s.conn.maxData {
+			chunk = chunk[:s.conn.maxData]
+		}
+		err := s.conn.send(adbWRTE, s.localID, s.remoteID, chunk)
+		if err != nil {
+			return written, err
+		}
+		if _, ok := <-s.acks; !ok {
+			s.mu.Lock()
+			err = s.err
+			s.mu.Unlock()
+			if err == nil {
+				err = io.ErrClosedPipe
+			}
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Close closes the stream, which stops the service on the device.
+func (s *usbStream) Close() error {
+	s.mu.Lock()
+	eof := s.eof
+	s.mu.Unlock()
+	if eof {
+		return nil
+	}
+	s.closed(io.ErrClosedPipe)
+	s.conn.mu.Lock()
+	delete(s.conn.streams, s.localID)
+	s.conn.mu.Unlock()
+	return s.conn.send(adbCLSE, s.localID, s.remoteID, nil)
+}
+
+// shellV2Reader extracts the standard output from the packets of the v2
+// shell protocol, recording the standard error and the exit code.
+type shellV2Reader struct {
+	r         io.Reader
+	stderr    *bytes.Buffer
+	exitCode  int
+	remaining int
+}
+
+func (r *shellV2Reader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		// Packet identifier, 1 for stdout, 2 for stderr and 3 for the exit
+		// code, followed by the length of the data.
+		header := make([]byte, 5)
+		_, err := io.ReadFull(r.r, header)
+		if err != nil {
+			return 0, err
+		}
+		length := int(binary.LittleEndian.Uint32(header[1:]))
+		switch header[0] {
+		case 1:
+			r.remaining = length
+		case 3:
+			code := make([]byte, length)
+			_, err = io.ReadFull(r.r, code)
+			if err == nil && length > 0 {
+				r.exitCode = int(code[0])
+			}
+		default:
+			_, err = io.CopyN(r.stderr, r.r, int64(length))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+// usbTransport runs the adb commands used by androidqf over a direct USB
+// connection to the device, reconnecting when the device comes back, for
+// example after a reboot.
+type usbTransport struct {
+	serial string
+	key    *rsa.PrivateKey
+
+	mu   sync.Mutex
+	conn *usbConn
+}
+
+// selectUSBDevice returns the device with the given serial, or the only
+// device connected if serial is empty.
+func selectUSBDevice(serial string) (*usbDeviceInfo, error) {
+	devices, err := findUSBDevices()
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no devices with USB debugging enabled connected over USB")
+	}
+	if serial == "" {
+		if len(devices) > 1 {
+			return nil, errors.New("multiple devices connected, please provide a serial number")
+		}
+		return &devices[0], nil
+	}
+	for i := range devices {
+		if strings.EqualFold(devices[i].Serial, serial) {
+			return &devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("serial %s not found in the device list", serial)
+}
+
+func (u *usbTransport) connect() (*usbConn, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conn != nil {
+		if u.conn.failed() == nil {
+			return u.conn, nil
+		}
+		u.conn.close()
+		u.conn = nil
+	}
+
+	info, err := selectUSBDevice(u.serial)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := openUSBDevice(info)
+	if err != nil {
+		return nil, err
+	}
+	conn := &usbConn{
+		dev:     dev,
+		streams: map[uint32]*usbStream{},
+		done:    make(chan struct{}),
+	}
+	err = conn.handshake(u.key)
+	if err != nil {
+		_ = dev.close()
+		return nil, err
+	}
+	go conn.run()
+	u.conn = conn
+	return conn, nil
+}
+
+// close closes the connection to the device, which is opened again when
+// needed.
+func (u *usbTransport) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conn != nil {
+		u.conn.close()
+		u.conn = nil
+	}
+}
+
+func (u *usbTransport) devices() ([]string, error) {
+	devices, err := findUSBDevices()
+	if err != nil {
+		return nil, err
+	}
+	serials := []string{}
+	for _, dev := range devices {
+		serials = append(serials, dev.Serial)
+	}
+	return serials, nil
+}
+
+func (u *usbTransport) open(service string) (*usbStream, error) {
+	conn, err := u.connect()
+	if err != nil {
+		return nil, err
+	}
+	return conn.open(service)
+}
+
+// start runs the adb commands which stream their output: shell, exec-out
+// and backup.
+func (u *usbTransport) start(args []string) (*process, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no adb command")
+	}
+	conn, err := u.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	command := strings.Join(args[1:], " ")
+	v2 := false
+	service := ""
+	switch args[0] {
+	case "shell":
+		v2 = conn.features["shell_v2"]
+		if v2 {
+			service = "shell,v2,raw:" + command
+		} else {
+			service = "shell:" + command
+		}
+	case "exec-out":
+		service = "exec:" + command
+	case "backup":
+		service = "backup:" + command
+	default:
+		return nil, fmt.Errorf("adb %s is not supported over the USB transport", args[0])
+	}
+
+	stream, err := conn.open(service)
+	if err != nil {
+		return nil, err
+	}
+	p := &process{stderr: &bytes.Buffer{}, kill: stream.Close}
+	if !v2 {
+		p.Stdout = stream
+		p.wait = func() error {
+			_, _ = io.Copy(io.Discard, stream)
+			stream.Close()
+			return nil
+		}
+		return p, nil
+	}
+
+	reader := &shellV2Reader{r: stream, stderr: p.stderr}
+	p.Stdout = reader
+	p.wait = func() error {
+		_, err := io.Copy(io.Discard, reader)
+		stream.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if reader.exitCode != 0 {
+			return fmt.Errorf("exit status %d", reader.exitCode)
+		}
+		return nil
+	}
+	return p, nil
+}
+
+// exec runs an adb command and returns its output, as the adb executable
+// would.
+func (u *usbTransport) exec(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no adb command")
+	}
+	switch args[0] {
+	case "get-state":
+		_, err := u.connect()
+		if err != nil {
+			return nil, err
+		}
+		return []byte("device\n"), nil
+	case "shell", "exec-out":
+		p, err := u.start(args)
+		if err != nil {
+			return nil, err
+		}
+		out, _ := io.ReadAll(p.Stdout)
+		return out, p.Wait()
+	case "pull":
+		if len(args) != 3 {
+			break
+		}
+		out, err := u.pull(args[1], args[2], 0, func(path string) (io.WriteCloser, error) {
+			return os.Create(path)
+		})
+		return []byte(out), err
+	case "push":
+		if len(args) != 3 {
+			break
+		}
+		err := u.push(args[1], args[2])
+		if err != nil {
+			return []byte(err.Error()), err
+		}
+		return []byte(fmt.Sprintf("%s: 1 file pushed", args[1])), nil
+	case "install":
+		return u.install(args[1:])
+	case "reboot":
+		stream, err := u.open("reboot:")
+		if err != nil {
+			return nil, err
+		}
+		_, _ = io.Copy(io.Discard, stream)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("adb %s is not supported over the USB transport", strings.Join(args, " "))
+}
+
+// syncRequest sends a request of the sync protocol.
+func syncRequest(stream io.Writer, id string, data []byte) error {
+	request := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	_, err := stream.Write(append(request, data...))
+	return err
+}
+
+// syncResponse reads the identifier and the length or value of a response
+// of the sync protocol.
+func syncResponse(stream io.Reader) (string, uint32, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(stream, header)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+func syncFailure(stream io.Reader, length uint32) string {
+	message := make([]byte, length)
+	_, _ = io.ReadFull(stream, message)
+	return string(message)
+}
+
+// syncDataReader reads the content of a file received with the sync
+// protocol.
+type syncDataReader struct {
+	stream    io.Reader
+	remaining uint32
+	done      bool
+}
+
+func (r *syncDataReader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		id, length, err := syncResponse(r.stream)
+		if err != nil {
+			return 0, err
+		}
+		switch id {
+		case "DATA":
+			r.remaining = length
+		case "DONE":
+			r.done = true
+		case "FAIL":
+			r.done = true
+			return 0, fmt.Errorf("remote %s", syncFailure(r.stream, length))
+		default:
+			return 0, fmt.Errorf("unexpected sync response %q", id)
+		}
+	}
+	if uint32(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.stream.Read(p)
+	r.remaining -= uint32(n)
+	return n, err
+}
+
+// pull copies a file from the device, with messages similar to those of
+// `adb pull`. Folders are created, their files are pulled by the callers.
+func (u *usbTransport) pull(remotePath, localPath string, rate int64,
+	create func(path string) (io.WriteCloser, error),
+) (string, error) {
+	stream, err := u.open("sync:")
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	defer func() { _ = syncRequest(stream, "QUIT", nil) }()
+
+	err = syncRequest(stream, "STAT", []byte(remotePath))
+	if err != nil {
+		return "", err
+	}
+	stat := make([]byte, 16)
+	_, err = io.ReadFull(stream, stat)
+	if err != nil {
+		return "", err
+	}
+	mode := binary.LittleEndian.Uint32(stat[4:])
+	if mode == 0 {
+		msg := fmt.Sprintf("adb: error: remote object '%s' does not exist", remotePath)
+		return msg, errors.New(msg)
+	}
+	if mode&0o170000 == 0o040000 {
+		return "", os.MkdirAll(utils.LongPath(localPath), 0o755)
+	}
+
+	err = syncRequest(stream, "RECV", []byte(remotePath))
+	if err != nil {
+		return "", err
+	}
+	file, err := create(localPath)
+	if err != nil {
+		return "", err
+	}
+	var reader io.Reader = &syncDataReader{stream: stream}
+	if rate > 0 {
+		reader = &rateLimitedReader{r: reader, rate: rate}
+	}
+	_, err = io.Copy(file, reader)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		msg := fmt.Sprintf("adb: error: failed to copy '%s' to '%s': %v", remotePath, localPath, err)
+		return msg, errors.New(msg)
+	}
+	return fmt.Sprintf("%s: 1 file pulled", remotePath), nil
+}
+
+// push copies a local file to the device, keeping its permissions.
+func (u *usbTransport) push(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	stream, err := u.open("sync:")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	defer func() { _ = syncRequest(stream, "QUIT", nil) }()
+
+	err = syncRequest(stream, "SEND", []byte(fmt.Sprintf("%s,%d", remotePath, 0o100000|stat.Mode().Perm())))
+	if err != nil {
+		return err
+	}
+	chunk := make([]byte, syncMaxData)
+	for {
+		n, readErr := file.Read(chunk)
+		if n > 0 {
+			err = syncRequest(stream, "DATA", chunk[:n])
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+	done := binary.LittleEndian.AppendUint32([]byte("DONE"), uint32(stat.ModTime().Unix()))
+	_, err = stream.Write(done)
+	if err != nil {
+		return err
+	}
+
+	id, length, err := syncResponse(stream)
+	if err != nil {
+		return err
+	}
+	if id == "FAIL" {
+		return fmt.Errorf("adb: error: failed to copy '%s' to '%s': remote %s", localPath, remotePath,
+			syncFailure(stream, length))
+	}
+	return nil
+}
+
+// install installs an APK, given the arguments of `adb install`.
+func (u *usbTransport) install(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no APK to install")
+	}
+	err := u.push(args[len(args)-1], usbInstallPath)
+	if err != nil {
+		return []byte(err.Error()), err
+	}
+	defer func() { _, _ = u.exec([]string{"shell", "rm", "-f", usbInstallPath}) }()
+
+	command := append([]string{"shell", "pm", "install"}, args[:len(args)-1]...)
+	return u.exec(append(command, usbInstallPath))
+}
+
+// bugreport generates a zipped bugreport with bugreportz and copies it to
+// the given local path, as `adb bugreport` does.
+func (u *usbTransport) bugreport(localPath string) error {
+	out, err := u.exec([]string{"shell", "bugreportz"})
+	if err != nil {
+		return fmt.Errorf("failed to generate the bugreport: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	remotePath := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if path, ok := strings.CutPrefix(line, "OK:"); ok {
+			remotePath = path
+		} else if reason, ok := strings.CutPrefix(line, "FAIL:"); ok {
+			return fmt.Errorf("failed to generate the bugreport: %s", reason)
+		}
+	}
+	if remotePath == "" {
+		return fmt.Errorf("unexpected output of bugreportz: %s", strings.TrimSpace(string(out)))
+	}
+
+	_, err = u.pull(remotePath, localPath, 0, func(path string) (io.WriteCloser, error) {
+		return os.Create(path)
+	})
+	return err
+}
+
+// pullUSB pulls a file over the USB transport, writing it to the output
+// sink if enabled.
+func (a *ADB) pullUSB(remotePath, localPath string) (string, error) {
+	a.record("pull", remotePath, localPath)
+	return a.usb.pull(remotePath, localPath, a.RateLimit, func(path string) (io.WriteCloser, error) {
+		if !utils.OutputSinkEnabled() {
+			err := os.MkdirAll(utils.LongPath(filepath.Dir(path)), 0o755)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return utils.CreateOutput(path)
+	})
+}
+
+// backupUSB writes a backup to backup.ab in the current folder, as
+// `adb backup` does.
+func (a *ADB) backupUSB(arg string) error {
+	p, err := a.start("backup", "-nocompress", arg)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create("backup.ab")
+	if err != nil {
+		_ = p.Kill()
+		return err
+	}
+	_, copyErr := io.Copy(file, p.Stdout)
+	closeErr := file.Close()
+	err = p.Wait()
+	if err == nil {
+		err = copyErr
+	}
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	usbSysfsPath = "/sys/bus/usb/devices"
+	// Largest bulk transfer accepted by usbfs on all kernels.
+	usbfsMaxTransfer = 16 * 1024
+	usbWriteTimeout  = 10 * time.Second
+)
+
+// usbdevfsBulkTransfer is struct usbdevfs_bulktransfer of linux/usbdevice_fs.h.
+type usbdevfsBulkTransfer struct {
+	endpoint uint32
+	length   uint32
+	timeout  uint32
+	data     unsafe.Pointer
+}
+
+func usbdevfsIOC(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'U'<<8 | nr
+}
+
+var (
+	usbdevfsBulk             = usbdevfsIOC(3, 2, unsafe.Sizeof(usbdevfsBulkTransfer{}))
+	usbdevfsClaimInterface   = usbdevfsIOC(2, 15, 4)
+	usbdevfsReleaseInterface = usbdevfsIOC(2, 16, 4)
+	usbdevfsClearHalt        = usbdevfsIOC(2, 21, 4)
+)
+
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// findUSBDevices returns the devices exposing an adb interface, from the
+// interfaces listed in sysfs.
+func findUSBDevices() ([]usbDeviceInfo, error) {
+	entries, err := os.ReadDir(usbSysfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the USB devices: %v", err)
+	}
+
+	devices := []usbDeviceInfo{}
+	for _, entry := range entries {
+		// Interfaces are named after their device, e.g. 1-2:1.0 for 1-2.
+		deviceName, _, ok := strings.Cut(entry.Name(), ":")
+		if !ok {
+			continue
+		}
+		ifaceDir := filepath.Join(usbSysfsPath, entry.Name())
+		if readSysfs(ifaceDir, "bInterfaceClass") != "ff" ||
+			readSysfs(ifaceDir, "bInterfaceSubClass") != "42" ||
+			readSysfs(ifaceDir, "bInterfaceProtocol") != "01" {
+			continue
+		}
+
+		deviceDir := filepath.Join(usbSysfsPath, deviceName)
+		bus, busErr := strconv.Atoi(readSysfs(deviceDir, "busnum"))
+		dev, devErr := strconv.Atoi(readSysfs(deviceDir, "devnum"))
+		iface, ifaceErr := strconv.ParseUint(readSysfs(ifaceDir, "bInterfaceNumber"), 16, 8)
+		if busErr != nil || devErr != nil || ifaceErr != nil {
+			continue
+		}
+		info := usbDeviceInfo{
+			Serial:    readSysfs(deviceDir, "serial"),
+			Path:      fmt.Sprintf("/dev/bus/usb/%03d/%03d", bus, dev),
+			Interface: uint8(iface),
+		}
+
+		endpoints, _ := filepath.Glob(filepath.Join(ifaceDir, "ep_*"))
+		for _, endpoint := range endpoints {
+			if readSysfs(endpoint, "type") != "Bulk" {
+				continue
+			}
+			address, err := strconv.ParseUint(readSysfs(endpoint, "bEndpointAddress"), 16, 8)
+			if err != nil {
+				continue
+			}
+			if readSysfs(endpoint, "direction") == "in" {
+				info.In = uint8(address)
+			} else {
+				info.Out = uint8(address)
+			}
+		}
+		if info.In == 0 || info.Out == 0 {
+			continue
+		}
+		if info.Serial == "" {
+			info.Serial = deviceName
+		}
+		devices = append(devices, info)
+	}
+	return devices, nil
+}
+
+// usbfsDevice is the adb interface of a device opened through usbfs.
+type usbfsDevice struct {
+	file  *os.File
+	iface uint32
+	in    uint32
+	out   uint32
+}
+
+func (d *usbfsDevice) ioctl(request uintptr, arg unsafe.Pointer) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.file.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func openUSBDevice(info *usbDeviceInfo) (usbDevice, error) {
+	file, err := os.OpenFile(info.Path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("permission denied opening %s, install the udev rules for Android devices "+
+			"or run androidqf as root", info.Path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	d := &usbfsDevice{
+		file:  file,
+		iface: uint32(info.Interface),
+		in:    uint32(info.In),
+		out:   uint32(info.Out),
+	}
+	_, err = d.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&d.iface))
+	if err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EBUSY) {
+			return nil, errors.New("the adb interface of the device is in use, stop the adb server " +
+				"with `adb kill-server`")
+		}
+		return nil, fmt.Errorf("failed to claim the adb interface of the device: %v", err)
+	}
+	// Discard anything left over from a previous connection.
+	_, _ = d.ioctl(usbdevfsClearHalt, unsafe.Pointer(&d.in))
+	_, _ = d.ioctl(usbdevfsClearHalt, unsafe.Pointer(&d.out))
+	return d, nil
+}
+
+func (d *usbfsDevice) bulk(endpoint uint32, p []byte, timeout time.Duration) (int, error) {
+	transfer := usbdevfsBulkTransfer{
+		endpoint: endpoint,
+		length:   uint32(len(p)),
+		timeout:  uint32(timeout.Milliseconds()),
+		data:     unsafe.Pointer(&p[0]),
+	}
+	n, err := d.ioctl(usbdevfsBulk, unsafe.Pointer(&transfer))
+	runtime.KeepAlive(p)
+	if errors.Is(err, syscall.ETIMEDOUT) {
+		return n, errUSBTimeout
+	} else if errors.Is(err, syscall.ENODEV) || errors.Is(err, syscall.ESHUTDOWN) {
+		return n, errUSBClosed
+	}
+	return n, err
+}
+
+func (d *usbfsDevice) read(p []byte, timeout time.Duration) (int, error) {
+	if len(p) > usbfsMaxTransfer {
+		p = p[:usbfsMaxTransfer]
+	}
+	return d.bulk(d.in, p, timeout)
+}
+
+func (d *usbfsDevice) write(p []byte) error {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > usbfsMaxTransfer {
+			chunk = chunk[:usbfsMaxTransfer]
+		}
+		n, err := d.bulk(d.out, chunk, usbWriteTimeout)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func (d *usbfsDevice) close() error {
+	_, _ = d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&d.iface))
+	return d.file.Close()
+}
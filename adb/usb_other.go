@@ -0,0 +1,20 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+//go:build !linux
+
+package adb
+
+import "errors"
+
+var errUSBUnsupported = errors.New("the USB transport is only supported on Linux")
+
+func findUSBDevices() ([]usbDeviceInfo, error) {
+	return nil, errUSBUnsupported
+}
+
+func openUSBDevice(info *usbDeviceInfo) (usbDevice, error) {
+	return nil, errUSBUnsupported
+}
@@ -0,0 +1,201 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	// DefaultCollectorTimeout is how long the collector can run without
+	// producing any output before it is considered hung.
+	DefaultCollectorTimeout = 5 * time.Minute
+	// Number of times a hung collector command is retried.
+	collectorRetries = 2
+)
+
+var errCollectorHung = errors.New("the collector produced no output")
+
+// activity records when a command last produced output.
+type activity struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newActivity() *activity {
+	return &activity{last: time.Now()}
+}
+
+func (a *activity) touch() {
+	a.mu.Lock()
+	a.last = time.Now()
+	a.mu.Unlock()
+}
+
+func (a *activity) idle() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.last)
+}
+
+type activityWriter struct {
+	w   io.Writer
+	act *activity
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.act.touch()
+	return w.w.Write(p)
+}
+
+type activityReader struct {
+	r   io.Reader
+	act *activity
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.act.touch()
+	}
+	return n, err
+}
+
+// watch kills the command, and any collector process left on the device,
+// if it produces no output for the timeout of the collector. It stops
+// watching when stop is closed, and returns whether the command was killed.
+func (c *Collector) watch(p *process, act *activity, stop <-chan struct{}) *atomic.Bool {
+	killed := &atomic.Bool{}
+	if c.Timeout <= 0 {
+		return killed
+	}
+
+	interval := c.Timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if act.idle() < c.Timeout {
+					continue
+				}
+				killed.Store(true)
+				_ = p.Kill()
+				c.killRunning()
+				return
+			}
+		}
+	}()
+	return killed
+}
+
+// killRunning kills the collector processes running on the device, which
+// are not stopped when the local adb process is.
+func (c *Collector) killRunning() {
+	for _, pid := range c.Adb.collectorPIDs([]string{c.ExePath}) {
+		log.Debugf("Killing hung collector process %s", pid)
+		_, _ = c.Adb.Shell("kill", "-9", pid)
+	}
+}
+
+// shellOnce runs the collector through adb shell, killing it if it hangs.
+func (c *Collector) shellOnce(cmd ...string) (string, error) {
+	p, err := c.Adb.start(append([]string{"shell"}, cmd...)...)
+	if err != nil {
+		return "", err
+	}
+
+	var stdout bytes.Buffer
+	act := newActivity()
+	stop := make(chan struct{})
+	killed := c.watch(p, act, stop)
+	_, _ = io.Copy(&stdout, &activityReader{r: p.Stdout, act: act})
+	err = p.Wait()
+	close(stop)
+
+	if killed.Load() {
+		return "", fmt.Errorf("%w for %s", errCollectorHung, c.Timeout)
+	}
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// shell runs the collector through adb shell, and retries it if it hangs,
+// for example while walking a stalled FUSE mount.
+func (c *Collector) shell(cmd ...string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		out, err := c.shellOnce(cmd...)
+		if !errors.Is(err, errCollectorHung) || attempt >= collectorRetries {
+			return out, err
+		}
+		log.Warningf("The collector hung while running `%s`, retrying...", strings.Join(cmd[1:], " "))
+	}
+}
+
+// streamOnce runs the collector through adb exec-out and passes each line
+// of its output to handle as it is received, so that long listings are
+// never buffered on the device or on the host. It kills the collector if
+// it hangs.
+func (c *Collector) streamOnce(handle func(line []byte), cmd ...string) error {
+	p, err := c.Adb.start("exec-out", strings.Join(cmd, " "))
+	if err != nil {
+		return err
+	}
+
+	act := newActivity()
+	stop := make(chan struct{})
+	killed := c.watch(p, act, stop)
+	defer close(stop)
+
+	// Listings and command outputs are not rate limited, only the files
+	// pulled are.
+	br := bufio.NewReader(&activityReader{r: p.Stdout, act: act})
+	for {
+		line, readErr := br.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			handle(line)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	err = p.Wait()
+	if killed.Load() {
+		return fmt.Errorf("%w for %s", errCollectorHung, c.Timeout)
+	}
+	return err
+}
+
+// stream runs the collector like streamOnce, and retries it if it hangs.
+// reset is called before each retry, so that the lines already handled can
+// be discarded.
+func (c *Collector) stream(reset func(), handle func(line []byte), cmd ...string) error {
+	for attempt := 0; ; attempt++ {
+		err := c.streamOnce(handle, cmd...)
+		if !errors.Is(err, errCollectorHung) || attempt >= collectorRetries {
+			return err
+		}
+		log.Warningf("The collector hung while running `%s`, retrying...", strings.Join(cmd[1:], " "))
+		reset()
+	}
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveMaxSize int64
+	archiveExclude []string
+)
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+
+	archiveCmd.Flags().Int64VarP(&archiveMaxSize, "max-size", "m", 0,
+		"Skip files bigger than this size in bytes (0 for no limit)")
+	archiveCmd.Flags().StringArrayVarP(&archiveExclude, "exclude", "e", []string{},
+		"Path to exclude from the archive (can be repeated)")
+}
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Write a compressed archive of a folder to stdout",
+	Long:  `Write a gzip-compressed tar archive of the readable files in a given folder to stdout`,
+	Run:   archive,
+}
+
+func isExcluded(path string) bool {
+	for _, exclude := range archiveExclude {
+		if path == exclude || strings.HasPrefix(path, strings.TrimSuffix(exclude, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func addToArchive(tw *tar.Writer, path string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, _ = os.Readlink(path)
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = strings.TrimPrefix(path, "/")
+
+	if !info.Mode().IsRegular() {
+		return tw.WriteHeader(header)
+	}
+
+	// Open the file before writing the header, so that unreadable files
+	// are skipped altogether.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	// Files might shrink while being read, in which case the archive would
+	// be corrupted: pad with zeros up to the declared size.
+	n, err := io.CopyN(tw, file, info.Size())
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n < info.Size() {
+		_, err = io.CopyN(tw, zeroReader{}, info.Size()-n)
+	}
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Execute the command
+func archive(cmd *cobra.Command, args []string) {
+	var target_path string
+	if len(args) == 0 {
+		target_path = "/sdcard/"
+	} else {
+		target_path = args[0]
+	}
+
+	gw := gzip.NewWriter(os.Stdout)
+	tw := tar.NewWriter(gw)
+
+	err := filepath.Walk(target_path,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if isExcluded(path) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && archiveMaxSize > 0 && info.Size() > archiveMaxSize {
+				return nil
+			}
+			if !info.IsDir() && !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+				// Skip devices, sockets and pipes.
+				return nil
+			}
+
+			return addToArchive(tw, path, info)
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
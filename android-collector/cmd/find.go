@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash"
+	"io"
 	"log"
 	"math"
 	"net/http"
@@ -49,6 +50,13 @@ type Job struct {
 	Hash     bool
 }
 
+const (
+	// Bytes read to detect the type of a file.
+	mimeHeaderSize = 8192
+	// Size of the chunks in which files are read to be hashed.
+	hashChunkSize = 64 * 1024
+)
+
 var hashOption bool
 
 func getMimeType(buf []byte) (string, error) {
@@ -108,13 +116,16 @@ func processFile(filePath string, fileInfo os.FileInfo, getHash bool) FileInfo {
 		}
 		defer file.Close()
 
-		buf := make([]byte, f.Size)
-		_, err = file.Read(buf)
-		if err != nil {
+		// Only the header is kept in memory to detect the type, and the
+		// rest of the file is hashed in chunks.
+		header := make([]byte, mimeHeaderSize)
+		n, err := io.ReadFull(file, header)
+		if err != nil && err != io.ErrUnexpectedEOF {
 			return f
 		}
+		header = header[:n]
 
-		mimeType, err := getMimeType(buf)
+		mimeType, err := getMimeType(header)
 		if err == nil {
 			f.MimeType = mimeType
 		}
@@ -126,8 +137,11 @@ func processFile(filePath string, fileInfo os.FileInfo, getHash bool) FileInfo {
 			sha512.New(),
 		}
 
-		for _, h := range hashes {
-			h.Write(buf)
+		w := io.MultiWriter(hashes[0], hashes[1], hashes[2], hashes[3])
+		w.Write(header)
+		_, err = io.CopyBuffer(w, file, make([]byte, hashChunkSize))
+		if err != nil {
+			return f
 		}
 
 		f.MD5 = hex.EncodeToString(hashes[0].Sum(nil))
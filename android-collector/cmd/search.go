@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+type SearchMatch struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	String string `json:"string"`
+	Text   string `json:"text"`
+}
+
+var (
+	searchMaxSize    int64
+	searchMaxMatches int
+	searchStrings    []string
+)
+
+// Maximum number of characters of the matching line kept as context.
+const searchMaxTextLength = 256
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().Int64VarP(&searchMaxSize, "max-size", "m", 10*1024*1024,
+		"Skip files bigger than this size in bytes")
+	searchCmd.Flags().IntVarP(&searchMaxMatches, "max-matches", "n", 1000,
+		"Stop after this number of matches")
+	searchCmd.Flags().StringArrayVarP(&searchStrings, "string", "s", []string{},
+		"String to search for (can be repeated)")
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Search strings in files in a given folder",
+	Long:  `Search strings (case insensitive) in readable files in a given folder`,
+	Run:   search,
+}
+
+func searchFile(filePath string, needles []string, remaining int) []SearchMatch {
+	matches := []SearchMatch{}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return matches
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		lower := strings.ToLower(line)
+		for _, needle := range needles {
+			if !strings.Contains(lower, needle) {
+				continue
+			}
+
+			text := line
+			if len(text) > searchMaxTextLength {
+				text = text[:searchMaxTextLength]
+			}
+			matches = append(matches, SearchMatch{
+				Path:   filePath,
+				Line:   lineNumber,
+				String: needle,
+				Text:   text,
+			})
+			if len(matches) >= remaining {
+				return matches
+			}
+		}
+	}
+
+	return matches
+}
+
+// Execute the command
+func search(cmd *cobra.Command, args []string) {
+	var target_path string
+	if len(args) == 0 {
+		target_path = "/"
+	} else {
+		target_path = args[0]
+	}
+
+	if len(searchStrings) == 0 {
+		return
+	}
+	needles := []string{}
+	for _, s := range searchStrings {
+		needles = append(needles, strings.ToLower(s))
+	}
+
+	total := 0
+	err := filepath.Walk(target_path,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if strings.HasPrefix(path, "/proc") || strings.HasPrefix(path, "/sys") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() || info.Size() > searchMaxSize {
+				return nil
+			}
+
+			for _, match := range searchFile(path, needles, searchMaxMatches-total) {
+				jsonData, err := json.Marshal(&match)
+				if err != nil {
+					continue
+				}
+				fmt.Println(string(jsonData))
+				total++
+			}
+			if total >= searchMaxMatches {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -6,7 +6,9 @@
 package assets
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"errors"
 	"os"
 	"path/filepath"
@@ -63,3 +65,24 @@ func CleanAssets() error {
 
 	return nil
 }
+
+// Hashes returns the SHA256 of all the assets embedded in the executable.
+func Hashes() map[string]string {
+	hashes := map[string]string{}
+	for _, asset := range getAssets() {
+		sum := sha256.Sum256(asset.Data)
+		hashes[asset.Name] = hex.EncodeToString(sum[:])
+	}
+
+	entries, _ := Collector.ReadDir(".")
+	for _, entry := range entries {
+		data, err := Collector.ReadFile(entry.Name())
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hashes[entry.Name()] = hex.EncodeToString(sum[:])
+	}
+
+	return hashes
+}
@@ -0,0 +1,104 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	saveRuntime "github.com/botherder/go-savetime/runtime"
+)
+
+// Config contains the optional settings loaded from config.json.
+type Config struct {
+	YaraRules     []string `json:"yara_rules"`
+	SearchStrings []string `json:"search_strings"`
+	Profile       string   `json:"profile"`
+	RedactWifi    bool     `json:"redact_wifi"`
+	// Copy the files in the shared storage (/sdcard).
+	CopySdCard bool `json:"copy_sdcard"`
+	// Export the largest tables as Parquet files.
+	Parquet bool `json:"parquet"`
+	// Seconds after which a collector command producing no output is killed
+	// and retried, 300 if 0 and disabled if negative.
+	CollectorTimeoutSeconds int `json:"collector_timeout_seconds"`
+	// Duration of the network capture in seconds, disabled if 0.
+	NetworkCaptureSeconds int `json:"network_capture_seconds"`
+	// Timesketch server to which the timeline is uploaded, if configured.
+	Timesketch *TimesketchConfig `json:"timesketch"`
+	// Elasticsearch or OpenSearch endpoint to which results are exported,
+	// if configured.
+	Elasticsearch *ElasticsearchConfig `json:"elasticsearch"`
+	// VirusTotal API key used to look up the hashes of apps, if configured.
+	VirusTotal *VirusTotalConfig `json:"virustotal"`
+	// Path or URL to the explanations of the findings, for example
+	// translated ones.
+	Explanations string `json:"explanations"`
+	// Record the durations, transfers and failures of acquisitions in
+	// metrics.json, for quality assurance.
+	Metrics bool `json:"metrics"`
+	// Server to which encrypted acquisitions are uploaded, if configured.
+	Upload *UploadConfig `json:"upload"`
+}
+
+// UploadConfig contains the details of the server to which encrypted
+// acquisitions are uploaded.
+type UploadConfig struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// TimesketchConfig contains the details to access a Timesketch server.
+type TimesketchConfig struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// ElasticsearchConfig contains the details to access an Elasticsearch or
+// OpenSearch endpoint. Either an API key or a username and password can be
+// used.
+type ElasticsearchConfig struct {
+	URL         string `json:"url"`
+	IndexPrefix string `json:"index_prefix"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	APIKey      string `json:"api_key"`
+}
+
+// VirusTotalConfig contains the VirusTotal API key, and the number of
+// lookups it allows per minute (4 by default, as for the public API).
+type VirusTotalConfig struct {
+	APIKey            string `json:"api_key"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+}
+
+// DefaultPath returns the path of config.json next to the executable.
+func DefaultPath() string {
+	return filepath.Join(saveRuntime.GetExecutableDirectory(), "config.json")
+}
+
+// Load reads the configuration file at the given path. A missing file is not
+// an error and results in an empty configuration.
+func Load(path string) (*Config, error) {
+	cfg := Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return &cfg, nil
+}
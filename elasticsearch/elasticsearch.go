@@ -0,0 +1,129 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Documents are sent to the bulk API in batches of at most this size.
+const maxBatchSize = 5 * 1024 * 1024
+
+// Indexer bulk-indexes documents into an Elasticsearch or OpenSearch
+// endpoint.
+type Indexer struct {
+	URL      string
+	Username string
+	Password string
+	APIKey   string
+
+	http  *http.Client
+	batch bytes.Buffer
+	count int
+}
+
+func New(url, username, password, apiKey string) *Indexer {
+	return &Indexer{
+		URL:      strings.TrimSuffix(url, "/"),
+		Username: username,
+		Password: password,
+		APIKey:   apiKey,
+		http:     &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Add queues a document to be indexed in the given index, sending the
+// queued documents when the batch is full.
+func (i *Indexer) Add(index string, doc any) error {
+	action, err := json.Marshal(map[string]any{"index": map[string]string{"_index": index}})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	i.batch.Write(action)
+	i.batch.WriteByte('\n')
+	i.batch.Write(data)
+	i.batch.WriteByte('\n')
+
+	if i.batch.Len() >= maxBatchSize {
+		return i.Flush()
+	}
+	return nil
+}
+
+// Count returns the number of documents indexed so far, as acknowledged by
+// the endpoint.
+func (i *Indexer) Count() int {
+	return i.count
+}
+
+// Flush sends the queued documents to the bulk API.
+func (i *Indexer) Flush() error {
+	if i.batch.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, i.URL+"/_bulk", bytes.NewReader(i.batch.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if i.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+i.APIKey)
+	} else if i.Username != "" {
+		req.SetBasicAuth(i.Username, i.Password)
+	}
+
+	resp, err := i.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", i.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bulk indexing failed: unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("failed to parse bulk indexing response: %v", err)
+	}
+	for _, item := range result.Items {
+		for _, status := range item {
+			if len(status.Error) == 0 {
+				i.count++
+			}
+		}
+	}
+	if result.Errors {
+		for _, item := range result.Items {
+			for _, status := range item {
+				if len(status.Error) > 0 {
+					return fmt.Errorf("bulk indexing failed: %s", status.Error)
+				}
+			}
+		}
+		return fmt.Errorf("bulk indexing failed")
+	}
+
+	i.batch.Reset()
+	return nil
+}
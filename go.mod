@@ -4,18 +4,19 @@ go 1.20
 
 require (
 	filippo.io/age v1.1.1
+	github.com/avast/apkparser v0.0.0-20230614091518-10cb8617da43
 	github.com/avast/apkverifier v0.0.0-20230614091700-49ed19602069
 	github.com/botherder/go-savetime v1.4.0
+	github.com/google/uuid v1.3.0
+	github.com/hillu/go-yara/v4 v4.3.2
 	github.com/i582/cfmt v1.4.0
 	github.com/manifoldco/promptui v0.9.0
 	github.com/satori/go.uuid v1.2.0
 )
 
 require (
-	github.com/avast/apkparser v0.0.0-20230614091518-10cb8617da43 // indirect
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
 	github.com/fatih/color v1.15.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/gookit/color v1.3.2 // indirect
 	github.com/klauspost/compress v1.16.6 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
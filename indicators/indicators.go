@@ -0,0 +1,238 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package indicators
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	saveRuntime "github.com/botherder/go-savetime/runtime"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+const (
+	indexURL     = "https://raw.githubusercontent.com/mvt-project/mvt-indicators/main/indicators.yaml"
+	githubAPIURL = "https://api.github.com/repos/%s/%s/contents/%s?ref=%s"
+	manifestName = "indicators.json"
+)
+
+// IndicatorFile describes a downloaded indicators bundle. The version is the
+// git blob hash of the file in its source repository.
+type IndicatorFile struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	URL     string `json:"url"`
+	File    string `json:"file"`
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+type Manifest struct {
+	Updated time.Time       `json:"updated"`
+	Files   []IndicatorFile `json:"files"`
+}
+
+type indexEntry struct {
+	Name        string
+	Source      string
+	Type        string
+	Owner       string
+	Repo        string
+	Branch      string
+	Path        string
+	DownloadURL string
+}
+
+// Folder returns the folder where indicators are cached for offline use.
+func Folder() string {
+	return filepath.Join(saveRuntime.GetExecutableDirectory(), "indicators")
+}
+
+// parseIndex parses the indicators.yaml index published by MVT. The format
+// is simple enough that we do not need a full YAML parser.
+func parseIndex(data string) []indexEntry {
+	entries := []indexEntry{}
+	var entry *indexEntry
+
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(line, "  -") || strings.HasPrefix(line, "- ") || line == "-" {
+			entries = append(entries, indexEntry{})
+			entry = &entries[len(entries)-1]
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+		}
+		if entry == nil || !strings.Contains(trimmed, ":") {
+			continue
+		}
+
+		parts := strings.SplitN(trimmed, ":", 2)
+		value := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+		switch strings.TrimSpace(parts[0]) {
+		case "name":
+			entry.Name = value
+		case "source":
+			entry.Source = value
+		case "type":
+			entry.Type = value
+		case "owner":
+			entry.Owner = value
+		case "repo":
+			entry.Repo = value
+		case "branch":
+			entry.Branch = value
+		case "path":
+			entry.Path = value
+		case "download_url":
+			entry.DownloadURL = value
+		}
+	}
+
+	return entries
+}
+
+// gitBlobHash computes the hash git uses to identify the content of a file.
+func gitBlobHash(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// downloadEntry downloads a bundle. For bundles hosted on GitHub, the blob
+// hash reported by the GitHub API is used as version and to detect truncated
+// downloads. It comes over the same channel as the bundle, so it does not
+// authenticate it: bundles are only pinned once stored in the local cache.
+func downloadEntry(entry indexEntry) ([]byte, IndicatorFile, error) {
+	file := IndicatorFile{Name: entry.Name, Source: entry.Source}
+
+	if entry.Type != "github" {
+		if entry.DownloadURL == "" {
+			return nil, file, fmt.Errorf("unsupported indicators type %q", entry.Type)
+		}
+		data, err := utils.Download(entry.DownloadURL)
+		if err != nil {
+			return nil, file, err
+		}
+		file.URL = entry.DownloadURL
+		file.Version = gitBlobHash(data)
+		return data, file, nil
+	}
+
+	apiData, err := utils.Download(fmt.Sprintf(githubAPIURL, entry.Owner, entry.Repo, entry.Path, entry.Branch))
+	if err != nil {
+		return nil, file, err
+	}
+	var content struct {
+		SHA         string `json:"sha"`
+		DownloadURL string `json:"download_url"`
+	}
+	err = json.Unmarshal(apiData, &content)
+	if err != nil {
+		return nil, file, fmt.Errorf("failed to parse GitHub API response: %v", err)
+	}
+
+	data, err := utils.Download(content.DownloadURL)
+	if err != nil {
+		return nil, file, err
+	}
+
+	if gitBlobHash(data) != content.SHA {
+		return nil, file, fmt.Errorf("hash mismatch for %s: expected %s", content.DownloadURL, content.SHA)
+	}
+
+	file.URL = content.DownloadURL
+	file.Version = content.SHA
+	return data, file, nil
+}
+
+// Update downloads the latest public indicators and stores them in the local
+// cache along with a manifest pinning the downloaded versions.
+func Update() (*Manifest, error) {
+	index, err := utils.Download(indexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(Folder(), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indicators folder: %v", err)
+	}
+
+	manifest := Manifest{
+		Updated: time.Now().UTC(),
+		Files:   []IndicatorFile{},
+	}
+
+	for _, entry := range parseIndex(string(index)) {
+		log.Infof("Downloading indicators %q...", entry.Name)
+		data, file, err := downloadEntry(entry)
+		if err != nil {
+			log.Errorf("Failed to download indicators %q: %v", entry.Name, err)
+			continue
+		}
+
+		fileName := filepath.Base(file.URL)
+		if entry.Owner != "" {
+			fileName = fmt.Sprintf("%s_%s_%s", entry.Owner, entry.Repo, fileName)
+		}
+		err = os.WriteFile(filepath.Join(Folder(), fileName), data, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store indicators: %v", err)
+		}
+
+		hash := sha256.Sum256(data)
+		file.File = fileName
+		file.SHA256 = hex.EncodeToString(hash[:])
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	data, err := json.MarshalIndent(&manifest, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(Folder(), manifestName), data, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write indicators manifest: %v", err)
+	}
+
+	return &manifest, nil
+}
+
+// Load returns the manifest of the locally cached indicators, after checking
+// that the cached files were not altered since they were downloaded.
+func Load() (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(Folder(), manifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse indicators manifest: %v", err)
+	}
+
+	for _, file := range manifest.Files {
+		content, err := os.ReadFile(filepath.Join(Folder(), file.File))
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(content)
+		if hex.EncodeToString(hash[:]) != file.SHA256 {
+			return nil, fmt.Errorf("cached indicators file %s does not match its pinned hash", file.File)
+		}
+	}
+
+	return &manifest, nil
+}
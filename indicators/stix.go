@@ -0,0 +1,118 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package indicators
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Indicator types as used in STIX2 patterns by MVT indicators.
+const (
+	TypeAppID      = "app:id"
+	TypeDomain     = "domain-name:value"
+	TypeProcess    = "process:name"
+	TypeFilePath   = "file:path"
+	TypeFileName   = "file:name"
+	TypeFileSHA256 = "file:hashes.sha256"
+	TypeProperty   = "android-property:name"
+)
+
+type Indicator struct {
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+type stixObject struct {
+	Type             string `json:"type"`
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Pattern          string `json:"pattern"`
+	RelationshipType string `json:"relationship_type"`
+	SourceRef        string `json:"source_ref"`
+	TargetRef        string `json:"target_ref"`
+}
+
+// parsePattern extracts type and value from simple STIX2 patterns such as
+// "[domain-name:value = 'example.com']".
+func parsePattern(pattern string) (string, string, bool) {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(pattern), "["), "]")
+	parts := strings.SplitN(pattern, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(parts[0]), strings.Trim(strings.TrimSpace(parts[1]), "'"), true
+}
+
+func loadBundle(path string) ([]Indicator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle struct {
+		Objects []stixObject `json:"objects"`
+	}
+	err = json.Unmarshal(data, &bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse STIX2 bundle %s: %v", path, err)
+	}
+
+	malware := map[string]string{}
+	for _, obj := range bundle.Objects {
+		if obj.Type == "malware" {
+			malware[obj.ID] = obj.Name
+		}
+	}
+	names := map[string]string{}
+	for _, obj := range bundle.Objects {
+		if obj.Type == "relationship" && obj.RelationshipType == "indicates" {
+			names[obj.SourceRef] = malware[obj.TargetRef]
+		}
+	}
+
+	indicators := []Indicator{}
+	for _, obj := range bundle.Objects {
+		if obj.Type != "indicator" {
+			continue
+		}
+		iocType, value, ok := parsePattern(obj.Pattern)
+		if !ok {
+			continue
+		}
+		indicators = append(indicators, Indicator{
+			Type:   iocType,
+			Value:  value,
+			Name:   names[obj.ID],
+			Source: filepath.Base(path),
+		})
+	}
+
+	return indicators, nil
+}
+
+// LoadIndicators parses all the STIX2 bundles listed in the manifest.
+func (m *Manifest) LoadIndicators() ([]Indicator, error) {
+	indicators := []Indicator{}
+	for _, file := range m.Files {
+		if !strings.HasSuffix(file.File, ".stix2") && !strings.HasSuffix(file.File, ".json") {
+			continue
+		}
+		fileIndicators, err := loadBundle(filepath.Join(Folder(), file.File))
+		if err != nil {
+			return nil, err
+		}
+		indicators = append(indicators, fileIndicators...)
+	}
+
+	return indicators, nil
+}
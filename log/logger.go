@@ -8,6 +8,7 @@ package log
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -30,7 +31,7 @@ const (
 type Logger struct {
 	LogLevel     LEVEL
 	FileLogLevel LEVEL
-	fd           *os.File
+	fd           io.WriteCloser
 	fileName     string
 	Color        bool
 }
@@ -143,7 +144,17 @@ func EnableFileLog(level LEVEL, filePath string) error {
 	return nil
 }
 
+// EnableWriterLog writes the log to the given writer instead of a file.
+func EnableWriterLog(level LEVEL, w io.WriteCloser) {
+	log.FileLogLevel = level
+	log.fd = w
+	log.fileName = ""
+}
+
 func DisableFileLog() {
+	if log.fd == nil {
+		return
+	}
 	log.fd.Close()
 	log.fd = nil
 	log.fileName = ""
@@ -9,13 +9,20 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	saveRuntime "github.com/botherder/go-savetime/runtime"
 	"github.com/i582/cfmt/cmd/cfmt"
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/config"
+	"github.com/mvt-project/androidqf/indicators"
 	"github.com/mvt-project/androidqf/log"
 	"github.com/mvt-project/androidqf/modules"
+	"github.com/mvt-project/androidqf/selftest"
 	"github.com/mvt-project/androidqf/utils"
 )
 
@@ -37,6 +44,308 @@ func systemPause() {
 	os.Stdin.Read(make([]byte, 1))
 }
 
+// runSelfTest plants markers on the test device, runs a full acquisition of
+// it in a separate androidqf process, and verifies that every module captured
+// its marker. It returns whether the self test passed.
+func runSelfTest(cfg *config.Config, folder, serial, transport string, userAdbKey bool, apkPath string) bool {
+	if _, err := os.Stat(acquisition.KeyFilePath()); err == nil {
+		log.Fatal("The self test cannot verify encrypted acquisitions, move key.txt away first")
+	}
+	if !utils.Confirm("selftest_device", "This plants test files, settings and logs on the device. Is it a designated test device?") {
+		log.Info("Self test cancelled")
+		return false
+	}
+
+	log.Info("Planting the self test markers on the device...")
+	s, err := selftest.Plant(apkPath)
+	if err != nil {
+		log.FatalExc("Failed to plant the self test markers", err)
+	}
+	if folder == "" {
+		folder = filepath.Join(saveRuntime.GetExecutableDirectory(), "selftest_"+s.ID)
+	}
+
+	args := []string{}
+	if serial != "" {
+		args = append(args, "-serial", serial)
+	}
+	if userAdbKey {
+		args = append(args, "-user-adb-key")
+	}
+	args = append(args, "-transport", transport)
+	// Release the device, which the USB transport cannot share with the
+	// acquisition process.
+	adb.Client.KillServer()
+	acqErr := s.Acquire(cfg, folder, args)
+
+	// The acquisition stopped the adb server when it completed.
+	adb.Client, err = adb.New(serial, !userAdbKey, transport)
+	if err != nil {
+		log.FatalExc("Impossible to initialize adb to remove the self test markers", err)
+	}
+	log.Info("Removing the self test markers from the device...")
+	s.Cleanup()
+
+	if acqErr != nil {
+		log.ErrorExc("Self test failed", acqErr)
+		return false
+	}
+
+	report, err := s.Verify(folder)
+	if err != nil {
+		log.ErrorExc("Failed to verify the self test acquisition", err)
+		return false
+	}
+	err = report.Store()
+	if err != nil {
+		log.ErrorExc("Failed to store the self test report", err)
+	}
+	for _, issue := range report.Issues {
+		if issue.Level == acquisition.CheckError {
+			log.Errorf("%s %s", issue.File, issue.Message)
+		}
+	}
+	for _, result := range report.Results {
+		if result.Captured {
+			log.Infof("- %s: captured (%s)", result.Module, result.Detail)
+		} else {
+			log.Errorf("- %s: NOT captured, %s", result.Module, result.Detail)
+		}
+	}
+	if report.Passed {
+		log.Infof("Self test passed, the report is in %s", filepath.Join(folder, selftest.ReportFile))
+	} else {
+		log.Errorf("Self test failed, the report is in %s", filepath.Join(folder, selftest.ReportFile))
+	}
+	return report.Passed
+}
+
+// The device is not rebooted with less than this left in the time budget,
+// as it takes minutes to boot again.
+const rebootBudget = 5 * time.Minute
+
+// runRebootPhase reboots the device and runs the second phase of the modules
+// which collect data again after a reboot, if any of them succeeded.
+func runRebootPhase(acq *acquisition.Acquisition, mods []modules.Module, succeeded map[string]bool, fast bool) {
+	rebootMods := []modules.Module{}
+	for _, mod := range mods {
+		if _, ok := mod.(modules.RebootModule); ok && succeeded[mod.Name()] {
+			rebootMods = append(rebootMods, mod)
+		}
+	}
+	if len(rebootMods) == 0 {
+		log.Info("No module collects data after a reboot, not rebooting the device")
+		return
+	}
+	if !utils.Confirm("reboot", "Reboot the device to compare its state before and after?") {
+		return
+	}
+
+	err := acq.Reboot()
+	if err != nil {
+		log.ErrorExc("Failed to reboot the device", err)
+		return
+	}
+	for _, mod := range rebootMods {
+		log.Debugf("Running module %s after the reboot", mod.Name())
+		err = mod.(modules.RebootModule).RunAfterReboot(acq, fast)
+		if err != nil {
+			log.Infof("ERROR: failed to run module %s after the reboot: %v", mod.Name(), err)
+		}
+		err = acq.UpdateModuleManifest(mod.Name())
+		if err != nil {
+			log.ErrorExc("Failed to update the module manifest", err)
+		}
+	}
+}
+
+// runBudgetedModule runs a module as planned by the time budget of the
+// acquisition, if any. It returns whether the module succeeded.
+func runBudgetedModule(acq *acquisition.Acquisition, budget *modules.Budget, mod modules.Module, fast bool) bool {
+	if budget == nil {
+		return runModule(acq, mod, fast)
+	}
+	plan := budget.Plan(mod, fast)
+	if plan.Skip {
+		return false
+	}
+	hashOnly := acq.HashOnly
+	acq.HashOnly = hashOnly || plan.HashOnly
+	defer func() { acq.HashOnly = hashOnly }()
+	defer budget.Done(mod)
+	return runModule(acq, mod, fast || plan.Fast)
+}
+
+// runModule runs a module storing its output in its own folder, followed by
+// the module manifest. It returns whether the module succeeded.
+func runModule(acq *acquisition.Acquisition, mod modules.Module, fast bool) bool {
+	if !acq.Policy.Allows(mod.Name()) {
+		log.Infof("Skipping module %s, which is not allowed by the policy", mod.Name())
+		return false
+	}
+	hashOnly := acq.HashOnly
+	acq.HashOnly = hashOnly || acq.Policy.HashOnlyFor(mod.Name())
+	defer func() { acq.HashOnly = hashOnly }()
+	if c, ok := mod.(modules.ContentModule); ok {
+		description := c.ContentDescription(acq)
+		if description != "" && !acq.RequestConsent(mod.Name(), description) {
+			log.Infof("Skipping module %s, the owner of the device did not agree to the copy", mod.Name())
+			return false
+		}
+	}
+
+	modulePath := acq.ModulePath(mod.Name())
+	err := os.MkdirAll(modulePath, 0o755)
+	if err != nil {
+		log.Infof("ERROR: failed to create folder for module %s: %v", mod.Name(), err)
+		return false
+	}
+	err = mod.InitStorage(modulePath)
+	if err != nil {
+		log.Infof(
+			"ERROR: failed to initialize storage for module %s: %v",
+			mod.Name(),
+			err,
+		)
+		return false
+	}
+
+	acq.StartModule(mod.Name())
+	started := time.Now().UTC()
+	historyStart := adb.Client.HistoryLen()
+	runErr := mod.Run(acq, fast)
+	if runErr != nil {
+		log.Infof("ERROR: failed to run module %s: %v", mod.Name(), runErr)
+		acq.ModuleFailed(mod.Name(), runErr)
+	}
+
+	err = acq.StoreModuleManifest(mod.Name(), started, adb.Client.History(historyStart), runErr)
+	if err != nil {
+		log.ErrorExc("Failed to store module manifest", err)
+	}
+	acq.CompleteModule(mod.Name())
+	return runErr == nil
+}
+
+// dependenciesMet returns true if the modules the given module depends on
+// succeeded, or completed before the acquisition was interrupted.
+func dependenciesMet(acq *acquisition.Acquisition, mod modules.Module, succeeded map[string]bool) bool {
+	for _, dep := range modules.Dependencies(mod) {
+		if !succeeded[dep] && !acq.ModuleCompleted(dep) {
+			log.Infof("Skipping module %s, which depends on module %s which did not succeed", mod.Name(), dep)
+			return false
+		}
+	}
+	return true
+}
+
+// retryFailed offers to retry the files and modules which failed, and
+// stores the list of those which still fail. It returns the number of items
+// retried, and of items which still fail.
+func retryFailed(acq *acquisition.Acquisition, fast bool) (int, int) {
+	retried := 0
+	if count := len(acq.FailedItems()); count > 0 {
+		log.Warningf("%d files or modules failed during the acquisition", count)
+		if utils.Confirm("retry_failed", fmt.Sprintf("Retry the %d failed items?", count)) {
+			retried = count
+			remaining := acq.RetryFailed(func(name string) {
+				for _, mod := range modules.List() {
+					if mod.Name() == name {
+						runModule(acq, mod, fast)
+					}
+				}
+			})
+			if remaining > 0 {
+				log.Warningf("%d items still failed, they are listed in %s", remaining, acquisition.FailedItemsFile)
+			} else {
+				log.Info("All the failed items were retried successfully")
+			}
+		}
+	}
+
+	err := acq.StoreFailedItems()
+	if err != nil {
+		log.ErrorExc("Failed to store the list of failed items", err)
+	}
+	return retried, len(acq.FailedItems())
+}
+
+// finishAcquisition stores the results of the acquisition, hashes its
+// files and completes it, for new acquisitions as well as for retries. It
+// returns false if the files could not be hashed.
+func finishAcquisition(acq *acquisition.Acquisition, cfg *config.Config, retried, stillFailed int) bool {
+	err := acq.StoreDetections()
+	if err != nil {
+		log.ErrorExc("Failed to store detections", err)
+	}
+
+	err = acq.StoreTimeline()
+	if err != nil {
+		log.ErrorExc("Failed to store timeline", err)
+	} else if cfg.Timesketch != nil && cfg.Timesketch.URL != "" {
+		err = acq.UploadTimeline(cfg.Timesketch.URL, cfg.Timesketch.Token)
+		if err != nil {
+			log.ErrorExc("Failed to upload timeline to Timesketch", err)
+		}
+	}
+
+	err = acq.StoreDatabase()
+	if err != nil {
+		log.ErrorExc("Failed to store results database", err)
+	}
+
+	if cfg.Parquet {
+		err = acq.StoreParquet()
+		if err != nil {
+			log.ErrorExc("Failed to export results to Parquet", err)
+		}
+	}
+
+	if cfg.Elasticsearch != nil && cfg.Elasticsearch.URL != "" {
+		err = acq.ExportToElasticsearch(cfg.Elasticsearch)
+		if err != nil {
+			log.ErrorExc("Failed to export results to Elasticsearch", err)
+		}
+	}
+
+	err = acq.StoreTimestamps()
+	if err != nil {
+		log.ErrorExc("Failed to store timestamp conventions", err)
+	}
+
+	err = acq.StoreRenamedFiles()
+	if err != nil {
+		log.ErrorExc("Failed to store the original names of renamed files", err)
+	}
+
+	err = acq.StoreCommands()
+	if err != nil {
+		log.ErrorExc("Failed to store the list of commands", err)
+	}
+
+	err = acq.HashFiles()
+	if err != nil {
+		log.ErrorExc("Failed to generate list of file hashes", err)
+		return false
+	}
+
+	acq.Complete()
+	acq.StoreInfo()
+
+	err = acq.StoreSecurely()
+	if err != nil {
+		log.ErrorExc("Something failed while encrypting the acquisition", err)
+		log.Warning("WARNING: The secure storage of the acquisition folder failed! The data is unencrypted!")
+	}
+
+	err = acq.StoreMetrics(retried, stillFailed)
+	if err != nil {
+		log.ErrorExc("Failed to store the metrics of the acquisition", err)
+	}
+
+	return true
+}
+
 func main() {
 	var err error
 	var verbose bool
@@ -46,12 +355,39 @@ func main() {
 	var module string
 	var output_folder string
 	var serial string
+	var system_baseline string
+	var package_baseline string
+	var recheck_baseline string
+	var recheck_days int
+	var config_path string
+	var profile_name string
+	var device_tmp string
+	var limit_rate string
+	var no_preflight bool
+	var hash_only bool
+	var encrypt_at_write bool
+	var case_id string
+	var anonymize bool
+	var anonymize_salt string
+	var resume string
+	var answers_path string
+	var retry_folder string
+	var policy_path string
+	var user_adb_key bool
+	var transport string
+	var min_battery int
+	var no_keep_awake bool
+	var metrics bool
+	var run_selftest bool
+	var reboot bool
+	var time_budget time.Duration
+	var selftest_apk string
 
 	// Command line options
 	flag.BoolVar(&verbose, "verbose", false, "Verbose mode")
 	flag.BoolVar(&verbose, "v", false, "Verbose mode")
-	flag.BoolVar(&fast, "fast", false, "Fast mode")
-	flag.BoolVar(&verbose, "f", false, "Fast mode")
+	flag.BoolVar(&fast, "fast", false, "Fast triage mode (triage profile, unless another one is selected)")
+	flag.BoolVar(&fast, "f", false, "Fast triage mode (triage profile, unless another one is selected)")
 	flag.BoolVar(&list_modules, "list", false, "List modules and exit")
 	flag.BoolVar(&list_modules, "l", false, "List modules and exit")
 	flag.StringVar(&module, "module", "", "Only execute a specific module")
@@ -60,6 +396,31 @@ func main() {
 	flag.StringVar(&output_folder, "o", "", "Output folder")
 	flag.StringVar(&serial, "serial", "", "Phone serial number")
 	flag.StringVar(&serial, "s", "", "Phone serial number")
+	flag.StringVar(&system_baseline, "system-baseline", "", "Path or URL to a database of known-good system hashes")
+	flag.StringVar(&package_baseline, "package-baseline", "", "Path or URL to the list of packages shipped in the firmware of the device")
+	flag.StringVar(&recheck_baseline, "baseline", "", "Path or URL to the re-check bundle of a previous acquisition to compare the device with")
+	flag.IntVar(&recheck_days, "recheck-days", 0, "Emit a re-check bundle suggesting a new acquisition in this many days")
+	flag.StringVar(&profile_name, "profile", "", "Acquisition profile (quick, standard, full)")
+	flag.StringVar(&profile_name, "p", "", "Acquisition profile (quick, standard, full)")
+	flag.StringVar(&device_tmp, "device-tmp", "", "Temporary folder to use on the device")
+	flag.StringVar(&limit_rate, "limit-rate", "", "Limit the transfer rate of files pulled from the device (e.g. 500K, 2M)")
+	flag.BoolVar(&no_preflight, "no-preflight", false, "Do not estimate the size of the acquisition before starting")
+	flag.BoolVar(&hash_only, "hash-only", false, "Only record hashes and metadata of files, without copying their content")
+	flag.BoolVar(&encrypt_at_write, "encrypt-at-write", false, "Encrypt all outputs with the age public key in key.txt as they are written")
+	flag.StringVar(&case_id, "case", "", "Store the acquisition in the folder of the case with this ID")
+	flag.StringVar(&resume, "resume", "", "Resume the interrupted acquisition stored in this folder")
+	flag.StringVar(&answers_path, "answers", "", "Answer the questions asked during the acquisition with the responses in this JSON file")
+	flag.BoolVar(&anonymize, "anonymize", false, "Replace device and account identifiers with pseudonyms")
+	flag.StringVar(&anonymize_salt, "anonymize-salt", "", "Path to the secret salt used to derive pseudonyms (default salt.txt next to the executable)")
+	flag.StringVar(&policy_path, "policy", "", "Path to the signed policy restricting what may be collected (default policy.json next to the executable)")
+	flag.IntVar(&min_battery, "min-battery", 15, "Pause transfers while the battery of the device is below this percentage (0 to disable)")
+	flag.BoolVar(&metrics, "metrics", false, "Record the durations, transfers and failures of the acquisition in metrics.json")
+	flag.BoolVar(&no_keep_awake, "no-keep-awake", false, "Do not keep the device awake during the acquisition")
+	flag.BoolVar(&reboot, "reboot", false, "Reboot the device once the modules completed, and collect again the data of the modules comparing the state of the device before and after")
+	flag.DurationVar(&time_budget, "time-budget", 0, "Complete the acquisition within this time (e.g. 15m), degrading or skipping modules as the deadline approaches")
+	flag.BoolVar(&user_adb_key, "user-adb-key", false, "Authenticate to the device with the adb key of the user instead of the dedicated key of androidqf")
+	flag.StringVar(&transport, "transport", adb.TransportServer, "How to connect to the device: server (through the adb server) or usb (directly over USB, Linux only)")
+	flag.StringVar(&config_path, "config", config.DefaultPath(), "Path to the configuration file")
 	flag.BoolVar(&version_flag, "version", false, "Show version")
 
 	flag.Parse()
@@ -72,20 +433,274 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch flag.Arg(0) {
+	case "update-indicators":
+		manifest, err := indicators.Update()
+		if err != nil {
+			log.FatalExc("Failed to update indicators", err)
+		}
+		log.Infof("Downloaded %d indicators files to %s", len(manifest.Files), indicators.Folder())
+		os.Exit(0)
+	case "export-metadata":
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: androidqf export-metadata <acquisition folder> [bundle.zip]")
+		}
+		folder := filepath.Clean(flag.Arg(1))
+		dest := folder + "_metadata.zip"
+		if flag.NArg() > 2 {
+			dest = flag.Arg(2)
+		}
+		count, err := acquisition.ExportMetadata(folder, dest)
+		if err != nil {
+			log.FatalExc("Failed to export the metadata bundle", err)
+		}
+		log.Infof("Exported %d files to %s", count, dest)
+		os.Exit(0)
+	case "summarize":
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: androidqf summarize <acquisition folder> [summary.md]")
+		}
+		summary, err := acquisition.Summarize(filepath.Clean(flag.Arg(1)))
+		if err != nil {
+			log.FatalExc("Failed to summarize the acquisition", err)
+		}
+		if flag.NArg() > 2 {
+			err = os.WriteFile(flag.Arg(2), []byte(summary), 0o644)
+			if err != nil {
+				log.FatalExc("Failed to write the summary", err)
+			}
+			log.Infof("Summary written to %s", flag.Arg(2))
+		} else {
+			fmt.Print(summary)
+		}
+		os.Exit(0)
+	case "explanations":
+		explanations, err := acquisition.DefaultExplanations()
+		if err != nil {
+			log.FatalExc("Failed to export the explanations of the findings", err)
+		}
+		fmt.Println(string(explanations))
+		os.Exit(0)
+	case "policy-keygen":
+		publicKey, privateKey, err := acquisition.GeneratePolicyKey()
+		if err != nil {
+			log.FatalExc("Failed to generate the policy key", err)
+		}
+		for _, key := range []struct{ name, content string }{
+			{"policy.key", privateKey},
+			{"policy.pub", publicKey},
+		} {
+			file, err := os.OpenFile(key.name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil {
+				log.FatalExc("Failed to store the policy key", err)
+			}
+			fmt.Fprintln(file, key.content)
+			file.Close()
+		}
+		log.Info("Stored the policy signing key in policy.key and its public key in policy.pub, " +
+			"embed it in androidqf with `make POLICY_PUBLIC_KEY=$(cat policy.pub)`")
+		os.Exit(0)
+	case "sign-policy":
+		if flag.NArg() < 3 {
+			log.Fatal("Usage: androidqf sign-policy <policy.json> <policy.key> [signed policy.json]")
+		}
+		signed, err := acquisition.SignPolicy(flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			log.FatalExc("Failed to sign the policy", err)
+		}
+		if flag.NArg() > 3 {
+			err = os.WriteFile(flag.Arg(3), signed, 0o644)
+			if err != nil {
+				log.FatalExc("Failed to write the signed policy", err)
+			}
+			log.Infof("Signed policy written to %s", flag.Arg(3))
+		} else {
+			fmt.Println(string(signed))
+		}
+		os.Exit(0)
+	case "adb-key":
+		switch flag.Arg(1) {
+		case "", "show":
+			key, err := adb.LoadOrCreateKey()
+			if err != nil {
+				log.FatalExc("Failed to load the adb key", err)
+			}
+			log.Infof("adb key: %s", key.Path)
+			log.Infof("Fingerprint: %s", key.Fingerprint)
+		case "remove":
+			err := adb.RemoveKey()
+			if err != nil {
+				log.FatalExc("Failed to remove the adb key", err)
+			}
+			log.Info("Removed the adb key, a new one will be generated by the next acquisition")
+		default:
+			log.Fatal("Usage: androidqf adb-key [show|remove]")
+		}
+		os.Exit(0)
+	case "flush-outbox":
+		cfg, err := config.Load(config_path)
+		if err != nil {
+			log.FatalExc("Impossible to load the configuration", err)
+		}
+		if cfg.Upload == nil || cfg.Upload.URL == "" {
+			log.Fatal("No upload server is configured")
+		}
+		remaining, err := acquisition.FlushOutbox(cfg.Upload)
+		if err != nil {
+			log.ErrorExc("Failed to upload the acquisitions in the outbox", err)
+		}
+		if remaining > 0 {
+			log.Warningf("%d acquisitions are still waiting in the outbox", remaining)
+			os.Exit(1)
+		}
+		log.Info("The outbox is empty")
+		os.Exit(0)
+	case "stats":
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: androidqf stats <folder> [<folder>...]")
+		}
+		stats, err := acquisition.AggregateMetrics(flag.Args()[1:])
+		if err != nil {
+			log.FatalExc("Failed to aggregate the metrics", err)
+		}
+		if stats.Acquisitions == 0 {
+			log.Fatal("No metrics found, acquisitions record them with -metrics")
+		}
+		classes := func(counts map[string]int) string {
+			names := []string{}
+			for name, count := range counts {
+				names = append(names, fmt.Sprintf("%s: %d", name, count))
+			}
+			sort.Strings(names)
+			return strings.Join(names, ", ")
+		}
+		log.Infof("%d acquisitions, median duration %.0fs, %s transferred", stats.Acquisitions,
+			stats.MedianSeconds, utils.FormatByteSize(stats.Bytes))
+		log.Infof("%d items retried, %d still failed, transfers paused %d times on low battery",
+			stats.Retried, stats.StillFailed, stats.BatteryPauses)
+		if len(stats.ErrorClasses) > 0 {
+			log.Infof("Errors: %s", classes(stats.ErrorClasses))
+		}
+		for _, ms := range stats.Modules {
+			line := fmt.Sprintf("- %s: failed %d/%d, median %.1fs, max %.1fs, %s, %d failed pulls", ms.Module,
+				ms.Failures, ms.Acquisitions, ms.MedianSeconds, ms.MaxSeconds, utils.FormatByteSize(ms.Bytes),
+				ms.FailedPulls)
+			if len(ms.ErrorClasses) > 0 {
+				line += fmt.Sprintf(" (%s)", classes(ms.ErrorClasses))
+			}
+			log.Info(line)
+		}
+		os.Exit(0)
+	case "check":
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: androidqf check <acquisition folder>")
+		}
+		report, err := acquisition.Check(filepath.Clean(flag.Arg(1)))
+		if err != nil {
+			log.FatalExc("Failed to check the acquisition", err)
+		}
+		for _, issue := range report.Issues {
+			message := issue.Message
+			if issue.File != "" {
+				message = fmt.Sprintf("%s %s", issue.File, issue.Message)
+			}
+			if issue.Level == acquisition.CheckError {
+				log.Error(message)
+			} else {
+				log.Warning(message)
+			}
+		}
+		log.Infof("Checked %d modules and %d files: %d errors, %d warnings", report.Modules, report.Files,
+			report.Errors(), len(report.Issues)-report.Errors())
+		if report.Errors() > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "retry":
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: androidqf retry <acquisition folder>")
+		}
+		retry_folder = filepath.Clean(flag.Arg(1))
+	case "selftest":
+		run_selftest = true
+		selftest_apk = flag.Arg(1)
+	}
+
 	if list_modules {
 		mods := modules.List()
 		log.Info("List of modules:")
 		for _, mod := range mods {
-			log.Infof("- %s", mod.Name())
+			if deps := modules.Dependencies(mod); len(deps) > 0 {
+				log.Infof("- %s (after %s)", mod.Name(), strings.Join(deps, ", "))
+			} else {
+				log.Infof("- %s", mod.Name())
+			}
+		}
+		log.Info("List of profiles:")
+		for _, profile := range modules.Profiles() {
+			log.Infof("- %s: %s", profile.Name, profile.Description)
 		}
 		os.Exit(0)
 	}
 
+	cfg, err := config.Load(config_path)
+	if err != nil {
+		log.FatalExc("Impossible to load the configuration", err)
+	}
+	if answers_path != "" {
+		err = utils.LoadAnswers(answers_path)
+		if err != nil {
+			log.FatalExc("Impossible to load the answers file", err)
+		}
+	}
+
+	if profile_name == "" {
+		profile_name = cfg.Profile
+	}
+	if profile_name == "" && fast {
+		profile_name = "triage"
+	}
+	profile, err := modules.GetProfile(profile_name)
+	if err != nil {
+		log.FatalExc("Impossible to select the acquisition profile", err)
+	}
+	fast = fast || profile.Fast
+
+	var policy *acquisition.Policy
+	if policy_path == "" {
+		if _, err := os.Stat(acquisition.PolicyFilePath()); err == nil {
+			policy_path = acquisition.PolicyFilePath()
+		}
+	}
+	if policy_path == "" && acquisition.PolicyRequired() {
+		log.Fatalf("This build of androidqf only runs with a collection policy signed by its organization, "+
+			"none was found in %s", acquisition.PolicyFilePath())
+	}
+	if policy_path != "" {
+		policy, err = acquisition.LoadPolicy(policy_path)
+		if err != nil {
+			log.FatalExc("Impossible to load the collection policy", err)
+		}
+		log.Infof("Enforcing the collection policy of %s", policy.Organization)
+		hash_only = hash_only || policy.HashOnly
+	}
+
 	log.Debug("Starting androidqf")
-	adb.Client, err = adb.New(serial)
+	if transport != adb.TransportServer && transport != adb.TransportUSB {
+		log.Fatalf("Unknown transport %s, use server or usb", transport)
+	}
+	adb.Client, err = adb.New(serial, !user_adb_key, transport)
 	if err != nil {
 		log.Fatal("Impossible to initialize adb: ", err)
 	}
+	if limit_rate != "" {
+		adb.Client.RateLimit, err = utils.ParseByteSize(limit_rate)
+		if err != nil {
+			log.FatalExc("Invalid transfer rate limit", err)
+		}
+		log.Infof("Limiting the transfer rate of files to %s/s", limit_rate)
+	}
+	adb.Client.MinBattery = min_battery
 
 	// Initialization
 	for {
@@ -98,52 +713,246 @@ func main() {
 		time.Sleep(5 * time.Second)
 	}
 
-	acq, err := acquisition.New(output_folder)
+	if run_selftest {
+		if !runSelfTest(cfg, output_folder, serial, transport, user_adb_key, selftest_apk) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	opts := acquisition.Options{
+		Path:           output_folder,
+		DeviceTmp:      device_tmp,
+		EncryptAtWrite: encrypt_at_write,
+		Anonymize:      anonymize,
+		SaltPath:       anonymize_salt,
+		NoCollector:    profile.NoCollector,
+		KeepAwake:      !no_keep_awake,
+	}
+	var acqCase *acquisition.Case
+	if case_id != "" {
+		// With a case, the output folder is where case folders are stored.
+		acqCase, err = acquisition.OpenCase(output_folder, case_id)
+		if err != nil {
+			log.FatalExc("Impossible to open the case", err)
+		}
+		opts.Path = ""
+		opts.Case = acqCase
+	}
+	if resume != "" {
+		opts.Path = resume
+		opts.Resume = true
+	}
+	if retry_folder != "" {
+		opts.Path = retry_folder
+		opts.Resume = true
+		opts.Case = nil
+		acqCase = nil
+	}
+
+	acq, err := acquisition.New(opts)
 	if err != nil {
 		log.Debug(err)
 		log.FatalExc("Impossible to initialise the acquisition", err)
 	}
+	acq.SystemBaseline = system_baseline
+	acq.PackageBaseline = package_baseline
+	acq.RecheckBaseline = recheck_baseline
+	acq.RecheckDays = recheck_days
+	acq.AdbKey = adb.Client.Key
+	if (metrics || cfg.Metrics) && retry_folder == "" {
+		acq.EnableMetrics(resume != "")
+	}
+	acq.Config = cfg
+	if cfg.Explanations != "" {
+		err = acq.LoadExplanations(cfg.Explanations)
+		if err != nil {
+			log.ErrorExc("Impossible to load the explanations of the findings", err)
+		}
+	}
+	if acq.Collector != nil && cfg.CollectorTimeoutSeconds != 0 {
+		acq.Collector.Timeout = time.Duration(cfg.CollectorTimeoutSeconds) * time.Second
+	}
+	acq.Profile = profile.Name
+	acq.HashOnly = hash_only
+	acq.Policy = policy
+	if time_budget > 0 {
+		acq.StartTimeBudget(time_budget)
+	}
+
+	manifest, err := indicators.Load()
+	if os.IsNotExist(err) {
+		log.Warning("No indicators of compromise available, run \"androidqf update-indicators\" to download them")
+	} else if err != nil {
+		log.Warningf("No indicators of compromise available: %v", err)
+	} else {
+		acq.Indicators = manifest.Files
+		acq.IOCs, err = manifest.LoadIndicators()
+		if err != nil {
+			log.ErrorExc("Failed to load indicators", err)
+		}
+	}
+
+	if retry_folder != "" {
+		if len(acq.FailedItems()) == 0 {
+			log.Info("No failed items to retry")
+		}
+		retried, stillFailed := retryFailed(acq, fast)
+		if !finishAcquisition(acq, cfg, retried, stillFailed) {
+			return
+		}
+		log.Info("Retry completed.")
+		os.Exit(0)
+	}
 
 	// Start acquisitions
 	log.Info(fmt.Sprintf("Started new acquisition in %s", acq.StoragePath))
 
-	mods := modules.List()
-	for _, mod := range mods {
+	allMods := modules.List()
+	selected := []modules.Module{}
+	for _, mod := range allMods {
 		if (module != "") && (module != mod.Name()) {
 			continue
 		}
-		err = mod.InitStorage(acq.StoragePath)
-		if err != nil {
-			log.Infof(
-				"ERROR: failed to initialize storage for module %s: %v",
-				mod.Name(),
-				err,
-			)
+		if module == "" && !profile.Includes(mod.Name()) {
+			log.Debugf("Skipping module %s with profile %s", mod.Name(), profile.Name)
+			continue
+		}
+		if !acq.Policy.Allows(mod.Name()) {
+			log.Infof("Skipping module %s, which is not allowed by the policy", mod.Name())
+			continue
+		}
+		selected = append(selected, mod)
+	}
+	count := len(selected)
+	selected = modules.WithDependencies(selected, allMods, func(mod modules.Module) bool {
+		return acq.Policy.Allows(mod.Name())
+	})
+	for _, mod := range selected[count:] {
+		log.Infof("Adding module %s, which other selected modules depend on", mod.Name())
+	}
+
+	mods := []modules.Module{}
+	deferred := []modules.Module{}
+	for _, mod := range selected {
+		if acq.ModuleCompleted(mod.Name()) {
+			log.Infof("Skipping module %s, which completed before the acquisition was interrupted", mod.Name())
+			continue
+		}
+		if d, ok := mod.(modules.DeferredModule); ok && d.RunsAfterAnalysis() {
+			deferred = append(deferred, mod)
+			continue
+		}
+		mods = append(mods, mod)
+	}
+	sort.SliceStable(mods, func(i, j int) bool {
+		return profile.Rank(mods[i].Name()) < profile.Rank(mods[j].Name())
+	})
+	// Modules run after the modules they depend on, whatever their rank in
+	// the profile.
+	mods, err = modules.Sort(mods)
+	if err != nil {
+		log.FatalExc("Impossible to order the modules", err)
+	}
+	deferred, err = modules.Sort(deferred)
+	if err != nil {
+		log.FatalExc("Impossible to order the modules", err)
+	}
+
+	skip := map[string]bool{}
+	if !no_preflight && !hash_only {
+		skip = modules.Preflight(acq, mods)
+	}
+
+	var budget *modules.Budget
+	if time_budget > 0 {
+		budget = modules.NewBudget(acq, append(append([]modules.Module{}, mods...), deferred...), skip)
+	}
+
+	succeeded := map[string]bool{}
+	for _, mod := range mods {
+		if skip[mod.Name()] {
+			log.Infof("Skipping module %s", mod.Name())
+			continue
+		}
+		if hw, ok := mod.(modules.HardwareModule); ok && hw.RequiresHardware() && acq.Emulator.Detected {
+			log.Infof("Skipping module %s on emulator", mod.Name())
 			continue
 		}
+		if r, ok := mod.(modules.RootModule); ok && r.RequiresRoot() && !modules.HasRoot(acq) {
+			log.Infof("Skipping module %s, which requires root", mod.Name())
+			continue
+		}
+		if !dependenciesMet(acq, mod, succeeded) {
+			continue
+		}
+		succeeded[mod.Name()] = runBudgetedModule(acq, budget, mod, fast)
+	}
 
-		err = mod.Run(acq, fast)
+	if reboot {
+		if budget != nil && acq.BudgetRemaining() < rebootBudget {
+			acq.CutFromBudget("reboot", acquisition.BudgetSkipped, "there is not enough time left to reboot the device")
+		} else {
+			runRebootPhase(acq, mods, succeeded, fast)
+		}
+	}
+
+	if len(cfg.YaraRules) > 0 {
+		err = acq.ScanYara(cfg.YaraRules)
 		if err != nil {
-			log.Infof("ERROR: failed to run module %s: %v", mod.Name(), err)
+			log.ErrorExc("Failed to scan the acquisition with YARA", err)
 		}
 	}
 
-	err = acq.HashFiles()
+	acq.MatchIndicators()
+
+	if cfg.VirusTotal != nil && cfg.VirusTotal.APIKey != "" {
+		err = acq.CheckVirusTotal(cfg.VirusTotal)
+		if err != nil {
+			log.ErrorExc("Failed to look up apps on VirusTotal", err)
+		}
+	}
+
+	err = modules.QuarantinePackages(acq)
 	if err != nil {
-		log.ErrorExc("Failed to generate list of file hashes", err)
+		log.ErrorExc("Failed to quarantine suspicious apps", err)
+	}
+
+	for _, mod := range deferred {
+		if !dependenciesMet(acq, mod, succeeded) {
+			continue
+		}
+		succeeded[mod.Name()] = runBudgetedModule(acq, budget, mod, fast)
+	}
+
+	retried, stillFailed := retryFailed(acq, fast)
+
+	if !finishAcquisition(acq, cfg, retried, stillFailed) {
 		return
 	}
 
-	acq.Complete()
-	acq.StoreInfo()
+	if acqCase != nil {
+		err = acqCase.AddAcquisition(acq)
+		if err != nil {
+			log.ErrorExc("Failed to add the acquisition to the case", err)
+		} else {
+			log.Infof("Acquisition added to case %s", acqCase.ID)
+		}
+	}
 
-	err = acq.StoreSecurely()
-	if err != nil {
-		log.ErrorExc("Something failed while encrypting the acquisition", err)
-		log.Warning("WARNING: The secure storage of the acquisition folder failed! The data is unencrypted!")
+	if cfg.Upload != nil && cfg.Upload.URL != "" {
+		err = acq.Upload(cfg.Upload)
+		if err != nil {
+			log.ErrorExc("Failed to upload the acquisition", err)
+		}
 	}
 
 	log.Info("Acquisition completed.")
+	if acq.AdbKey != nil {
+		for _, line := range acq.AdbKey.RevocationGuidance() {
+			log.Info(line)
+		}
+	}
 
 	systemPause()
 }
@@ -0,0 +1,157 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type AppLabel struct {
+	Package string `json:"package"`
+	Label   string `json:"label"`
+	// Icon stored in the icons folder, if it is a bitmap.
+	Icon string `json:"icon,omitempty"`
+	// Name of the launcher icon in the APK.
+	IconResource string `json:"icon_resource,omitempty"`
+	// "local_copy" if the APK was copied by the packages module, "pulled" if
+	// it was pulled only to read its label.
+	Source string `json:"source,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AppLabels extracts the display label and launcher icon of the installed
+// packages, so that operators can tell which app a package name is.
+type AppLabels struct {
+	StoragePath string
+}
+
+func NewAppLabels() *AppLabels {
+	return &AppLabels{}
+}
+
+func (a *AppLabels) Name() string {
+	return "app_labels"
+}
+
+func (a *AppLabels) Dependencies() []string {
+	return []string{"packages"}
+}
+
+func (a *AppLabels) InitStorage(storagePath string) error {
+	a.StoragePath = storagePath
+	return os.MkdirAll(utils.LongPath(filepath.Join(storagePath, "icons")), 0o755)
+}
+
+// loadPackages reads the packages listed by the packages module.
+func loadPackages(acq *acquisition.Acquisition) ([]adb.Package, error) {
+	data, err := utils.ReadOutput(filepath.Join(acq.ModulePath("packages"), "packages.json"))
+	if err != nil {
+		return nil, err
+	}
+	var packages []adb.Package
+	err = json.Unmarshal(data, &packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse packages.json: %v", err)
+	}
+	return packages, nil
+}
+
+// baseAPK returns the base APK of a package, which declares its label and
+// icon, rather than one of its splits.
+func baseAPK(pkg adb.Package) *adb.PackageFile {
+	for i := range pkg.Files {
+		if path.Base(pkg.Files[i].Path) == "base.apk" {
+			return &pkg.Files[i]
+		}
+	}
+	if len(pkg.Files) > 0 {
+		return &pkg.Files[0]
+	}
+	return nil
+}
+
+func (a *AppLabels) Run(acq *acquisition.Acquisition, fast bool) error {
+	packages, err := loadPackages(acq)
+	if err != nil {
+		return err
+	}
+
+	// APKs which were not copied are pulled for third-party packages, unless
+	// no content may be copied or the acquisition is fast.
+	pull := !fast && !acq.HashOnly
+	tmpDir := ""
+	if pull {
+		tmpDir, err = os.MkdirTemp("", "androidqf_labels_")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+	}
+
+	log.Info("Extracting the labels and icons of the installed packages...")
+
+	labels := []AppLabel{}
+	for _, pkg := range packages {
+		apk := baseAPK(pkg)
+		if apk == nil {
+			continue
+		}
+
+		label := AppLabel{Package: pkg.Name}
+		apkPath := ""
+		if apk.LocalName != "" && !utils.OutputSinkEnabled() {
+			apkPath = filepath.Join(acq.ModulePath("packages"), filepath.FromSlash(apk.LocalName))
+			label.Source = "local_copy"
+		} else if pull && !pkg.System {
+			// The temporary copy is not an output of the acquisition, and is
+			// not retried if the pull fails.
+			apkPath = filepath.Join(tmpDir, utils.SanitizeFileName(pkg.Name)+".apk")
+			out, err := adb.Client.Exec("pull", apk.Path, apkPath)
+			if err != nil {
+				log.Debugf("Failed to pull %s to read its label: %s", apk.Path, out)
+				label.Error = fmt.Sprintf("failed to pull %s: %s", apk.Path, strings.TrimSpace(string(out)))
+				labels = append(labels, label)
+				continue
+			}
+			label.Source = "pulled"
+		} else {
+			continue
+		}
+
+		name, iconResource, icon, err := utils.AppLabel(apkPath)
+		if label.Source == "pulled" {
+			os.Remove(apkPath)
+		}
+		label.Label = name
+		label.IconResource = iconResource
+		if err != nil {
+			label.Error = err.Error()
+		}
+		if icon != nil {
+			iconName := utils.SanitizeFileName(pkg.Name) + strings.ToLower(path.Ext(iconResource))
+			err = utils.WriteOutput(filepath.Join(a.StoragePath, "icons", iconName), icon)
+			if err != nil {
+				return fmt.Errorf("failed to store the icon of %s: %v", pkg.Name, err)
+			}
+			label.Icon = "icons/" + iconName
+		}
+		labels = append(labels, label)
+	}
+
+	log.Infof("Extracted the labels of %d packages", len(labels))
+
+	return saveCommandOutputJson(filepath.Join(a.StoragePath, "app_labels.json"), &labels)
+}
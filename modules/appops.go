@@ -0,0 +1,93 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Layout of the access times in `dumpsys appops`, in device local time.
+const appOpsTimeFormat = "2006-01-02 15:04:05.000"
+
+// Process states, as printed by `dumpsys appops`, in which the app was not
+// visible to the user.
+var backgroundAppOpStates = []string{"bg", "fgsvc", "cch"}
+
+// The discrete accesses kept for the privacy dashboard are printed with a
+// slightly different format ("Uid: 10123", "Package: com.foo", "CAMERA:").
+var (
+	appOpsUIDRegexp     = regexp.MustCompile(`^Uid:? (\d+):?$`)
+	appOpsPackageRegexp = regexp.MustCompile(`^Package:? (\S+?):?$`)
+	appOpsOpRegexp      = regexp.MustCompile(`^([A-Z_]+)(?: \(\w+.*|:)$`)
+	appOpsAccessRegexp  = regexp.MustCompile(
+		`^(Access|Reject): \[([^\]]+)\] (\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{3})(?:.*duration=(\S+))?`)
+)
+
+// AppOpAccess is an access to an app op recorded in the history kept by
+// the app ops service.
+type AppOpAccess struct {
+	Package string `json:"package"`
+	UID     int    `json:"uid"`
+	Op      string `json:"op"`
+	// "access", or "reject" if the access was denied.
+	Result string `json:"result"`
+	// Process state of the app during the access (e.g. top, fg, bg).
+	State    string `json:"state"`
+	Time     string `json:"time"`
+	TimeUTC  string `json:"time_utc,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// isBackgroundState returns whether an app in the given process state was
+// not visible to the user.
+func isBackgroundState(state string) bool {
+	for _, prefix := range backgroundAppOpStates {
+		if strings.HasPrefix(state, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAppOpsAccesses extracts the accesses to the given app ops from the
+// output of `dumpsys appops`.
+func parseAppOpsAccesses(out string, ops map[string]bool) []AppOpAccess {
+	accesses := []AppOpAccess{}
+	uid, pkg, op := 0, "", ""
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := appOpsUIDRegexp.FindStringSubmatch(trimmed); match != nil {
+			uid, _ = strconv.Atoi(match[1])
+			pkg, op = "", ""
+			continue
+		}
+		if match := appOpsPackageRegexp.FindStringSubmatch(trimmed); match != nil {
+			pkg, op = match[1], ""
+			continue
+		}
+		if match := appOpsOpRegexp.FindStringSubmatch(trimmed); match != nil {
+			op = match[1]
+			continue
+		}
+		if !ops[op] || pkg == "" {
+			continue
+		}
+		if match := appOpsAccessRegexp.FindStringSubmatch(trimmed); match != nil {
+			accesses = append(accesses, AppOpAccess{
+				Package:  pkg,
+				UID:      uid,
+				Op:       op,
+				Result:   strings.ToLower(match[1]),
+				State:    match[2],
+				Time:     match[3],
+				Duration: match[4],
+			})
+		}
+	}
+	return accesses
+}
@@ -10,10 +10,10 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/manifoldco/promptui"
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 const (
@@ -34,18 +34,34 @@ func (b *Backup) Name() string {
 	return "backup"
 }
 
+func (b *Backup) ContentDescription(acq *acquisition.Acquisition) string {
+	if acq.HashOnly || utils.StreamOutputs() {
+		return ""
+	}
+	return "a backup of the SMS and MMS messages, or of the data of all the apps which allow it " +
+		"(such as messages, contacts and call logs), as chosen next"
+}
+
 func (b *Backup) InitStorage(storagePath string) error {
 	b.StoragePath = storagePath
 	return nil
 }
 
 func (b *Backup) Run(acq *acquisition.Acquisition, fast bool) error {
-	log.Info("Would you like to take a backup of the device?")
-	promptBackup := promptui.Select{
-		Label: "Backup",
-		Items: []string{backupOnlySMS, backupEverything, backupNothing},
+	if acq.HashOnly {
+		log.Info("Skipping backup in hash-only mode")
+		return nil
 	}
-	_, backupOption, err := promptBackup.Run()
+	// adb writes the backup straight to disk, so it can be neither encrypted
+	// nor anonymized.
+	if utils.StreamOutputs() {
+		log.Info("Skipping backup when encrypting or anonymizing outputs")
+		return nil
+	}
+
+	log.Info("Would you like to take a backup of the device?")
+	backupOption, err := utils.Select("backup", "Backup",
+		[]string{backupOnlySMS, backupEverything, backupNothing})
 	if err != nil {
 		return fmt.Errorf("failed to make selection for backup option: %v", err)
 	}
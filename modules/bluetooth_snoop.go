@@ -0,0 +1,107 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type BluetoothSnoopLog struct {
+	Path      string `json:"path"`
+	LocalPath string `json:"local_path"`
+	SHA256    string `json:"sha256"`
+}
+
+type BluetoothSnoopReport struct {
+	Mode string              `json:"mode"`
+	Logs []BluetoothSnoopLog `json:"logs"`
+}
+
+type BluetoothSnoop struct {
+	StoragePath string
+}
+
+func NewBluetoothSnoop() *BluetoothSnoop {
+	return &BluetoothSnoop{}
+}
+
+func (b *BluetoothSnoop) Name() string {
+	return "bluetooth_snoop"
+}
+
+func (b *BluetoothSnoop) InitStorage(storagePath string) error {
+	b.StoragePath = storagePath
+	return nil
+}
+
+func (b *BluetoothSnoop) RequiresHardware() bool {
+	return true
+}
+
+func (b *BluetoothSnoop) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Checking for Bluetooth HCI snoop logs...")
+
+	report := BluetoothSnoopReport{Logs: []BluetoothSnoopLog{}}
+
+	// Recent versions use a property (disabled, filtered or full), older ones
+	// a secure setting.
+	report.Mode, _ = adb.Client.Shell("getprop", "persist.bluetooth.btsnooplogmode")
+	if report.Mode == "" {
+		enabled, _ := adb.Client.Shell("settings", "get", "secure", "bluetooth_hci_log")
+		if enabled == "1" {
+			report.Mode = "full"
+		} else {
+			report.Mode = "disabled"
+		}
+	}
+
+	paths := []string{
+		"/data/misc/bluetooth/logs/btsnoop_hci.log",
+		"/data/misc/bluetooth/logs/btsnoop_hci.log.last",
+		"/data/log/bt/btsnoop_hci.log",
+		acq.SdCard + "btsnoop_hci.log",
+		acq.SdCard + "Android/data/btsnoop_hci.log",
+	}
+	for _, path := range paths {
+		if acq.HashOnly {
+			if sha256, ok := hashRemoteFile(path); ok {
+				report.Logs = append(report.Logs, BluetoothSnoopLog{Path: path, SHA256: sha256})
+			}
+			continue
+		}
+
+		localName := strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", "_")
+		localPath := filepath.Join(b.StoragePath, localName)
+		out, err := adb.Client.Pull(path, localPath)
+		if err != nil {
+			log.Debugf("Failed to pull %s: %s", path, strings.TrimSpace(out))
+			continue
+		}
+
+		sha256, err := utils.OutputSHA256(localPath)
+		if err != nil {
+			return err
+		}
+		log.Infof("Retrieved Bluetooth HCI snoop log %s", path)
+		report.Logs = append(report.Logs, BluetoothSnoopLog{
+			Path:      path,
+			LocalPath: localName,
+			SHA256:    sha256,
+		})
+	}
+
+	if report.Mode != "disabled" && len(report.Logs) == 0 {
+		log.Info("Bluetooth HCI snoop logging is enabled, but the logs are not accessible without root. They might be included in the bugreport.")
+	}
+
+	return saveCommandOutputJson(filepath.Join(b.StoragePath, "bluetooth_snoop.json"), &report)
+}
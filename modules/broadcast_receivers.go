@@ -0,0 +1,198 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	TriggerBoot     = "boot"
+	TriggerSMS      = "sms"
+	TriggerCalls    = "calls"
+	TriggerPackages = "packages"
+	TriggerUnlock   = "unlock"
+	TriggerPower    = "power"
+)
+
+// Broadcasts which start an app when the device boots, or when the user
+// receives messages or calls, grouped by trigger.
+var highValueBroadcasts = map[string]string{
+	"android.intent.action.BOOT_COMPLETED":         TriggerBoot,
+	"android.intent.action.LOCKED_BOOT_COMPLETED":  TriggerBoot,
+	"android.intent.action.QUICKBOOT_POWERON":      TriggerBoot,
+	"com.htc.intent.action.QUICKBOOT_POWERON":      TriggerBoot,
+	"android.intent.action.MY_PACKAGE_REPLACED":    TriggerBoot,
+	"android.provider.Telephony.SMS_RECEIVED":      TriggerSMS,
+	"android.provider.Telephony.SMS_DELIVER":       TriggerSMS,
+	"android.provider.Telephony.WAP_PUSH_RECEIVED": TriggerSMS,
+	"android.intent.action.DATA_SMS_RECEIVED":      TriggerSMS,
+	"android.intent.action.PHONE_STATE":            TriggerCalls,
+	"android.intent.action.NEW_OUTGOING_CALL":      TriggerCalls,
+	"android.intent.action.PACKAGE_ADDED":          TriggerPackages,
+	"android.intent.action.PACKAGE_REMOVED":        TriggerPackages,
+	"android.intent.action.PACKAGE_REPLACED":       TriggerPackages,
+	"android.intent.action.USER_PRESENT":           TriggerUnlock,
+	"android.intent.action.ACTION_POWER_CONNECTED": TriggerPower,
+}
+
+var (
+	// android.intent.action.BOOT_COMPLETED:
+	resolverActionRegexp = regexp.MustCompile(`^\s+([\w.]+):$`)
+	// 1a2b3c com.foo/.BootReceiver filter 4d5e6f
+	resolverEntryRegexp = regexp.MustCompile(`^\s+[0-9a-f]+ ([\w.]+)/(\S+)`)
+)
+
+type BroadcastReceiver struct {
+	Package   string `json:"package"`
+	Component string `json:"component"`
+	Action    string `json:"action"`
+	Trigger   string `json:"trigger"`
+	System    bool   `json:"system"`
+}
+
+type PackageTriggers struct {
+	Package  string   `json:"package"`
+	System   bool     `json:"system"`
+	Triggers []string `json:"triggers"`
+}
+
+type BroadcastReceiversReport struct {
+	Receivers []BroadcastReceiver `json:"receivers"`
+	// Triggers starting each package, for triage.
+	Packages []PackageTriggers `json:"packages"`
+}
+
+// BroadcastReceivers enumerates the packages registered to be started when
+// the device boots or receives messages and calls, which malware uses to
+// persist and to react to the activity of the user.
+type BroadcastReceivers struct {
+	StoragePath string
+}
+
+func NewBroadcastReceivers() *BroadcastReceivers {
+	return &BroadcastReceivers{}
+}
+
+func (b *BroadcastReceivers) Name() string {
+	return "broadcast_receivers"
+}
+
+func (b *BroadcastReceivers) InitStorage(storagePath string) error {
+	b.StoragePath = storagePath
+	return nil
+}
+
+// parseBroadcastReceivers extracts the receivers of the high-value
+// broadcasts from the receiver resolver table of `dumpsys package r`.
+func parseBroadcastReceivers(out string) []BroadcastReceiver {
+	receivers := []BroadcastReceiver{}
+	seen := map[BroadcastReceiver]bool{}
+	inReceivers := false
+	action := ""
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" && line[0] != ' ' {
+			inReceivers = strings.HasPrefix(line, "Receiver Resolver Table")
+			action = ""
+			continue
+		}
+		if !inReceivers {
+			continue
+		}
+		if match := resolverActionRegexp.FindStringSubmatch(line); match != nil {
+			action = match[1]
+			continue
+		}
+		trigger, ok := highValueBroadcasts[action]
+		if !ok {
+			continue
+		}
+		match := resolverEntryRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		component := match[2]
+		if strings.HasPrefix(component, ".") {
+			component = match[1] + component
+		}
+		receiver := BroadcastReceiver{
+			Package:   match[1],
+			Component: component,
+			Action:    action,
+			Trigger:   trigger,
+		}
+		if !seen[receiver] {
+			seen[receiver] = true
+			receivers = append(receivers, receiver)
+		}
+	}
+	return receivers
+}
+
+func (b *BroadcastReceivers) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting receivers of boot, SMS and call broadcasts...")
+
+	out, err := adb.Client.Shell("dumpsys", "package", "r")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys package r`: %v", err)
+	}
+
+	system := systemPackages()
+	report := BroadcastReceiversReport{
+		Receivers: parseBroadcastReceivers(out),
+		Packages:  []PackageTriggers{},
+	}
+	triggers := map[string]map[string]bool{}
+	for i := range report.Receivers {
+		receiver := &report.Receivers[i]
+		receiver.System = system[receiver.Package]
+		if triggers[receiver.Package] == nil {
+			triggers[receiver.Package] = map[string]bool{}
+		}
+		triggers[receiver.Package][receiver.Trigger] = true
+	}
+	for pkg, set := range triggers {
+		entry := PackageTriggers{Package: pkg, System: system[pkg], Triggers: []string{}}
+		for trigger := range set {
+			entry.Triggers = append(entry.Triggers, trigger)
+		}
+		sort.Strings(entry.Triggers)
+		report.Packages = append(report.Packages, entry)
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		return report.Packages[i].Package < report.Packages[j].Package
+	})
+
+	// Many apps start at boot, but few also need to react to messages or
+	// calls.
+	for _, entry := range report.Packages {
+		set := triggers[entry.Package]
+		if entry.System || !set[TriggerBoot] || !(set[TriggerSMS] || set[TriggerCalls]) {
+			continue
+		}
+		title := fmt.Sprintf("Non-system package starts at boot and on incoming messages or calls: %s", entry.Package)
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    title,
+			Source:   b.Name(),
+			File:     b.Name() + "/broadcast_receivers.json",
+			Value:    strings.Join(entry.Triggers, ","),
+			Package:  entry.Package,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(b.StoragePath, "broadcast_receivers.json"), &report)
+}
@@ -0,0 +1,155 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	// Modules pulling files or copying content get this many times the
+	// share of the remaining time of the other modules.
+	budgetHeavyWeight = 4
+	// Below these fractions of the budget left, modules pulling files only
+	// hash them, then modules copying content are skipped and the others
+	// run in fast mode.
+	budgetHashOnlyLeft = 0.5
+	budgetSkipLeft     = 0.25
+)
+
+// BudgetPlan is how a module runs to fit the acquisition in its time
+// budget.
+type BudgetPlan struct {
+	Skip     bool
+	HashOnly bool
+	Fast     bool
+}
+
+// Budget spreads the time left before the deadline of the acquisition
+// across the modules still to run, and degrades them as the deadline
+// approaches. Modules are not interrupted, so the deadline can be exceeded
+// by the module running when it passes.
+type Budget struct {
+	acq *acquisition.Acquisition
+	// Weights of the modules still to run.
+	pending map[string]float64
+	// When the first module started, and the total weight of the modules
+	// which ran since, to measure the pace of the acquisition.
+	started time.Time
+	done    float64
+}
+
+func budgetWeight(mod Module) float64 {
+	if _, ok := mod.(SizeEstimator); ok {
+		return budgetHeavyWeight
+	}
+	if _, ok := mod.(ContentModule); ok {
+		return budgetHeavyWeight
+	}
+	return 1
+}
+
+// NewBudget returns the budget of the given modules, leaving out those
+// which will not run.
+func NewBudget(acq *acquisition.Acquisition, mods []Module, skip map[string]bool) *Budget {
+	b := &Budget{acq: acq, pending: map[string]float64{}}
+	for _, mod := range mods {
+		if skip[mod.Name()] {
+			continue
+		}
+		if hw, ok := mod.(HardwareModule); ok && hw.RequiresHardware() && acq.Emulator.Detected {
+			continue
+		}
+		if r, ok := mod.(RootModule); ok && r.RequiresRoot() && !HasRoot(acq) {
+			continue
+		}
+		b.pending[mod.Name()] = budgetWeight(mod)
+	}
+	return b
+}
+
+// Plan decides how to run a module given the time left. Its share of the
+// remaining time is compared with the time it is expected to take at the
+// pace of the modules which already ran. The cuts are recorded in the
+// acquisition.
+func (b *Budget) Plan(mod Module, fast bool) BudgetPlan {
+	plan := BudgetPlan{}
+	weight := budgetWeight(mod)
+	var total float64
+	for _, w := range b.pending {
+		total += w
+	}
+	if _, ok := b.pending[mod.Name()]; !ok {
+		total += weight
+	}
+
+	remaining := b.acq.BudgetRemaining()
+	if remaining <= 0 {
+		b.skip(mod, "the time budget is exhausted")
+		return BudgetPlan{Skip: true}
+	}
+	if b.started.IsZero() {
+		b.started = time.Now()
+	}
+	left := remaining.Seconds() / b.acq.Budget.Seconds
+	share := time.Duration(float64(remaining) * weight / total)
+	var expected time.Duration
+	if b.done > 0 {
+		elapsed := time.Since(b.started)
+		expected = time.Duration(float64(elapsed) * weight / b.done)
+	}
+	log.Debugf("Module %s has %s of the %s left, expected to take %s", mod.Name(),
+		share.Round(time.Second), remaining.Round(time.Second), expected.Round(time.Second))
+
+	reason := ""
+	switch {
+	case left < budgetSkipLeft:
+		reason = fmt.Sprintf("only %s of the time budget is left", remaining.Round(time.Second))
+	case expected > 2*share:
+		reason = fmt.Sprintf("it is expected to take %s, but only has %s of the time left",
+			expected.Round(time.Second), share.Round(time.Second))
+	}
+	if reason != "" {
+		if _, ok := mod.(ContentModule); ok {
+			b.skip(mod, reason)
+			return BudgetPlan{Skip: true}
+		}
+		if !fast {
+			plan.Fast = true
+			b.acq.CutFromBudget(mod.Name(), acquisition.BudgetFast, reason)
+		}
+	}
+
+	reason = ""
+	switch {
+	case left < budgetHashOnlyLeft:
+		reason = fmt.Sprintf("only %s of the time budget is left", remaining.Round(time.Second))
+	case expected > share:
+		reason = fmt.Sprintf("it is expected to take %s, but only has %s of the time left",
+			expected.Round(time.Second), share.Round(time.Second))
+	}
+	if _, ok := mod.(SizeEstimator); ok && reason != "" && !b.acq.HashOnly {
+		plan.HashOnly = true
+		b.acq.CutFromBudget(mod.Name(), acquisition.BudgetHashOnly, reason)
+	}
+
+	return plan
+}
+
+// Done records that a module ran.
+func (b *Budget) Done(mod Module) {
+	delete(b.pending, mod.Name())
+	b.done += budgetWeight(mod)
+}
+
+func (b *Budget) skip(mod Module, reason string) {
+	delete(b.pending, mod.Name())
+	b.acq.CutFromBudget(mod.Name(), acquisition.BudgetSkipped, reason)
+}
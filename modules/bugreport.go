@@ -12,6 +12,7 @@ import (
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type Bugreport struct {
@@ -26,12 +27,30 @@ func (b *Bugreport) Name() string {
 	return "bugreport"
 }
 
+func (b *Bugreport) ContentDescription(acq *acquisition.Acquisition) string {
+	if acq.HashOnly || utils.StreamOutputs() {
+		return ""
+	}
+	return "a bug report, with the system logs, the accounts, recent notifications and the usage history of the apps"
+}
+
 func (b *Bugreport) InitStorage(storagePath string) error {
 	b.StoragePath = storagePath
 	return nil
 }
 
 func (b *Bugreport) Run(acq *acquisition.Acquisition, fast bool) error {
+	if acq.HashOnly {
+		log.Info("Skipping bugreport in hash-only mode")
+		return nil
+	}
+	// adb writes the bugreport straight to disk, so it can be neither encrypted
+	// nor anonymized.
+	if utils.StreamOutputs() {
+		log.Info("Skipping bugreport when encrypting or anonymizing outputs")
+		return nil
+	}
+
 	log.Info(
 		"Generating a bugreport for the device...",
 	)
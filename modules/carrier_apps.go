@@ -0,0 +1,172 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var (
+	// mPrivilegedPackageInfo=PrivilegedPackageInfo {packageNames=[com.foo], uids=[10123], ...}
+	privilegedPackagesRegexp = regexp.MustCompile(`packageNames=\[([^\]]*)\]`)
+	// <disabled-until-used-preinstalled-carrier-app package="com.foo" />
+	sysconfigPackageRegexp = regexp.MustCompile(`package="([^"]+)"`)
+)
+
+// Folders of the partitions declaring the preinstalled carrier apps.
+var sysconfigFolders = []string{
+	"/system/etc/sysconfig", "/system_ext/etc/sysconfig", "/product/etc/sysconfig", "/vendor/etc/sysconfig",
+}
+
+const (
+	carrierSourcePrivileges = "carrier_privileges"
+	carrierSourceSysconfig  = "preinstalled_carrier_app"
+	carrierSourceService    = "carrier_service"
+)
+
+type CarrierPackage struct {
+	Package string `json:"package"`
+	System  bool   `json:"system"`
+	// Whether the package is signed with a certificate allowed by the
+	// carrier privilege rules of an inserted SIM card.
+	CarrierPrivileges bool `json:"carrier_privileges"`
+	// How the package was found to be a carrier app.
+	Sources []string `json:"sources"`
+}
+
+// CarrierApps reports the packages holding carrier privileges granted by the
+// SIM cards, and the carrier apps preinstalled on the device, a category
+// historically abused for silent installation and tracking.
+type CarrierApps struct {
+	StoragePath string
+}
+
+func NewCarrierApps() *CarrierApps {
+	return &CarrierApps{}
+}
+
+func (c *CarrierApps) Name() string {
+	return "carrier_apps"
+}
+
+func (c *CarrierApps) InitStorage(storagePath string) error {
+	c.StoragePath = storagePath
+	return nil
+}
+
+// parseCarrierPrivilegedPackages extracts the packages with carrier
+// privileges from the output of `dumpsys phone`.
+func parseCarrierPrivilegedPackages(out string) []string {
+	packages := []string{}
+	for _, match := range privilegedPackagesRegexp.FindAllStringSubmatch(out, -1) {
+		for _, pkg := range strings.Split(match[1], ",") {
+			pkg = strings.TrimSpace(pkg)
+			if pkg != "" {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return packages
+}
+
+func (c *CarrierApps) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting carrier apps and carrier-privileged packages...")
+
+	found := map[string]*CarrierPackage{}
+	add := func(pkg, source string) {
+		carrier, ok := found[pkg]
+		if !ok {
+			carrier = &CarrierPackage{Package: pkg, Sources: []string{}}
+			found[pkg] = carrier
+		}
+		for _, existing := range carrier.Sources {
+			if existing == source {
+				return
+			}
+		}
+		carrier.Sources = append(carrier.Sources, source)
+		if source == carrierSourcePrivileges {
+			carrier.CarrierPrivileges = true
+		}
+	}
+
+	out, err := adb.Client.Shell("dumpsys", "phone")
+	if err != nil {
+		log.Debugf("Failed to run `dumpsys phone`: %v", err)
+	} else {
+		err = saveCommandOutput(filepath.Join(c.StoragePath, "phone.txt"), out)
+		if err != nil {
+			return err
+		}
+		for _, pkg := range parseCarrierPrivilegedPackages(out) {
+			add(pkg, carrierSourcePrivileges)
+		}
+	}
+
+	files := []string{}
+	for _, folder := range sysconfigFolders {
+		files = append(files, folder+"/*.xml")
+	}
+	out, _ = adb.Client.Shell("grep", "-h", "carrier", strings.Join(files, " "), "2>", "/dev/null")
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, "preinstalled-carrier") {
+			continue
+		}
+		if match := sysconfigPackageRegexp.FindStringSubmatch(line); match != nil {
+			add(match[1], carrierSourceSysconfig)
+		}
+	}
+
+	if acq.Capabilities.Has("cmd") {
+		out, err = adb.Client.Shell("cmd", "package", "query-services", "--brief",
+			"-a", "android.service.carrier.CarrierService")
+		if err != nil {
+			log.Debugf("Failed to list carrier services: %v", err)
+		} else {
+			for _, pkg := range parseActivityPackages(out) {
+				add(pkg, carrierSourceService)
+			}
+		}
+	}
+
+	system := systemPackages()
+	packages := []CarrierPackage{}
+	for _, carrier := range found {
+		carrier.System = system[carrier.Package]
+		packages = append(packages, *carrier)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Package < packages[j].Package
+	})
+
+	for _, carrier := range packages {
+		if !carrier.CarrierPrivileges || carrier.System {
+			continue
+		}
+		title := fmt.Sprintf("Non-system package holds carrier privileges: %s", carrier.Package)
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    title,
+			Source:   c.Name(),
+			File:     c.Name() + "/carrier_apps.json",
+			Value:    carrier.Package,
+			Package:  carrier.Package,
+		})
+	}
+	log.Debugf("Found %d carrier apps", len(packages))
+
+	return saveCommandOutputJson(filepath.Join(c.StoragePath, "carrier_apps.json"), &packages)
+}
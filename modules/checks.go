@@ -0,0 +1,253 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// ConfigCheck is the result of the comparison of a device setting with the
+// recommended baseline.
+type ConfigCheck struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	Value       string `json:"value"`
+	Expected    string `json:"expected"`
+	// Passed is false if the setting differs from the baseline, and nil if
+	// its value could not be read.
+	Passed *bool `json:"passed"`
+}
+
+// configCheck reads a value from the device and tells whether it matches
+// the baseline. It returns an empty value if it cannot be read.
+type configCheck struct {
+	id          string
+	description string
+	severity    string
+	expected    string
+	read        func(acq *acquisition.Acquisition) string
+	passes      func(value string) bool
+}
+
+func readSetting(namespace, key string) func(acq *acquisition.Acquisition) string {
+	return func(acq *acquisition.Acquisition) string {
+		value, err := adb.Client.Shell("settings", "get", namespace, key)
+		if err != nil || value == "null" {
+			return ""
+		}
+		return value
+	}
+}
+
+func isValue(expected string) func(value string) bool {
+	return func(value string) bool {
+		return value == expected
+	}
+}
+
+// readUnknownSources returns the packages allowed to install other apps.
+// Before Android 8 this was a single setting for all apps.
+func readUnknownSources(acq *acquisition.Acquisition) string {
+	if !acq.Capabilities.AtLeast(26) {
+		return readSetting("secure", "install_non_market_apps")(acq)
+	}
+	packages, err := appOpsPackages("REQUEST_INSTALL_PACKAGES", "allow")
+	if err != nil {
+		return ""
+	}
+	if len(packages) == 0 {
+		return "none"
+	}
+	return strings.Join(packages, ",")
+}
+
+func readAdbTCPPort(acq *acquisition.Acquisition) string {
+	port, _ := adb.Client.Shell("getprop", "service.adb.tcp.port")
+	if port == "" {
+		port = "-1"
+	}
+	return port
+}
+
+func readWirelessDebugging(acq *acquisition.Acquisition) string {
+	value := readSetting("global", "adb_wifi_enabled")(acq)
+	if value == "" && !acq.Capabilities.AtLeast(30) {
+		// Wireless debugging is not available before Android 11.
+		return "0"
+	}
+	return value
+}
+
+// readLockScreen returns whether a PIN, pattern or password is set.
+// Verifying an empty credential only succeeds if there is none.
+func readLockScreen(acq *acquisition.Acquisition) string {
+	disabled, _ := adb.Client.Shell("cmd", "lock_settings", "get-disabled")
+	if disabled == "true" {
+		return "disabled"
+	}
+	out, err := adb.Client.Shell("cmd", "lock_settings", "verify")
+	if err != nil && out == "" {
+		return ""
+	}
+	if strings.Contains(out, "verified successfully") {
+		return "none"
+	}
+	return "secure"
+}
+
+// readAdbKeys returns the number of computers allowed to use USB debugging
+// without confirmation. The list is only readable with root privileges.
+func readAdbKeys(acq *acquisition.Acquisition) string {
+	out, err := adb.Client.Shell("cat", "/data/misc/adb/adb_keys")
+	if err != nil || strings.Contains(out, "Permission denied") || strings.Contains(out, "No such file") {
+		return ""
+	}
+	count := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return strconv.Itoa(count)
+}
+
+var configChecks = []configCheck{
+	{
+		id:          "unknown_sources",
+		description: "Apps can be installed from unknown sources",
+		severity:    acquisition.SeverityMedium,
+		expected:    "none",
+		read:        readUnknownSources,
+		passes: func(value string) bool {
+			return value == "none" || value == "0"
+		},
+	},
+	{
+		id:          "developer_options",
+		description: "Developer options are enabled",
+		severity:    acquisition.SeverityLow,
+		expected:    "0",
+		read:        readSetting("global", "development_settings_enabled"),
+		passes:      isValue("0"),
+	},
+	{
+		id:          "adb_tcp",
+		description: "ADB over network is enabled",
+		severity:    acquisition.SeverityHigh,
+		expected:    "-1",
+		read:        readAdbTCPPort,
+		passes: func(value string) bool {
+			port, err := strconv.Atoi(value)
+			return err == nil && port <= 0
+		},
+	},
+	{
+		id:          "wireless_debugging",
+		description: "Wireless debugging is enabled",
+		severity:    acquisition.SeverityHigh,
+		expected:    "0",
+		read:        readWirelessDebugging,
+		passes:      isValue("0"),
+	},
+	{
+		id:          "lock_screen",
+		description: "No secure lock screen is set",
+		severity:    acquisition.SeverityMedium,
+		expected:    "secure",
+		read:        readLockScreen,
+		passes:      isValue("secure"),
+	},
+	{
+		id:          "play_protect",
+		description: "Google Play Protect app verification is disabled",
+		severity:    acquisition.SeverityHigh,
+		expected:    "1",
+		read:        readSetting("global", "package_verifier_enable"),
+		passes:      isValue("1"),
+	},
+	{
+		id:          "verify_adb_installs",
+		description: "Apps installed over USB are not verified",
+		severity:    acquisition.SeverityMedium,
+		expected:    "1",
+		read:        readSetting("global", "verifier_verify_adb_installs"),
+		passes:      isValue("1"),
+	},
+	{
+		id:          "adb_keys",
+		description: "Computers are allowed to use USB debugging without confirmation",
+		severity:    acquisition.SeverityMedium,
+		expected:    "0",
+		read:        readAdbKeys,
+		passes:      isValue("0"),
+	},
+}
+
+// Checks compares security-relevant settings of the device with a baseline
+// of recommended values, and reports each deviation with its severity.
+type Checks struct {
+	StoragePath string
+}
+
+func NewChecks() *Checks {
+	return &Checks{}
+}
+
+func (c *Checks) Name() string {
+	return "checks"
+}
+
+func (c *Checks) InitStorage(storagePath string) error {
+	c.StoragePath = storagePath
+	return nil
+}
+
+func (c *Checks) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Checking the device configuration for risky settings...")
+
+	results := []ConfigCheck{}
+	for _, check := range configChecks {
+		result := ConfigCheck{
+			ID:          check.id,
+			Description: check.description,
+			Severity:    check.severity,
+			Value:       check.read(acq),
+			Expected:    check.expected,
+		}
+		if result.Value == "" {
+			log.Debugf("Unable to check %s", check.id)
+			results = append(results, result)
+			continue
+		}
+
+		passed := check.passes(result.Value)
+		result.Passed = &passed
+		results = append(results, result)
+		if passed {
+			continue
+		}
+
+		log.Warningf("%s (%s)", check.description, result.Value)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: check.severity,
+			Title:    check.description,
+			Source:   c.Name(),
+			File:     c.Name() + "/checks.json",
+			Value:    fmt.Sprintf("%s=%s", check.id, result.Value),
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(c.StoragePath, "checks.json"), &results)
+}
@@ -0,0 +1,104 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type ClipboardAccess struct {
+	AppOpAccess
+	System bool `json:"system"`
+}
+
+// Clipboard collects the history of clipboard reads by each package, as
+// reading the clipboard from the background is used by spyware to steal
+// passwords and messages.
+type Clipboard struct {
+	StoragePath string
+}
+
+func NewClipboard() *Clipboard {
+	return &Clipboard{}
+}
+
+func (c *Clipboard) Name() string {
+	return "clipboard"
+}
+
+func (c *Clipboard) InitStorage(storagePath string) error {
+	c.StoragePath = storagePath
+	return nil
+}
+
+func (c *Clipboard) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting clipboard access history...")
+
+	out, err := adb.Client.Shell("dumpsys", "appops", "--op", "READ_CLIPBOARD")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys appops`: %v", err)
+	}
+	err = saveCommandOutput(filepath.Join(c.StoragePath, "appops_clipboard.txt"), out)
+	if err != nil {
+		return err
+	}
+
+	system := systemPackages()
+	accesses := []ClipboardAccess{}
+	// Packages which read, or attempted to read, the clipboard while not
+	// visible to the user.
+	read := map[string]bool{}
+	attempted := map[string]bool{}
+	for _, access := range parseAppOpsAccesses(out, map[string]bool{"READ_CLIPBOARD": true}) {
+		access.TimeUTC = acq.DeviceTimeToUTC(appOpsTimeFormat, access.Time)
+		accesses = append(accesses, ClipboardAccess{AppOpAccess: access, System: system[access.Package]})
+		if system[access.Package] || !isBackgroundState(access.State) {
+			continue
+		}
+		if access.Result == "access" {
+			read[access.Package] = true
+		} else {
+			attempted[access.Package] = true
+		}
+	}
+
+	packages := []string{}
+	for pkg := range read {
+		packages = append(packages, pkg)
+	}
+	for pkg := range attempted {
+		if !read[pkg] {
+			packages = append(packages, pkg)
+		}
+	}
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		severity := acquisition.SeverityMedium
+		title := fmt.Sprintf("Non-system package read the clipboard while not visible: %s", pkg)
+		if !read[pkg] {
+			severity = acquisition.SeverityLow
+			title = fmt.Sprintf("Non-system package attempted to read the clipboard while not visible: %s", pkg)
+		}
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: severity,
+			Title:    title,
+			Source:   c.Name(),
+			File:     c.Name() + "/clipboard.json",
+			Value:    pkg,
+			Package:  pkg,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(c.StoragePath, "clipboard.json"), &accesses)
+}
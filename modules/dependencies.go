@@ -0,0 +1,111 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+)
+
+// DependentModule is implemented by modules which use the results of other
+// modules, which must run and succeed before them.
+type DependentModule interface {
+	Dependencies() []string
+}
+
+// RootModule is implemented by modules which can only collect data with
+// root privileges, which are skipped when the device is not rooted.
+type RootModule interface {
+	RequiresRoot() bool
+}
+
+// Dependencies returns the names of the modules the given module depends on.
+func Dependencies(mod Module) []string {
+	if d, ok := mod.(DependentModule); ok {
+		return d.Dependencies()
+	}
+	return nil
+}
+
+// HasRoot returns true if the modules requiring root can run on the device.
+func HasRoot(acq *acquisition.Acquisition) bool {
+	return acq.Capabilities != nil && (acq.Capabilities.Root || acq.Capabilities.Has("su"))
+}
+
+// WithDependencies returns the selected modules, followed by the modules from
+// all which they depend on and which were not selected, unless include
+// returns false for them.
+func WithDependencies(selected, all []Module, include func(Module) bool) []Module {
+	byName := map[string]Module{}
+	for _, mod := range all {
+		byName[mod.Name()] = mod
+	}
+	added := map[string]bool{}
+	for _, mod := range selected {
+		added[mod.Name()] = true
+	}
+
+	result := append([]Module{}, selected...)
+	for i := 0; i < len(result); i++ {
+		for _, name := range Dependencies(result[i]) {
+			dep, ok := byName[name]
+			if !ok || added[name] || !include(dep) {
+				continue
+			}
+			added[name] = true
+			result = append(result, dep)
+		}
+	}
+	return result
+}
+
+// Sort orders the modules so that each one comes after the modules it
+// depends on, and otherwise keeps their order. Dependencies which are not
+// among the modules are ignored. It fails if the dependencies form a cycle.
+func Sort(mods []Module) ([]Module, error) {
+	present := map[string]bool{}
+	for _, mod := range mods {
+		present[mod.Name()] = true
+	}
+
+	sorted := make([]Module, 0, len(mods))
+	placed := map[string]bool{}
+	for len(sorted) < len(mods) {
+		progress := false
+		for _, mod := range mods {
+			if placed[mod.Name()] {
+				continue
+			}
+			ready := true
+			for _, dep := range Dependencies(mod) {
+				if present[dep] && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, mod)
+				placed[mod.Name()] = true
+				progress = true
+				// Start over, to keep the order of the modules which
+				// were waiting.
+				break
+			}
+		}
+		if !progress {
+			cycle := []string{}
+			for _, mod := range mods {
+				if !placed[mod.Name()] {
+					cycle = append(cycle, mod.Name())
+				}
+			}
+			return nil, fmt.Errorf("circular dependencies between modules %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,214 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type DeviceAdmin struct {
+	Component string   `json:"component"`
+	Package   string   `json:"package"`
+	Policies  []string `json:"policies"`
+	System    bool     `json:"system"`
+	Flagged   bool     `json:"flagged"`
+}
+
+type DeviceOwner struct {
+	Type    string `json:"type"`
+	Package string `json:"package"`
+	Admin   string `json:"admin"`
+	System  bool   `json:"system"`
+	Flagged bool   `json:"flagged"`
+}
+
+type DevicePolicyReport struct {
+	Managed bool          `json:"managed"`
+	Owners  []DeviceOwner `json:"owners"`
+	Admins  []DeviceAdmin `json:"admins"`
+}
+
+type DevicePolicy struct {
+	StoragePath string
+}
+
+func NewDevicePolicy() *DevicePolicy {
+	return &DevicePolicy{}
+}
+
+func (d *DevicePolicy) Name() string {
+	return "device_policy"
+}
+
+func (d *DevicePolicy) InitStorage(storagePath string) error {
+	d.StoragePath = storagePath
+	return nil
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// parseDevicePolicy parses the output of `dumpsys device_policy` to extract
+// device and profile owners, as well as the enabled device admins and their
+// requested policies.
+func parseDevicePolicy(out string) DevicePolicyReport {
+	report := DevicePolicyReport{
+		Owners: []DeviceOwner{},
+		Admins: []DeviceAdmin{},
+	}
+
+	var owner *DeviceOwner
+	var admin *DeviceAdmin
+	inAdmins := false
+	adminsIndent := 0
+	inPolicies := false
+	policiesIndent := 0
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		indent := indentation(line)
+
+		if inPolicies {
+			if indent > policiesIndent {
+				admin.Policies = append(admin.Policies, trimmed)
+				continue
+			}
+			inPolicies = false
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "Device Owner"):
+			report.Owners = append(report.Owners, DeviceOwner{Type: "device_owner"})
+			owner = &report.Owners[len(report.Owners)-1]
+			inAdmins = false
+			continue
+		case strings.HasPrefix(trimmed, "Profile Owner"):
+			report.Owners = append(report.Owners, DeviceOwner{Type: "profile_owner"})
+			owner = &report.Owners[len(report.Owners)-1]
+			inAdmins = false
+			continue
+		case strings.HasPrefix(trimmed, "Enabled Device Admins"):
+			owner = nil
+			inAdmins = true
+			adminsIndent = indent
+			continue
+		}
+
+		if owner != nil {
+			switch {
+			case strings.HasPrefix(trimmed, "admin="):
+				owner.Admin = strings.TrimSuffix(strings.TrimPrefix(trimmed, "admin=ComponentInfo{"), "}")
+				if owner.Package == "" {
+					owner.Package = strings.Split(owner.Admin, "/")[0]
+				}
+			case strings.HasPrefix(trimmed, "package="):
+				owner.Package = strings.TrimPrefix(trimmed, "package=")
+			}
+			continue
+		}
+
+		if inAdmins {
+			if indent <= adminsIndent {
+				inAdmins = false
+				admin = nil
+				continue
+			}
+			if strings.HasSuffix(trimmed, ":") && strings.Contains(trimmed, "/") {
+				component := strings.TrimSuffix(trimmed, ":")
+				report.Admins = append(report.Admins, DeviceAdmin{
+					Component: component,
+					Package:   strings.Split(component, "/")[0],
+					Policies:  []string{},
+				})
+				admin = &report.Admins[len(report.Admins)-1]
+				continue
+			}
+			if admin != nil && trimmed == "policies:" {
+				inPolicies = true
+				policiesIndent = indent
+			}
+		}
+	}
+
+	// Drop empty owner sections (e.g. "Device Owner: none").
+	owners := []DeviceOwner{}
+	for _, o := range report.Owners {
+		if o.Package != "" {
+			owners = append(owners, o)
+		}
+	}
+	report.Owners = owners
+	report.Managed = len(report.Owners) > 0
+
+	return report
+}
+
+func (d *DevicePolicy) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting device policy and management state...")
+
+	out, err := adb.Client.Shell("dumpsys", "device_policy")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys device_policy`: %v", err)
+	}
+
+	err = saveCommandOutput(filepath.Join(d.StoragePath, "device_policy.txt"), out)
+	if err != nil {
+		return err
+	}
+
+	system := systemPackages()
+	report := parseDevicePolicy(out)
+
+	// Any device or profile owner, and any device admin which is not part of
+	// the system image, is unexpected on a personal device.
+	for i := range report.Owners {
+		report.Owners[i].System = system[report.Owners[i].Package]
+		report.Owners[i].Flagged = !report.Owners[i].System
+		if report.Owners[i].Flagged {
+			title := fmt.Sprintf("Unexpected %s: %s", strings.Replace(report.Owners[i].Type, "_", " ", 1),
+				report.Owners[i].Package)
+			log.Warning(title)
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: acquisition.SeverityHigh,
+				Title:    title,
+				Source:   d.Name(),
+				File:     d.Name() + "/device_policy.json",
+				Value:    report.Owners[i].Package,
+				Package:  report.Owners[i].Package,
+			})
+		}
+	}
+	for i := range report.Admins {
+		report.Admins[i].System = system[report.Admins[i].Package]
+		report.Admins[i].Flagged = !report.Admins[i].System
+		if report.Admins[i].Flagged {
+			log.Warningf("Found non-system device admin: %s", report.Admins[i].Package)
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: acquisition.SeverityMedium,
+				Title:    fmt.Sprintf("Non-system device admin: %s", report.Admins[i].Package),
+				Source:   d.Name(),
+				File:     d.Name() + "/device_policy.json",
+				Value:    report.Admins[i].Package,
+				Package:  report.Admins[i].Package,
+			})
+		}
+	}
+
+	return saveCommandOutputJson(filepath.Join(d.StoragePath, "device_policy.json"), &report)
+}
@@ -0,0 +1,180 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var (
+	networkAgentRegexp  = regexp.MustCompile(`NetworkAgentInfo\{\s*network\{(\d+)\}`)
+	networkTypeRegexp   = regexp.MustCompile(`ni\{(\S+)`)
+	interfaceNameRegexp = regexp.MustCompile(`InterfaceName: (\S+)`)
+	dnsAddressesRegexp  = regexp.MustCompile(`DnsAddresses: \[ ([^\]]*)\]`)
+	usePrivateDNSRegexp = regexp.MustCompile(`UsePrivateDns: (true|false)`)
+	privateDNSRegexp    = regexp.MustCompile(`PrivateDnsServerName: ([^\s}]+)`)
+)
+
+// NetworkDNS is the resolver configuration of a network, as reported by
+// `dumpsys connectivity`.
+type NetworkDNS struct {
+	NetworkID            int      `json:"network_id"`
+	Type                 string   `json:"type"`
+	Interface            string   `json:"interface"`
+	Servers              []string `json:"servers"`
+	UsePrivateDNS        bool     `json:"use_private_dns"`
+	PrivateDNSServerName string   `json:"private_dns_server_name"`
+}
+
+type DNSReport struct {
+	PrivateDNSMode      string       `json:"private_dns_mode"`
+	PrivateDNSSpecifier string       `json:"private_dns_specifier"`
+	LegacyServers       []string     `json:"legacy_servers"`
+	Networks            []NetworkDNS `json:"networks"`
+}
+
+// parseConnectivityDNS extracts the resolver configuration of each network
+// from the LinkProperties listed by `dumpsys connectivity`.
+func parseConnectivityDNS(out string) []NetworkDNS {
+	networks := []NetworkDNS{}
+	seen := map[int]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		match := networkAgentRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(match[1])
+		// Networks can be listed in more than one section.
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		network := NetworkDNS{NetworkID: id, Servers: []string{}}
+		if match := networkTypeRegexp.FindStringSubmatch(line); match != nil {
+			network.Type = match[1]
+		}
+		if match := interfaceNameRegexp.FindStringSubmatch(line); match != nil {
+			network.Interface = match[1]
+		}
+		if match := dnsAddressesRegexp.FindStringSubmatch(line); match != nil {
+			for _, server := range strings.Split(match[1], ",") {
+				server = strings.TrimPrefix(strings.TrimSpace(server), "/")
+				if server != "" {
+					network.Servers = append(network.Servers, server)
+				}
+			}
+		}
+		if match := usePrivateDNSRegexp.FindStringSubmatch(line); match != nil {
+			network.UsePrivateDNS = match[1] == "true"
+		}
+		if match := privateDNSRegexp.FindStringSubmatch(line); match != nil {
+			network.PrivateDNSServerName = match[1]
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+type DNS struct {
+	StoragePath string
+}
+
+func NewDNS() *DNS {
+	return &DNS{}
+}
+
+func (d *DNS) Name() string {
+	return "dns"
+}
+
+func (d *DNS) InitStorage(storagePath string) error {
+	d.StoragePath = storagePath
+	return nil
+}
+
+func (d *DNS) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting DNS resolver configuration...")
+
+	out, err := adb.Client.Shell("dumpsys", "dnsresolver")
+	if err != nil {
+		log.Debugf("failed to run `adb shell dumpsys dnsresolver`: %v", err)
+	} else {
+		err = saveCommandOutput(filepath.Join(d.StoragePath, "dns_resolver.txt"), out)
+		if err != nil {
+			return err
+		}
+	}
+
+	report := DNSReport{LegacyServers: []string{}, Networks: []NetworkDNS{}}
+
+	out, err = adb.Client.Shell("dumpsys", "connectivity")
+	if err != nil {
+		log.Debugf("failed to run `adb shell dumpsys connectivity`: %v", err)
+	} else {
+		err = saveCommandOutput(filepath.Join(d.StoragePath, "connectivity.txt"), out)
+		if err != nil {
+			return err
+		}
+		report.Networks = parseConnectivityDNS(out)
+	}
+
+	report.PrivateDNSMode, err = adb.Client.Shell("settings", "get", "global", "private_dns_mode")
+	if err != nil {
+		return fmt.Errorf("failed to get private DNS mode: %v", err)
+	}
+	report.PrivateDNSSpecifier, _ = adb.Client.Shell("settings", "get", "global", "private_dns_specifier")
+	if report.PrivateDNSSpecifier == "null" {
+		report.PrivateDNSSpecifier = ""
+	}
+
+	// Older Android versions expose the resolvers as properties.
+	for i := 1; i <= 4; i++ {
+		server, err := adb.Client.Shell("getprop", fmt.Sprintf("net.dns%d", i))
+		if err == nil && strings.TrimSpace(server) != "" {
+			report.LegacyServers = append(report.LegacyServers, strings.TrimSpace(server))
+		}
+	}
+
+	if report.PrivateDNSMode == "hostname" && report.PrivateDNSSpecifier != "" {
+		log.Infof("Private DNS is configured to use %s", report.PrivateDNSSpecifier)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityLow,
+			Title:    fmt.Sprintf("Private DNS is configured to use %s", report.PrivateDNSSpecifier),
+			Source:   d.Name(),
+			File:     d.Name() + "/dns.json",
+			Value:    report.PrivateDNSSpecifier,
+		})
+	}
+
+	for _, network := range report.Networks {
+		if network.PrivateDNSServerName == "" || network.PrivateDNSServerName == report.PrivateDNSSpecifier {
+			continue
+		}
+		log.Infof("Network %d (%s) uses private DNS server %s", network.NetworkID,
+			network.Interface, network.PrivateDNSServerName)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityLow,
+			Title: fmt.Sprintf("Network %d (%s) uses private DNS server %s", network.NetworkID,
+				network.Interface, network.PrivateDNSServerName),
+			Source: d.Name(),
+			File:   d.Name() + "/dns.json",
+			Value:  network.PrivateDNSServerName,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(d.StoragePath, "dns.json"), &report)
+}
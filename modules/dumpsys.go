@@ -9,7 +9,6 @@ import (
 	"path/filepath"
 
 	"github.com/mvt-project/androidqf/acquisition"
-	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
 )
 
@@ -33,10 +32,10 @@ func (d *Dumpsys) InitStorage(storagePath string) error {
 func (d *Dumpsys) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting device diagnostic information. This might take a while...")
 
-	out, err := adb.Client.Shell("dumpsys")
+	_, err := saveShellOutput(filepath.Join(d.StoragePath, "dumpsys.txt"), "dumpsys")
 	if err != nil {
 		return fmt.Errorf("failed to run `adb shell dumpsys`: %v", err)
 	}
 
-	return saveCommandOutput(filepath.Join(d.StoragePath, "dumpsys.txt"), out)
+	return nil
 }
@@ -0,0 +1,203 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	rt "github.com/botherder/go-savetime/runtime"
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// ExternalModulesFolder is the folder next to the executable in which
+// external module executables are discovered.
+const ExternalModulesFolder = "modules.d"
+
+// ExternalRequest is written as JSON to the standard input of an external
+// module.
+type ExternalRequest struct {
+	AndroidQFVersion string `json:"androidqf_version"`
+	AcquisitionUUID  string `json:"acquisition_uuid"`
+	// Path of the adb executable, and socket of the adb server to which it
+	// connects, with the serial of the device to use.
+	AdbPath         string `json:"adb_path"`
+	AdbServerSocket string `json:"adb_server_socket"`
+	Serial          string `json:"serial"`
+	APILevel        int    `json:"api_level"`
+	// Folder in which the module stores its output.
+	OutputDir string `json:"output_dir"`
+	Fast      bool   `json:"fast"`
+	HashOnly  bool   `json:"hash_only"`
+}
+
+// ExternalResponse is read as JSON from the standard output of an external
+// module. The file of the detections is relative to the output folder.
+type ExternalResponse struct {
+	Detections []acquisition.Detection `json:"detections"`
+	Error      string                  `json:"error"`
+}
+
+// External runs an executable dropped in the modules.d folder, which lets
+// organizations add their own collectors without modifying androidqf.
+type External struct {
+	StoragePath string
+	ExePath     string
+	name        string
+}
+
+func NewExternal(exePath string) *External {
+	name := strings.TrimSuffix(filepath.Base(exePath), filepath.Ext(exePath))
+	return &External{ExePath: exePath, name: name}
+}
+
+func (e *External) Name() string {
+	return e.name
+}
+
+func (e *External) InitStorage(storagePath string) error {
+	e.StoragePath = storagePath
+	return nil
+}
+
+// externalModules returns the external modules found in the modules.d folder,
+// except those named like a built-in module.
+func externalModules(builtin []Module) []Module {
+	folder := filepath.Join(rt.GetExecutableDirectory(), ExternalModulesFolder)
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil
+	}
+
+	names := map[string]bool{}
+	for _, mod := range builtin {
+		names[mod.Name()] = true
+	}
+	found := []Module{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if runtime.GOOS == "windows" {
+			if !strings.EqualFold(filepath.Ext(entry.Name()), ".exe") {
+				continue
+			}
+		} else if info.Mode()&0o111 == 0 {
+			continue
+		}
+		mod := NewExternal(filepath.Join(folder, entry.Name()))
+		if names[mod.Name()] {
+			log.Warningf("Ignoring external module %s, named like another module", entry.Name())
+			continue
+		}
+		names[mod.Name()] = true
+		found = append(found, mod)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Name() < found[j].Name() })
+	return found
+}
+
+// adbServerSocket returns the socket of the adb server, which can be moved
+// to another port with ANDROID_ADB_SERVER_PORT.
+func adbServerSocket() string {
+	port := os.Getenv("ANDROID_ADB_SERVER_PORT")
+	if port == "" {
+		port = "5037"
+	}
+	return "tcp:localhost:" + port
+}
+
+func (e *External) Run(acq *acquisition.Acquisition, fast bool) error {
+	// The outputs of the module would not be encrypted.
+	if utils.OutputSinkEnabled() {
+		log.Warningf("Skipping external module %s when encrypting outputs as they are written", e.name)
+		return nil
+	}
+	// There is no adb server for the module to connect to.
+	if !adb.Client.UsesServer() {
+		log.Warningf("Skipping external module %s when connected to the device directly over USB", e.name)
+		return nil
+	}
+
+	log.Infof("Running external module %s...", e.name)
+
+	request := ExternalRequest{
+		AndroidQFVersion: utils.Version,
+		AcquisitionUUID:  acq.UUID,
+		AdbPath:          adb.Client.ExePath,
+		AdbServerSocket:  adbServerSocket(),
+		Serial:           adb.Client.Serial,
+		OutputDir:        e.StoragePath,
+		Fast:             fast,
+		HashOnly:         acq.HashOnly,
+	}
+	if acq.Device != nil {
+		request.APILevel = acq.Device.APILevel
+	}
+	input, err := json.Marshal(&request)
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the request: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(e.ExePath)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "ADB_SERVER_SOCKET="+request.AdbServerSocket)
+	if request.Serial != "" {
+		cmd.Env = append(cmd.Env, "ANDROID_SERIAL="+request.Serial)
+	}
+	runErr := cmd.Run()
+	if stderr.Len() > 0 {
+		log.Debugf("External module %s: %s", e.name, strings.TrimSpace(stderr.String()))
+	}
+
+	var response ExternalResponse
+	if stdout.Len() > 0 {
+		err = json.Unmarshal(stdout.Bytes(), &response)
+		if err != nil {
+			return fmt.Errorf("failed to parse the response of external module %s: %v", e.name, err)
+		}
+	}
+
+	for _, d := range response.Detections {
+		if d.Engine == "" {
+			d.Engine = acquisition.EngineHeuristic
+		}
+		if d.Severity == "" {
+			d.Severity = acquisition.SeverityMedium
+		}
+		if d.Source == "" {
+			d.Source = e.name
+		}
+		d.File = path.Join(e.name, d.File)
+		acq.AddDetection(d)
+	}
+
+	if runErr != nil {
+		return fmt.Errorf("external module %s failed: %v", e.name, runErr)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("external module %s failed: %s", e.name, response.Error)
+	}
+	return nil
+}
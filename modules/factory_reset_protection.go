@@ -0,0 +1,96 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"path/filepath"
+	"regexp"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var googleAccountRegexp = regexp.MustCompile(`Account \{name=([^,]+), type=com\.google\}`)
+
+type FactoryResetProtectionReport struct {
+	// Whether the device has a persistent data block storing FRP state.
+	Supported bool   `json:"supported"`
+	Partition string `json:"partition"`
+	// FRP is armed once a Google account is added to a provisioned device,
+	// and a factory reset then requires one of these accounts.
+	Active           bool     `json:"active"`
+	GoogleAccounts   []string `json:"google_accounts"`
+	Provisioned      bool     `json:"provisioned"`
+	SetupComplete    bool     `json:"setup_complete"`
+	LockScreen       string   `json:"lock_screen"`
+	OEMUnlockAllowed bool     `json:"oem_unlock_allowed"`
+}
+
+// FactoryResetProtection records whether factory reset protection is
+// active and the Google accounts anchoring it, which matters when the
+// device could be taken over or reset under coercion.
+type FactoryResetProtection struct {
+	StoragePath string
+}
+
+func NewFactoryResetProtection() *FactoryResetProtection {
+	return &FactoryResetProtection{}
+}
+
+func (f *FactoryResetProtection) Name() string {
+	return "factory_reset_protection"
+}
+
+func (f *FactoryResetProtection) InitStorage(storagePath string) error {
+	f.StoragePath = storagePath
+	return nil
+}
+
+// parseGoogleAccounts returns the Google accounts listed by `dumpsys account`.
+func parseGoogleAccounts(out string) []string {
+	accounts := []string{}
+	seen := map[string]bool{}
+	for _, match := range googleAccountRegexp.FindAllStringSubmatch(out, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			accounts = append(accounts, match[1])
+		}
+	}
+	return accounts
+}
+
+func (f *FactoryResetProtection) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting factory reset protection state...")
+
+	report := FactoryResetProtectionReport{}
+
+	report.Partition, _ = adb.Client.Shell("getprop", "ro.frp.pst")
+	report.Supported = report.Partition != ""
+
+	out, err := adb.Client.Shell("dumpsys", "account")
+	if err != nil {
+		log.Debugf("Failed to list accounts: %v", err)
+	}
+	report.GoogleAccounts = parseGoogleAccounts(out)
+
+	provisioned, _ := adb.Client.Shell("settings", "get", "global", "device_provisioned")
+	report.Provisioned = provisioned == "1"
+	setup, _ := adb.Client.Shell("settings", "get", "secure", "user_setup_complete")
+	report.SetupComplete = setup == "1"
+	report.LockScreen = readLockScreen(acq)
+	unlock, _ := adb.Client.Shell("getprop", "sys.oem_unlock_allowed")
+	report.OEMUnlockAllowed = unlock == "1"
+
+	report.Active = report.Supported && report.Provisioned && len(report.GoogleAccounts) > 0
+	if report.Active {
+		log.Infof("Factory reset protection is active with %d Google accounts", len(report.GoogleAccounts))
+	} else {
+		log.Info("Factory reset protection is not active")
+	}
+
+	return saveCommandOutputJson(filepath.Join(f.StoragePath, "factory_reset_protection.json"), &report)
+}
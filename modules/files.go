@@ -6,6 +6,7 @@ package modules
 
 import (
 	"path/filepath"
+	"sort"
 
 	"github.com/botherder/go-savetime/slice"
 	"github.com/mvt-project/androidqf/acquisition"
@@ -83,5 +84,11 @@ func (f *Files) Run(acq *acquisition.Acquisition, fast bool) error {
 		}
 	}
 
+	// The order of the listing depends on the order of the entries of each
+	// folder on disk.
+	sort.Slice(fileDetails, func(i, j int) bool {
+		return fileDetails[i].Path < fileDetails[j].Path
+	})
+
 	return saveCommandOutputJson(filepath.Join(f.StoragePath, "files.json"), &fileDetails)
 }
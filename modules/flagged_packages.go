@@ -0,0 +1,147 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// FlaggedPackages collects additional details on the packages flagged by
+// indicators of compromise or heuristics, and a static feature report of
+// their APKs. It runs after the indicators have been checked.
+type FlaggedPackages struct {
+	StoragePath string
+}
+
+func NewFlaggedPackages() *FlaggedPackages {
+	return &FlaggedPackages{}
+}
+
+func (f *FlaggedPackages) Name() string {
+	return "flagged_packages"
+}
+
+func (f *FlaggedPackages) RunsAfterAnalysis() bool {
+	return true
+}
+
+func (f *FlaggedPackages) Dependencies() []string {
+	return []string{"packages"}
+}
+
+func (f *FlaggedPackages) InitStorage(storagePath string) error {
+	f.StoragePath = storagePath
+	return nil
+}
+
+func (f *FlaggedPackages) Run(acq *acquisition.Acquisition, fast bool) error {
+	packages := acq.FlaggedPackages()
+	if len(packages) == 0 {
+		return nil
+	}
+
+	log.Infof("Collecting additional details on %d flagged packages...", len(packages))
+	apks := f.localAPKs(acq)
+
+	for _, pkg := range packages {
+		pkgPath := filepath.Join(f.StoragePath, pkg)
+		err := os.MkdirAll(utils.LongPath(pkgPath), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create folder for package %s: %v", pkg, err)
+		}
+
+		commands := []struct {
+			fileName string
+			command  []string
+		}{
+			{"dumpsys_package.txt", []string{"dumpsys", "package", pkg}},
+			{"appops.txt", []string{"cmd", "appops", "get", pkg}},
+			{"pm_dump.txt", []string{"pm", "dump", pkg}},
+			{"data_listing.txt", []string{
+				"ls", "-laR", "/data/data/" + pkg, acq.SdCard + "Android/data/" + pkg, "2>&1",
+			}},
+		}
+		for _, cmd := range commands {
+			// Listing errors (e.g. permission denied) are still saved.
+			out, err := adb.Client.Shell(cmd.command...)
+			if err != nil && out == "" {
+				log.Debugf("Failed to run `%v` for package %s: %v", cmd.command, pkg, err)
+				continue
+			}
+
+			err = saveCommandOutput(filepath.Join(pkgPath, cmd.fileName), out)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = f.analyzeAPKs(pkgPath, apks[pkg])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// localAPKs returns the paths of the copies of the APKs of each package made
+// by the packages module. They are not available when outputs are encrypted
+// as they are written.
+func (f *FlaggedPackages) localAPKs(acq *acquisition.Acquisition) map[string][]string {
+	apks := map[string][]string{}
+	if utils.OutputSinkEnabled() {
+		return apks
+	}
+	packages, err := loadPackages(acq)
+	if err != nil {
+		return apks
+	}
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			if file.LocalName != "" {
+				apks[pkg.Name] = append(apks[pkg.Name],
+					filepath.Join(acq.ModulePath("packages"), filepath.FromSlash(file.LocalName)))
+			}
+		}
+	}
+	return apks
+}
+
+// analyzeAPKs extracts the DEX files of the APKs of a flagged package, and
+// writes static_report.json with the URLs, strings of interest, embedded
+// certificates and packer indicators found in them.
+func (f *FlaggedPackages) analyzeAPKs(pkgPath string, apks []string) error {
+	if len(apks) == 0 {
+		return nil
+	}
+
+	reports := []*utils.StaticReport{}
+	for _, apk := range apks {
+		dexPath := filepath.Join(pkgPath, "dex", strings.TrimSuffix(filepath.Base(apk), ".apk"))
+		report, err := utils.AnalyzeAPK(apk, func(name string, data []byte) error {
+			err := os.MkdirAll(utils.LongPath(dexPath), 0o755)
+			if err != nil {
+				return err
+			}
+			return utils.WriteOutput(filepath.Join(dexPath, name), data)
+		})
+		if err != nil {
+			log.Debugf("Failed to analyze %s: %v", apk, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	return saveCommandOutputJson(filepath.Join(pkgPath, "static_report.json"), &reports)
+}
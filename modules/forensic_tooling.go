@@ -0,0 +1,262 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Names commonly found in the packages, processes and files of forensic
+// acquisition agents and instrumentation tools.
+var forensicToolingKeywords = []string{
+	"cellebrite", "oxygenforensic", "msab", "magnetforensics", "mobiledit",
+	"elcomsoft", "forensic", "andriller", "frida",
+}
+
+// AdbConnection is a TCP connection to adbd.
+type AdbConnection struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+}
+
+// AdbShell is a process started by adbd, other than androidqf's own
+// commands.
+type AdbShell struct {
+	PID  string `json:"pid"`
+	Name string `json:"name"`
+}
+
+type ForensicToolingReport struct {
+	HostDevices       []string        `json:"host_devices"`
+	AdbTCPPort        string          `json:"adb_tcp_port"`
+	WirelessDebugging bool            `json:"wireless_debugging"`
+	Connections       []AdbConnection `json:"connections"`
+	Shells            []AdbShell      `json:"shells"`
+	TmpFiles          []string        `json:"tmp_files"`
+	Packages          []string        `json:"packages"`
+	Processes         []string        `json:"processes"`
+	// Processes and files left by previous runs of androidqf, removed when
+	// the acquisition started.
+	StaleFiles *adb.StaleFiles `json:"stale_files"`
+}
+
+// ForensicTooling records other adb sessions and traces of other forensic
+// or monitoring tools present on the device at the time of acquisition.
+type ForensicTooling struct {
+	StoragePath string
+}
+
+func NewForensicTooling() *ForensicTooling {
+	return &ForensicTooling{}
+}
+
+func (f *ForensicTooling) Name() string {
+	return "forensic_tooling"
+}
+
+func (f *ForensicTooling) InitStorage(storagePath string) error {
+	f.StoragePath = storagePath
+	return nil
+}
+
+func matchesForensicTooling(name string) bool {
+	name = strings.ToLower(name)
+	for _, keyword := range forensicToolingKeywords {
+		if strings.Contains(name, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTCPEndpoint converts an address from /proc/net/tcp (hexadecimal IPv4
+// address in host byte order and port) to its usual notation. IPv6
+// addresses are kept as they are.
+func parseTCPEndpoint(endpoint string) (string, int) {
+	addr, portHex, ok := strings.Cut(endpoint, ":")
+	if !ok {
+		return endpoint, 0
+	}
+	port, _ := strconv.ParseInt(portHex, 16, 32)
+	if len(addr) != 8 {
+		return fmt.Sprintf("%s:%d", addr, port), int(port)
+	}
+	ip, err := strconv.ParseUint(addr, 16, 32)
+	if err != nil {
+		return endpoint, int(port)
+	}
+	return fmt.Sprintf("%d.%d.%d.%d:%d", ip&0xff, ip>>8&0xff, ip>>16&0xff, ip>>24, port), int(port)
+}
+
+// parseAdbConnections returns the established TCP connections to the given
+// local port from the content of /proc/net/tcp and /proc/net/tcp6.
+func parseAdbConnections(out string, port int) []AdbConnection {
+	connections := []AdbConnection{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		// The state of established connections is 01.
+		if len(fields) < 4 || fields[3] != "01" {
+			continue
+		}
+		local, localPort := parseTCPEndpoint(fields[1])
+		if localPort != port {
+			continue
+		}
+		remote, _ := parseTCPEndpoint(fields[2])
+		connections = append(connections, AdbConnection{Local: local, Remote: remote})
+	}
+	return connections
+}
+
+// parseAdbShells returns the processes whose parent is adbd, from the output
+// of `ps -A -o PID,PPID,NAME`.
+func parseAdbShells(out string) []AdbShell {
+	type process struct{ pid, ppid, name string }
+	processes := []process{}
+	byPID := map[string]process{}
+	adbd := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] == "PID" {
+			continue
+		}
+		p := process{pid: fields[0], ppid: fields[1], name: strings.Join(fields[2:], " ")}
+		if filepath.Base(p.name) == "adbd" {
+			adbd[p.pid] = true
+		}
+		processes = append(processes, p)
+		byPID[p.pid] = p
+	}
+
+	// The ps command listing the processes, and the shell running it, are
+	// started by androidqf.
+	ours := map[string]bool{}
+	for _, p := range processes {
+		if p.name != "ps" {
+			continue
+		}
+		ours[p.pid] = true
+		if parent, ok := byPID[p.ppid]; ok && adbd[parent.ppid] {
+			ours[parent.pid] = true
+		}
+	}
+
+	shells := []AdbShell{}
+	for _, p := range processes {
+		if !adbd[p.ppid] || ours[p.pid] {
+			continue
+		}
+		shells = append(shells, AdbShell{PID: p.pid, Name: p.name})
+	}
+	return shells
+}
+
+func (f *ForensicTooling) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Checking for other adb sessions and forensic tools...")
+
+	report := ForensicToolingReport{
+		HostDevices: []string{},
+		TmpFiles:    []string{},
+		Packages:    []string{},
+		Processes:   []string{},
+		StaleFiles:  acq.StaleFiles,
+	}
+
+	devices, err := adb.Client.Devices()
+	if err != nil {
+		log.Debugf("Failed to list devices connected to the host: %v", err)
+	}
+	report.HostDevices = append(report.HostDevices, devices...)
+
+	report.AdbTCPPort, _ = adb.Client.Shell("getprop", "service.adb.tcp.port")
+	wireless, _ := adb.Client.Shell("settings", "get", "global", "adb_wifi_enabled")
+	report.WirelessDebugging = wireless == "1"
+
+	port := 5555
+	if p, err := strconv.Atoi(report.AdbTCPPort); err == nil && p > 0 {
+		port = p
+	}
+	tcp, _ := adb.Client.Shell("cat", "/proc/net/tcp", "/proc/net/tcp6")
+	report.Connections = parseAdbConnections(tcp, port)
+
+	ps, err := adb.Client.Shell("ps", "-A", "-o", "PID,PPID,NAME")
+	if err != nil {
+		log.Debugf("Failed to list processes: %v", err)
+	}
+	report.Shells = parseAdbShells(ps)
+	for _, line := range strings.Split(ps, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && matchesForensicTooling(fields[2]) {
+			report.Processes = append(report.Processes, strings.Join(fields[2:], " "))
+		}
+	}
+
+	files, _ := adb.Client.Shell("ls", "-a", "/data/local/tmp/")
+	for _, name := range strings.Split(files, "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+		ours := false
+		for _, created := range adb.DeviceFileNames {
+			if name == created {
+				ours = true
+			}
+		}
+		if !ours {
+			report.TmpFiles = append(report.TmpFiles, "/data/local/tmp/"+name)
+		}
+	}
+
+	packages, _ := adb.Client.Shell("pm", "list", "packages")
+	for _, line := range strings.Split(packages, "\n") {
+		pkg := strings.TrimPrefix(strings.TrimSpace(line), "package:")
+		if pkg != "" && matchesForensicTooling(pkg) {
+			report.Packages = append(report.Packages, pkg)
+		}
+	}
+
+	findings := []string{}
+	if len(report.HostDevices) > 1 {
+		log.Infof("%d devices are connected to this computer", len(report.HostDevices))
+	}
+	// When androidqf itself is connected over the network, one of the
+	// connections is its own, which cannot be told apart from the others.
+	ownConnection := strings.Contains(adb.Client.Serial, ":")
+	if len(report.Connections) > 1 || (len(report.Connections) == 1 && !ownConnection) {
+		for _, conn := range report.Connections {
+			findings = append(findings, fmt.Sprintf("adb connection from %s", conn.Remote))
+		}
+	}
+	for _, shell := range report.Shells {
+		findings = append(findings, fmt.Sprintf("adb shell process %s (%s)", shell.Name, shell.PID))
+	}
+	for _, pkg := range report.Packages {
+		findings = append(findings, fmt.Sprintf("forensic tool package %s", pkg))
+	}
+	for _, process := range report.Processes {
+		findings = append(findings, fmt.Sprintf("forensic tool process %s", process))
+	}
+	for _, finding := range findings {
+		log.Warningf("Found %s", finding)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    fmt.Sprintf("Found %s", finding),
+			Source:   f.Name(),
+			File:     f.Name() + "/forensic_tooling.json",
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(f.StoragePath, "forensic_tooling.json"), &report)
+}
@@ -9,7 +9,6 @@ import (
 	"path/filepath"
 
 	"github.com/mvt-project/androidqf/acquisition"
-	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
 )
 
@@ -33,10 +32,10 @@ func (g *GetProp) InitStorage(storagePath string) error {
 func (g *GetProp) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting device properties...")
 
-	out, err := adb.Client.Shell("getprop")
+	_, err := saveShellOutput(filepath.Join(g.StoragePath, "getprop.txt"), "getprop")
 	if err != nil {
 		return fmt.Errorf("failed to run `adb shell getprop`: %v", err)
 	}
 
-	return saveCommandOutput(filepath.Join(g.StoragePath, "getprop.txt"), out)
+	return nil
 }
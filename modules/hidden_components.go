@@ -0,0 +1,233 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var (
+	// User 0: ceDataInode=123 installed=true hidden=false ... enabled=0
+	packageUserRegexp = regexp.MustCompile(`^\s+User (\d+): .*\binstalled=(\w+)`)
+	hiddenRegexp      = regexp.MustCompile(`\bhidden=(\w+)`)
+	enabledRegexp     = regexp.MustCompile(`\benabled=(\d+)`)
+)
+
+// Values of the enabled state of a package, from PackageManager.
+var enabledStates = map[int]string{
+	0: "default",
+	1: "enabled",
+	2: "disabled",
+	3: "disabled_user",
+	4: "disabled_until_used",
+}
+
+type HiddenComponentsPackage struct {
+	Package string `json:"package"`
+	User    int    `json:"user"`
+	System  bool   `json:"system"`
+	// Hidden with `pm hide` or by a device admin.
+	Hidden bool `json:"hidden"`
+	// Enabled state of the whole package.
+	State string `json:"state"`
+	// Components disabled or enabled with `pm disable`/`pm enable` or
+	// setComponentEnabledSetting, overriding the manifest.
+	DisabledComponents []string `json:"disabled_components"`
+	EnabledComponents  []string `json:"enabled_components"`
+	// Whether the package has an activity shown in the app drawer.
+	LauncherActivity bool `json:"launcher_activity"`
+}
+
+// HiddenComponents reports packages hidden or disabled, and components
+// toggled at runtime, which stalkerware uses to disappear from the app
+// drawer.
+type HiddenComponents struct {
+	StoragePath string
+}
+
+func NewHiddenComponents() *HiddenComponents {
+	return &HiddenComponents{}
+}
+
+func (h *HiddenComponents) Name() string {
+	return "hidden_components"
+}
+
+func (h *HiddenComponents) InitStorage(storagePath string) error {
+	h.StoragePath = storagePath
+	return nil
+}
+
+// parsePackageComponents extracts the hidden and enabled state of each
+// package, and the components toggled at runtime, from the user sections of
+// `dumpsys package packages`.
+func parsePackageComponents(out string) []HiddenComponentsPackage {
+	packages := []HiddenComponentsPackage{}
+	inPackages := false
+	pkg := ""
+	var current *HiddenComponentsPackage
+	list := ""
+	for _, line := range strings.Split(out, "\n") {
+		// Other sections, such as hidden system packages, list packages
+		// again.
+		if line != "" && line[0] != ' ' {
+			inPackages = strings.HasPrefix(line, "Packages:")
+			pkg = ""
+			current = nil
+			continue
+		}
+		if !inPackages {
+			continue
+		}
+		if match := packageSectionRegexp.FindStringSubmatch(line); match != nil {
+			pkg = match[1]
+			current = nil
+			list = ""
+			continue
+		}
+		if pkg == "" {
+			continue
+		}
+		if match := packageUserRegexp.FindStringSubmatch(line); match != nil {
+			current = nil
+			list = ""
+			if match[2] != "true" {
+				continue
+			}
+			user, _ := strconv.Atoi(match[1])
+			packages = append(packages, HiddenComponentsPackage{
+				Package:            pkg,
+				User:               user,
+				State:              enabledStates[0],
+				DisabledComponents: []string{},
+				EnabledComponents:  []string{},
+			})
+			current = &packages[len(packages)-1]
+			if hidden := hiddenRegexp.FindStringSubmatch(line); hidden != nil {
+				current.Hidden = hidden[1] == "true"
+			}
+			if enabled := enabledRegexp.FindStringSubmatch(line); enabled != nil {
+				value, _ := strconv.Atoi(enabled[1])
+				if state, ok := enabledStates[value]; ok {
+					current.State = state
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch trimmed {
+		case "disabledComponents:", "enabledComponents:":
+			list = trimmed
+			continue
+		}
+		if list == "" || trimmed == "" {
+			continue
+		}
+		// Component names are indented below the list, anything else ends it.
+		if strings.Contains(trimmed, " ") || strings.HasSuffix(trimmed, ":") || strings.Contains(trimmed, "=") {
+			list = ""
+			continue
+		}
+		if list == "disabledComponents:" {
+			current.DisabledComponents = append(current.DisabledComponents, trimmed)
+		} else {
+			current.EnabledComponents = append(current.EnabledComponents, trimmed)
+		}
+	}
+	return packages
+}
+
+func (h *HiddenComponents) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting hidden packages and disabled components...")
+
+	out, err := adb.Client.Shell("dumpsys", "package", "packages")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys package packages`: %v", err)
+	}
+	packages := parsePackageComponents(out)
+
+	launchers := map[string]bool{}
+	launchersKnown := false
+	if acq.Capabilities.Has("cmd") {
+		out, err = adb.Client.Shell("cmd", "package", "query-activities", "--brief",
+			"-a", "android.intent.action.MAIN", "-c", "android.intent.category.LAUNCHER")
+		if err != nil {
+			log.Debugf("Failed to list launcher activities: %v", err)
+		} else {
+			launchersKnown = true
+			for _, pkg := range parseActivityPackages(out) {
+				launchers[pkg] = true
+			}
+		}
+	}
+
+	system := systemPackages()
+	report := []HiddenComponentsPackage{}
+	for _, pkg := range packages {
+		pkg.System = system[pkg.Package]
+		pkg.LauncherActivity = launchers[pkg.Package]
+		if !pkg.Hidden && pkg.State == enabledStates[0] && len(pkg.DisabledComponents) == 0 &&
+			len(pkg.EnabledComponents) == 0 {
+			continue
+		}
+		report = append(report, pkg)
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].Package < report[j].Package
+	})
+
+	for _, pkg := range report {
+		if pkg.System || pkg.User != 0 {
+			continue
+		}
+
+		title := ""
+		severity := ""
+		switch {
+		case pkg.Hidden:
+			title = fmt.Sprintf("Non-system package is hidden from the user: %s", pkg.Package)
+			severity = acquisition.SeverityHigh
+		case launchersKnown && !pkg.LauncherActivity && len(pkg.DisabledComponents) > 0 &&
+			pkg.State != "disabled" && pkg.State != "disabled_user":
+			// An enabled app which disabled some of its own components and
+			// has no icon in the app drawer most likely hid its icon.
+			title = fmt.Sprintf("Non-system package disabled components and has no icon in the app drawer: %s",
+				pkg.Package)
+			severity = acquisition.SeverityHigh
+		case len(pkg.EnabledComponents) > 0 && len(pkg.DisabledComponents) > 0:
+			title = fmt.Sprintf("Non-system package toggles its components at runtime: %s", pkg.Package)
+			severity = acquisition.SeverityLow
+		default:
+			continue
+		}
+
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: severity,
+			Title:    title,
+			Source:   h.Name(),
+			File:     h.Name() + "/hidden_components.json",
+			Value:    strings.Join(pkg.DisabledComponents, ","),
+			Package:  pkg.Package,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(h.StoragePath, "hidden_components.json"), &report)
+}
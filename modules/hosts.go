@@ -0,0 +1,168 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type HostsEntry struct {
+	Address   string   `json:"address"`
+	Hostnames []string `json:"hostnames"`
+}
+
+type HostsFile struct {
+	Path      string       `json:"path"`
+	LocalPath string       `json:"local_path,omitempty"`
+	SHA256    string       `json:"sha256"`
+	Entries   []HostsEntry `json:"entries"`
+	Modified  bool         `json:"modified"`
+}
+
+type Hosts struct {
+	StoragePath string
+	HostsPath   string
+}
+
+func NewHosts() *Hosts {
+	return &Hosts{}
+}
+
+func (h *Hosts) Name() string {
+	return "hosts"
+}
+
+func (h *Hosts) InitStorage(storagePath string) error {
+	h.StoragePath = storagePath
+	h.HostsPath = filepath.Join(storagePath, "hosts")
+	err := os.Mkdir(h.HostsPath, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create hosts folder: %v", err)
+	}
+
+	return nil
+}
+
+// isDefaultHostsEntry returns true for the entries shipped with stock Android.
+func isDefaultHostsEntry(entry HostsEntry) bool {
+	for _, hostname := range entry.Hostnames {
+		if hostname != "localhost" && hostname != "ip6-localhost" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHosts(content string) []HostsEntry {
+	entries := []HostsEntry{}
+	for _, line := range strings.Split(content, "\n") {
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		entries = append(entries, HostsEntry{Address: fields[0], Hostnames: fields[1:]})
+	}
+	return entries
+}
+
+// collectHostsFile copies and parses a hosts file. In hash-only mode the file
+// is hashed on the device, and only the parsed entries are kept. It returns
+// nil if the file does not exist.
+func (h *Hosts) collectHostsFile(acq *acquisition.Acquisition, hostsFile string) (*HostsFile, error) {
+	if acq.HashOnly {
+		sha256, ok := hashRemoteFile(hostsFile)
+		if !ok {
+			return nil, nil
+		}
+		content, _ := adb.Client.Shell("cat", hostsFile)
+		return &HostsFile{
+			Path:    hostsFile,
+			SHA256:  sha256,
+			Entries: parseHosts(content),
+		}, nil
+	}
+
+	localPath := filepath.Join(h.HostsPath, strings.ReplaceAll(strings.TrimPrefix(hostsFile, "/"), "/", "_"))
+	out, err := adb.Client.Pull(hostsFile, localPath)
+	if err != nil {
+		log.Debugf("Failed to pull hosts file %s: %s", hostsFile, strings.TrimSpace(out))
+		return nil, nil
+	}
+
+	content, err := utils.ReadOutput(localPath)
+	if err != nil {
+		return nil, err
+	}
+	sha256, err := utils.OutputSHA256(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HostsFile{
+		Path:      hostsFile,
+		LocalPath: filepath.Join("hosts", filepath.Base(localPath)),
+		SHA256:    sha256,
+		Entries:   parseHosts(string(content)),
+	}, nil
+}
+
+func (h *Hosts) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting hosts files...")
+
+	hostsFiles := []string{"/system/etc/hosts", "/etc/hosts", "/vendor/etc/hosts"}
+	// Hosts files overlaid by Magisk modules, e.g. the systemless hosts module.
+	overlays, _ := adb.Client.Shell("ls", "/data/adb/modules/*/system/etc/hosts", "2>", "/dev/null")
+	for _, overlay := range strings.Split(overlays, "\n") {
+		overlay = strings.TrimSpace(overlay)
+		if overlay != "" && !strings.Contains(overlay, "No such file") {
+			hostsFiles = append(hostsFiles, overlay)
+		}
+	}
+
+	results := []HostsFile{}
+	for _, hostsFile := range hostsFiles {
+		result, err := h.collectHostsFile(acq, hostsFile)
+		if err != nil {
+			return err
+		} else if result == nil {
+			continue
+		}
+		custom := 0
+		for _, entry := range result.Entries {
+			if !isDefaultHostsEntry(entry) {
+				custom++
+			}
+		}
+		result.Modified = custom > 0
+
+		if result.Modified {
+			log.Warningf("The hosts file %s contains %d custom entries", hostsFile, custom)
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: acquisition.SeverityMedium,
+				Title:    fmt.Sprintf("Hosts file %s was modified", hostsFile),
+				Source:   h.Name(),
+				File:     h.Name() + "/hosts.json",
+				Value:    result.SHA256,
+			})
+		}
+
+		results = append(results, *result)
+	}
+
+	return saveCommandOutputJson(filepath.Join(h.StoragePath, "hosts.json"), &results)
+}
@@ -0,0 +1,120 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type InstallUnknownAppsGrant struct {
+	Package string `json:"package"`
+	System  bool   `json:"system"`
+	// Packages installed by this package.
+	Installed []string `json:"installed"`
+}
+
+// InstallUnknownApps collects the packages allowed to install other apps
+// ("install unknown apps"), as droppers are a common first stage of
+// targeted infections.
+type InstallUnknownApps struct {
+	StoragePath string
+}
+
+func NewInstallUnknownApps() *InstallUnknownApps {
+	return &InstallUnknownApps{}
+}
+
+func (i *InstallUnknownApps) Name() string {
+	return "install_unknown_apps"
+}
+
+func (i *InstallUnknownApps) InitStorage(storagePath string) error {
+	i.StoragePath = storagePath
+	return nil
+}
+
+// packageInstallers maps each installer to the packages it installed, from
+// the output of `pm list packages -i`.
+func packageInstallers() map[string][]string {
+	installed := map[string][]string{}
+	out, err := adb.Client.Shell("pm", "list", "packages", "-i")
+	if err != nil {
+		log.Debugf("Impossible to get installers of packages: %v", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pkg := strings.TrimPrefix(fields[0], "package:")
+		installer := strings.TrimPrefix(fields[1], "installer=")
+		if installer != "" && installer != "null" {
+			installed[installer] = append(installed[installer], pkg)
+		}
+	}
+	return installed
+}
+
+func (i *InstallUnknownApps) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting packages allowed to install unknown apps...")
+
+	if !acq.Capabilities.AtLeast(26) {
+		log.Info("The permission to install unknown apps is per app since Android 8, skipping")
+		return nil
+	}
+	if !acq.Capabilities.Has("cmd") {
+		log.Info("The device does not support querying app ops, skipping install unknown apps")
+		return nil
+	}
+
+	// Unlike other app ops, the default mode of REQUEST_INSTALL_PACKAGES
+	// denies the installation, so only explicit grants are relevant.
+	packages, err := appOpsPackages("REQUEST_INSTALL_PACKAGES", "allow")
+	if err != nil {
+		return fmt.Errorf("failed to query REQUEST_INSTALL_PACKAGES app op: %v", err)
+	}
+
+	grants := []InstallUnknownAppsGrant{}
+	system := systemPackages()
+	installers := packageInstallers()
+	for _, pkg := range packages {
+		grant := InstallUnknownAppsGrant{
+			Package:   pkg,
+			System:    system[pkg],
+			Installed: installers[pkg],
+		}
+		if grant.Installed == nil {
+			grant.Installed = []string{}
+		}
+		grants = append(grants, grant)
+
+		if grant.System {
+			continue
+		}
+		title := fmt.Sprintf("Non-system package can install unknown apps: %s", pkg)
+		if len(grant.Installed) > 0 {
+			title = fmt.Sprintf("%s (installed %s)", title, strings.Join(grant.Installed, ", "))
+		}
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    title,
+			Source:   i.Name(),
+			File:     i.Name() + "/install_unknown_apps.json",
+			Value:    pkg,
+			Package:  pkg,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(i.StoragePath, "install_unknown_apps.json"), &grants)
+}
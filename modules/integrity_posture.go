@@ -0,0 +1,192 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Properties describing verified boot and the build, which determine the
+// verdicts of attestation and Play Integrity checks.
+var integrityProperties = []string{
+	"ro.boot.verifiedbootstate",
+	"ro.boot.flash.locked",
+	"ro.boot.vbmeta.device_state",
+	"ro.boot.veritymode",
+	"ro.boot.vbmeta.digest",
+	"ro.build.type",
+	"ro.build.tags",
+	"ro.debuggable",
+	"ro.secure",
+	"ro.product.first_api_level",
+	"ro.hardware.keystore",
+	"ro.hardware.gatekeeper",
+	"ro.oem_unlock_supported",
+	"sys.oem_unlock_allowed",
+}
+
+// Features declaring hardware-backed key attestation.
+var attestationFeatures = []string{
+	"android.hardware.hardware_keystore",
+	"android.hardware.strongbox_keystore",
+	"android.hardware.keystore.app_attest_key",
+	"android.software.device_id_attestation",
+}
+
+var versionNameRegexp = regexp.MustCompile(`\bversionName=(\S+)`)
+
+type GMSPackage struct {
+	Package   string `json:"package"`
+	Installed bool   `json:"installed"`
+	Enabled   bool   `json:"enabled"`
+	Version   string `json:"version"`
+}
+
+type IntegrityPostureReport struct {
+	Properties map[string]string `json:"properties"`
+	// Attestation features declared by the device, with their version.
+	Features map[string]string `json:"features"`
+	GMS      []GMSPackage      `json:"gms"`
+	// Whether the DroidGuard process, which runs Play Integrity and
+	// SafetyNet checks, was running.
+	DroidGuardRunning bool `json:"droidguard_running"`
+	// Reasons why the results of integrity checks on this device cannot be
+	// trusted. Empty if none was found.
+	Issues      []string `json:"issues"`
+	Trustworthy bool     `json:"trustworthy"`
+}
+
+// IntegrityPosture records the verified boot state, the key attestation
+// support and the state of Google Play services, so that later analysis
+// knows whether integrity checks on the device can be trusted.
+type IntegrityPosture struct {
+	StoragePath string
+}
+
+func NewIntegrityPosture() *IntegrityPosture {
+	return &IntegrityPosture{}
+}
+
+func (i *IntegrityPosture) Name() string {
+	return "integrity_posture"
+}
+
+func (i *IntegrityPosture) InitStorage(storagePath string) error {
+	i.StoragePath = storagePath
+	return nil
+}
+
+// parseFeatures returns the requested features, with their version if any,
+// from the output of `pm list features`.
+func parseFeatures(out string, wanted []string) map[string]string {
+	features := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		feature := strings.TrimPrefix(strings.TrimSpace(line), "feature:")
+		name, version, _ := strings.Cut(feature, "=")
+		for _, w := range wanted {
+			if name == w {
+				features[name] = version
+			}
+		}
+	}
+	return features
+}
+
+func getGMSPackage(pkg string, disabled map[string]bool) GMSPackage {
+	gms := GMSPackage{Package: pkg}
+	out, err := adb.Client.Shell("dumpsys", "package", pkg)
+	if err != nil || !strings.Contains(out, "Package ["+pkg+"]") {
+		return gms
+	}
+	gms.Installed = true
+	gms.Enabled = !disabled[pkg]
+	if match := versionNameRegexp.FindStringSubmatch(out); match != nil {
+		gms.Version = match[1]
+	}
+	return gms
+}
+
+// integrityIssues returns the reasons why attestation and integrity verdicts
+// of the device are unreliable.
+func integrityIssues(acq *acquisition.Acquisition, report *IntegrityPostureReport) []string {
+	issues := []string{}
+	props := report.Properties
+	if state := props["ro.boot.verifiedbootstate"]; state != "" && state != "green" {
+		issues = append(issues, fmt.Sprintf("verified boot state is %s", state))
+	}
+	if props["ro.boot.flash.locked"] == "0" || props["ro.boot.vbmeta.device_state"] == "unlocked" {
+		issues = append(issues, "the bootloader is unlocked")
+	}
+	if props["ro.build.tags"] != "" && props["ro.build.tags"] != "release-keys" {
+		issues = append(issues, fmt.Sprintf("the build is signed with %s", props["ro.build.tags"]))
+	}
+	if props["ro.debuggable"] == "1" {
+		issues = append(issues, "the build is debuggable")
+	}
+	if acq.Capabilities != nil && acq.Capabilities.Root {
+		issues = append(issues, "the adb shell has root privileges")
+	}
+	if acq.Emulator != nil && acq.Emulator.Detected {
+		issues = append(issues, "the device is an emulator")
+	}
+	if _, ok := report.Features["android.hardware.hardware_keystore"]; !ok && acq.Capabilities.AtLeast(31) {
+		issues = append(issues, "no hardware-backed keystore is declared")
+	}
+	for _, gms := range report.GMS {
+		if gms.Package == "com.google.android.gms" && (!gms.Installed || !gms.Enabled) {
+			issues = append(issues, "Google Play services are not available, Play Integrity checks cannot run")
+		}
+	}
+	return issues
+}
+
+func (i *IntegrityPosture) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting device attestation and integrity posture...")
+
+	report := IntegrityPostureReport{
+		Properties: map[string]string{},
+		GMS:        []GMSPackage{},
+	}
+
+	for _, prop := range integrityProperties {
+		value, err := adb.Client.Shell("getprop", prop)
+		if err == nil && value != "" {
+			report.Properties[prop] = value
+		}
+	}
+
+	features, err := adb.Client.Shell("pm", "list", "features")
+	if err != nil {
+		log.Debugf("Failed to list device features: %v", err)
+	}
+	report.Features = parseFeatures(features, attestationFeatures)
+
+	disabled := map[string]bool{}
+	out, _ := adb.Client.Shell("pm", "list", "packages", "-d")
+	for _, line := range strings.Split(out, "\n") {
+		disabled[strings.TrimPrefix(strings.TrimSpace(line), "package:")] = true
+	}
+	for _, pkg := range []string{"com.google.android.gms", "com.android.vending"} {
+		report.GMS = append(report.GMS, getGMSPackage(pkg, disabled))
+	}
+	pid, _ := adb.Client.Shell("pidof", "com.google.android.gms.unstable")
+	report.DroidGuardRunning = pid != ""
+
+	report.Issues = integrityIssues(acq, &report)
+	report.Trustworthy = len(report.Issues) == 0
+	for _, issue := range report.Issues {
+		log.Infof("Integrity checks on this device cannot be trusted: %s", issue)
+	}
+
+	return saveCommandOutputJson(filepath.Join(i.StoragePath, "integrity_posture.json"), &report)
+}
@@ -0,0 +1,223 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var (
+	// CredentialType: PIN
+	credentialTypeRegexp = regexp.MustCompile(`^\s*CredentialType:\s*(.+)$`)
+	// User "Owner" (id=0, flags=0x13) (current): trusted=0, trustManaged=1, deviceLocked=1
+	trustUserRegexp = regexp.MustCompile(`\(id=(\d+),.*trusted=(\d), trustManaged=(\d)`)
+)
+
+type StorageEncryption struct {
+	State string `json:"state"`
+	// "file" for file-based encryption, "block" for full-disk encryption.
+	Type           string `json:"type"`
+	FilenamesMode  string `json:"filenames_mode"`
+	ContentsMode   string `json:"contents_mode"`
+	MetadataActive bool   `json:"metadata_encryption"`
+	// Whether the credential encrypted storage of the primary user is
+	// unlocked, which requires the user to have unlocked the device since
+	// it booted.
+	UserStorageUnlocked bool `json:"user_storage_unlocked"`
+}
+
+type TrustAgent struct {
+	Component string `json:"component"`
+	System    bool   `json:"system"`
+	// Whether the agent currently keeps the device unlocked.
+	ManagingTrust bool `json:"managing_trust"`
+}
+
+type LockPostureReport struct {
+	Encryption StorageEncryption `json:"encryption"`
+	// Type of the credential of the primary user (none, pattern, PIN or
+	// password), never the credential itself.
+	CredentialType       string       `json:"credential_type"`
+	LockAfterTimeout     string       `json:"lock_after_timeout_ms"`
+	ScreenOffTimeout     string       `json:"screen_off_timeout_ms"`
+	ShowNotifications    string       `json:"lock_screen_show_notifications"`
+	PrivateNotifications string       `json:"lock_screen_private_notifications"`
+	TrustAgents          []TrustAgent `json:"trust_agents"`
+	Trusted              bool         `json:"trusted"`
+	TrustManaged         bool         `json:"trust_managed"`
+}
+
+// LockPosture records the storage encryption state, the type of lock screen
+// and the Smart Lock trust agents, as weakened lock configurations often
+// accompany surveillance by someone with physical access to the device.
+type LockPosture struct {
+	StoragePath string
+}
+
+func NewLockPosture() *LockPosture {
+	return &LockPosture{}
+}
+
+func (l *LockPosture) Name() string {
+	return "lock_posture"
+}
+
+func (l *LockPosture) InitStorage(storagePath string) error {
+	l.StoragePath = storagePath
+	return nil
+}
+
+func getProp(name string) string {
+	value, _ := adb.Client.Shell("getprop", name)
+	return strings.TrimSpace(value)
+}
+
+// parseCredentialType extracts the type of credential of the primary user
+// from the output of `dumpsys lock_settings`.
+func parseCredentialType(out string) string {
+	inUser := false
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "User ") {
+			inUser = trimmed == "User 0"
+			continue
+		}
+		if !inUser {
+			continue
+		}
+		if match := credentialTypeRegexp.FindStringSubmatch(line); match != nil {
+			return strings.ToLower(strings.TrimSpace(match[1]))
+		}
+	}
+	return ""
+}
+
+// parseTrustState extracts whether trust agents keep the primary user
+// unlocked, and the agents doing so, from the output of `dumpsys trust`.
+func parseTrustState(out string) (bool, bool, map[string]bool) {
+	trusted, managed := false, false
+	managing := map[string]bool{}
+	inUser := false
+	agent := ""
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := trustUserRegexp.FindStringSubmatch(trimmed); match != nil {
+			inUser = match[1] == "0"
+			if inUser {
+				trusted = match[2] == "1"
+				managed = match[3] == "1"
+			}
+			continue
+		}
+		if !inUser {
+			continue
+		}
+		if strings.Contains(trimmed, "/") && !strings.Contains(trimmed, "=") {
+			agent = trimmed
+		} else if agent != "" && strings.Contains(trimmed, "managingTrust=1") {
+			managing[agent] = true
+		}
+	}
+	return trusted, managed, managing
+}
+
+func (l *LockPosture) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting storage encryption and lock screen posture...")
+
+	report := LockPostureReport{
+		Encryption: StorageEncryption{
+			State:               getProp("ro.crypto.state"),
+			Type:                getProp("ro.crypto.type"),
+			FilenamesMode:       getProp("ro.crypto.volume.filenames_mode"),
+			ContentsMode:        getProp("ro.crypto.volume.contents_mode"),
+			MetadataActive:      getProp("ro.crypto.metadata.enabled") == "true",
+			UserStorageUnlocked: getProp("sys.user.0.ce_available") == "true",
+		},
+		LockAfterTimeout:     readSetting("secure", "lock_screen_lock_after_timeout")(acq),
+		ScreenOffTimeout:     readSetting("system", "screen_off_timeout")(acq),
+		ShowNotifications:    readSetting("secure", "lock_screen_show_notifications")(acq),
+		PrivateNotifications: readSetting("secure", "lock_screen_allow_private_notifications")(acq),
+		TrustAgents:          []TrustAgent{},
+	}
+
+	out, err := adb.Client.Shell("dumpsys", "lock_settings")
+	if err == nil {
+		report.CredentialType = parseCredentialType(out)
+	}
+	if report.CredentialType == "" && acq.Capabilities.Has("cmd") {
+		report.CredentialType = readLockScreen(acq)
+	}
+
+	out, err = adb.Client.Shell("dumpsys", "trust")
+	if err != nil {
+		log.Debugf("Failed to run `dumpsys trust`: %v", err)
+	} else {
+		err = saveCommandOutput(filepath.Join(l.StoragePath, "trust.txt"), out)
+		if err != nil {
+			return err
+		}
+	}
+	var managing map[string]bool
+	report.Trusted, report.TrustManaged, managing = parseTrustState(out)
+
+	system := systemPackages()
+	enabled := readSetting("secure", "enabled_trust_agents")(acq)
+	for _, component := range strings.Split(enabled, ":") {
+		component = strings.TrimSpace(component)
+		if component == "" {
+			continue
+		}
+		pkg := strings.SplitN(component, "/", 2)[0]
+		report.TrustAgents = append(report.TrustAgents, TrustAgent{
+			Component:     component,
+			System:        system[pkg],
+			ManagingTrust: managing[component],
+		})
+	}
+
+	if report.Encryption.State != "" && report.Encryption.State != "encrypted" {
+		log.Warning("The storage of the device is not encrypted")
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityHigh,
+			Title:    "The storage of the device is not encrypted",
+			Source:   l.Name(),
+			File:     l.Name() + "/lock_posture.json",
+			Value:    report.Encryption.State,
+		})
+	}
+	for _, agent := range report.TrustAgents {
+		if !agent.ManagingTrust && agent.System {
+			continue
+		}
+		pkg := strings.SplitN(agent.Component, "/", 2)[0]
+		severity := acquisition.SeverityLow
+		title := fmt.Sprintf("Trust agent keeps the device unlocked: %s", agent.Component)
+		if !agent.System {
+			severity = acquisition.SeverityMedium
+			title = fmt.Sprintf("Non-system trust agent is enabled: %s", agent.Component)
+		}
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: severity,
+			Title:    title,
+			Source:   l.Name(),
+			File:     l.Name() + "/lock_posture.json",
+			Value:    agent.Component,
+			Package:  pkg,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(l.StoragePath, "lock_posture.json"), &report)
+}
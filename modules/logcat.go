@@ -5,14 +5,39 @@
 package modules
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
+// LogcatEntry is a single line of logcat in the default threadtime format.
+type LogcatEntry struct {
+	Timestamp string `json:"timestamp"`
+	// The timestamp converted from device local time to UTC.
+	TimestampUTC string `json:"timestamp_utc"`
+	PID          int    `json:"pid"`
+	TID          int    `json:"tid"`
+	Priority     string `json:"priority"`
+	Tag          string `json:"tag"`
+	Message      string `json:"message"`
+	Buffer       string `json:"buffer"`
+}
+
+var logcatLineRegexp = regexp.MustCompile(
+	`^(\d{2})-(\d{2}) (\d{2}:\d{2}:\d{2}\.\d{3})\s+(\d+)\s+(\d+)\s+([VDIWEFS])\s+(.*?)\s*: (.*)$`)
+
 type Logcat struct {
 	StoragePath string
 }
@@ -30,26 +55,175 @@ func (l *Logcat) InitStorage(storagePath string) error {
 	return nil
 }
 
+// parseLogcat converts the output of logcat into structured entries, which
+// are passed to emit one at a time. As the threadtime format has no year, it
+// is inferred from the acquisition date. Timestamps are in the local time of
+// the device, in the given timezone.
+func parseLogcat(r io.Reader, now time.Time, loc *time.Location, emit func(LogcatEntry) error) error {
+	var entry *LogcatEntry
+	buffer := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "--------- beginning of ") {
+			buffer = strings.TrimPrefix(line, "--------- beginning of ")
+			continue
+		}
+
+		match := logcatLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			// Continuation of a multi-line message.
+			if entry != nil && line != "" {
+				entry.Message += "\n" + line
+			}
+			continue
+		}
+
+		if entry != nil {
+			err := emit(*entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		month, _ := strconv.Atoi(match[1])
+		year := now.Year()
+		if month > int(now.Month()) {
+			year--
+		}
+		pid, _ := strconv.Atoi(match[4])
+		tid, _ := strconv.Atoi(match[5])
+
+		entry = &LogcatEntry{
+			Timestamp: fmt.Sprintf("%d-%s-%sT%s", year, match[1], match[2], match[3]),
+			PID:       pid,
+			TID:       tid,
+			Priority:  match[6],
+			Tag:       match[7],
+			Message:   match[8],
+			Buffer:    buffer,
+		}
+		t, err := time.ParseInLocation(acquisition.LogcatTimeFormat, entry.Timestamp, loc)
+		if err == nil {
+			entry.TimestampUTC = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if entry != nil {
+		return emit(*entry)
+	}
+	return nil
+}
+
+// saveLogcatJSONL stores the parsed logcat from srcPath with one JSON entry
+// per line.
+func saveLogcatJSONL(filePath, srcPath string, now time.Time, loc *time.Location) error {
+	src, err := utils.OpenOutput(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", srcPath, err)
+	}
+	defer src.Close()
+
+	file, err := utils.CreateOutput(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s file: %v", filePath, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	err = parseLogcat(src, now, loc, func(entry LogcatEntry) error {
+		return encoder.Encode(&entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write logcat entry to %s: %v", filePath, err)
+	}
+
+	return nil
+}
+
+// saveLogcatMetadata parses logcat straight from the device and stores the
+// entries without their message, so that no content is retained in
+// hash-only mode.
+func saveLogcatMetadata(filePath string, now time.Time, loc *time.Location, cmd ...string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(adb.Client.ShellToWriter(pw, cmd...))
+	}()
+	defer pr.Close()
+
+	out := &lazyOutput{path: filePath}
+	encoder := json.NewEncoder(out)
+	err := parseLogcat(pr, now, loc, func(entry LogcatEntry) error {
+		entry.Message = ""
+		return encoder.Encode(&entry)
+	})
+	if out.file != nil {
+		out.file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse logcat: %v", err)
+	}
+
+	return nil
+}
+
+func (l *Logcat) ContentDescription(acq *acquisition.Acquisition) string {
+	if acq.HashOnly {
+		return ""
+	}
+	return "the system logs, which can contain the content of notifications and messages logged by apps"
+}
+
 func (l *Logcat) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting logcat...")
 
-	out, err := adb.Client.Shell("logcat", "-d", "-b", "all", "\"*:V\"")
-	if err != nil {
-		return fmt.Errorf("failed to run `adb shell logcat`: %v", err)
+	if acq.HashOnly {
+		err := saveLogcatMetadata(filepath.Join(l.StoragePath, "logcat.jsonl"), acq.Started, acq.Clock.Location(),
+			"logcat", "-d", "-b", "all", "\"*:V\"")
+		if err != nil {
+			return err
+		}
+		err = saveLogcatMetadata(filepath.Join(l.StoragePath, "logcat_old.jsonl"), acq.Started, acq.Clock.Location(),
+			"logcat", "-L", "-b", "all", "\"*:V\"")
+		if err != nil {
+			log.Debugf("failed to run `adb shell logcat -L`: %v", err)
+		}
+		return nil
 	}
 
-	err = saveCommandOutput(filepath.Join(l.StoragePath, "logcat.txt"), out)
+	logcatPath := filepath.Join(l.StoragePath, "logcat.txt")
+	saved, err := saveShellOutput(logcatPath, "logcat", "-d", "-b", "all", "\"*:V\"")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to run `adb shell logcat`: %v", err)
+	}
+	if saved {
+		err = saveLogcatJSONL(filepath.Join(l.StoragePath, "logcat.jsonl"), logcatPath, acq.Started, acq.Clock.Location())
+		if err != nil {
+			log.Errorf("Failed to save parsed logcat: %v", err)
+		}
 	}
 
 	// logcat from before reboot
-	out, err = adb.Client.Shell("logcat", "-L", "-b", "all", "\"*:V\"")
+	logcatOldPath := filepath.Join(l.StoragePath, "logcat_old.txt")
+	saved, err = saveShellOutput(logcatOldPath, "logcat", "-L", "-b", "all", "\"*:V\"")
 	if err != nil {
 		// Often fails, totally normal
 		log.Debugf("failed to run `adb shell logcat -L`: %v", err)
+		// Do not keep a partial output, unless it was already written to
+		// the output sink.
+		if saved && !utils.OutputSinkEnabled() {
+			os.Remove(logcatOldPath)
+		}
+		return nil
+	}
+	if !saved {
 		return nil
 	}
 
-	return saveCommandOutput(filepath.Join(l.StoragePath, "logcat_old.txt"), out)
+	return saveLogcatJSONL(filepath.Join(l.StoragePath, "logcat_old.jsonl"), logcatOldPath, acq.Started, acq.Clock.Location())
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type Logs struct {
@@ -40,6 +41,28 @@ func (l *Logs) InitStorage(storagePath string) error {
 	return nil
 }
 
+// Folders on the device from which all files are pulled.
+var logFolders = []string{"/data/anr/", "/data/log/", "/sdcard/log/"}
+
+func (l *Logs) EstimateSize(acq *acquisition.Acquisition) (int64, error) {
+	var total int64
+	var lastErr error
+	estimated := false
+	for _, logFolder := range logFolders {
+		size, err := folderSize(acq, logFolder)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		total += size
+		estimated = true
+	}
+	if !estimated {
+		return 0, fmt.Errorf("failed to estimate the size of any log folder: %v", lastErr)
+	}
+	return total, nil
+}
+
 func (l *Logs) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting system logs...")
 
@@ -51,7 +74,7 @@ func (l *Logs) Run(acq *acquisition.Acquisition, fast bool) error {
 	}
 
 	// FIXME: needed to list files versus pulling folders?
-	for _, logFolder := range []string{"/data/anr/", "/data/log/", "/sdcard/log/"} {
+	for _, logFolder := range logFolders {
 		files, err := adb.Client.ListFiles(logFolder, true)
 		if err != nil {
 			log.Debugf("Impossible to get files from %", logFolder)
@@ -65,13 +88,23 @@ func (l *Logs) Run(acq *acquisition.Acquisition, fast bool) error {
 		log.Debugf("Files in %s: %s", logFolder, files)
 	}
 
+	if acq.HashOnly {
+		logHashes := map[string]string{}
+		for _, logFile := range logFiles {
+			if sha256, ok := hashRemoteFile(logFile); ok {
+				logHashes[logFile] = sha256
+			}
+		}
+		return saveCommandOutputJson(filepath.Join(l.StoragePath, "logs_hashes.json"), &logHashes)
+	}
+
 	for _, logFile := range logFiles {
-		localPath := filepath.Join(l.LogsPath, logFile)
+		localPath := utils.LocalPath(l.LogsPath, logFile)
 		localDir, _ := filepath.Split(localPath)
 		log.Debugf("From: %s", logFile)
 		log.Debugf("To: %s", localPath)
 
-		err := os.MkdirAll(localDir, 0o755)
+		err := os.MkdirAll(utils.LongPath(localDir), 0o755)
 		if err != nil {
 			log.Errorf("Failed to create folders for logs %s: %v\n", localDir, err)
 			continue
@@ -0,0 +1,218 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	MediaCamera     = "camera"
+	MediaMicrophone = "microphone"
+)
+
+// App ops recording the use of the camera and of the microphone.
+var mediaAppOps = map[string]string{
+	"CAMERA":                        MediaCamera,
+	"PHONE_CALL_CAMERA":             MediaCamera,
+	"RECORD_AUDIO":                  MediaMicrophone,
+	"PHONE_CALL_MICROPHONE":         MediaMicrophone,
+	"RECEIVE_AMBIENT_TRIGGER_AUDIO": MediaMicrophone,
+}
+
+var (
+	// 04-14 10:00:00 : CONNECT device 0 client for package com.foo (PID 1234)
+	cameraEventRegexp = regexp.MustCompile(
+		`^(\d\d-\d\d \d\d:\d\d:\d\d) : (CONNECT|DISCONNECT|EVICT|REJECT) device (\S+) client for package (\S+) \(PID (\d+)\)`)
+	// 04-14 10:00:00:123 rec start riid:5 uid:10123 session:57 src:MIC pack:com.foo
+	recordEventRegexp = regexp.MustCompile(
+		`^(\d\d-\d\d \d\d:\d\d:\d\d)\S* rec (start|stop|update|release)\b.*\buid:(\d+)\b.*\bpack:(\S+)`)
+)
+
+// MediaUsageEvent is a use of the camera or of the microphone by a package.
+type MediaUsageEvent struct {
+	Source  string `json:"source"`
+	Media   string `json:"media"`
+	Package string `json:"package"`
+	UID     int    `json:"uid,omitempty"`
+	Event   string `json:"event"`
+	// Device local time as reported, and converted to UTC when the year is
+	// known.
+	Time    string `json:"time"`
+	TimeUTC string `json:"time_utc,omitempty"`
+	// Process state of the app during the access (app ops only).
+	State    string `json:"state,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	System   bool   `json:"system"`
+}
+
+// MediaUsage reconstructs the recent use of the camera and of the
+// microphone by each package, from the app ops history, the camera service
+// events and the audio recording activity.
+type MediaUsage struct {
+	StoragePath string
+}
+
+func NewMediaUsage() *MediaUsage {
+	return &MediaUsage{}
+}
+
+func (m *MediaUsage) Name() string {
+	return "media_usage"
+}
+
+func (m *MediaUsage) InitStorage(storagePath string) error {
+	m.StoragePath = storagePath
+	return nil
+}
+
+// parseAppOpsMediaAccess extracts the accesses to the camera and microphone
+// app ops from the output of `dumpsys appops`.
+func parseAppOpsMediaAccess(out string) []MediaUsageEvent {
+	ops := map[string]bool{}
+	for op := range mediaAppOps {
+		ops[op] = true
+	}
+
+	events := []MediaUsageEvent{}
+	for _, access := range parseAppOpsAccesses(out, ops) {
+		events = append(events, MediaUsageEvent{
+			Source:   "appops",
+			Media:    mediaAppOps[access.Op],
+			Package:  access.Package,
+			UID:      access.UID,
+			Event:    access.Result + " " + access.Op,
+			Time:     access.Time,
+			State:    access.State,
+			Duration: access.Duration,
+		})
+	}
+	return events
+}
+
+// parseCameraEvents extracts the clients connecting to the cameras from the
+// output of `dumpsys media.camera`.
+func parseCameraEvents(out string) []MediaUsageEvent {
+	events := []MediaUsageEvent{}
+	for _, line := range strings.Split(out, "\n") {
+		match := cameraEventRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		events = append(events, MediaUsageEvent{
+			Source:  "media.camera",
+			Media:   MediaCamera,
+			Package: match[4],
+			Event:   fmt.Sprintf("%s camera %s", strings.ToLower(match[2]), match[3]),
+			Time:    match[1],
+		})
+	}
+	return events
+}
+
+// parseRecordEvents extracts the audio recordings started and stopped from
+// the output of `dumpsys audio`.
+func parseRecordEvents(out string) []MediaUsageEvent {
+	events := []MediaUsageEvent{}
+	for _, line := range strings.Split(out, "\n") {
+		match := recordEventRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		uid, _ := strconv.Atoi(match[3])
+		events = append(events, MediaUsageEvent{
+			Source:  "audio",
+			Media:   MediaMicrophone,
+			Package: match[4],
+			UID:     uid,
+			Event:   "recording " + match[2],
+			Time:    match[1],
+		})
+	}
+	return events
+}
+
+func (m *MediaUsage) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting camera and microphone usage history...")
+
+	events := []MediaUsageEvent{}
+	for _, source := range []struct {
+		fileName string
+		service  string
+		parse    func(string) []MediaUsageEvent
+	}{
+		{"appops.txt", "appops", parseAppOpsMediaAccess},
+		{"media_camera.txt", "media.camera", parseCameraEvents},
+		{"audio.txt", "audio", parseRecordEvents},
+	} {
+		out, err := adb.Client.Shell("dumpsys", source.service)
+		if err != nil {
+			log.Debugf("Failed to run `dumpsys %s`: %v", source.service, err)
+			continue
+		}
+		err = saveCommandOutput(filepath.Join(m.StoragePath, source.fileName), out)
+		if err != nil {
+			return err
+		}
+		events = append(events, source.parse(out)...)
+	}
+
+	system := systemPackages()
+	background := map[string]map[string]bool{}
+	for i := range events {
+		event := &events[i]
+		event.System = system[event.Package]
+		if event.Source == "appops" {
+			event.TimeUTC = acq.DeviceTimeToUTC(appOpsTimeFormat, event.Time)
+			// Rejected accesses did not reach the camera or microphone.
+			if !event.System && isBackgroundState(event.State) && strings.HasPrefix(event.Event, "access") {
+				if background[event.Package] == nil {
+					background[event.Package] = map[string]bool{}
+				}
+				background[event.Package][event.Media] = true
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Package < events[j].Package
+	})
+
+	packages := make([]string, 0, len(background))
+	for pkg := range background {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		media := []string{}
+		for _, kind := range []string{MediaCamera, MediaMicrophone} {
+			if background[pkg][kind] {
+				media = append(media, kind)
+			}
+		}
+		log.Warningf("Non-system package %s used the %s while not visible", pkg, strings.Join(media, " and "))
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title: fmt.Sprintf("Non-system package used the %s while not visible: %s",
+				strings.Join(media, " and "), pkg),
+			Source:  m.Name(),
+			File:    m.Name() + "/media_usage.json",
+			Value:   pkg,
+			Package: pkg,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(m.StoragePath, "media_usage.json"), &events)
+}
@@ -7,9 +7,12 @@ package modules
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type Module interface {
@@ -18,24 +21,96 @@ type Module interface {
 	Run(acq *acquisition.Acquisition, fast bool) error
 }
 
+// HardwareModule is implemented by modules collecting data from physical
+// hardware (e.g. radios), which are skipped on emulators.
+type HardwareModule interface {
+	RequiresHardware() bool
+}
+
+// DeferredModule is implemented by modules which use the results of the
+// analysis of the acquisition, and therefore run after indicators have been
+// checked.
+type DeferredModule interface {
+	RunsAfterAnalysis() bool
+}
+
+// ContentModule is implemented by modules copying content from the device,
+// such as messages or photos, which only run if the owner of the device
+// agrees. ContentDescription describes exactly what will be copied, or is
+// empty if the module will not copy any content in this acquisition.
+type ContentModule interface {
+	ContentDescription(acq *acquisition.Acquisition) string
+}
+
+// RebootModule is implemented by modules which collect data again after the
+// device is rebooted, to compare its state before and after, e.g. to find
+// what starts at boot. RunAfterReboot writes its output next to the output
+// of Run.
+type RebootModule interface {
+	RunAfterReboot(acq *acquisition.Acquisition, fast bool) error
+}
+
 func List() []Module {
-	return []Module{
+	mods := []Module{
+		// Runs first, to record the state of the device before androidqf
+		// starts collecting data.
+		NewForensicTooling(),
 		NewBackup(),
 		NewPackages(),
+		NewAppLabels(),
 		NewGetProp(),
+		NewSecurityPatch(),
+		NewIntegrityPosture(),
 		NewDumpsys(),
 		NewProcesses(),
+		NewProcstats(),
 		NewServices(),
+		NewRunningServices(),
 		NewBugreport(),
 		NewFiles(),
+		NewSearch(),
 		NewSettings(),
+		NewChecks(),
+		NewFactoryResetProtection(),
+		NewLockPosture(),
+		NewDNS(),
+		NewNetwork(),
+		NewNeighbors(),
+		NewNetworkCapture(),
+		NewHosts(),
+		NewWifi(),
+		NewBluetoothSnoop(),
+		NewTrackers(),
 		NewSELinux(),
 		NewEnvironment(),
 		NewRootBinaries(),
+		NewRootFrameworks(),
+		NewDevicePolicy(),
+		NewOverlays(),
+		NewUsageAccess(),
+		NewInstallUnknownApps(),
+		NewRiskMatrix(),
+		NewPackageRelationships(),
+		NewWebView(),
+		NewCarrierApps(),
+		NewSensors(),
+		NewMediaUsage(),
+		NewClipboard(),
+		NewPersistence(),
+		NewBroadcastReceivers(),
+		NewHiddenComponents(),
+		NewPrivacyDashboard(),
+		NewSystemIntegrity(),
+		NewPackageBaseline(),
 		NewLogcat(),
 		NewLogs(),
 		NewTemp(),
+		NewSdCard(),
+		NewFlaggedPackages(),
+		NewRecheck(),
 	}
+	// External modules run after the built-in ones.
+	return append(mods, externalModules(mods)...)
 }
 
 func saveCommandOutputJson(filePath string, data any) error {
@@ -46,19 +121,56 @@ func saveCommandOutputJson(filePath string, data any) error {
 	return saveCommandOutput(filePath, string(jsonData))
 }
 
+// lazyOutput creates an output file on the first write, so that commands
+// failing without any output do not leave empty files behind.
+type lazyOutput struct {
+	path string
+	file io.WriteCloser
+}
+
+func (o *lazyOutput) Write(p []byte) (int, error) {
+	if o.file == nil {
+		file, err := utils.CreateOutput(o.path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create %s file: %v", o.path, err)
+		}
+		o.file = file
+	}
+	return o.file.Write(p)
+}
+
+// saveShellOutput streams the output of a shell command straight to a file,
+// without keeping it in memory. The file is only created if the command
+// produces some output, and it returns whether it was.
+func saveShellOutput(filePath string, cmd ...string) (bool, error) {
+	out := &lazyOutput{path: filePath}
+	err := adb.Client.ShellToWriter(out, cmd...)
+	if out.file == nil {
+		return false, err
+	}
+
+	closeErr := out.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return true, err
+}
+
 func saveCommandOutput(filePath, output string) error {
-	file, err := os.Create(filePath)
+	file, err := utils.CreateOutput(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to create %s file: %v", filePath, err)
 	}
 	defer file.Close()
 
-	_, err = file.WriteString(output)
+	_, err = io.WriteString(file, output)
 	if err != nil {
 		return fmt.Errorf("failed to write command output to %s: %v", filePath, err)
 	}
 
-	file.Sync()
+	if f, ok := file.(*os.File); ok {
+		f.Sync()
+	}
 
 	return nil
 }
@@ -0,0 +1,121 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type Neighbor struct {
+	Address   string `json:"address"`
+	Interface string `json:"interface"`
+	MAC       string `json:"mac"`
+	State     string `json:"state"`
+	Router    bool   `json:"router"`
+}
+
+type Neighbors struct {
+	StoragePath string
+}
+
+func NewNeighbors() *Neighbors {
+	return &Neighbors{}
+}
+
+func (n *Neighbors) Name() string {
+	return "neighbors"
+}
+
+func (n *Neighbors) InitStorage(storagePath string) error {
+	n.StoragePath = storagePath
+	return nil
+}
+
+// parseIPNeigh parses the output of `ip neigh`.
+func parseIPNeigh(out string) []Neighbor {
+	neighbors := []Neighbor{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		neighbor := Neighbor{Address: fields[0], State: fields[len(fields)-1]}
+		for i := 1; i < len(fields); i++ {
+			switch fields[i] {
+			case "dev":
+				if i+1 < len(fields) {
+					neighbor.Interface = fields[i+1]
+				}
+			case "lladdr":
+				if i+1 < len(fields) {
+					neighbor.MAC = strings.ToLower(fields[i+1])
+				}
+			case "router":
+				neighbor.Router = true
+			}
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors
+}
+
+// parseProcNetARP parses /proc/net/arp, which is available when `ip neigh`
+// is not allowed.
+func parseProcNetARP(out string) []Neighbor {
+	neighbors := []Neighbor{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] == "IP" {
+			continue
+		}
+		state := "REACHABLE"
+		if fields[2] == "0x0" {
+			state = "INCOMPLETE"
+		}
+		neighbors = append(neighbors, Neighbor{
+			Address:   fields[0],
+			Interface: fields[5],
+			MAC:       strings.ToLower(fields[3]),
+			State:     state,
+		})
+	}
+	return neighbors
+}
+
+func (n *Neighbors) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting neighbor cache...")
+
+	var neighbors []Neighbor
+	out, err := adb.Client.Shell("ip", "neigh")
+	if err == nil && acq.Capabilities.Has("ip") {
+		err = saveCommandOutput(filepath.Join(n.StoragePath, "ip_neigh.txt"), out)
+		if err != nil {
+			return err
+		}
+		neighbors = parseIPNeigh(out)
+	} else {
+		log.Debugf("Failed to run `ip neigh`, falling back to /proc/net/arp: %v", err)
+		out, err = adb.Client.Shell("cat", "/proc/net/arp")
+		if err != nil {
+			log.Debugf("Impossible to read /proc/net/arp: %v", err)
+		}
+		err = saveCommandOutput(filepath.Join(n.StoragePath, "arp.txt"), out)
+		if err != nil {
+			return err
+		}
+		neighbors = parseProcNetARP(out)
+	}
+
+	log.Debugf("Found %d entries in the neighbor cache", len(neighbors))
+
+	return saveCommandOutputJson(filepath.Join(n.StoragePath, "neighbors.json"), &neighbors)
+}
@@ -0,0 +1,206 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type NetworkInterface struct {
+	Index     int      `json:"index"`
+	Name      string   `json:"name"`
+	Flags     []string `json:"flags"`
+	MTU       int      `json:"mtu"`
+	State     string   `json:"state"`
+	LinkType  string   `json:"link_type"`
+	Addresses []string `json:"addresses"`
+	RxBytes   uint64   `json:"rx_bytes"`
+	RxPackets uint64   `json:"rx_packets"`
+	TxBytes   uint64   `json:"tx_bytes"`
+	TxPackets uint64   `json:"tx_packets"`
+	Tunnel    bool     `json:"tunnel"`
+}
+
+type NetworkReport struct {
+	Interfaces []NetworkInterface `json:"interfaces"`
+	Routes     []string           `json:"routes"`
+	Rules      []string           `json:"rules"`
+}
+
+// Name prefixes of tunnel interfaces, such as those used by VPNs.
+var tunnelPrefixes = []string{"tun", "tap", "ppp", "ipsec", "wg", "gre", "sit", "ip6tnl", "vti"}
+
+type Network struct {
+	StoragePath string
+}
+
+func NewNetwork() *Network {
+	return &Network{}
+}
+
+func (n *Network) Name() string {
+	return "network"
+}
+
+func (n *Network) InitStorage(storagePath string) error {
+	n.StoragePath = storagePath
+	return nil
+}
+
+// parseIPLink parses the output of `ip -o link`.
+func parseIPLink(out string) map[string]*NetworkInterface {
+	interfaces := map[string]*NetworkInterface{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		iface := &NetworkInterface{
+			Name:      strings.Split(strings.TrimSuffix(fields[1], ":"), "@")[0],
+			Flags:     strings.Split(strings.Trim(fields[2], "<>"), ","),
+			Addresses: []string{},
+		}
+		iface.Index, _ = strconv.Atoi(strings.TrimSuffix(fields[0], ":"))
+		for i := 3; i < len(fields)-1; i++ {
+			switch {
+			case fields[i] == "mtu":
+				iface.MTU, _ = strconv.Atoi(fields[i+1])
+			case fields[i] == "state":
+				iface.State = fields[i+1]
+			case strings.HasPrefix(fields[i], "link/"):
+				iface.LinkType = strings.TrimPrefix(fields[i], "link/")
+			}
+		}
+		for _, prefix := range tunnelPrefixes {
+			if strings.HasPrefix(iface.Name, prefix) {
+				iface.Tunnel = true
+			}
+		}
+		interfaces[iface.Name] = iface
+	}
+	return interfaces
+}
+
+// parseIPAddr adds the addresses from `ip -o addr` to the interfaces.
+func parseIPAddr(out string, interfaces map[string]*NetworkInterface) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[2] != "inet" && fields[2] != "inet6") {
+			continue
+		}
+		if iface, ok := interfaces[fields[1]]; ok {
+			iface.Addresses = append(iface.Addresses, fields[3])
+		}
+	}
+}
+
+// parseNetDev adds the traffic counters from /proc/net/dev to the interfaces.
+func parseNetDev(out string, interfaces map[string]*NetworkInterface) {
+	for _, line := range strings.Split(out, "\n") {
+		name, counters, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		iface, ok := interfaces[strings.TrimSpace(name)]
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 10 {
+			continue
+		}
+		iface.RxBytes, _ = strconv.ParseUint(fields[0], 10, 64)
+		iface.RxPackets, _ = strconv.ParseUint(fields[1], 10, 64)
+		iface.TxBytes, _ = strconv.ParseUint(fields[8], 10, 64)
+		iface.TxPackets, _ = strconv.ParseUint(fields[9], 10, 64)
+	}
+}
+
+func splitLines(out string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (n *Network) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting network interfaces and routing tables...")
+
+	if !acq.Capabilities.Has("ip") {
+		log.Info("The device does not have `ip`, skipping network interfaces")
+		return nil
+	}
+
+	outputs := map[string]string{}
+	commands := []struct {
+		fileName string
+		command  []string
+	}{
+		{"ip_link.txt", []string{"ip", "-o", "link"}},
+		{"ip_addr.txt", []string{"ip", "-o", "addr"}},
+		{"ip_route.txt", []string{"ip", "route", "show", "table", "all"}},
+		{"ip_rule.txt", []string{"ip", "rule"}},
+		{"net_dev.txt", []string{"cat", "/proc/net/dev"}},
+	}
+	for _, cmd := range commands {
+		out, err := adb.Client.Shell(cmd.command...)
+		if err != nil {
+			log.Debugf("Failed to run `%s`: %v", strings.Join(cmd.command, " "), err)
+		}
+		outputs[cmd.fileName] = out
+
+		err = saveCommandOutput(filepath.Join(n.StoragePath, cmd.fileName), out)
+		if err != nil {
+			return err
+		}
+	}
+
+	interfaces := parseIPLink(outputs["ip_link.txt"])
+	parseIPAddr(outputs["ip_addr.txt"], interfaces)
+	parseNetDev(outputs["net_dev.txt"], interfaces)
+
+	report := NetworkReport{
+		Interfaces: []NetworkInterface{},
+		Routes:     splitLines(outputs["ip_route.txt"]),
+		Rules:      splitLines(outputs["ip_rule.txt"]),
+	}
+	for _, iface := range interfaces {
+		report.Interfaces = append(report.Interfaces, *iface)
+	}
+	sort.Slice(report.Interfaces, func(i, j int) bool {
+		return report.Interfaces[i].Index < report.Interfaces[j].Index
+	})
+
+	for _, iface := range report.Interfaces {
+		if !iface.Tunnel || iface.State == "DOWN" {
+			continue
+		}
+		log.Warningf("Found tunnel interface %s", iface.Name)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityLow,
+			Title:    fmt.Sprintf("Tunnel network interface %s is active", iface.Name),
+			Source:   n.Name(),
+			File:     n.Name() + "/network.json",
+			Value:    iface.Name,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(n.StoragePath, "network.json"), &report)
+}
@@ -0,0 +1,103 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type NetworkCaptureReport struct {
+	Duration int    `json:"duration"`
+	Command  string `json:"command"`
+	SHA256   string `json:"sha256"`
+}
+
+type NetworkCapture struct {
+	StoragePath string
+}
+
+func NewNetworkCapture() *NetworkCapture {
+	return &NetworkCapture{}
+}
+
+func (n *NetworkCapture) Name() string {
+	return "network_capture"
+}
+
+func (n *NetworkCapture) RequiresRoot() bool {
+	return true
+}
+
+func (n *NetworkCapture) ContentDescription(acq *acquisition.Acquisition) string {
+	if acq.HashOnly || acq.Config.NetworkCaptureSeconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("the network traffic of the device for %d seconds, including the content of connections "+
+		"which are not encrypted", acq.Config.NetworkCaptureSeconds)
+}
+
+func (n *NetworkCapture) InitStorage(storagePath string) error {
+	n.StoragePath = storagePath
+	return nil
+}
+
+func (n *NetworkCapture) Run(acq *acquisition.Acquisition, fast bool) error {
+	duration := acq.Config.NetworkCaptureSeconds
+	if acq.HashOnly {
+		log.Info("Skipping network capture in hash-only mode")
+		return nil
+	}
+	if duration <= 0 {
+		log.Debug("Network capture is not enabled in the configuration")
+		return nil
+	}
+	if !acq.Capabilities.Has("tcpdump") {
+		log.Info("Network capture requires tcpdump on the device, skipping")
+		return nil
+	}
+
+	log.Infof("Capturing network traffic for %d seconds...", duration)
+
+	remotePath := acq.TmpDir + "androidqf.pcap"
+	adb.Client.TrackDeviceFile(remotePath)
+	command := fmt.Sprintf("timeout %d tcpdump -i any -s 0 -w %s", duration, remotePath)
+	if acq.Capabilities.Root {
+		_, _ = adb.Client.Shell(command)
+	} else {
+		// tcpdump is stopped by timeout, so the exit status is not relevant.
+		_, _ = adb.Client.Shell("su", "-c", "'"+command+"'")
+	}
+
+	localPath := filepath.Join(n.StoragePath, "capture.pcap")
+	out, err := adb.Client.Pull(remotePath, localPath)
+	if err != nil {
+		return fmt.Errorf("failed to pull network capture: %s", out)
+	}
+	if acq.Capabilities.Root {
+		_, _ = adb.Client.Shell("rm", remotePath)
+	} else {
+		_, _ = adb.Client.Shell("su", "-c", "'rm "+remotePath+"'")
+	}
+
+	sha256, err := utils.OutputSHA256(localPath)
+	if err != nil {
+		return err
+	}
+
+	report := NetworkCaptureReport{
+		Duration: duration,
+		Command:  command,
+		SHA256:   sha256,
+	}
+
+	return saveCommandOutputJson(filepath.Join(n.StoragePath, "network_capture.json"), &report)
+}
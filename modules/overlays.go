@@ -0,0 +1,255 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type OverlayPackage struct {
+	Package string `json:"package"`
+	System  bool   `json:"system"`
+	Flagged bool   `json:"flagged"`
+}
+
+type OverlayWindow struct {
+	Window  string `json:"window"`
+	Package string `json:"package"`
+	UID     int    `json:"uid"`
+	Type    string `json:"type"`
+	// The app op through which the window is allowed, if any.
+	AppOp   string `json:"app_op"`
+	Visible bool   `json:"visible"`
+	System  bool   `json:"system"`
+}
+
+type OverlaysReport struct {
+	// Time at which the windows were captured.
+	Captured time.Time        `json:"captured"`
+	Allowed  []OverlayPackage `json:"allowed"`
+	Attached []OverlayWindow  `json:"attached"`
+}
+
+type Overlays struct {
+	StoragePath string
+}
+
+func NewOverlays() *Overlays {
+	return &Overlays{}
+}
+
+func (o *Overlays) Name() string {
+	return "overlays"
+}
+
+func (o *Overlays) InitStorage(storagePath string) error {
+	o.StoragePath = storagePath
+	return nil
+}
+
+// appOpsPackages returns the packages for which the given app op is set to
+// the given mode, e.g. SYSTEM_ALERT_WINDOW set to allow.
+func appOpsPackages(op, mode string) ([]string, error) {
+	out, err := adb.Client.Shell("cmd", "appops", "query-op", op, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, " ") {
+			// Skip "No operations." and error messages.
+			continue
+		}
+		packages = append(packages, line)
+	}
+	sort.Strings(packages)
+
+	return packages, nil
+}
+
+// systemPackages returns the set of packages part of the system image.
+func systemPackages() map[string]bool {
+	packages := map[string]bool{}
+	out, err := adb.Client.Shell("pm", "list", "packages", "-s")
+	if err != nil {
+		log.Debugf("Impossible to get list of system packages: %v", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		packages[strings.TrimPrefix(strings.TrimSpace(line), "package:")] = true
+	}
+	return packages
+}
+
+var (
+	windowRegexp        = regexp.MustCompile(`Window\{[0-9a-f]+ u\d+ ([^}]+)\}`)
+	windowTypeRegexp    = regexp.MustCompile(`\bty=([A-Z_]+)`)
+	windowOwnerRegexp   = regexp.MustCompile(`\bmOwnerUid=(\d+)`)
+	windowPackageRegexp = regexp.MustCompile(`\bpackage=(\S+)`)
+	windowAppOpRegexp   = regexp.MustCompile(`\bappop=(\S+)`)
+)
+
+// parseOverlayWindows extracts the windows drawn over other apps from the
+// output of `dumpsys window windows`, with the package owning them. The
+// title of a window is chosen by the app, so the owner is taken from the
+// package= attribute when present.
+func parseOverlayWindows(out string) []OverlayWindow {
+	overlayTypes := map[string]bool{
+		"APPLICATION_OVERLAY": true,
+		"SYSTEM_ALERT":        true,
+		"SYSTEM_OVERLAY":      true,
+		"SYSTEM_ERROR":        true,
+		"PHONE":               true,
+		"PRIORITY_PHONE":      true,
+	}
+
+	windows := []OverlayWindow{}
+	seen := map[string]bool{}
+	var current *OverlayWindow
+	hasSurface, viewVisible := false, false
+	flush := func() {
+		if current == nil || !overlayTypes[current.Type] || seen[current.Window] {
+			return
+		}
+		seen[current.Window] = true
+		if current.Package == "" {
+			current.Package = strings.Split(current.Window, "/")[0]
+		}
+		current.Visible = current.Visible || (hasSurface && viewVisible)
+		windows = append(windows, *current)
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "Window #") {
+			flush()
+			current = nil
+			hasSurface, viewVisible = false, false
+			if match := windowRegexp.FindStringSubmatch(trimmed); match != nil {
+				current = &OverlayWindow{Window: match[1]}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "mAttrs=") && current.Type == "" {
+			if match := windowTypeRegexp.FindStringSubmatch(trimmed); match != nil {
+				current.Type = match[1]
+			}
+		}
+		if match := windowOwnerRegexp.FindStringSubmatch(trimmed); match != nil {
+			current.UID, _ = strconv.Atoi(match[1])
+			if match := windowPackageRegexp.FindStringSubmatch(trimmed); match != nil {
+				current.Package = match[1]
+			}
+			if match := windowAppOpRegexp.FindStringSubmatch(trimmed); match != nil && match[1] != "NONE" {
+				current.AppOp = match[1]
+			}
+		}
+		if strings.Contains(trimmed, "mHasSurface=true") {
+			hasSurface = true
+		}
+		if strings.Contains(trimmed, "mViewVisibility=0x0 ") || strings.HasSuffix(trimmed, "mViewVisibility=0x0") {
+			viewVisible = true
+		}
+		if strings.Contains(trimmed, "isVisible=true") {
+			current.Visible = true
+		}
+	}
+	flush()
+
+	return windows
+}
+
+func (o *Overlays) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting packages allowed to draw over other apps...")
+
+	report := OverlaysReport{
+		Allowed:  []OverlayPackage{},
+		Attached: []OverlayWindow{},
+	}
+
+	system := systemPackages()
+
+	allowed := []string{}
+	if acq.Capabilities.Has("cmd") {
+		var err error
+		allowed, err = appOpsPackages("SYSTEM_ALERT_WINDOW", "allow")
+		if err != nil {
+			log.Debugf("Impossible to query SYSTEM_ALERT_WINDOW app op: %v", err)
+		}
+	} else {
+		log.Debug("The device does not have `cmd`, skipping app ops query")
+	}
+	for _, pkg := range allowed {
+		overlay := OverlayPackage{
+			Package: pkg,
+			System:  system[pkg],
+			Flagged: !system[pkg],
+		}
+		if overlay.Flagged {
+			log.Warningf("Non-system package %s can draw over other apps", pkg)
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: acquisition.SeverityLow,
+				Title:    fmt.Sprintf("Non-system package can draw over other apps: %s", pkg),
+				Source:   o.Name(),
+				File:     o.Name() + "/overlays.json",
+				Value:    pkg,
+				Package:  pkg,
+			})
+		}
+		report.Allowed = append(report.Allowed, overlay)
+	}
+
+	report.Captured = time.Now().UTC()
+	out, err := adb.Client.Shell("dumpsys", "window", "windows")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys window windows`: %v", err)
+	}
+	err = saveCommandOutput(filepath.Join(o.StoragePath, "window.txt"), out)
+	if err != nil {
+		return err
+	}
+
+	report.Attached = parseOverlayWindows(out)
+	for i := range report.Attached {
+		window := &report.Attached[i]
+		window.System = system[window.Package]
+		if window.System {
+			continue
+		}
+		state := "attached"
+		if window.Visible {
+			state = "visible"
+		}
+		log.Warningf("Non-system package %s has an overlay window currently %s", window.Package, state)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    fmt.Sprintf("Overlay window %s by non-system package: %s", state, window.Package),
+			Source:   o.Name(),
+			File:     o.Name() + "/overlays.json",
+			Value:    window.Window,
+			Package:  window.Package,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(o.StoragePath, "overlays.json"), &report)
+}
@@ -0,0 +1,168 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type BaselinePackage struct {
+	Package string `json:"package"`
+	System  bool   `json:"system"`
+}
+
+type PackageBaselineReport struct {
+	Fingerprint   string            `json:"fingerprint"`
+	Baseline      string            `json:"baseline"`
+	Compared      bool              `json:"compared"`
+	TotalPackages int               `json:"total_packages"`
+	Added         []BaselinePackage `json:"added"`
+	Missing       []string          `json:"missing"`
+}
+
+// PackageBaseline compares the packages installed on the device with those
+// shipped in the matching firmware, to isolate the packages added after the
+// device left the factory even without indicators of compromise.
+type PackageBaseline struct {
+	StoragePath string
+}
+
+func NewPackageBaseline() *PackageBaseline {
+	return &PackageBaseline{}
+}
+
+func (p *PackageBaseline) Name() string {
+	return "package_baseline"
+}
+
+func (p *PackageBaseline) InitStorage(storagePath string) error {
+	p.StoragePath = storagePath
+	return nil
+}
+
+// loadPackageBaseline loads the packages shipped in the firmware with the
+// given build fingerprint. The baseline is either a JSON database mapping
+// build fingerprints to lists of package names, or a list of package names
+// for a single firmware, one per line, as printed by `pm list packages`.
+func loadPackageBaseline(location, fingerprint string) (map[string]bool, error) {
+	data, err := utils.ReadLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := map[string]bool{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var database map[string][]string
+		err = json.Unmarshal(data, &database)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse package baseline database: %v", err)
+		}
+		packages, ok := database[fingerprint]
+		if !ok {
+			return nil, fmt.Errorf("no package baseline available for fingerprint %s", fingerprint)
+		}
+		for _, pkg := range packages {
+			baseline[pkg] = true
+		}
+		return baseline, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		pkg := strings.TrimPrefix(strings.TrimSpace(line), "package:")
+		if pkg != "" && !strings.HasPrefix(pkg, "#") {
+			baseline[pkg] = true
+		}
+	}
+	return baseline, nil
+}
+
+func (p *PackageBaseline) Run(acq *acquisition.Acquisition, fast bool) error {
+	if acq.PackageBaseline == "" {
+		log.Debug("No package baseline provided, skipping comparison")
+		return nil
+	}
+
+	log.Info("Comparing installed packages with the firmware baseline...")
+
+	fingerprint, err := adb.Client.Shell("getprop", "ro.build.fingerprint")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell getprop ro.build.fingerprint`: %v", err)
+	}
+	// Packages uninstalled for the current user are still part of the
+	// firmware, so they are not reported as missing.
+	out, err := adb.Client.Shell("pm", "list", "packages", "-u")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell pm list packages`: %v", err)
+	}
+	installed := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		pkg := strings.TrimPrefix(strings.TrimSpace(line), "package:")
+		if pkg != "" {
+			installed[pkg] = true
+		}
+	}
+
+	report := PackageBaselineReport{
+		Fingerprint:   fingerprint,
+		Baseline:      acq.PackageBaseline,
+		TotalPackages: len(installed),
+		Added:         []BaselinePackage{},
+		Missing:       []string{},
+	}
+
+	baseline, err := loadPackageBaseline(acq.PackageBaseline, fingerprint)
+	if err != nil {
+		log.Errorf("Impossible to load package baseline: %v", err)
+		return saveCommandOutputJson(filepath.Join(p.StoragePath, "package_baseline.json"), &report)
+	}
+	report.Compared = true
+
+	system := systemPackages()
+	for pkg := range installed {
+		if !baseline[pkg] {
+			report.Added = append(report.Added, BaselinePackage{Package: pkg, System: system[pkg]})
+		}
+	}
+	for pkg := range baseline {
+		if !installed[pkg] {
+			report.Missing = append(report.Missing, pkg)
+		}
+	}
+	sort.Slice(report.Added, func(i, j int) bool {
+		return report.Added[i].Package < report.Added[j].Package
+	})
+	sort.Strings(report.Missing)
+
+	// Apps installed by the user are expected to be missing from the
+	// baseline, while system packages should all come from the firmware.
+	for _, pkg := range report.Added {
+		if !pkg.System {
+			continue
+		}
+		log.Warningf("Found system package not shipped in the firmware: %s", pkg.Package)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityHigh,
+			Title:    fmt.Sprintf("System package not shipped in the firmware: %s", pkg.Package),
+			Source:   p.Name(),
+			File:     p.Name() + "/package_baseline.json",
+			Value:    pkg.Package,
+			Package:  pkg.Package,
+		})
+	}
+	log.Infof("Found %d packages absent from the firmware baseline", len(report.Added))
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "package_baseline.json"), &report)
+}
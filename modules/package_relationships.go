@@ -0,0 +1,289 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+const (
+	RelationSharedUID           = "shared_uid"
+	RelationSignaturePermission = "signature_permission"
+	RelationKnownSigner         = "known_signer_permission"
+	RelationSameSigner          = "same_signer"
+)
+
+var (
+	permissionSectionRegexp = regexp.MustCompile(`^\s*Permission \[([^\]]+)\]`)
+	// sharedUser=SharedUserSetting{1a2b3c android.uid.system/1000}
+	sharedUserRegexp = regexp.MustCompile(`sharedUser=SharedUserSetting\{\S+ ([^/}]+)/(\d+)\}`)
+	// signatures=PackageSignatures{c5d6e7 version:3, signatures:[8ddb342f], past signatures:[]}
+	signaturesRegexp = regexp.MustCompile(`signatures=PackageSignatures\{.*?signatures:\[([^\]]*)\]`)
+	// uid=10123 gids=[] type=0 prot=signature|knownSigner
+	protectionRegexp = regexp.MustCompile(`\bprot=(\S+)`)
+)
+
+type PackageNode struct {
+	Package    string `json:"package"`
+	System     bool   `json:"system"`
+	SharedUser string `json:"shared_user,omitempty"`
+	Signers    string `json:"signers,omitempty"`
+}
+
+type PackageEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"`
+	// Shared user, permission or signer connecting the packages.
+	Detail string `json:"detail"`
+}
+
+type PackageGraph struct {
+	Nodes []PackageNode `json:"nodes"`
+	Edges []PackageEdge `json:"edges"`
+}
+
+type permissionDefinition struct {
+	source     string
+	protection string
+}
+
+// PackageRelationships maps the shared UIDs, the signature permissions
+// granted between packages and the packages signed by the same certificate
+// into a graph, which exposes a benign-looking app sharing its privileges
+// with a payload.
+type PackageRelationships struct {
+	StoragePath string
+}
+
+func NewPackageRelationships() *PackageRelationships {
+	return &PackageRelationships{}
+}
+
+func (p *PackageRelationships) Name() string {
+	return "package_relationships"
+}
+
+func (p *PackageRelationships) InitStorage(storagePath string) error {
+	p.StoragePath = storagePath
+	return nil
+}
+
+// parsePackageIdentities returns the packages with their shared user and
+// signers, and the permissions defined by packages with their protection
+// level, from the output of `dumpsys package`.
+func parsePackageIdentities(out string) (map[string]*PackageNode, map[string]permissionDefinition) {
+	nodes := map[string]*PackageNode{}
+	permissions := map[string]permissionDefinition{}
+	var node *PackageNode
+	permission := ""
+	for _, line := range strings.Split(out, "\n") {
+		if match := packageSectionRegexp.FindStringSubmatch(line); match != nil {
+			permission = ""
+			// Updated system packages are listed again as hidden packages.
+			if _, ok := nodes[match[1]]; ok {
+				node = nil
+				continue
+			}
+			node = &PackageNode{Package: match[1]}
+			nodes[match[1]] = node
+			continue
+		}
+		if match := permissionSectionRegexp.FindStringSubmatch(line); match != nil {
+			node = nil
+			permission = match[1]
+			continue
+		}
+		if line != "" && line[0] != ' ' {
+			node = nil
+			permission = ""
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case node != nil:
+			if match := sharedUserRegexp.FindStringSubmatch(trimmed); match != nil {
+				node.SharedUser = match[1]
+			} else if match := signaturesRegexp.FindStringSubmatch(trimmed); match != nil && node.Signers == "" {
+				node.Signers = strings.ReplaceAll(match[1], " ", "")
+			}
+		case permission != "":
+			definition := permissions[permission]
+			if strings.HasPrefix(trimmed, "sourcePackage=") {
+				definition.source = strings.TrimPrefix(trimmed, "sourcePackage=")
+			} else if match := protectionRegexp.FindStringSubmatch(trimmed); match != nil {
+				definition.protection = match[1]
+			}
+			permissions[permission] = definition
+		}
+	}
+	return nodes, permissions
+}
+
+// buildPackageGraph connects the packages sharing a UID or a signer, and
+// those granted a signature permission defined by another package. The
+// packages of the platform are left out, as they are all related.
+func buildPackageGraph(nodes map[string]*PackageNode, permissions map[string]permissionDefinition,
+	granted map[string]map[string]bool,
+) PackageGraph {
+	platformSigners := ""
+	if platform, ok := nodes["android"]; ok {
+		platformSigners = platform.Signers
+	}
+
+	edges := []PackageEdge{}
+	groups := map[string][]string{}
+	signers := map[string][]string{}
+	for name, node := range nodes {
+		if node.SharedUser != "" && !strings.HasPrefix(node.SharedUser, "android.") {
+			groups[node.SharedUser] = append(groups[node.SharedUser], name)
+		}
+		if node.Signers != "" && node.Signers != platformSigners {
+			signers[node.Signers] = append(signers[node.Signers], name)
+		}
+	}
+	connect := func(members map[string][]string, relation string) {
+		for detail, packages := range members {
+			sort.Strings(packages)
+			for i := 1; i < len(packages); i++ {
+				edges = append(edges, PackageEdge{packages[0], packages[i], relation, detail})
+			}
+		}
+	}
+	connect(groups, RelationSharedUID)
+	connect(signers, RelationSameSigner)
+
+	for pkg, perms := range granted {
+		for perm := range perms {
+			definition, ok := permissions[perm]
+			if !ok || definition.source == "" || definition.source == pkg || definition.source == "android" ||
+				!strings.Contains(definition.protection, "signature") {
+				continue
+			}
+			relation := RelationSignaturePermission
+			if strings.Contains(definition.protection, "knownSigner") {
+				relation = RelationKnownSigner
+			}
+			edges = append(edges, PackageEdge{pkg, definition.source, relation, perm})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Detail < edges[j].Detail
+	})
+
+	related := map[string]bool{}
+	for _, edge := range edges {
+		related[edge.From] = true
+		related[edge.To] = true
+	}
+	graph := PackageGraph{Nodes: []PackageNode{}, Edges: edges}
+	for name := range related {
+		if node, ok := nodes[name]; ok {
+			graph.Nodes = append(graph.Nodes, *node)
+		} else {
+			graph.Nodes = append(graph.Nodes, PackageNode{Package: name})
+		}
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].Package < graph.Nodes[j].Package
+	})
+	return graph
+}
+
+// dot returns the graph in the Graphviz format.
+func (g PackageGraph) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph packages {\n")
+	for _, node := range g.Nodes {
+		shape := "ellipse"
+		if node.System {
+			shape = "box"
+		}
+		fmt.Fprintf(&b, "    %q [shape=%s];\n", node.Package, shape)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "    %q -> %q [label=%q];\n", edge.From, edge.To, edge.Type+": "+edge.Detail)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (p *PackageRelationships) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Mapping relationships between packages...")
+
+	out, err := adb.Client.Shell("dumpsys", "package")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys package`: %v", err)
+	}
+
+	nodes, permissions := parsePackageIdentities(out)
+	system := systemPackages()
+	for name, node := range nodes {
+		node.System = system[name]
+	}
+	graph := buildPackageGraph(nodes, permissions, parseGrantedPermissions(out))
+
+	for _, edge := range graph.Edges {
+		if edge.Type != RelationSharedUID || (nodes[edge.From].System && nodes[edge.To].System) {
+			continue
+		}
+		title := fmt.Sprintf("Non-system package shares its UID with another package: %s and %s", edge.From, edge.To)
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    title,
+			Source:   p.Name(),
+			File:     p.Name() + "/package_relationships.json",
+			Value:    edge.Detail,
+			Package:  edge.To,
+		})
+	}
+	// Running as a platform UID requires being signed with the platform
+	// certificate, whose keys have leaked for some manufacturers.
+	names := []string{}
+	for name, node := range nodes {
+		if !node.System && strings.HasPrefix(node.SharedUser, "android.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		title := fmt.Sprintf("Non-system package runs with the platform UID %s: %s", nodes[name].SharedUser, name)
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityHigh,
+			Title:    title,
+			Source:   p.Name(),
+			File:     p.Name() + "/package_relationships.json",
+			Value:    nodes[name].SharedUser,
+			Package:  name,
+		})
+	}
+	log.Debugf("Found %d relationships between %d packages", len(graph.Edges), len(graph.Nodes))
+
+	err = saveCommandOutput(filepath.Join(p.StoragePath, "package_relationships.dot"), graph.dot())
+	if err != nil {
+		return err
+	}
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "package_relationships.json"), &graph)
+}
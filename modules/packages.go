@@ -5,12 +5,13 @@
 package modules
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/manifoldco/promptui"
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
@@ -23,8 +24,39 @@ const (
 	apkNone          = "Do not download any"
 	apkRemoveTrusted = "Yes"
 	apkKeepAll       = "No"
+
+	flagInvalidCertificate  = "invalid_certificate"
+	flagMasqueradingPreload = "masquerading_preload"
 )
 
+// Partitions of the manufacturer, where packages named after the Android
+// platform are not expected.
+var oemPartitions = map[string]bool{"vendor": true, "odm": true, "oem": true}
+
+// Namespaces of the packages of the Android platform.
+var platformPrefixes = []string{"android.", "com.android.", "com.google.android."}
+
+type PackageSource struct {
+	Name             string `json:"name"`
+	Installer        string `json:"installer"`
+	System           bool   `json:"system"`
+	FirstInstallTime string `json:"first_install_time"`
+	LastUpdateTime   string `json:"last_update_time"`
+}
+
+type PackageSourcesReport struct {
+	PlayStore  []PackageSource `json:"play_store"`
+	OEM        []PackageSource `json:"oem"`
+	Sideloaded []PackageSource `json:"sideloaded"`
+}
+
+type PackagePartitionEntry struct {
+	Name      string   `json:"name"`
+	Paths     []string `json:"paths"`
+	System    bool     `json:"system"`
+	Installer string   `json:"installer"`
+}
+
 type Packages struct {
 	StoragePath string
 	ApksPath    string
@@ -58,27 +90,129 @@ func (p *Packages) getPathToLocalCopy(packageName, filePath string) string {
 		)
 	}
 
-	localPath := filepath.Join(p.ApksPath, fmt.Sprintf("%s%s.apk", packageName, fileName))
+	localPath := filepath.Join(p.ApksPath, utils.SanitizeFileName(fmt.Sprintf("%s%s.apk", packageName, fileName)))
 	counter := 0
 	for {
-		if _, err := os.Stat(localPath); os.IsNotExist(err) {
+		if _, err := os.Stat(utils.LongPath(localPath)); os.IsNotExist(err) {
 			break
 		}
 
 		counter++
 		localPath = filepath.Join(
 			p.ApksPath,
-			fmt.Sprintf("%s%s_%d.apk", packageName, fileName, counter),
+			utils.SanitizeFileName(fmt.Sprintf("%s%s_%d.apk", packageName, fileName, counter)),
 		)
 	}
 
 	return localPath
 }
 
+func (p *Packages) saveSourcesReport(packages []adb.Package) error {
+	report := PackageSourcesReport{
+		PlayStore:  []PackageSource{},
+		OEM:        []PackageSource{},
+		Sideloaded: []PackageSource{},
+	}
+
+	for _, pkg := range packages {
+		source := PackageSource{
+			Name:             pkg.Name,
+			Installer:        pkg.Installer,
+			System:           pkg.System,
+			FirstInstallTime: pkg.FirstInstallTime,
+			LastUpdateTime:   pkg.LastUpdateTime,
+		}
+
+		switch pkg.InstallSource() {
+		case utils.InstallSourcePlayStore:
+			report.PlayStore = append(report.PlayStore, source)
+		case utils.InstallSourceOEM:
+			report.OEM = append(report.OEM, source)
+		default:
+			report.Sideloaded = append(report.Sideloaded, source)
+		}
+	}
+
+	log.Infof("Found %d packages installed from the Play Store, %d from the manufacturer and %d sideloaded",
+		len(report.PlayStore), len(report.OEM), len(report.Sideloaded))
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "package_sources.json"), &report)
+}
+
+// savePartitionsReport stores the packages grouped by the partition storing
+// their APKs.
+func (p *Packages) savePartitionsReport(packages []adb.Package) error {
+	report := map[string][]PackagePartitionEntry{}
+	for _, pkg := range packages {
+		entry := PackagePartitionEntry{
+			Name:      pkg.Name,
+			Paths:     []string{},
+			System:    pkg.System,
+			Installer: pkg.Installer,
+		}
+		for _, file := range pkg.Files {
+			entry.Paths = append(entry.Paths, file.Path)
+		}
+		partition := pkg.Partition
+		if partition == "" {
+			partition = "unknown"
+		}
+		report[partition] = append(report[partition], entry)
+	}
+
+	counts := []string{}
+	for _, partition := range []string{"system", "system_ext", "product", "vendor", "odm", "oem", "apex", "data"} {
+		if len(report[partition]) > 0 {
+			counts = append(counts, fmt.Sprintf("%d in %s", len(report[partition]), partition))
+		}
+	}
+	log.Infof("Found packages stored in each partition: %s", strings.Join(counts, ", "))
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "package_partitions.json"), &report)
+}
+
+// isMasqueradingPreload returns whether a package stored in a partition of
+// the manufacturer is named after the Android platform, as implants do to
+// blend in with the preloaded apps.
+func isMasqueradingPreload(pkg adb.Package) bool {
+	if !oemPartitions[pkg.Partition] {
+		return false
+	}
+	for _, prefix := range platformPrefixes {
+		if strings.HasPrefix(pkg.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EstimateSize returns the total size of the APKs installed on the device.
+func (p *Packages) EstimateSize(acq *acquisition.Acquisition) (int64, error) {
+	if profile, _ := GetProfile(acq.Profile); profile.SkipAPKs {
+		return 0, errNotEnabled
+	}
+
+	out, err := adb.Client.Shell("for p in $(pm list packages -f | sed -e 's/^package://' -e 's/=[^=]*$//'); " +
+		"do stat -c %s \"$(dirname $p)\"/*.apk; done 2>/dev/null")
+	if err != nil && out == "" {
+		return 0, err
+	}
+
+	var total int64
+	for _, line := range strings.Split(out, "\n") {
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+		if err == nil {
+			total += size
+		}
+	}
+	return total, nil
+}
+
 func (p *Packages) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting information on installed apps. This might take a while...")
 
-	packages, err := adb.Client.GetPackages(fast)
+	// In hash-only mode the hashes computed on the device are all we keep.
+	packages, err := adb.Client.GetPackages(fast && !acq.HashOnly)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve list of installed packages: %v", err)
 	}
@@ -88,14 +222,37 @@ func (p *Packages) Run(acq *acquisition.Acquisition, fast bool) error {
 		len(packages),
 	)
 
-	fmt.Println("Would you like to download copies of all apps or only non-system ones?")
-	downloadPrompt := promptui.Select{
-		Label: "Download",
-		Items: []string{apkAll, apkNotSystem, apkNone},
+	err = p.saveSourcesReport(packages)
+	if err != nil {
+		log.Errorf("Failed to save package sources report: %v", err)
 	}
-	_, download, err := downloadPrompt.Run()
+	err = p.savePartitionsReport(packages)
 	if err != nil {
-		return fmt.Errorf("failed to make selection for download option: %v", err)
+		log.Errorf("Failed to save package partitions report: %v", err)
+	}
+
+	for ip := range packages {
+		packages[ip].FirstInstallTimeUTC = acq.DeviceTimeToUTC(acquisition.PackageTimeFormat,
+			packages[ip].FirstInstallTime)
+		packages[ip].LastUpdateTimeUTC = acq.DeviceTimeToUTC(acquisition.PackageTimeFormat,
+			packages[ip].LastUpdateTime)
+		if isMasqueradingPreload(packages[ip]) {
+			packages[ip].Flag(flagMasqueradingPreload)
+		}
+	}
+
+	download := apkNone
+	profile, _ := GetProfile(acq.Profile)
+	if acq.HashOnly {
+		log.Info("Not downloading copies of apps in hash-only mode")
+	} else if profile.SkipAPKs {
+		log.Infof("Not downloading copies of apps with profile %s", profile.Name)
+	} else {
+		fmt.Println("Would you like to download copies of all apps or only non-system ones?")
+		download, err = utils.Select("download_apks", "Download", []string{apkAll, apkNotSystem, apkNone})
+		if err != nil {
+			return fmt.Errorf("failed to make selection for download option: %v", err)
+		}
 	}
 
 	// If the user decides to not download any APK, then we skip this.
@@ -104,16 +261,16 @@ func (p *Packages) Run(acq *acquisition.Acquisition, fast bool) error {
 
 		// Ask if the user want to remove trusted packages
 		fmt.Println("Would you like to remove copies of apps signed with a trusted certificate to limit the size of the output folder?")
-		promptAll := promptui.Select{
-			Label: "Remove",
-			Items: []string{apkRemoveTrusted, apkKeepAll},
-		}
-		_, keepOption, err := promptAll.Run()
+		keepOption, err := utils.Select("remove_trusted_apks", "Remove", []string{apkRemoveTrusted, apkKeepAll})
 		if err != nil {
 			return fmt.Errorf("failed to make selection for download option: %v",
 				err)
 		}
 
+		if utils.OutputSinkEnabled() {
+			log.Info("APK certificates are not verified and suspicious APKs are not quarantined when encrypting outputs as they are written")
+		}
+
 		for ip := 0; ip < len(packages); ip++ {
 			// If we the user did not request to download all packages and if
 			// the package is marked as system, we skip it.
@@ -135,6 +292,13 @@ func (p *Packages) Run(acq *acquisition.Acquisition, fast bool) error {
 				}
 
 				log.Debugf("Downloaded %s to %s", packageFile.Path, localPath)
+				relPath, _ := filepath.Rel(p.StoragePath, localPath)
+				packageFile.LocalName = filepath.ToSlash(relPath)
+
+				// The APK is only available in the encrypted archive.
+				if utils.OutputSinkEnabled() {
+					continue
+				}
 
 				// Check the certificate
 				verified, cert, err := utils.VerifyCertificate(localPath)
@@ -162,9 +326,101 @@ func (p *Packages) Run(acq *acquisition.Acquisition, fast bool) error {
 						}
 					}
 				}
+
+				if packageFile.CertificateError != "" {
+					packages[ip].Flag(flagInvalidCertificate)
+				}
 			}
 		}
 	}
 
+	for _, pkg := range packages {
+		for _, flag := range pkg.Flags {
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: acquisition.SeverityMedium,
+				Title:    fmt.Sprintf("Package %s is flagged as %s", pkg.Name, strings.Replace(flag, "_", " ", -1)),
+				Source:   p.Name(),
+				File:     p.Name() + "/packages.json",
+				Value:    pkg.Name,
+				Package:  pkg.Name,
+			})
+		}
+	}
+
 	return saveCommandOutputJson(filepath.Join(p.StoragePath, "packages.json"), &packages)
 }
+
+// QuarantinePackages stores the copies of the apps flagged by heuristics or
+// indicators of compromise in a password-protected archive, in case an
+// antivirus deletes the original ones. It runs once the indicators have been
+// checked, and marks the quarantined files in packages.json.
+func QuarantinePackages(acq *acquisition.Acquisition) error {
+	if utils.OutputSinkEnabled() {
+		return nil
+	}
+
+	storagePath := acq.ModulePath("packages")
+	packagesPath := filepath.Join(storagePath, "packages.json")
+	data, err := utils.ReadOutput(packagesPath)
+	if err != nil {
+		// The packages module did not run.
+		return nil
+	}
+	var packages []adb.Package
+	err = json.Unmarshal(data, &packages)
+	if err != nil {
+		return fmt.Errorf("failed to parse packages.json: %v", err)
+	}
+
+	flagged := map[string]bool{}
+	for _, name := range acq.FlaggedPackages() {
+		flagged[name] = true
+	}
+
+	var quarantine *utils.QuarantineZip
+	for ip := range packages {
+		if !flagged[packages[ip].Name] {
+			continue
+		}
+		for ipf := range packages[ip].Files {
+			packageFile := &packages[ip].Files[ipf]
+			if packageFile.LocalName == "" || packageFile.TrustedCertificate {
+				continue
+			}
+			localPath := filepath.Join(storagePath, filepath.FromSlash(packageFile.LocalName))
+			if _, err := os.Stat(localPath); err != nil {
+				continue
+			}
+
+			if quarantine == nil {
+				quarantine, err = utils.NewQuarantineZip(
+					filepath.Join(storagePath, "quarantine.zip"),
+					utils.QuarantinePassword)
+				if err != nil {
+					return fmt.Errorf("failed to create quarantine archive: %v", err)
+				}
+			}
+			err = quarantine.Add(filepath.Base(localPath), localPath)
+			if err != nil {
+				log.Errorf("Failed to quarantine %s: %v", localPath, err)
+				continue
+			}
+			packageFile.Quarantined = true
+			log.Debugf("Quarantined suspicious APK %s", localPath)
+		}
+	}
+	if quarantine == nil {
+		return nil
+	}
+
+	err = quarantine.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close quarantine archive: %v", err)
+	}
+	err = saveCommandOutputJson(packagesPath, &packages)
+	if err != nil {
+		return err
+	}
+	return acq.UpdateModuleManifest("packages")
+}
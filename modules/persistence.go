@@ -0,0 +1,245 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// Foreground services running for longer than this are considered
+// long-lived.
+const longLivedServiceAge = 24 * time.Hour
+
+type ExactAlarmGrant struct {
+	Package string `json:"package"`
+	System  bool   `json:"system"`
+	// Permission through which exact alarms are allowed.
+	Permission string `json:"permission"`
+}
+
+type LongLivedService struct {
+	Package string    `json:"package"`
+	Service string    `json:"service"`
+	System  bool      `json:"system"`
+	Started time.Time `json:"started"`
+	// Hours since the service was started.
+	RunningHours int `json:"running_hours"`
+}
+
+type PersistenceReport struct {
+	ExactAlarms        []ExactAlarmGrant  `json:"exact_alarms"`
+	ForegroundServices []LongLivedService `json:"foreground_services"`
+}
+
+// Persistence reports the packages allowed to schedule exact alarms and
+// those running long-lived foreground services, which can be used to keep
+// an app running and wake it up at any time.
+type Persistence struct {
+	StoragePath string
+}
+
+func NewPersistence() *Persistence {
+	return &Persistence{}
+}
+
+func (p *Persistence) Name() string {
+	return "persistence"
+}
+
+func (p *Persistence) InitStorage(storagePath string) error {
+	p.StoragePath = storagePath
+	return nil
+}
+
+// exactAlarmGrants returns the packages allowed to schedule exact alarms.
+// USE_EXACT_ALARM is granted at install, while SCHEDULE_EXACT_ALARM can be
+// revoked by the user through its app op.
+func exactAlarmGrants(acq *acquisition.Acquisition, system map[string]bool) ([]ExactAlarmGrant, error) {
+	grants := []ExactAlarmGrant{}
+	if !acq.Capabilities.AtLeast(31) {
+		// Exact alarms do not require a permission before Android 12.
+		return grants, nil
+	}
+
+	out, err := adb.Client.Shell("dumpsys", "package")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run `adb shell dumpsys package`: %v", err)
+	}
+	granted := parseGrantedPermissions(out)
+
+	revoked := map[string]bool{}
+	if acq.Capabilities.Has("cmd") {
+		for _, mode := range []string{"ignore", "deny", "errored"} {
+			packages, _ := appOpsPackages("SCHEDULE_EXACT_ALARM", mode)
+			for _, pkg := range packages {
+				revoked[pkg] = true
+			}
+		}
+	}
+
+	for pkg, permissions := range granted {
+		switch {
+		case permissions["android.permission.USE_EXACT_ALARM"]:
+			grants = append(grants, ExactAlarmGrant{pkg, system[pkg], "USE_EXACT_ALARM"})
+		case permissions["android.permission.SCHEDULE_EXACT_ALARM"] && !revoked[pkg]:
+			grants = append(grants, ExactAlarmGrant{pkg, system[pkg], "SCHEDULE_EXACT_ALARM"})
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].Package < grants[j].Package
+	})
+
+	return grants, nil
+}
+
+func (p *Persistence) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting exact alarm grants and long-lived foreground services...")
+
+	report := PersistenceReport{}
+	system := systemPackages()
+
+	var err error
+	report.ExactAlarms, err = exactAlarmGrants(acq, system)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	out, err := adb.Client.Shell("dumpsys", "activity", "services")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys activity services`: %v", err)
+	}
+	report.ForegroundServices = []LongLivedService{}
+	for _, service := range parseRunningServices(out, now) {
+		if !service.Foreground || service.Started.IsZero() || now.Sub(service.Started) < longLivedServiceAge {
+			continue
+		}
+		report.ForegroundServices = append(report.ForegroundServices, LongLivedService{
+			Package:      service.Package,
+			Service:      service.Service,
+			System:       system[service.Package],
+			Started:      service.Started,
+			RunningHours: int(now.Sub(service.Started).Hours()),
+		})
+	}
+
+	alarms := map[string]bool{}
+	for _, grant := range report.ExactAlarms {
+		alarms[grant.Package] = true
+	}
+	seen := map[string]bool{}
+	for _, service := range report.ForegroundServices {
+		if service.System || seen[service.Package] {
+			continue
+		}
+		seen[service.Package] = true
+
+		// Exact alarms allow restarting the app on schedule if its
+		// service is ever stopped.
+		severity := acquisition.SeverityLow
+		title := fmt.Sprintf("Non-system package runs a long-lived foreground service: %s", service.Package)
+		if alarms[service.Package] {
+			severity = acquisition.SeverityMedium
+			title = fmt.Sprintf("Non-system package runs a long-lived foreground service and can schedule exact alarms: %s",
+				service.Package)
+		}
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: severity,
+			Title:    title,
+			Source:   p.Name(),
+			File:     p.Name() + "/persistence.json",
+			Value:    service.Service,
+			Package:  service.Package,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "persistence.json"), &report)
+}
+
+type RebootService struct {
+	Package string `json:"package"`
+	Service string `json:"service"`
+	// Whether the package ran a long-lived foreground service before the
+	// reboot.
+	LongLivedBefore bool `json:"long_lived_before"`
+}
+
+// RunAfterReboot lists the foreground services of non-system packages
+// running after the reboot, which were started at boot.
+func (p *Persistence) RunAfterReboot(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting foreground services started at boot...")
+
+	out, err := adb.Client.Shell("dumpsys", "activity", "services")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys activity services`: %v", err)
+	}
+
+	longLived := map[string]bool{}
+	data, err := utils.ReadOutput(filepath.Join(p.StoragePath, "persistence.json"))
+	if err == nil {
+		var before PersistenceReport
+		if json.Unmarshal(data, &before) == nil {
+			for _, service := range before.ForegroundServices {
+				longLived[service.Package] = true
+			}
+		}
+	}
+
+	system := systemPackages()
+	services := []RebootService{}
+	seen := map[string]bool{}
+	for _, service := range parseRunningServices(out, time.Now().UTC()) {
+		if !service.Foreground || system[service.Package] {
+			continue
+		}
+		services = append(services, RebootService{
+			Package:         service.Package,
+			Service:         service.Service,
+			LongLivedBefore: longLived[service.Package],
+		})
+		if seen[service.Package] {
+			continue
+		}
+		seen[service.Package] = true
+
+		severity := acquisition.SeverityLow
+		title := fmt.Sprintf("Non-system package starts a foreground service at boot: %s", service.Package)
+		if longLived[service.Package] {
+			severity = acquisition.SeverityMedium
+			title = fmt.Sprintf("Non-system package keeps a foreground service running across reboots: %s",
+				service.Package)
+		}
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: severity,
+			Title:    title,
+			Source:   p.Name(),
+			File:     p.Name() + "/persistence_after_reboot.json",
+			Value:    service.Service,
+			Package:  service.Package,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Package != services[j].Package {
+			return services[i].Package < services[j].Package
+		}
+		return services[i].Service < services[j].Service
+	})
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "persistence_after_reboot.json"), &services)
+}
@@ -0,0 +1,125 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+const (
+	preflightProceed = "Proceed with all modules"
+	preflightChoose  = "Choose which modules to skip"
+
+	// The operator is only asked to choose modules to skip for acquisitions
+	// larger than this.
+	preflightPromptSize = 1024 * 1024 * 1024
+)
+
+// errNotEnabled is returned by EstimateSize for modules which will not pull
+// anything because they are not enabled.
+var errNotEnabled = errors.New("module is not enabled")
+
+// SizeEstimator is implemented by modules which pull files from the device,
+// and can estimate how much data they will transfer.
+type SizeEstimator interface {
+	EstimateSize(acq *acquisition.Acquisition) (int64, error)
+}
+
+// folderSize returns the total size of the readable files in a folder on
+// the device, preferably using the collector.
+func folderSize(acq *acquisition.Acquisition, path string) (int64, error) {
+	if acq.Collector != nil {
+		files, err := acq.Collector.Find(path)
+		if err == nil {
+			var total int64
+			for _, file := range files {
+				if !strings.HasPrefix(file.Mode, "d") {
+					total += file.Size
+				}
+			}
+			return total, nil
+		}
+	}
+
+	out, err := adb.Client.Shell("du", "-sk", path, "2>", "/dev/null")
+	if err != nil && out == "" {
+		return 0, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected output of du: %s", out)
+	}
+	kb, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return kb * 1024, nil
+}
+
+// Preflight estimates the size of the data pulled by each module and
+// presents it. If the acquisition is large, it lets the operator choose
+// modules to skip. It returns the names of the modules to skip.
+func Preflight(acq *acquisition.Acquisition, mods []Module) map[string]bool {
+	skip := map[string]bool{}
+
+	log.Info("Estimating the size of the acquisition...")
+
+	estimates := map[string]int64{}
+	names := []string{}
+	var total int64
+	for _, mod := range mods {
+		estimator, ok := mod.(SizeEstimator)
+		if !ok {
+			continue
+		}
+		if hw, ok := mod.(HardwareModule); ok && hw.RequiresHardware() && acq.Emulator.Detected {
+			continue
+		}
+		size, err := estimator.EstimateSize(acq)
+		if errors.Is(err, errNotEnabled) {
+			continue
+		} else if err != nil {
+			log.Debugf("Failed to estimate the size of module %s: %v", mod.Name(), err)
+			continue
+		}
+		estimates[mod.Name()] = size
+		names = append(names, mod.Name())
+		total += size
+	}
+	if len(names) == 0 {
+		return skip
+	}
+
+	for _, name := range names {
+		log.Infof("- %s: up to %s", name, utils.FormatByteSize(estimates[name]))
+	}
+	log.Infof("The acquisition might require up to %s", utils.FormatByteSize(total))
+	if total < preflightPromptSize {
+		return skip
+	}
+
+	choice, err := utils.Select("preflight", "Size", []string{preflightProceed, preflightChoose})
+	if err != nil || choice == preflightProceed {
+		return skip
+	}
+
+	for _, name := range names {
+		if !utils.Confirm("run_module_"+name, fmt.Sprintf("Run module %s (up to %s)?", name,
+			utils.FormatByteSize(estimates[name]))) {
+			skip[name] = true
+		}
+	}
+
+	return skip
+}
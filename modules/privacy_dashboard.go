@@ -0,0 +1,103 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Maximum number of discrete accesses printed for each app op. The history
+// covers the last 7 days.
+const discreteAccessesLimit = "10000"
+
+// App ops shown in the privacy dashboard, grouped by permission.
+var privacyDashboardOps = map[string]string{
+	"CAMERA":                MediaCamera,
+	"PHONE_CALL_CAMERA":     MediaCamera,
+	"RECORD_AUDIO":          MediaMicrophone,
+	"PHONE_CALL_MICROPHONE": MediaMicrophone,
+	"FINE_LOCATION":         "location",
+	"COARSE_LOCATION":       "location",
+}
+
+type PermissionUsage struct {
+	AppOpAccess
+	Permission string `json:"permission"`
+	System     bool   `json:"system"`
+}
+
+// PrivacyDashboard collects the history of camera, microphone and location
+// accesses behind the privacy dashboard of Android 12 and later, so that
+// the timeline of the last 7 days is preserved in the acquisition.
+type PrivacyDashboard struct {
+	StoragePath string
+}
+
+func NewPrivacyDashboard() *PrivacyDashboard {
+	return &PrivacyDashboard{}
+}
+
+func (p *PrivacyDashboard) Name() string {
+	return "privacy_dashboard"
+}
+
+func (p *PrivacyDashboard) InitStorage(storagePath string) error {
+	p.StoragePath = storagePath
+	return nil
+}
+
+func (p *PrivacyDashboard) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting permission usage history...")
+
+	if !acq.Capabilities.AtLeast(31) {
+		log.Info("The permission usage history is only kept since Android 12, skipping")
+		return nil
+	}
+
+	out, err := adb.Client.Shell("dumpsys", "appops", "--include-discrete", discreteAccessesLimit)
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys appops --include-discrete`: %v", err)
+	}
+	err = saveCommandOutput(filepath.Join(p.StoragePath, "appops_discrete.txt"), out)
+	if err != nil {
+		return err
+	}
+
+	ops := map[string]bool{}
+	for op := range privacyDashboardOps {
+		ops[op] = true
+	}
+	system := systemPackages()
+	usages := []PermissionUsage{}
+	seen := map[AppOpAccess]bool{}
+	for _, access := range parseAppOpsAccesses(out, ops) {
+		// The last access of each op is also printed outside of the
+		// discrete history.
+		if seen[access] {
+			continue
+		}
+		seen[access] = true
+
+		access.TimeUTC = acq.DeviceTimeToUTC(appOpsTimeFormat, access.Time)
+		usages = append(usages, PermissionUsage{
+			AppOpAccess: access,
+			Permission:  privacyDashboardOps[access.Op],
+			System:      system[access.Package],
+		})
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].Time < usages[j].Time
+	})
+	log.Debugf("Found %d camera, microphone and location accesses", len(usages))
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "privacy_dashboard.json"), &usages)
+}
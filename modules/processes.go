@@ -5,12 +5,16 @@
 package modules
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type Processes struct {
@@ -32,19 +36,98 @@ func (p *Processes) InitStorage(storagePath string) error {
 
 func (p *Processes) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting list of running processes...")
+	return p.collect(acq, "processes.txt")
+}
 
+func (p *Processes) collect(acq *acquisition.Acquisition, fileName string) error {
 	if acq.Collector == nil {
 		out, err := adb.Client.Shell("ps -A")
 		if err != nil {
 			return fmt.Errorf("failed to run `adb shell ps -A`: %v", err)
 		}
 
-		return saveCommandOutput(filepath.Join(p.StoragePath, "processes.txt"), out)
+		return saveCommandOutput(filepath.Join(p.StoragePath, fileName), out)
 	} else {
 		out, err := acq.Collector.Processes()
 		if err != nil {
 			return err
 		}
-		return saveCommandOutputJson(filepath.Join(p.StoragePath, "processes.txt"), &out)
+		return saveCommandOutputJson(filepath.Join(p.StoragePath, fileName), &out)
+	}
+}
+
+// ProcessesRebootDiff compares the processes running before and after the
+// device was rebooted. Kernel threads are ignored.
+type ProcessesRebootDiff struct {
+	// Running before the reboot only, e.g. started by the user or by an app
+	// which did not start again.
+	BeforeOnly []string `json:"before_only"`
+	// Running after the reboot only, started at boot.
+	AfterOnly []string `json:"after_only"`
+	Both      int      `json:"both"`
+}
+
+// processNames returns the names of the processes listed in the output of
+// the collector or of `ps -A`, other than kernel threads.
+func processNames(data []byte) map[string]bool {
+	names := map[string]bool{}
+	var processes []adb.ProcessInfo
+	if json.Unmarshal(data, &processes) == nil {
+		for _, process := range processes {
+			if process.Pid != 2 && process.Ppid != 2 {
+				names[process.Filename] = true
+			}
+		}
+		return names
+	}
+
+	// USER PID PPID VSZ RSS WCHAN ADDR S NAME
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || fields[1] == "PID" || fields[1] == "2" || fields[2] == "2" {
+			continue
+		}
+		names[fields[len(fields)-1]] = true
+	}
+	return names
+}
+
+func (p *Processes) RunAfterReboot(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting list of running processes after the reboot...")
+	err := p.collect(acq, "processes_after_reboot.txt")
+	if err != nil {
+		return err
+	}
+
+	before, err := utils.ReadOutput(filepath.Join(p.StoragePath, "processes.txt"))
+	if err != nil {
+		// The outputs cannot be read back when they are encrypted.
+		log.Debugf("Not comparing the processes before and after the reboot: %v", err)
+		return nil
+	}
+	after, err := utils.ReadOutput(filepath.Join(p.StoragePath, "processes_after_reboot.txt"))
+	if err != nil {
+		log.Debugf("Not comparing the processes before and after the reboot: %v", err)
+		return nil
+	}
+
+	namesBefore := processNames(before)
+	namesAfter := processNames(after)
+	diff := ProcessesRebootDiff{BeforeOnly: []string{}, AfterOnly: []string{}}
+	for name := range namesBefore {
+		if namesAfter[name] {
+			diff.Both++
+		} else {
+			diff.BeforeOnly = append(diff.BeforeOnly, name)
+		}
 	}
+	for name := range namesAfter {
+		if !namesBefore[name] {
+			diff.AfterOnly = append(diff.AfterOnly, name)
+		}
+	}
+	sort.Strings(diff.BeforeOnly)
+	sort.Strings(diff.AfterOnly)
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "processes_reboot_diff.json"), &diff)
 }
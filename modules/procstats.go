@@ -0,0 +1,158 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// PackageProcessStats combines the process statistics and the current memory
+// usage of a package.
+type PackageProcessStats struct {
+	Package string `json:"package"`
+	UID     string `json:"uid"`
+	Version string `json:"version"`
+	// Share of the procstats period during which the package was running.
+	Total   string `json:"total"`
+	PIDs    []int  `json:"pids"`
+	PSSKB   int    `json:"pss_kb"`
+	Flagged bool   `json:"flagged"`
+}
+
+var (
+	procstatsPackageRegexp = regexp.MustCompile(`^\s*\* (\S+) / (\S+) / v(\d+):$`)
+	meminfoProcessRegexp   = regexp.MustCompile(`^\s*([\d,]+)K: (\S+) \(pid (\d+)`)
+)
+
+type Procstats struct {
+	StoragePath string
+}
+
+func NewProcstats() *Procstats {
+	return &Procstats{}
+}
+
+func (p *Procstats) Name() string {
+	return "procstats"
+}
+
+func (p *Procstats) InitStorage(storagePath string) error {
+	p.StoragePath = storagePath
+	return nil
+}
+
+// parseProcstats extracts the packages listed in `dumpsys procstats`.
+func parseProcstats(out string, stats map[string]*PackageProcessStats) {
+	var current *PackageProcessStats
+	for _, line := range strings.Split(out, "\n") {
+		if match := procstatsPackageRegexp.FindStringSubmatch(line); match != nil {
+			if _, ok := stats[match[1]]; ok {
+				current = nil
+				continue
+			}
+			current = &PackageProcessStats{
+				Package: match[1],
+				UID:     match[2],
+				Version: match[3],
+				PIDs:    []int{},
+			}
+			stats[match[1]] = current
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if current != nil && strings.HasPrefix(trimmed, "TOTAL:") {
+			current.Total = strings.TrimSpace(strings.TrimPrefix(trimmed, "TOTAL:"))
+			current = nil
+		}
+	}
+}
+
+// parseMeminfo extracts the PSS of each process from `dumpsys meminfo`.
+func parseMeminfo(out string, stats map[string]*PackageProcessStats) {
+	inPSS := false
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "Total PSS by process") {
+			inPSS = true
+			continue
+		}
+		if !inPSS {
+			continue
+		}
+		if trimmed == "" {
+			break
+		}
+
+		match := meminfoProcessRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		// Processes are named after the package, with an optional suffix.
+		pkg := strings.Split(match[2], ":")[0]
+		entry, ok := stats[pkg]
+		if !ok {
+			entry = &PackageProcessStats{Package: pkg, PIDs: []int{}}
+			stats[pkg] = entry
+		}
+		pss, _ := strconv.Atoi(strings.ReplaceAll(match[1], ",", ""))
+		pid, _ := strconv.Atoi(match[3])
+		entry.PSSKB += pss
+		entry.PIDs = append(entry.PIDs, pid)
+	}
+}
+
+func (p *Procstats) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting process statistics and memory usage...")
+
+	procstats, err := adb.Client.Shell("dumpsys", "procstats", "--full-details")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys procstats`: %v", err)
+	}
+	err = saveCommandOutput(filepath.Join(p.StoragePath, "procstats.txt"), procstats)
+	if err != nil {
+		return err
+	}
+
+	meminfo, err := adb.Client.Shell("dumpsys", "meminfo")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys meminfo`: %v", err)
+	}
+	err = saveCommandOutput(filepath.Join(p.StoragePath, "meminfo.txt"), meminfo)
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]*PackageProcessStats{}
+	parseProcstats(procstats, stats)
+	parseMeminfo(meminfo, stats)
+
+	flagged := map[string]bool{}
+	for _, pkg := range acq.FlaggedPackages() {
+		flagged[pkg] = true
+	}
+
+	results := make([]PackageProcessStats, 0, len(stats))
+	for _, entry := range stats {
+		entry.Flagged = flagged[entry.Package]
+		if entry.Flagged && (entry.Total != "" || len(entry.PIDs) > 0) {
+			log.Warningf("Flagged package %s has running processes", entry.Package)
+		}
+		results = append(results, *entry)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Package < results[j].Package })
+
+	return saveCommandOutputJson(filepath.Join(p.StoragePath, "procstats.json"), &results)
+}
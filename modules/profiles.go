@@ -0,0 +1,106 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Profile is a named preset of modules and options.
+type Profile struct {
+	Name        string
+	Description string
+	// Modules which are not run with this profile.
+	Exclude []string
+	// If set, only these modules are run, in this order.
+	Include []string
+	Fast    bool
+	// Do not download copies of the installed apps.
+	SkipAPKs bool
+	// Do not upload the collector to the device.
+	NoCollector bool
+}
+
+var profiles = map[string]Profile{
+	"triage": {
+		Name:        "triage",
+		Description: "Walk-in triage in under two minutes: device properties, settings, apps and their grants, local heuristics only",
+		Include: []string{
+			"getprop", "settings", "checks", "packages", "device_policy", "risk_matrix",
+			"install_unknown_apps", "broadcast_receivers", "flagged_packages",
+			"recheck",
+		},
+		Fast:        true,
+		SkipAPKs:    true,
+		NoCollector: true,
+	},
+	"quick": {
+		Name:        "quick",
+		Description: "Triage in a few minutes: no backup, no copies of apps, no file listing",
+		Exclude:     []string{"backup", "bugreport", "files", "search", "system_integrity", "logs", "sdcard"},
+		Fast:        true,
+		SkipAPKs:    true,
+	},
+	"standard": {
+		Name:        "standard",
+		Description: "All modules except the slowest ones (bugreport, system integrity)",
+		Exclude:     []string{"bugreport", "system_integrity"},
+	},
+	"full": {
+		Name:        "full",
+		Description: "All modules",
+		Exclude:     []string{},
+	},
+}
+
+// Profiles returns all the available profiles sorted by name.
+func Profiles() []Profile {
+	list := make([]Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetProfile returns the profile with the given name. An empty name returns
+// the full profile.
+func GetProfile(name string) (Profile, error) {
+	if name == "" {
+		name = "full"
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %s", name)
+	}
+	return profile, nil
+}
+
+// Includes returns true if the module with the given name is run with this
+// profile.
+func (p Profile) Includes(module string) bool {
+	if p.Include != nil {
+		return p.Rank(module) < len(p.Include)
+	}
+	for _, excluded := range p.Exclude {
+		if excluded == module {
+			return false
+		}
+	}
+	return true
+}
+
+// Rank returns the position of the module with the given name in the order
+// of this profile. Without an order, modules keep their default one.
+func (p Profile) Rank(module string) int {
+	for i, included := range p.Include {
+		if included == module {
+			return i
+		}
+	}
+	return len(p.Include)
+}
@@ -0,0 +1,302 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// RecheckPackage is the state of an installed package recorded in a re-check
+// bundle.
+type RecheckPackage struct {
+	System         bool     `json:"system"`
+	LastUpdateTime string   `json:"last_update_time"`
+	SHA256         []string `json:"sha256"`
+}
+
+// RecheckBundle records the state of a device, for a later acquisition to
+// report what changed in between.
+type RecheckBundle struct {
+	AcquisitionUUID string                    `json:"acquisition_uuid"`
+	Created         time.Time                 `json:"created"`
+	SuggestedDate   string                    `json:"suggested_date"`
+	Manufacturer    string                    `json:"manufacturer"`
+	Model           string                    `json:"model"`
+	Fingerprint     string                    `json:"fingerprint"`
+	PatchLevel      string                    `json:"patch_level"`
+	Packages        map[string]RecheckPackage `json:"packages"`
+	// SHA256 of the files of the system partitions, when they were hashed.
+	SystemHashes map[string]string `json:"system_hashes"`
+}
+
+type RecheckComparison struct {
+	Baseline            string             `json:"baseline"`
+	BaselineAcquisition string             `json:"baseline_acquisition"`
+	BaselineCreated     time.Time          `json:"baseline_created"`
+	FingerprintChanged  bool               `json:"fingerprint_changed"`
+	PatchLevel          string             `json:"patch_level"`
+	BaselinePatchLevel  string             `json:"baseline_patch_level"`
+	InstalledPackages   []string           `json:"installed_packages"`
+	RemovedPackages     []string           `json:"removed_packages"`
+	UpdatedPackages     []string           `json:"updated_packages"`
+	ReplacedPackages    []string           `json:"replaced_packages"`
+	ModifiedSystemFiles []SystemFileChange `json:"modified_system_files"`
+}
+
+// Recheck compares the device with the re-check bundle of a previous
+// acquisition given with -baseline, and emits a new bundle when requested
+// with -recheck-days, to follow the devices of at-risk users over time. It
+// runs after the other modules, whose results it reuses.
+type Recheck struct {
+	StoragePath string
+}
+
+func NewRecheck() *Recheck {
+	return &Recheck{}
+}
+
+func (r *Recheck) Name() string {
+	return "recheck"
+}
+
+func (r *Recheck) RunsAfterAnalysis() bool {
+	return true
+}
+
+func (r *Recheck) Dependencies() []string {
+	return []string{"packages"}
+}
+
+func (r *Recheck) InitStorage(storagePath string) error {
+	r.StoragePath = storagePath
+	return nil
+}
+
+// loadRecheckBundle loads a re-check bundle from a path or URL.
+func loadRecheckBundle(location string) (*RecheckBundle, error) {
+	data, err := utils.ReadLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	var bundle RecheckBundle
+	err = json.Unmarshal(data, &bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse re-check bundle: %v", err)
+	}
+	return &bundle, nil
+}
+
+// currentBundle builds the re-check bundle of this acquisition from the
+// results of the packages and system integrity modules.
+func (r *Recheck) currentBundle(acq *acquisition.Acquisition) *RecheckBundle {
+	bundle := RecheckBundle{
+		AcquisitionUUID: acq.UUID,
+		Created:         time.Now().UTC(),
+		Packages:        map[string]RecheckPackage{},
+		SystemHashes:    map[string]string{},
+	}
+	if acq.Device != nil {
+		bundle.Manufacturer = acq.Device.Manufacturer
+		bundle.Model = acq.Device.Model
+		bundle.Fingerprint = acq.Device.Fingerprint
+		bundle.PatchLevel = acq.Device.PatchLevel
+	}
+
+	data, err := utils.ReadOutput(filepath.Join(acq.ModulePath("packages"), "packages.json"))
+	if err != nil {
+		log.Debug("The packages module did not run, the re-check bundle does not list packages")
+	} else {
+		var packages []adb.Package
+		err = json.Unmarshal(data, &packages)
+		if err != nil {
+			log.Errorf("Failed to parse packages.json: %v", err)
+		}
+		for _, pkg := range packages {
+			hashes := []string{}
+			for _, file := range pkg.Files {
+				if file.SHA256 != "" {
+					hashes = append(hashes, strings.ToLower(file.SHA256))
+				}
+			}
+			sort.Strings(hashes)
+			bundle.Packages[pkg.Name] = RecheckPackage{
+				System:         pkg.System,
+				LastUpdateTime: pkg.LastUpdateTime,
+				SHA256:         hashes,
+			}
+		}
+	}
+
+	data, err = utils.ReadOutput(filepath.Join(acq.ModulePath("system_integrity"), "system_hashes.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &bundle.SystemHashes)
+		if err != nil {
+			log.Errorf("Failed to parse system_hashes.json: %v", err)
+		}
+	}
+
+	return &bundle
+}
+
+// compareRecheckBundles returns the changes between the baseline bundle and
+// the current one. System files are only compared if the firmware did not
+// change, as an update legitimately modifies them.
+func compareRecheckBundles(baseline, current *RecheckBundle) RecheckComparison {
+	comparison := RecheckComparison{
+		BaselineAcquisition: baseline.AcquisitionUUID,
+		BaselineCreated:     baseline.Created,
+		FingerprintChanged:  baseline.Fingerprint != current.Fingerprint,
+		PatchLevel:          current.PatchLevel,
+		BaselinePatchLevel:  baseline.PatchLevel,
+		InstalledPackages:   []string{},
+		RemovedPackages:     []string{},
+		UpdatedPackages:     []string{},
+		ReplacedPackages:    []string{},
+		ModifiedSystemFiles: []SystemFileChange{},
+	}
+
+	for name, pkg := range current.Packages {
+		previous, ok := baseline.Packages[name]
+		if !ok {
+			comparison.InstalledPackages = append(comparison.InstalledPackages, name)
+			continue
+		}
+		// Hashes are missing when they could not be computed on the device.
+		if len(pkg.SHA256) == 0 || len(previous.SHA256) == 0 ||
+			strings.Join(pkg.SHA256, ",") == strings.Join(previous.SHA256, ",") {
+			continue
+		}
+		if pkg.LastUpdateTime != previous.LastUpdateTime || comparison.FingerprintChanged {
+			comparison.UpdatedPackages = append(comparison.UpdatedPackages, name)
+		} else {
+			comparison.ReplacedPackages = append(comparison.ReplacedPackages, name)
+		}
+	}
+	for name := range baseline.Packages {
+		if _, ok := current.Packages[name]; !ok {
+			comparison.RemovedPackages = append(comparison.RemovedPackages, name)
+		}
+	}
+
+	if !comparison.FingerprintChanged {
+		for path, hash := range current.SystemHashes {
+			previous, ok := baseline.SystemHashes[path]
+			if ok && !strings.EqualFold(previous, hash) {
+				comparison.ModifiedSystemFiles = append(comparison.ModifiedSystemFiles, SystemFileChange{
+					Path:           path,
+					SHA256:         hash,
+					BaselineSHA256: previous,
+				})
+			}
+		}
+	}
+
+	for _, list := range [][]string{
+		comparison.InstalledPackages, comparison.RemovedPackages,
+		comparison.UpdatedPackages, comparison.ReplacedPackages,
+	} {
+		sort.Strings(list)
+	}
+	sort.Slice(comparison.ModifiedSystemFiles, func(i, j int) bool {
+		return comparison.ModifiedSystemFiles[i].Path < comparison.ModifiedSystemFiles[j].Path
+	})
+	return comparison
+}
+
+func (r *Recheck) Run(acq *acquisition.Acquisition, fast bool) error {
+	if acq.RecheckBaseline == "" && acq.RecheckDays <= 0 {
+		log.Debug("No re-check bundle requested or provided, skipping")
+		return nil
+	}
+
+	current := r.currentBundle(acq)
+
+	if acq.RecheckBaseline != "" {
+		log.Info("Comparing the device with the previous acquisition...")
+
+		baseline, err := loadRecheckBundle(acq.RecheckBaseline)
+		if err != nil {
+			log.Errorf("Impossible to load the re-check bundle: %v", err)
+		} else {
+			comparison := compareRecheckBundles(baseline, current)
+			comparison.Baseline = acq.RecheckBaseline
+			if comparison.FingerprintChanged {
+				log.Infof("The firmware changed since the previous acquisition (%s), system files are not compared",
+					current.Fingerprint)
+			}
+			log.Infof("Since the previous acquisition: %d packages installed, %d removed, %d updated",
+				len(comparison.InstalledPackages), len(comparison.RemovedPackages), len(comparison.UpdatedPackages))
+
+			for _, name := range comparison.InstalledPackages {
+				if current.Packages[name].System {
+					continue
+				}
+				acq.AddDetection(acquisition.Detection{
+					Engine:   acquisition.EngineHeuristic,
+					Severity: acquisition.SeverityMedium,
+					Title:    fmt.Sprintf("Package installed since the previous acquisition: %s", name),
+					Source:   r.Name(),
+					File:     r.Name() + "/recheck_comparison.json",
+					Value:    strings.Join(current.Packages[name].SHA256, ","),
+					Package:  name,
+				})
+			}
+			// An APK replaced without the package being updated was not
+			// installed through the package manager.
+			for _, name := range comparison.ReplacedPackages {
+				title := fmt.Sprintf("APK of package %s changed without an update since the previous acquisition", name)
+				log.Warning(title)
+				acq.AddDetection(acquisition.Detection{
+					Engine:   acquisition.EngineHeuristic,
+					Severity: acquisition.SeverityHigh,
+					Title:    title,
+					Source:   r.Name(),
+					File:     r.Name() + "/recheck_comparison.json",
+					Value:    strings.Join(current.Packages[name].SHA256, ","),
+					Package:  name,
+				})
+			}
+			for _, change := range comparison.ModifiedSystemFiles {
+				acq.AddDetection(acquisition.Detection{
+					Engine:   acquisition.EngineHeuristic,
+					Severity: acquisition.SeverityHigh,
+					Title:    fmt.Sprintf("System file %s changed since the previous acquisition", change.Path),
+					Source:   r.Name(),
+					File:     r.Name() + "/recheck_comparison.json",
+					Value:    change.SHA256,
+				})
+			}
+
+			err = saveCommandOutputJson(filepath.Join(r.StoragePath, "recheck_comparison.json"), &comparison)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if acq.RecheckDays > 0 {
+		current.SuggestedDate = current.Created.AddDate(0, 0, acq.RecheckDays).Format("2006-01-02")
+		bundlePath := filepath.Join(r.StoragePath, "recheck.json")
+		err := saveCommandOutputJson(bundlePath, current)
+		if err != nil {
+			return err
+		}
+		log.Infof("Re-check bundle saved to %s, suggested date for the next acquisition: %s",
+			bundlePath, current.SuggestedDate)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// hashRemoteFolder returns the SHA256 of all readable files in the given
+// folder on the device, preferably using the collector.
+func hashRemoteFolder(acq *acquisition.Acquisition, folder string) map[string]string {
+	hashes := map[string]string{}
+
+	if acq.Collector != nil {
+		files, err := acq.Collector.FindHash(folder)
+		if err == nil {
+			for _, file := range files {
+				if file.SHA256 != "" {
+					hashes[file.Path] = file.SHA256
+				}
+			}
+			return hashes
+		}
+		log.Debugf("Failed to hash %s with the collector: %v", folder, err)
+	}
+
+	if !acq.Capabilities.Has("sha256sum") {
+		log.Debugf("The device does not have `sha256sum`, impossible to hash %s", folder)
+		return hashes
+	}
+
+	out, _ := adb.Client.Shell("find", folder, "-type", "f", "-exec", "sha256sum", "{}", "+", "2>", "/dev/null")
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			continue
+		}
+		hashes[strings.TrimSpace(fields[1])] = fields[0]
+	}
+
+	return hashes
+}
+
+// hashRemoteFile returns the SHA256 of a file on the device.
+func hashRemoteFile(path string) (string, bool) {
+	out, err := adb.Client.Shell("sha256sum", path, "2>", "/dev/null")
+	if err != nil {
+		return "", false
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", false
+	}
+	return fields[0], true
+}
@@ -0,0 +1,275 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Non-system packages whose combined risk reaches this score are reported.
+const riskMatrixThreshold = 10
+
+// sensitiveCapability is a capability which can be abused to spy on the
+// user, with the weight it contributes to the risk of a package.
+type sensitiveCapability struct {
+	name        string
+	weight      int
+	permissions []string
+}
+
+// Capabilities granted through permissions are listed first, the others
+// are collected from settings, app ops and device policies.
+var sensitiveCapabilities = []sensitiveCapability{
+	{"sms", 3, []string{
+		"android.permission.READ_SMS", "android.permission.RECEIVE_SMS", "android.permission.SEND_SMS",
+	}},
+	{"location", 2, []string{
+		"android.permission.ACCESS_FINE_LOCATION", "android.permission.ACCESS_COARSE_LOCATION",
+		"android.permission.ACCESS_BACKGROUND_LOCATION",
+	}},
+	{"microphone", 3, []string{"android.permission.RECORD_AUDIO"}},
+	{"camera", 2, []string{"android.permission.CAMERA"}},
+	{"accessibility", 4, nil},
+	{"device_admin", 4, nil},
+	{"notifications", 3, nil},
+	{"overlay", 2, nil},
+}
+
+// PackageRisk lists the sensitive capabilities of a package and their
+// combined risk score.
+type PackageRisk struct {
+	Package      string   `json:"package"`
+	System       bool     `json:"system"`
+	Capabilities []string `json:"capabilities"`
+	Score        int      `json:"score"`
+}
+
+// RiskMatrix ranks packages by the combination of sensitive capabilities
+// they hold, to help prioritize which apps to look at first.
+type RiskMatrix struct {
+	StoragePath string
+}
+
+func NewRiskMatrix() *RiskMatrix {
+	return &RiskMatrix{}
+}
+
+func (r *RiskMatrix) Name() string {
+	return "risk_matrix"
+}
+
+func (r *RiskMatrix) InitStorage(storagePath string) error {
+	r.StoragePath = storagePath
+	return nil
+}
+
+var (
+	packageSectionRegexp    = regexp.MustCompile(`^\s*Package \[([^\]]+)\]`)
+	grantedPermissionRegexp = regexp.MustCompile(`^\s*([\w.]+): granted=true`)
+)
+
+// parseGrantedPermissions returns the permissions granted to each package
+// from the output of `dumpsys package`.
+func parseGrantedPermissions(out string) map[string]map[string]bool {
+	granted := map[string]map[string]bool{}
+	pkg := ""
+	for _, line := range strings.Split(out, "\n") {
+		if match := packageSectionRegexp.FindStringSubmatch(line); match != nil {
+			pkg = match[1]
+			continue
+		}
+		// Permissions of shared users, and other sections, do not belong to
+		// the previous package.
+		if strings.HasPrefix(strings.TrimSpace(line), "SharedUser [") ||
+			(line != "" && line[0] != ' ') {
+			pkg = ""
+			continue
+		}
+		if pkg == "" {
+			continue
+		}
+		if match := grantedPermissionRegexp.FindStringSubmatch(line); match != nil {
+			if granted[pkg] == nil {
+				granted[pkg] = map[string]bool{}
+			}
+			granted[pkg][match[1]] = true
+		}
+	}
+	return granted
+}
+
+// componentPackages returns the packages of a colon-separated list of
+// components, as stored in settings such as enabled_accessibility_services.
+func componentPackages(value string) []string {
+	packages := []string{}
+	if value == "null" {
+		return packages
+	}
+	for _, component := range strings.Split(value, ":") {
+		pkg, _, _ := strings.Cut(strings.TrimSpace(component), "/")
+		if pkg != "" {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+// capabilityHolders returns, for each capability not granted through a
+// permission, the packages holding it.
+func capabilityHolders() map[string][]string {
+	holders := map[string][]string{}
+
+	accessibility, _ := adb.Client.Shell("settings", "get", "secure", "enabled_accessibility_services")
+	holders["accessibility"] = componentPackages(accessibility)
+
+	listeners, _ := adb.Client.Shell("settings", "get", "secure", "enabled_notification_listeners")
+	holders["notifications"] = componentPackages(listeners)
+
+	policy, err := adb.Client.Shell("dumpsys", "device_policy")
+	if err != nil {
+		log.Debugf("Failed to get device admins: %v", err)
+	}
+	report := parseDevicePolicy(policy)
+	for _, admin := range report.Admins {
+		holders["device_admin"] = append(holders["device_admin"], admin.Package)
+	}
+	for _, owner := range report.Owners {
+		holders["device_admin"] = append(holders["device_admin"], owner.Package)
+	}
+
+	overlays, err := appOpsPackages("SYSTEM_ALERT_WINDOW", "allow")
+	if err != nil {
+		log.Debugf("Failed to query SYSTEM_ALERT_WINDOW app op: %v", err)
+	}
+	holders["overlay"] = overlays
+
+	return holders
+}
+
+// rankPackageRisks combines the permissions and other capabilities of each
+// package, and sorts the packages by decreasing risk.
+func rankPackageRisks(granted map[string]map[string]bool, holders map[string][]string,
+	system map[string]bool,
+) []PackageRisk {
+	held := map[string]map[string]bool{}
+	hold := func(pkg, capability string) {
+		if held[pkg] == nil {
+			held[pkg] = map[string]bool{}
+		}
+		held[pkg][capability] = true
+	}
+	for pkg, permissions := range granted {
+		for _, capability := range sensitiveCapabilities {
+			for _, permission := range capability.permissions {
+				if permissions[permission] {
+					hold(pkg, capability.name)
+				}
+			}
+		}
+	}
+	for capability, packages := range holders {
+		for _, pkg := range packages {
+			hold(pkg, capability)
+		}
+	}
+
+	risks := []PackageRisk{}
+	for pkg, capabilities := range held {
+		risk := PackageRisk{Package: pkg, System: system[pkg], Capabilities: []string{}}
+		for _, capability := range sensitiveCapabilities {
+			if capabilities[capability.name] {
+				risk.Capabilities = append(risk.Capabilities, capability.name)
+				risk.Score += capability.weight
+			}
+		}
+		risks = append(risks, risk)
+	}
+	sort.Slice(risks, func(i, j int) bool {
+		if risks[i].Score != risks[j].Score {
+			return risks[i].Score > risks[j].Score
+		}
+		return risks[i].Package < risks[j].Package
+	})
+	return risks
+}
+
+// storeRiskMatrix writes the ranking as a CSV table with one column per
+// capability, which can be opened in a spreadsheet.
+func (r *RiskMatrix) storeRiskMatrix(risks []PackageRisk) error {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+
+	header := []string{"package", "system", "score"}
+	for _, capability := range sensitiveCapabilities {
+		header = append(header, capability.name)
+	}
+	_ = w.Write(header)
+	for _, risk := range risks {
+		row := []string{risk.Package, strconv.FormatBool(risk.System), strconv.Itoa(risk.Score)}
+		held := map[string]bool{}
+		for _, capability := range risk.Capabilities {
+			held[capability] = true
+		}
+		for _, capability := range sensitiveCapabilities {
+			if held[capability.name] {
+				row = append(row, "x")
+			} else {
+				row = append(row, "")
+			}
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write risk matrix: %v", err)
+	}
+
+	return saveCommandOutput(filepath.Join(r.StoragePath, "risk_matrix.csv"), b.String())
+}
+
+func (r *RiskMatrix) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Ranking packages by their sensitive capabilities...")
+
+	out, err := adb.Client.Shell("dumpsys", "package")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys package`: %v", err)
+	}
+
+	risks := rankPackageRisks(parseGrantedPermissions(out), capabilityHolders(), systemPackages())
+	for _, risk := range risks {
+		if risk.System || risk.Score < riskMatrixThreshold {
+			continue
+		}
+		title := fmt.Sprintf("Non-system package combines sensitive capabilities: %s (%s)",
+			risk.Package, strings.Join(risk.Capabilities, ", "))
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    title,
+			Source:   r.Name(),
+			File:     r.Name() + "/risk_matrix.json",
+			Value:    risk.Package,
+			Package:  risk.Package,
+		})
+	}
+
+	err = r.storeRiskMatrix(risks)
+	if err != nil {
+		return err
+	}
+	return saveCommandOutputJson(filepath.Join(r.StoragePath, "risk_matrix.json"), &risks)
+}
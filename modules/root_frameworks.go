@@ -0,0 +1,220 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type RootFrameworkIndicator struct {
+	Framework string `json:"framework"`
+	Source    string `json:"source"`
+	Evidence  string `json:"evidence"`
+}
+
+type RootFrameworkModule struct {
+	Framework string `json:"framework"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Enabled   bool   `json:"enabled"`
+}
+
+type RootFrameworksReport struct {
+	Indicators []RootFrameworkIndicator `json:"indicators"`
+	Modules    []RootFrameworkModule    `json:"modules"`
+	// Paths which could not be checked, because their parent folder is
+	// not readable without root.
+	Unreadable []string `json:"unreadable"`
+}
+
+type RootFrameworks struct {
+	StoragePath string
+}
+
+func NewRootFrameworks() *RootFrameworks {
+	return &RootFrameworks{}
+}
+
+func (r *RootFrameworks) Name() string {
+	return "root_frameworks"
+}
+
+func (r *RootFrameworks) InitStorage(storagePath string) error {
+	r.StoragePath = storagePath
+	return nil
+}
+
+// listModules lists the modules installed under a framework folder, such as
+// /data/adb/modules/ for Magisk or KernelSU. It usually requires root.
+func (r *RootFrameworks) listModules(framework, folder string) []RootFrameworkModule {
+	modules := []RootFrameworkModule{}
+
+	entries, err := adb.Client.ListFiles(folder, false)
+	if err != nil {
+		log.Debugf("Impossible to list modules in %s: %v", folder, err)
+		return modules
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		modulePath := folder + entry
+		module := RootFrameworkModule{
+			Framework: framework,
+			ID:        entry,
+			Path:      modulePath,
+			Enabled:   true,
+		}
+
+		// Magisk creates a "disable" file in the module folder when the
+		// module is turned off from the manager.
+		disabled, err := adb.Client.FileExists(modulePath + "/disable")
+		if err == nil && disabled {
+			module.Enabled = false
+		}
+
+		props, _ := adb.Client.Shell("cat", modulePath+"/module.prop")
+		for _, line := range strings.Split(props, "\n") {
+			if strings.HasPrefix(line, "name=") {
+				module.Name = strings.TrimSpace(strings.TrimPrefix(line, "name="))
+			}
+		}
+
+		modules = append(modules, module)
+	}
+
+	return modules
+}
+
+func (r *RootFrameworks) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Checking for rooting frameworks and hooking modules...")
+
+	report := RootFrameworksReport{
+		Indicators: []RootFrameworkIndicator{},
+		Modules:    []RootFrameworkModule{},
+		Unreadable: []string{},
+	}
+
+	paths := map[string]string{
+		"/data/adb/magisk":                            "magisk",
+		"/data/adb/magisk.db":                         "magisk",
+		"/data/adb/modules":                           "magisk",
+		"/sbin/.magisk":                               "magisk",
+		"/debug_ramdisk/.magisk":                      "magisk",
+		"/data/adb/ksu":                               "kernelsu",
+		"/data/adb/ksud":                              "kernelsu",
+		"/data/adb/ap":                                "apatch",
+		"/data/adb/lspd":                              "lsposed",
+		"/data/adb/modules/zygisk_lsposed":            "lsposed",
+		"/data/adb/modules/riru_lsposed":              "lsposed",
+		"/data/adb/modules/riru-core":                 "riru",
+		"/data/adb/modules/zygisksu":                  "zygisk",
+		"/data/adb/modules/zygisk_shamiko":            "zygisk",
+		"/system/framework/XposedBridge.jar":          "xposed",
+		"/system/bin/app_process.orig":                "xposed",
+		"/system/lib/libxposed_art.so":                "xposed",
+		"/system/lib64/libxposed_art.so":              "xposed",
+		"/system/framework/edxp.jar":                  "edxposed",
+		"/data/misc/riru":                             "riru",
+		"/data/local/tmp/frida-server":                "frida",
+		"/data/local/tmp/re.frida.server":             "frida",
+		"/system/xbin/daemonsu":                       "supersu",
+		"/system/etc/init.d/99SuperSUDaemon":          "supersu",
+		"/data/data/de.robv.android.xposed.installer": "xposed",
+	}
+	pathsList := make([]string, 0, len(paths))
+	for path := range paths {
+		pathsList = append(pathsList, path)
+	}
+	sort.Strings(pathsList)
+
+	for _, path := range pathsList {
+		framework := paths[path]
+		out, err := adb.Client.Shell("ls", "-d", path)
+		// Without root, folders such as /data/adb cannot be searched, and
+		// `ls` is denied whether the path exists or not.
+		if strings.Contains(out, "Permission denied") {
+			report.Unreadable = append(report.Unreadable, path)
+			continue
+		}
+		if err != nil || out == "" || strings.Contains(out, "No such file") {
+			continue
+		}
+		log.Debugf("Found rooting framework path: %s", path)
+		report.Indicators = append(report.Indicators, RootFrameworkIndicator{
+			Framework: framework,
+			Source:    "file",
+			Evidence:  path,
+		})
+	}
+
+	packageNames := map[string]string{
+		"com.topjohnwu.magisk":             "magisk",
+		"io.github.vvb2060.magisk":         "magisk",
+		"io.github.huskydg.magisk":         "magisk",
+		"me.weishu.kernelsu":               "kernelsu",
+		"me.bmax.apatch":                   "apatch",
+		"org.lsposed.manager":              "lsposed",
+		"io.github.lsposed.manager":        "lsposed",
+		"de.robv.android.xposed.installer": "xposed",
+		"org.meowcat.edxposed.manager":     "edxposed",
+		"com.solohsu.android.edxp.manager": "edxposed",
+		"org.lsposed.lspatch":              "lspatch",
+		"eu.chainfire.supersu":             "supersu",
+		"com.koushikdutta.superuser":       "superuser",
+		"com.noshufou.android.su":          "superuser",
+		"com.kingroot.kinguser":            "kingroot",
+		"com.kingo.root":                   "kingoroot",
+	}
+	out, err := adb.Client.Shell("pm", "list", "packages")
+	if err != nil {
+		log.Debugf("Impossible to get list of packages: %v", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		packageName := strings.TrimPrefix(strings.TrimSpace(line), "package:")
+		framework, ok := packageNames[packageName]
+		if !ok {
+			continue
+		}
+		log.Debugf("Found rooting framework package: %s", packageName)
+		report.Indicators = append(report.Indicators, RootFrameworkIndicator{
+			Framework: framework,
+			Source:    "package",
+			Evidence:  packageName,
+		})
+	}
+
+	report.Modules = append(report.Modules, r.listModules("magisk", "/data/adb/modules/")...)
+
+	for _, indicator := range report.Indicators {
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityHigh,
+			Title:    fmt.Sprintf("Traces of rooting framework %s", indicator.Framework),
+			Source:   r.Name(),
+			File:     r.Name() + "/root_frameworks.json",
+			Value:    indicator.Evidence,
+		})
+	}
+
+	if len(report.Indicators) > 0 {
+		log.Warningf("Found %d traces of rooting frameworks or hooking modules!",
+			len(report.Indicators))
+	}
+
+	return saveCommandOutputJson(filepath.Join(r.StoragePath, "root_frameworks.json"), &report)
+}
@@ -0,0 +1,151 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type RunningService struct {
+	Package    string    `json:"package"`
+	Service    string    `json:"service"`
+	User       int       `json:"user"`
+	Process    string    `json:"process"`
+	PID        int       `json:"pid"`
+	Foreground bool      `json:"foreground"`
+	Started    time.Time `json:"started"`
+}
+
+var (
+	serviceRecordRegexp = regexp.MustCompile(`^\* ServiceRecord\{[0-9a-f]+ u(\d+) ([^}\s]+)\}`)
+	processRecordRegexp = regexp.MustCompile(`ProcessRecord\{[0-9a-f]+ (\d+):`)
+	androidDurationPart = regexp.MustCompile(`(\d+)(ms|d|h|m|s)`)
+)
+
+type RunningServices struct {
+	StoragePath string
+}
+
+func NewRunningServices() *RunningServices {
+	return &RunningServices{}
+}
+
+func (r *RunningServices) Name() string {
+	return "running_services"
+}
+
+func (r *RunningServices) InitStorage(storagePath string) error {
+	r.StoragePath = storagePath
+	return nil
+}
+
+// parseAndroidDuration parses the durations printed by dumpsys, such as
+// "-1d2h3m4s567ms", relative to the time of the dump.
+func parseAndroidDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+	}
+	value = strings.TrimLeft(value, "+-")
+
+	parts := androidDurationPart.FindAllStringSubmatch(value, -1)
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	units := map[string]time.Duration{
+		"d":  24 * time.Hour,
+		"h":  time.Hour,
+		"m":  time.Minute,
+		"s":  time.Second,
+		"ms": time.Millisecond,
+	}
+	var duration time.Duration
+	for _, part := range parts {
+		n, _ := strconv.Atoi(part[1])
+		duration += time.Duration(n) * units[part[2]]
+	}
+
+	return sign * duration, nil
+}
+
+// parseRunningServices parses the output of `dumpsys activity services`.
+func parseRunningServices(out string, now time.Time) []RunningService {
+	services := []RunningService{}
+	var current *RunningService
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := serviceRecordRegexp.FindStringSubmatch(trimmed); match != nil {
+			user, _ := strconv.Atoi(match[1])
+			services = append(services, RunningService{
+				Package: strings.Split(match[2], "/")[0],
+				Service: match[2],
+				User:    user,
+			})
+			current = &services[len(services)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		for _, field := range strings.Fields(trimmed) {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			switch key {
+			case "packageName":
+				current.Package = value
+			case "processName":
+				current.Process = value
+			case "isForeground":
+				current.Foreground = value == "true"
+			case "createTime":
+				if offset, err := parseAndroidDuration(value); err == nil {
+					current.Started = now.Add(offset)
+				}
+			}
+		}
+		if strings.HasPrefix(trimmed, "app=") {
+			if match := processRecordRegexp.FindStringSubmatch(trimmed); match != nil {
+				current.PID, _ = strconv.Atoi(match[1])
+			}
+		}
+	}
+
+	return services
+}
+
+func (r *RunningServices) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting running services...")
+
+	now := time.Now().UTC()
+	out, err := adb.Client.Shell("dumpsys", "activity", "services")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys activity services`: %v", err)
+	}
+
+	err = saveCommandOutput(filepath.Join(r.StoragePath, "running_services.txt"), out)
+	if err != nil {
+		return err
+	}
+
+	services := parseRunningServices(out, now)
+	log.Debugf("Found %d running services", len(services))
+
+	return saveCommandOutputJson(filepath.Join(r.StoragePath, "running_services.json"), &services)
+}
@@ -0,0 +1,69 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type SdCard struct {
+	StoragePath string
+	SdCardPath  string
+}
+
+func NewSdCard() *SdCard {
+	return &SdCard{}
+}
+
+func (s *SdCard) Name() string {
+	return "sdcard"
+}
+
+func (s *SdCard) InitStorage(storagePath string) error {
+	s.StoragePath = storagePath
+	s.SdCardPath = filepath.Join(storagePath, "sdcard")
+	err := os.Mkdir(s.SdCardPath, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create sdcard folder: %v", err)
+	}
+
+	return nil
+}
+
+func (s *SdCard) EstimateSize(acq *acquisition.Acquisition) (int64, error) {
+	if !acq.Config.CopySdCard {
+		return 0, errNotEnabled
+	}
+	return folderSize(acq, acq.SdCard)
+}
+
+func (s *SdCard) ContentDescription(acq *acquisition.Acquisition) string {
+	if !acq.Config.CopySdCard || acq.HashOnly {
+		return ""
+	}
+	return "all the files in the shared storage, such as photos, screenshots, downloads and documents"
+}
+
+func (s *SdCard) Run(acq *acquisition.Acquisition, fast bool) error {
+	if !acq.Config.CopySdCard {
+		log.Debug("Copying the shared storage is not enabled in the configuration")
+		return nil
+	}
+
+	log.Info("Collecting files in the shared storage. This might take a while...")
+
+	if acq.HashOnly {
+		hashes := hashRemoteFolder(acq, acq.SdCard)
+		return saveCommandOutputJson(filepath.Join(s.StoragePath, "sdcard_hashes.json"), &hashes)
+	}
+
+	return pullFolder(acq, acq.SdCard, s.SdCardPath)
+}
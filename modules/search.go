@@ -0,0 +1,91 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Maximum number of matches returned by the collector for each folder.
+const searchMaxMatches = 1000
+
+type Search struct {
+	StoragePath string
+}
+
+func NewSearch() *Search {
+	return &Search{}
+}
+
+func (s *Search) Name() string {
+	return "search"
+}
+
+func (s *Search) InitStorage(storagePath string) error {
+	s.StoragePath = storagePath
+	return nil
+}
+
+func (s *Search) Run(acq *acquisition.Acquisition, fast bool) error {
+	if acq.Config == nil || len(acq.Config.SearchStrings) == 0 {
+		log.Debug("No search strings configured, skipping on-device search")
+		return nil
+	}
+	if acq.Collector == nil {
+		log.Info("On-device search requires the collector, skipping")
+		return nil
+	}
+
+	log.Info("Searching for configured strings on the device. This might take a while...")
+
+	folders := []string{"/sdcard/", "/data/local/tmp/", "/data/misc/", "/data/system/"}
+	if acq.TmpDir != "/data/local/tmp/" {
+		folders = append(folders, acq.TmpDir)
+	}
+
+	matches := []adb.SearchMatch{}
+	for _, folder := range folders {
+		out, err := acq.Collector.Search(folder, acq.Config.SearchStrings, searchMaxMatches)
+		if err != nil {
+			log.Debugf("Failed to search in %s: %v", folder, err)
+			continue
+		}
+		matches = append(matches, out...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Path != matches[j].Path {
+			return matches[i].Path < matches[j].Path
+		}
+		return matches[i].Line < matches[j].Line
+	})
+
+	for i, match := range matches {
+		// The matching line is content from the device.
+		if acq.HashOnly {
+			matches[i].Text = ""
+		}
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    fmt.Sprintf("String %q found on the device in %s", match.String, match.Path),
+			Source:   s.Name(),
+			File:     s.Name() + "/search.json",
+			Value:    match.String,
+		})
+	}
+
+	if len(matches) > 0 {
+		log.Warningf("Found %d matches for the configured search strings!", len(matches))
+	}
+
+	return saveCommandOutputJson(filepath.Join(s.StoragePath, "search.json"), &matches)
+}
@@ -0,0 +1,194 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+// Devices which have not been patched in this many days are considered stale.
+const stalePatchDays = 90
+
+const (
+	patchStatusUpToDate = "up_to_date"
+	patchStatusStale    = "stale"
+	patchStatusUnknown  = "unknown"
+)
+
+// Build date in the output of `uname -v`, e.g. "Wed Jan 10 12:34:56 UTC 2024".
+var kernelBuildDateRegexp = regexp.MustCompile(`([A-Z][a-z]{2}) +(\d{1,2}) \d{2}:\d{2}:\d{2} \S+ (\d{4})`)
+
+type SecurityPatchReport struct {
+	AndroidVersion        string                `json:"android_version"`
+	SDK                   string                `json:"sdk"`
+	SecurityPatch         string                `json:"security_patch"`
+	VendorSecurityPatch   string                `json:"vendor_security_patch"`
+	KernelVersion         string                `json:"kernel_version"`
+	KernelBuildDate       string                `json:"kernel_build_date"`
+	Status                string                `json:"status"`
+	DaysSincePatch        int                   `json:"days_since_patch"`
+	Stale                 bool                  `json:"stale"`
+	UnpatchedExploitedCVE []utils.Vulnerability `json:"unpatched_exploited_cves"`
+}
+
+// parseKernelBuildDate returns the date in which the kernel was built, as a
+// patch level, from the output of `uname -v`.
+func parseKernelBuildDate(version string) string {
+	match := kernelBuildDateRegexp.FindStringSubmatch(version)
+	if match == nil {
+		return ""
+	}
+	date, err := time.Parse("Jan 2 2006", fmt.Sprintf("%s %s %s", match[1], match[2], match[3]))
+	if err != nil {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// isPatchLevel returns true if the value is a valid patch level date. Patch
+// levels in this format can be compared as strings.
+func isPatchLevel(value string) bool {
+	_, err := time.Parse("2006-01-02", value)
+	return err == nil
+}
+
+// unpatched returns true if the device is missing the fix for the given
+// vulnerability, false if it has it or if it cannot be determined.
+func (r *SecurityPatchReport) unpatched(vuln utils.Vulnerability) bool {
+	platform := r.SecurityPatch
+	if !isPatchLevel(platform) {
+		platform = ""
+	}
+	// The vendor patch level covers vendor components and the kernel on
+	// devices which have one, otherwise the platform one does.
+	vendor := r.VendorSecurityPatch
+	if !isPatchLevel(vendor) {
+		vendor = platform
+	}
+
+	switch vuln.Patch {
+	case utils.PatchKernel:
+		// A kernel built before the fix was published cannot include the
+		// patches shipped with that bulletin.
+		if r.KernelBuildDate != "" && r.KernelBuildDate < vuln.Bulletin {
+			return true
+		}
+		return vendor != "" && vendor < vuln.Bulletin
+	case utils.PatchVendor:
+		return vendor != "" && vendor < vuln.Bulletin
+	default:
+		return platform != "" && platform < vuln.Bulletin
+	}
+}
+
+type SecurityPatch struct {
+	StoragePath string
+}
+
+func NewSecurityPatch() *SecurityPatch {
+	return &SecurityPatch{}
+}
+
+func (s *SecurityPatch) Name() string {
+	return "security_patch"
+}
+
+func (s *SecurityPatch) InitStorage(storagePath string) error {
+	s.StoragePath = storagePath
+	return nil
+}
+
+func (s *SecurityPatch) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting security patch level...")
+
+	props := map[string]string{
+		"ro.build.version.release":        "",
+		"ro.build.version.sdk":            "",
+		"ro.build.version.security_patch": "",
+		"ro.vendor.build.security_patch":  "",
+	}
+	for prop := range props {
+		out, err := adb.Client.Shell("getprop", prop)
+		if err != nil {
+			log.Debugf("Failed to get property %s: %v", prop, err)
+			continue
+		}
+		props[prop] = out
+	}
+
+	kernel, err := adb.Client.Shell("uname", "-r")
+	if err != nil {
+		log.Debugf("Failed to get kernel version: %v", err)
+	}
+	kernelBuild, err := adb.Client.Shell("uname", "-v")
+	if err != nil {
+		log.Debugf("Failed to get kernel build: %v", err)
+	}
+
+	report := SecurityPatchReport{
+		AndroidVersion:        props["ro.build.version.release"],
+		SDK:                   props["ro.build.version.sdk"],
+		SecurityPatch:         props["ro.build.version.security_patch"],
+		VendorSecurityPatch:   props["ro.vendor.build.security_patch"],
+		KernelVersion:         strings.TrimSpace(kernel),
+		KernelBuildDate:       parseKernelBuildDate(kernelBuild),
+		Status:                patchStatusUnknown,
+		UnpatchedExploitedCVE: []utils.Vulnerability{},
+	}
+
+	// The patch level is missing or invalid on some emulators and custom
+	// ROMs, in which case only the vendor patch level and the kernel can be
+	// evaluated.
+	patchDate, err := time.Parse("2006-01-02", report.SecurityPatch)
+	if err != nil {
+		log.Warningf("Unable to parse the security patch level %q, the exposure of the device is unknown",
+			report.SecurityPatch)
+	} else {
+		report.DaysSincePatch = int(acq.Started.Sub(patchDate).Hours() / 24)
+		report.Stale = report.DaysSincePatch > stalePatchDays
+		report.Status = patchStatusUpToDate
+		if report.Stale {
+			report.Status = patchStatusStale
+		}
+	}
+
+	for _, vuln := range utils.ExploitedVulnerabilities() {
+		if report.unpatched(vuln) {
+			report.UnpatchedExploitedCVE = append(report.UnpatchedExploitedCVE, vuln)
+		}
+	}
+
+	for _, vuln := range report.UnpatchedExploitedCVE {
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityLow,
+			Title:    fmt.Sprintf("Device is not patched against %s, exploited in the wild", vuln.CVE),
+			Source:   s.Name(),
+			File:     s.Name() + "/security_patch.json",
+			Value:    vuln.CVE,
+		})
+	}
+
+	if report.Stale {
+		log.Warningf("The device security patch level is %s, %d days old", report.SecurityPatch,
+			report.DaysSincePatch)
+	}
+	if len(report.UnpatchedExploitedCVE) > 0 {
+		log.Warningf("The device is missing patches for %d vulnerabilities known to be exploited in the wild",
+			len(report.UnpatchedExploitedCVE))
+	}
+
+	return saveCommandOutputJson(filepath.Join(s.StoragePath, "security_patch.json"), &report)
+}
@@ -0,0 +1,218 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Types of sensors which can be used to track the movements of the user.
+var motionSensorTypes = map[string]bool{
+	"android.sensor.accelerometer":              true,
+	"android.sensor.accelerometer_uncalibrated": true,
+	"android.sensor.gyroscope":                  true,
+	"android.sensor.gyroscope_uncalibrated":     true,
+	"android.sensor.linear_acceleration":        true,
+}
+
+var (
+	// 0x0000000b) LSM6DSO Accelerometer | STMicro | ver: 1 | type: android.sensor.accelerometer(1) | ...
+	sensorRegexp = regexp.MustCompile(`^(0x[0-9a-fA-F]+)\)\s+(.+?)\s*\|.*\btype: ([\w.]+)\(`)
+	// 12:34:56 + 0x0000000b pid= 1234 uid= 10120 package=com.foo samplingPeriod=20000us batchingPeriod=0us
+	sensorRegistrationRegexp = regexp.MustCompile(
+		`^(\d{2}:\d{2}:\d{2}) ([+-]) (0x[0-9a-fA-F]+) pid=\s*(\d+) uid=\s*(\d+) package=(\S+)(?: samplingPeriod=(\d+)us)?`)
+	// com.foo | WakeLockRefCount 0 | uid 10120 | cache size 0 | max cache size 0
+	sensorConnectionRegexp = regexp.MustCompile(`^(\S+) \| WakeLockRefCount \d+ \| uid (\d+)`)
+	// LSM6DSO Accelerometer 0x0000000b | status: active | pending flush events 0
+	sensorConnectionSensorRegexp = regexp.MustCompile(`^(.+?) (0x[0-9a-fA-F]+) \| status: (\w+)`)
+)
+
+type Sensor struct {
+	Handle string `json:"handle"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+}
+
+// SensorRegistration is a past registration or unregistration of a sensor
+// listener, as kept by the sensor service.
+type SensorRegistration struct {
+	Time             string `json:"time"`
+	Registered       bool   `json:"registered"`
+	Sensor           Sensor `json:"sensor"`
+	PID              int    `json:"pid"`
+	UID              int    `json:"uid"`
+	Package          string `json:"package"`
+	SamplingPeriodUs int    `json:"sampling_period_us,omitempty"`
+}
+
+// SensorListener is a package with sensor listeners active at the time of
+// acquisition.
+type SensorListener struct {
+	Package string   `json:"package"`
+	UID     int      `json:"uid"`
+	System  bool     `json:"system"`
+	Sensors []Sensor `json:"sensors"`
+}
+
+type SensorsReport struct {
+	Sensors       []Sensor             `json:"sensors"`
+	Listeners     []SensorListener     `json:"listeners"`
+	Registrations []SensorRegistration `json:"registrations"`
+}
+
+// Sensors collects the sensors of the device and the packages listening to
+// them, as continuous motion sensor listeners can be used to track the
+// movements of the user.
+type Sensors struct {
+	StoragePath string
+}
+
+func NewSensors() *Sensors {
+	return &Sensors{}
+}
+
+func (s *Sensors) Name() string {
+	return "sensors"
+}
+
+func (s *Sensors) InitStorage(storagePath string) error {
+	s.StoragePath = storagePath
+	return nil
+}
+
+// parseSensorService extracts the sensors, the active listeners and the
+// recent registrations from the output of `dumpsys sensorservice`.
+func parseSensorService(out string) SensorsReport {
+	report := SensorsReport{
+		Sensors:       []Sensor{},
+		Listeners:     []SensorListener{},
+		Registrations: []SensorRegistration{},
+	}
+
+	handles := map[string]Sensor{}
+	sensor := func(handle string) Sensor {
+		handle = strings.ToLower(handle)
+		if s, ok := handles[handle]; ok {
+			return s
+		}
+		return Sensor{Handle: handle}
+	}
+
+	var listener *SensorListener
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := sensorRegexp.FindStringSubmatch(trimmed); match != nil {
+			s := Sensor{Handle: strings.ToLower(match[1]), Name: match[2], Type: match[3]}
+			if _, ok := handles[s.Handle]; !ok {
+				handles[s.Handle] = s
+				report.Sensors = append(report.Sensors, s)
+			}
+			continue
+		}
+		if match := sensorRegistrationRegexp.FindStringSubmatch(trimmed); match != nil {
+			pid, _ := strconv.Atoi(match[4])
+			uid, _ := strconv.Atoi(match[5])
+			period, _ := strconv.Atoi(match[7])
+			report.Registrations = append(report.Registrations, SensorRegistration{
+				Time:             match[1],
+				Registered:       match[2] == "+",
+				Sensor:           sensor(match[3]),
+				PID:              pid,
+				UID:              uid,
+				Package:          match[6],
+				SamplingPeriodUs: period,
+			})
+			continue
+		}
+		if strings.HasPrefix(trimmed, "Connection Number") {
+			listener = nil
+			continue
+		}
+		if match := sensorConnectionRegexp.FindStringSubmatch(trimmed); match != nil {
+			uid, _ := strconv.Atoi(match[2])
+			report.Listeners = append(report.Listeners, SensorListener{
+				Package: match[1],
+				UID:     uid,
+				Sensors: []Sensor{},
+			})
+			listener = &report.Listeners[len(report.Listeners)-1]
+			continue
+		}
+		if match := sensorConnectionSensorRegexp.FindStringSubmatch(trimmed); match != nil && listener != nil {
+			if match[3] == "active" {
+				listener.Sensors = append(listener.Sensors, sensor(match[2]))
+			}
+		}
+	}
+
+	listeners := []SensorListener{}
+	for _, l := range report.Listeners {
+		if len(l.Sensors) > 0 {
+			listeners = append(listeners, l)
+		}
+	}
+	sort.SliceStable(listeners, func(i, j int) bool {
+		return listeners[i].Package < listeners[j].Package
+	})
+	report.Listeners = listeners
+
+	return report
+}
+
+func (s *Sensors) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting sensor listeners...")
+
+	out, err := adb.Client.Shell("dumpsys", "sensorservice")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell dumpsys sensorservice`: %v", err)
+	}
+	err = saveCommandOutput(filepath.Join(s.StoragePath, "sensorservice.txt"), out)
+	if err != nil {
+		return err
+	}
+
+	report := parseSensorService(out)
+	system := systemPackages()
+	for i := range report.Listeners {
+		listener := &report.Listeners[i]
+		listener.System = system[listener.Package]
+		if listener.System {
+			continue
+		}
+
+		motion := []string{}
+		for _, sensor := range listener.Sensors {
+			if motionSensorTypes[sensor.Type] {
+				motion = append(motion, sensor.Name)
+			}
+		}
+		if len(motion) == 0 {
+			continue
+		}
+		log.Warningf("Non-system package %s is listening to motion sensors: %s",
+			listener.Package, strings.Join(motion, ", "))
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityLow,
+			Title:    fmt.Sprintf("Non-system package is listening to motion sensors: %s", listener.Package),
+			Source:   s.Name(),
+			File:     s.Name() + "/sensors.json",
+			Value:    strings.Join(motion, ", "),
+			Package:  listener.Package,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(s.StoragePath, "sensors.json"), &report)
+}
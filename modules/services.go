@@ -9,7 +9,6 @@ import (
 	"path/filepath"
 
 	"github.com/mvt-project/androidqf/acquisition"
-	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
 )
 
@@ -33,10 +32,10 @@ func (s *Services) InitStorage(storagePath string) error {
 func (s *Services) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting list of services...")
 
-	out, err := adb.Client.Shell("service list")
+	_, err := saveShellOutput(filepath.Join(s.StoragePath, "services.txt"), "service list")
 	if err != nil {
 		return fmt.Errorf("failed to run `adb shell service list`: %v", err)
 	}
 
-	return saveCommandOutput(filepath.Join(s.StoragePath, "services.txt"), out)
+	return nil
 }
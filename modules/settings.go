@@ -22,7 +22,7 @@ func NewSettings() *Settings {
 }
 
 func (s *Settings) Name() string {
-	return "logcat"
+	return "settings"
 }
 
 func (s *Settings) InitStorage(storagePath string) error {
@@ -33,8 +33,14 @@ func (s *Settings) InitStorage(storagePath string) error {
 func (s *Settings) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting device settings...")
 
+	// Older devices do not have the `cmd` binary, but ship `settings`.
+	command := "cmd settings"
+	if !acq.Capabilities.Has("cmd") {
+		command = "settings"
+	}
+
 	for _, namespace := range []string{"system", "secure", "global"} {
-		out, err := adb.Client.Shell(fmt.Sprintf("cmd settings list %s", namespace))
+		out, err := adb.Client.Shell(fmt.Sprintf("%s list %s", command, namespace))
 		if err != nil {
 			return fmt.Errorf("failed to run `cmd settings %s`: %v", namespace, err)
 		}
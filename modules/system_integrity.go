@@ -0,0 +1,172 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
+)
+
+type SystemFileChange struct {
+	Path           string `json:"path"`
+	SHA256         string `json:"sha256"`
+	BaselineSHA256 string `json:"baseline_sha256"`
+}
+
+type SystemIntegrityReport struct {
+	Fingerprint string             `json:"fingerprint"`
+	Baseline    string             `json:"baseline"`
+	Compared    bool               `json:"compared"`
+	TotalFiles  int                `json:"total_files"`
+	Added       []SystemFileChange `json:"added"`
+	Modified    []SystemFileChange `json:"modified"`
+	Missing     []SystemFileChange `json:"missing"`
+}
+
+type SystemIntegrity struct {
+	StoragePath string
+}
+
+func NewSystemIntegrity() *SystemIntegrity {
+	return &SystemIntegrity{}
+}
+
+func (s *SystemIntegrity) Name() string {
+	return "system_integrity"
+}
+
+func (s *SystemIntegrity) InitStorage(storagePath string) error {
+	s.StoragePath = storagePath
+	return nil
+}
+
+// loadBaseline loads the known-good hashes for the given build fingerprint.
+// The baseline database maps build fingerprints to file paths and SHA256.
+func (s *SystemIntegrity) loadBaseline(location, fingerprint string) (map[string]string, error) {
+	data, err := utils.ReadLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	var database map[string]map[string]string
+	err = json.Unmarshal(data, &database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse baseline database: %v", err)
+	}
+
+	baseline, ok := database[fingerprint]
+	if !ok {
+		return nil, fmt.Errorf("no baseline available for fingerprint %s", fingerprint)
+	}
+
+	return baseline, nil
+}
+
+func (s *SystemIntegrity) Run(acq *acquisition.Acquisition, fast bool) error {
+	if fast {
+		log.Info("Skipping system partition integrity check in fast mode")
+		return nil
+	}
+
+	log.Info("Hashing system partitions. This might take a while...")
+
+	fingerprint, err := adb.Client.Shell("getprop", "ro.build.fingerprint")
+	if err != nil {
+		return fmt.Errorf("failed to run `adb shell getprop ro.build.fingerprint`: %v", err)
+	}
+
+	hashes := map[string]string{}
+	for _, folder := range []string{"/system/", "/vendor/"} {
+		for path, hash := range hashRemoteFolder(acq, folder) {
+			hashes[path] = hash
+		}
+	}
+
+	err = saveCommandOutputJson(filepath.Join(s.StoragePath, "system_hashes.json"), &hashes)
+	if err != nil {
+		return err
+	}
+
+	report := SystemIntegrityReport{
+		Fingerprint: fingerprint,
+		Baseline:    acq.SystemBaseline,
+		TotalFiles:  len(hashes),
+		Added:       []SystemFileChange{},
+		Modified:    []SystemFileChange{},
+		Missing:     []SystemFileChange{},
+	}
+
+	if acq.SystemBaseline == "" {
+		log.Debug("No system baseline provided, skipping comparison")
+		return saveCommandOutputJson(filepath.Join(s.StoragePath, "system_integrity.json"), &report)
+	}
+
+	baseline, err := s.loadBaseline(acq.SystemBaseline, fingerprint)
+	if err != nil {
+		log.Errorf("Impossible to load system baseline: %v", err)
+		return saveCommandOutputJson(filepath.Join(s.StoragePath, "system_integrity.json"), &report)
+	}
+	report.Compared = true
+
+	for path, hash := range hashes {
+		baselineHash, ok := baseline[path]
+		if !ok {
+			report.Added = append(report.Added, SystemFileChange{Path: path, SHA256: hash})
+		} else if !strings.EqualFold(baselineHash, hash) {
+			report.Modified = append(report.Modified, SystemFileChange{
+				Path:           path,
+				SHA256:         hash,
+				BaselineSHA256: baselineHash,
+			})
+		}
+	}
+	// Files missing from the device might just not be readable without root.
+	for path, baselineHash := range baseline {
+		if _, ok := hashes[path]; !ok {
+			report.Missing = append(report.Missing, SystemFileChange{Path: path, BaselineSHA256: baselineHash})
+		}
+	}
+
+	for _, changes := range [][]SystemFileChange{report.Added, report.Modified, report.Missing} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	}
+
+	for _, change := range report.Modified {
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityHigh,
+			Title:    fmt.Sprintf("Modified system file %s", change.Path),
+			Source:   s.Name(),
+			File:     s.Name() + "/system_integrity.json",
+			Value:    change.SHA256,
+		})
+	}
+	for _, change := range report.Added {
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    fmt.Sprintf("Unknown system file %s", change.Path),
+			Source:   s.Name(),
+			File:     s.Name() + "/system_integrity.json",
+			Value:    change.SHA256,
+		})
+	}
+
+	if len(report.Added) > 0 || len(report.Modified) > 0 {
+		log.Warningf("Found %d added and %d modified files in system partitions!",
+			len(report.Added), len(report.Modified))
+	}
+
+	return saveCommandOutputJson(filepath.Join(s.StoragePath, "system_integrity.json"), &report)
+}
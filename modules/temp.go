@@ -13,6 +13,7 @@ import (
 	"github.com/mvt-project/androidqf/acquisition"
 	"github.com/mvt-project/androidqf/adb"
 	"github.com/mvt-project/androidqf/log"
+	"github.com/mvt-project/androidqf/utils"
 )
 
 type Temp struct {
@@ -39,23 +40,54 @@ func (t *Temp) InitStorage(storagePath string) error {
 	return nil
 }
 
+func (t *Temp) EstimateSize(acq *acquisition.Acquisition) (int64, error) {
+	return folderSize(acq, acq.TmpDir)
+}
+
 func (t *Temp) Run(acq *acquisition.Acquisition, fast bool) error {
 	log.Info("Collecting files in tmp folder...")
 
-	// TODO: Also check default tmp folders
-	tmpFiles, err := adb.Client.ListFiles(acq.TmpDir, true)
+	if acq.HashOnly {
+		tmpHashes := hashRemoteFolder(acq, acq.TmpDir)
+		if acq.Collector != nil {
+			delete(tmpHashes, acq.Collector.ExePath)
+		}
+		return saveCommandOutputJson(filepath.Join(t.StoragePath, "tmp_hashes.json"), &tmpHashes)
+	}
+
+	return pullFolder(acq, acq.TmpDir, t.TempPath)
+}
+
+// pullFolder copies the files in a folder on the device to a local folder.
+// Compressing the folder on the device with the collector is much faster
+// than pulling the files one by one, which is done only if it fails.
+func pullFolder(acq *acquisition.Acquisition, remoteFolder, localFolder string) error {
+	if acq.Collector != nil {
+		count, err := acq.Collector.Archive(remoteFolder, localFolder,
+			[]string{acq.Collector.ExePath})
+		if err == nil {
+			log.Debugf("Extracted %d files from the archive of %s", count, remoteFolder)
+			return nil
+		}
+		log.Debugf("Failed to archive %s with the collector, pulling files one by one: %v",
+			remoteFolder, err)
+	}
+
+	files, err := adb.Client.ListFiles(remoteFolder, true)
 	if err != nil {
-		return fmt.Errorf("failed to list files in tmp: %v", err)
+		return fmt.Errorf("failed to list files in %s: %v", remoteFolder, err)
 	}
 
-	for _, file := range tmpFiles {
-		if file == acq.TmpDir {
+	for _, file := range files {
+		if file == remoteFolder || (acq.Collector != nil && file == acq.Collector.ExePath) {
 			continue
 		}
-		dest_path := filepath.Join(t.TempPath,
-			strings.TrimPrefix(file, acq.TmpDir))
+		dest_path := utils.LocalPath(localFolder, strings.TrimPrefix(file, remoteFolder))
 
-		adb.Client.Pull(file, dest_path)
+		out, err := adb.Client.Pull(file, dest_path)
+		if err != nil {
+			log.Debugf("Failed to pull %s: %s", file, strings.TrimSpace(out))
+		}
 	}
 	return nil
 }
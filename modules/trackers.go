@@ -0,0 +1,222 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var (
+	// AssociationInfo{mId=1, mUserId=0, mPackageName='com.foo', mDisplayName='Watch', ...}
+	associationRegexp      = regexp.MustCompile(`Association(?:Info)?\{(.*)\}`)
+	associationFieldRegexp = regexp.MustCompile(`(\w+)=('[^']*'|[^,}]*)`)
+	notificationPkgRegexp  = regexp.MustCompile(`\bpkg=(\S+)`)
+	notificationChanRegexp = regexp.MustCompile(`\bchannel=([^\s)]+)`)
+	// android.title=String (Unknown tracker traveling with you)
+	notificationTextRegexp = regexp.MustCompile(`^android\.(title|text|bigText)=\S+ \((.*)\)$`)
+	trackerAlertRegexp     = regexp.MustCompile(
+		`(?i)unknown (tracker|tag|item|device)|tracker (found|detected|moving|travel)|\bairtag|smarttag|` +
+			`travel(l)?ing with you|unwanted track`)
+)
+
+// Services of Google Play services dumped for Fast Pair and Find My Device
+// artifacts. The name is matched against the class of running services.
+var nearbyServices = []string{"fastpair", "findmydevice"}
+
+type CompanionAssociation struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	Package     string `json:"package"`
+	DisplayName string `json:"display_name"`
+	MACAddress  string `json:"mac_address"`
+	Profile     string `json:"profile"`
+	SelfManaged bool   `json:"self_managed"`
+	Revoked     bool   `json:"revoked"`
+	Approved    string `json:"approved"`
+	System      bool   `json:"system"`
+}
+
+type TrackerAlert struct {
+	Package string `json:"package"`
+	Channel string `json:"channel"`
+	Title   string `json:"title"`
+	Text    string `json:"text"`
+}
+
+type TrackersReport struct {
+	Associations []CompanionAssociation `json:"associations"`
+	Alerts       []TrackerAlert         `json:"alerts"`
+	// Nearby services of Google Play services which could be dumped.
+	NearbyDumps []string `json:"nearby_dumps"`
+}
+
+// Trackers collects the devices associated with apps through the companion
+// device manager, the Fast Pair and Find My Device state where readable, and
+// the recent alerts about unknown Bluetooth trackers, to support
+// investigations into physical tracking devices.
+type Trackers struct {
+	StoragePath string
+}
+
+func NewTrackers() *Trackers {
+	return &Trackers{}
+}
+
+func (t *Trackers) Name() string {
+	return "trackers"
+}
+
+func (t *Trackers) InitStorage(storagePath string) error {
+	t.StoragePath = storagePath
+	return nil
+}
+
+// parseCompanionAssociations extracts the associations from the output of
+// `dumpsys companiondevice`.
+func parseCompanionAssociations(out string) []CompanionAssociation {
+	associations := []CompanionAssociation{}
+	for _, line := range strings.Split(out, "\n") {
+		match := associationRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		fields := map[string]string{}
+		for _, field := range associationFieldRegexp.FindAllStringSubmatch(match[1], -1) {
+			value := strings.Trim(strings.TrimSpace(field[2]), "'")
+			if value == "null" {
+				value = ""
+			}
+			fields[field[1]] = value
+		}
+		if fields["mPackageName"] == "" {
+			continue
+		}
+		associations = append(associations, CompanionAssociation{
+			ID:          fields["mId"],
+			UserID:      fields["mUserId"],
+			Package:     fields["mPackageName"],
+			DisplayName: fields["mDisplayName"],
+			MACAddress:  fields["mDeviceMacAddress"],
+			Profile:     fields["mDeviceProfile"],
+			SelfManaged: fields["mSelfManaged"] == "true",
+			Revoked:     fields["mRevoked"] == "true",
+			Approved:    fields["mTimeApprovedMs"],
+		})
+	}
+	return associations
+}
+
+// parseTrackerAlerts extracts the notifications about unknown trackers from
+// the output of `dumpsys notification --noredact`. Other notifications are
+// not kept, as their content is private.
+func parseTrackerAlerts(out string) []TrackerAlert {
+	alerts := []TrackerAlert{}
+	var current *TrackerAlert
+	flush := func() {
+		if current != nil && trackerAlertRegexp.MatchString(current.Channel+" "+current.Title+" "+current.Text) {
+			alerts = append(alerts, *current)
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "NotificationRecord(") || strings.HasPrefix(line, "StatusBarNotification(") {
+			flush()
+			current = &TrackerAlert{}
+			if match := notificationPkgRegexp.FindStringSubmatch(line); match != nil {
+				current.Package = match[1]
+			}
+			if match := notificationChanRegexp.FindStringSubmatch(line); match != nil {
+				current.Channel = match[1]
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if match := notificationTextRegexp.FindStringSubmatch(line); match != nil {
+			if match[1] == "title" {
+				current.Title = match[2]
+			} else if current.Text == "" {
+				current.Text = match[2]
+			}
+		}
+	}
+	flush()
+	return alerts
+}
+
+func (t *Trackers) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting companion devices and tracker alerts...")
+
+	report := TrackersReport{
+		Associations: []CompanionAssociation{},
+		Alerts:       []TrackerAlert{},
+		NearbyDumps:  []string{},
+	}
+
+	out, err := adb.Client.Shell("dumpsys", "companiondevice")
+	if err != nil {
+		log.Debugf("Failed to run `dumpsys companiondevice`: %v", err)
+	} else {
+		err = saveCommandOutput(filepath.Join(t.StoragePath, "companiondevice.txt"), out)
+		if err != nil {
+			return err
+		}
+		system := systemPackages()
+		report.Associations = parseCompanionAssociations(out)
+		for i := range report.Associations {
+			report.Associations[i].System = system[report.Associations[i].Package]
+		}
+	}
+
+	for _, service := range nearbyServices {
+		out, err := adb.Client.Shell("dumpsys", "activity", "service", service)
+		if err != nil || out == "" || strings.HasPrefix(out, "No services match") {
+			log.Debugf("No %s service could be dumped", service)
+			continue
+		}
+		err = saveCommandOutput(filepath.Join(t.StoragePath, fmt.Sprintf("nearby_%s.txt", service)), out)
+		if err != nil {
+			return err
+		}
+		report.NearbyDumps = append(report.NearbyDumps, service)
+	}
+
+	out, err = adb.Client.Shell("dumpsys", "notification", "--noredact")
+	if err != nil {
+		log.Debugf("Failed to run `dumpsys notification`: %v", err)
+	} else {
+		report.Alerts = parseTrackerAlerts(out)
+	}
+
+	for _, alert := range report.Alerts {
+		summary := alert.Title
+		if summary == "" {
+			summary = alert.Channel
+		}
+		title := fmt.Sprintf("Alert about an unknown tracker: %s", summary)
+		log.Warning(title)
+		acq.AddDetection(acquisition.Detection{
+			Engine:   acquisition.EngineHeuristic,
+			Severity: acquisition.SeverityMedium,
+			Title:    title,
+			Source:   t.Name(),
+			File:     t.Name() + "/trackers.json",
+			Value:    alert.Text,
+			Package:  alert.Package,
+		})
+	}
+
+	return saveCommandOutputJson(filepath.Join(t.StoragePath, "trackers.json"), &report)
+}
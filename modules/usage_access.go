@@ -0,0 +1,107 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+type UsageAccessGrant struct {
+	Package string `json:"package"`
+	System  bool   `json:"system"`
+}
+
+type UsageAccess struct {
+	StoragePath string
+}
+
+func NewUsageAccess() *UsageAccess {
+	return &UsageAccess{}
+}
+
+func (u *UsageAccess) Name() string {
+	return "usage_access"
+}
+
+func (u *UsageAccess) InitStorage(storagePath string) error {
+	u.StoragePath = storagePath
+	return nil
+}
+
+// filterPermissionHolders returns the packages which were granted the given
+// permission, according to `dumpsys package`.
+func filterPermissionHolders(packages []string, permission string) []string {
+	holders := []string{}
+	for _, pkg := range packages {
+		out, err := adb.Client.Shell("dumpsys", "package", pkg)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), permission+": granted=true") {
+				holders = append(holders, pkg)
+				break
+			}
+		}
+	}
+	return holders
+}
+
+func (u *UsageAccess) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting packages granted usage access...")
+
+	if !acq.Capabilities.Has("cmd") {
+		log.Info("The device does not support querying app ops, skipping usage access")
+		return nil
+	}
+
+	// Usage access is granted through the GET_USAGE_STATS app op, either
+	// explicitly allowed or left to the default with the permission granted.
+	grants := []UsageAccessGrant{}
+	seen := map[string]bool{}
+	system := systemPackages()
+	for _, mode := range []string{"allow", "default"} {
+		packages, err := appOpsPackages("GET_USAGE_STATS", mode)
+		if err != nil {
+			return fmt.Errorf("failed to query GET_USAGE_STATS app op: %v", err)
+		}
+		if mode == "default" {
+			// With the default mode, only the packages holding the
+			// PACKAGE_USAGE_STATS permission are actually granted access.
+			packages = filterPermissionHolders(packages, "android.permission.PACKAGE_USAGE_STATS")
+		}
+
+		for _, pkg := range packages {
+			if seen[pkg] {
+				continue
+			}
+			seen[pkg] = true
+			grants = append(grants, UsageAccessGrant{Package: pkg, System: system[pkg]})
+
+			if system[pkg] {
+				continue
+			}
+			log.Warningf("Non-system package %s has usage access", pkg)
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: acquisition.SeverityLow,
+				Title:    fmt.Sprintf("Non-system package has usage access: %s", pkg),
+				Source:   u.Name(),
+				File:     u.Name() + "/usage_access.json",
+				Value:    pkg,
+				Package:  pkg,
+			})
+		}
+	}
+
+	return saveCommandOutputJson(filepath.Join(u.StoragePath, "usage_access.json"), &grants)
+}
@@ -0,0 +1,200 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+var (
+	// Current WebView package (name, version): (com.google.android.webview, 119.0.6045.163)
+	currentWebViewRegexp = regexp.MustCompile(`Current WebView package \(name, version\): \((\S+), ([^)]*)\)`)
+	// Valid package com.google.android.webview (versionName: 119.0.6045.163, ...) is installed/enabled for all users
+	webViewPackageRegexp = regexp.MustCompile(`^(Valid|Invalid) package (\S+)`)
+	activityRegexp       = regexp.MustCompile(`^([\w.]+)/(\S+)$`)
+)
+
+// WebView implementations shipped by Google and by the major manufacturers.
+var standardWebViews = map[string]bool{
+	"com.google.android.webview":        true,
+	"com.google.android.webview.beta":   true,
+	"com.google.android.webview.dev":    true,
+	"com.google.android.webview.canary": true,
+	"com.android.webview":               true,
+	"com.android.chrome":                true,
+	"com.chrome.beta":                   true,
+	"com.chrome.dev":                    true,
+	"com.chrome.canary":                 true,
+	"com.google.android.apps.chrome":    true,
+	"com.huawei.webview":                true,
+	"com.amazon.webview.chromium":       true,
+}
+
+type WebViewProvider struct {
+	Package  string `json:"package"`
+	Valid    bool   `json:"valid"`
+	System   bool   `json:"system"`
+	Standard bool   `json:"standard"`
+}
+
+type WebViewReport struct {
+	CurrentProvider string            `json:"current_provider"`
+	CurrentVersion  string            `json:"current_version"`
+	Providers       []WebViewProvider `json:"providers"`
+	Browsers        []string          `json:"browsers"`
+	DefaultBrowser  string            `json:"default_browser"`
+	InstantApps     []string          `json:"instant_apps"`
+}
+
+// WebView lists the WebView implementation used by apps, the browsers
+// installed and the instant apps, as a non-standard WebView can intercept
+// the web traffic of every app embedding web content.
+type WebView struct {
+	StoragePath string
+}
+
+func NewWebView() *WebView {
+	return &WebView{}
+}
+
+func (w *WebView) Name() string {
+	return "webview"
+}
+
+func (w *WebView) InitStorage(storagePath string) error {
+	w.StoragePath = storagePath
+	return nil
+}
+
+// parseWebViewProviders extracts the WebView in use and the candidate
+// implementations from the output of `dumpsys webviewupdate`.
+func parseWebViewProviders(out string) (string, string, []WebViewProvider) {
+	current, version := "", ""
+	providers := []WebViewProvider{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if match := currentWebViewRegexp.FindStringSubmatch(line); match != nil {
+			current, version = match[1], strings.TrimSpace(match[2])
+			continue
+		}
+		match := webViewPackageRegexp.FindStringSubmatch(line)
+		if match == nil || seen[match[2]] {
+			continue
+		}
+		seen[match[2]] = true
+		providers = append(providers, WebViewProvider{
+			Package:  match[2],
+			Valid:    match[1] == "Valid",
+			Standard: standardWebViews[match[2]],
+		})
+	}
+	return current, version, providers
+}
+
+// parseActivityPackages returns the packages of the activities listed by
+// `cmd package query-activities --brief`.
+func parseActivityPackages(out string) []string {
+	packages := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		match := activityRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		packages = append(packages, match[1])
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+func (w *WebView) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting WebView provider, browsers and instant apps...")
+
+	report := WebViewReport{
+		Providers:   []WebViewProvider{},
+		Browsers:    []string{},
+		InstantApps: []string{},
+	}
+	system := systemPackages()
+
+	out, err := adb.Client.Shell("dumpsys", "webviewupdate")
+	if err != nil {
+		log.Debugf("Failed to run `dumpsys webviewupdate`: %v", err)
+	} else {
+		err = saveCommandOutput(filepath.Join(w.StoragePath, "webviewupdate.txt"), out)
+		if err != nil {
+			return err
+		}
+		report.CurrentProvider, report.CurrentVersion, report.Providers = parseWebViewProviders(out)
+		for i := range report.Providers {
+			report.Providers[i].System = system[report.Providers[i].Package]
+		}
+	}
+
+	if acq.Capabilities.Has("cmd") {
+		out, err = adb.Client.Shell("cmd", "package", "query-activities", "--brief",
+			"-a", "android.intent.action.VIEW", "-c", "android.intent.category.BROWSABLE", "-d", "http://example.com")
+		if err != nil {
+			log.Debugf("Failed to list browsers: %v", err)
+		} else {
+			report.Browsers = parseActivityPackages(out)
+		}
+		if acq.Capabilities.AtLeast(29) {
+			report.DefaultBrowser, _ = adb.Client.Shell("cmd", "role", "get-role-holders", "android.app.role.BROWSER")
+		}
+	}
+
+	if acq.Capabilities.AtLeast(26) {
+		out, err = adb.Client.Shell("pm", "list", "packages", "--instant")
+		if err != nil {
+			log.Debugf("Failed to list instant apps: %v", err)
+		} else {
+			for _, line := range strings.Split(out, "\n") {
+				pkg := strings.TrimPrefix(strings.TrimSpace(line), "package:")
+				if pkg != "" {
+					report.InstantApps = append(report.InstantApps, pkg)
+				}
+			}
+		}
+	}
+
+	if report.CurrentProvider != "" {
+		severity := ""
+		title := ""
+		switch {
+		case !system[report.CurrentProvider]:
+			severity = acquisition.SeverityHigh
+			title = fmt.Sprintf("WebView implementation is not a system package: %s", report.CurrentProvider)
+		case !standardWebViews[report.CurrentProvider]:
+			severity = acquisition.SeverityMedium
+			title = fmt.Sprintf("Non-standard WebView implementation in use: %s", report.CurrentProvider)
+		}
+		if title != "" {
+			log.Warning(title)
+			acq.AddDetection(acquisition.Detection{
+				Engine:   acquisition.EngineHeuristic,
+				Severity: severity,
+				Title:    title,
+				Source:   w.Name(),
+				File:     w.Name() + "/webview.json",
+				Value:    report.CurrentVersion,
+				Package:  report.CurrentProvider,
+			})
+		}
+	}
+
+	return saveCommandOutputJson(filepath.Join(w.StoragePath, "webview.json"), &report)
+}
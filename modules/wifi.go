@@ -0,0 +1,135 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package modules
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/log"
+)
+
+const redactedValue = "[redacted]"
+
+type WifiNetwork struct {
+	BSSID     string `json:"bssid"`
+	SSID      string `json:"ssid"`
+	Frequency int    `json:"frequency,omitempty"`
+	RSSI      int    `json:"rssi,omitempty"`
+	Flags     string `json:"flags,omitempty"`
+}
+
+type WifiReport struct {
+	Redacted    bool          `json:"redacted"`
+	Connected   *WifiNetwork  `json:"connected"`
+	ScanResults []WifiNetwork `json:"scan_results"`
+}
+
+var (
+	bssidRegexp         = regexp.MustCompile(`^([0-9a-fA-F]{2}:){5}[0-9a-fA-F]{2}$`)
+	wifiConnectedRegexp = regexp.MustCompile(`SSID: "?([^",]*)"?, BSSID: (([0-9a-fA-F]{2}:){5}[0-9a-fA-F]{2})`)
+)
+
+type Wifi struct {
+	StoragePath string
+}
+
+func NewWifi() *Wifi {
+	return &Wifi{}
+}
+
+func (w *Wifi) Name() string {
+	return "wifi"
+}
+
+func (w *Wifi) InitStorage(storagePath string) error {
+	w.StoragePath = storagePath
+	return nil
+}
+
+func (w *Wifi) RequiresHardware() bool {
+	return true
+}
+
+// parseWifiScanResults parses the output of `cmd wifi list-scan-results`.
+func parseWifiScanResults(out string) []WifiNetwork {
+	networks := []WifiNetwork{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !bssidRegexp.MatchString(fields[0]) {
+			continue
+		}
+
+		network := WifiNetwork{BSSID: strings.ToLower(fields[0])}
+		network.Frequency, _ = strconv.Atoi(fields[1])
+		network.RSSI, _ = strconv.Atoi(fields[2])
+		ssidFields := fields[4:]
+		if last := fields[len(fields)-1]; strings.HasPrefix(last, "[") {
+			network.Flags = last
+			ssidFields = fields[4 : len(fields)-1]
+		}
+		network.SSID = strings.Join(ssidFields, " ")
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// redact removes the SSID and keeps only the manufacturer prefix of the BSSID,
+// so that the networks cannot be used to geolocate the device.
+func (n *WifiNetwork) redact() {
+	if len(n.BSSID) == 17 {
+		n.BSSID = n.BSSID[:8] + ":xx:xx:xx"
+	}
+	if n.SSID != "" {
+		n.SSID = redactedValue
+	}
+}
+
+func (w *Wifi) Run(acq *acquisition.Acquisition, fast bool) error {
+	log.Info("Collecting Wi-Fi scan results...")
+
+	report := WifiReport{
+		Redacted:    acq.Config.RedactWifi,
+		ScanResults: []WifiNetwork{},
+	}
+
+	status, err := adb.Client.Shell("cmd", "wifi", "status")
+	if err != nil || !wifiConnectedRegexp.MatchString(status) {
+		// Older versions of Android do not have `cmd wifi`.
+		status, _ = adb.Client.Shell("dumpsys", "wifi")
+	}
+	if match := wifiConnectedRegexp.FindStringSubmatch(status); match != nil {
+		report.Connected = &WifiNetwork{SSID: match[1], BSSID: strings.ToLower(match[2])}
+	}
+
+	out, err := adb.Client.Shell("cmd", "wifi", "list-scan-results")
+	if err != nil {
+		log.Debugf("Impossible to get Wi-Fi scan results: %v", err)
+	} else {
+		report.ScanResults = parseWifiScanResults(out)
+	}
+
+	if report.Redacted {
+		if report.Connected != nil {
+			report.Connected.redact()
+		}
+		for i := range report.ScanResults {
+			report.ScanResults[i].redact()
+		}
+	} else {
+		// The raw output is only kept when not redacting.
+		err = saveCommandOutput(filepath.Join(w.StoragePath, "wifi_scan.txt"), out)
+		if err != nil {
+			return err
+		}
+	}
+
+	return saveCommandOutputJson(filepath.Join(w.StoragePath, "wifi.json"), &report)
+}
@@ -0,0 +1,282 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+// Package parquet writes Apache Parquet files without external
+// dependencies. It only supports flat tables of required string and integer
+// columns, stored uncompressed with the plain encoding, which is all
+// androidqf needs to export large tables.
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Type is the type of the values of a column.
+type Type int
+
+const (
+	Int64 Type = iota
+	String
+)
+
+const (
+	magic = "PAR1"
+	// Rows are buffered in memory and written in row groups of this size.
+	rowGroupSize = 64 * 1024
+
+	// Values from the Thrift definition of the file format.
+	typeInt64            = 2
+	typeByteArray        = 6
+	repetitionRequired   = 0
+	convertedTypeUTF8    = 0
+	encodingPlain        = 0
+	encodingRLE          = 3
+	codecUncompressed    = 0
+	pageTypeDataPage     = 0
+	parquetFormatVersion = 1
+)
+
+// Column is a column of the table.
+type Column struct {
+	Name string
+	Type Type
+}
+
+type columnChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+type rowGroup struct {
+	rows    int64
+	size    int64
+	columns []columnChunk
+}
+
+// Writer creates a Parquet file with a single table.
+type Writer struct {
+	file      *os.File
+	out       *bufio.Writer
+	offset    int64
+	columns   []Column
+	pages     [][]byte
+	rows      int64
+	total     int64
+	rowGroups []rowGroup
+}
+
+// Create creates the Parquet file at path, with the given columns.
+func Create(path string, columns []Column) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("a table needs at least one column")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		file:    file,
+		out:     bufio.NewWriter(file),
+		columns: columns,
+		pages:   make([][]byte, len(columns)),
+	}
+	err = w.write([]byte(magic))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.out.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// Write adds a row. Values must be int, int64, bool or string, following
+// the types of the columns.
+func (w *Writer) Write(values ...any) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("expected %d values, got %d", len(w.columns), len(values))
+	}
+
+	for i, value := range values {
+		column := w.columns[i]
+		switch column.Type {
+		case Int64:
+			var v int64
+			switch value := value.(type) {
+			case int:
+				v = int64(value)
+			case int64:
+				v = value
+			case bool:
+				if value {
+					v = 1
+				}
+			default:
+				return fmt.Errorf("unsupported value of type %T for integer column %s", value, column.Name)
+			}
+			w.pages[i] = binary.LittleEndian.AppendUint64(w.pages[i], uint64(v))
+		case String:
+			v, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("unsupported value of type %T for string column %s", value, column.Name)
+			}
+			w.pages[i] = binary.LittleEndian.AppendUint32(w.pages[i], uint32(len(v)))
+			w.pages[i] = append(w.pages[i], v...)
+		}
+	}
+
+	w.rows++
+	if w.rows >= rowGroupSize {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+// flushRowGroup writes the buffered rows as a row group, with one data page
+// per column.
+func (w *Writer) flushRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+
+	group := rowGroup{rows: w.rows}
+	for i, data := range w.pages {
+		var header thriftWriter
+		header.i32(1, pageTypeDataPage)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5)
+		header.i32(1, int32(w.rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunk := columnChunk{
+			offset: w.offset,
+			size:   int64(len(header.buf) + len(data)),
+			values: w.rows,
+		}
+		err := w.write(header.buf)
+		if err != nil {
+			return err
+		}
+		err = w.write(data)
+		if err != nil {
+			return err
+		}
+
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.size
+		w.pages[i] = w.pages[i][:0]
+	}
+
+	w.rowGroups = append(w.rowGroups, group)
+	w.total += w.rows
+	w.rows = 0
+	return nil
+}
+
+// Close writes the remaining rows and the metadata of the file.
+func (w *Writer) Close() error {
+	defer w.file.Close()
+
+	err := w.flushRowGroup()
+	if err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.i32(1, parquetFormatVersion)
+
+	meta.beginList(2, thriftStruct, len(w.columns)+1)
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(w.columns)))
+	meta.stop()
+	for _, column := range w.columns {
+		if column.Type == String {
+			meta.i32(1, typeByteArray)
+		} else {
+			meta.i32(1, typeInt64)
+		}
+		meta.i32(3, repetitionRequired)
+		meta.binary(4, column.Name)
+		if column.Type == String {
+			meta.i32(6, convertedTypeUTF8)
+		}
+		meta.stop()
+	}
+	meta.endList()
+
+	meta.i64(3, w.total)
+
+	meta.beginList(4, thriftStruct, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		meta.beginList(1, thriftStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			if w.columns[i].Type == String {
+				meta.i32(1, typeByteArray)
+			} else {
+				meta.i32(1, typeInt64)
+			}
+			meta.beginList(2, thriftI32, 2)
+			meta.listI32(encodingPlain)
+			meta.listI32(encodingRLE)
+			meta.endList()
+			meta.beginList(3, thriftBinary, 1)
+			meta.listBinary(w.columns[i].Name)
+			meta.endList()
+			meta.i32(4, codecUncompressed)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.stop()
+		}
+		meta.endList()
+		meta.i64(2, group.size)
+		meta.i64(3, group.rows)
+		meta.stop()
+	}
+	meta.endList()
+
+	meta.binary(6, "androidqf")
+	meta.stop()
+
+	err = w.write(meta.buf)
+	if err != nil {
+		return err
+	}
+	err = w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta.buf))))
+	if err != nil {
+		return err
+	}
+	err = w.write([]byte(magic))
+	if err != nil {
+		return err
+	}
+	err = w.out.Flush()
+	if err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,227 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tstruct is a struct decoded from the Thrift compact protocol, by field
+// identifier.
+type tstruct map[int16]any
+
+// thriftReader decodes the Thrift compact protocol, to check what
+// thriftWriter encodes.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		panic("invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(valueType byte) any {
+	switch valueType {
+	case thriftI32:
+		return int32(r.zigzag())
+	case thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		size := int(r.varint())
+		v := string(r.buf[r.pos : r.pos+size])
+		r.pos += size
+		return v
+	case thriftList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", valueType))
+}
+
+func (r *thriftReader) readStruct() tstruct {
+	s := tstruct{}
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return s
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+func writeTestFile(t *testing.T, path string, rows int) {
+	w, err := Create(path, []Column{
+		{Name: "id", Type: Int64},
+		{Name: "name", Type: String},
+		{Name: "flag", Type: Int64},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		err = w.Write(i*i, strings.Repeat("x", i%20), i%2 == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// More rows than a row group, so that the file has two.
+	rows := rowGroupSize + 100
+	path := filepath.Join(t.TempDir(), "test.parquet")
+	writeTestFile(t, path, rows)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatal("missing magic bytes")
+	}
+	footerSize := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerSize
+	reader := &thriftReader{buf: data[footerStart : len(data)-8]}
+	meta := reader.readStruct()
+	if reader.pos != footerSize {
+		t.Fatalf("decoded %d bytes of the %d bytes of metadata", reader.pos, footerSize)
+	}
+
+	if meta[1] != int32(parquetFormatVersion) || meta[3] != int64(rows) || meta[6] != "androidqf" {
+		t.Fatalf("unexpected metadata: %v", meta)
+	}
+	schema := meta[2].([]any)
+	names := []string{}
+	for _, element := range schema[1:] {
+		names = append(names, element.(tstruct)[4].(string))
+	}
+	if len(schema) != 4 || schema[0].(tstruct)[5] != int32(3) || strings.Join(names, ",") != "id,name,flag" {
+		t.Fatalf("unexpected schema: %v", schema)
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 row groups, got %d", len(groups))
+	}
+	row := 0
+	for _, g := range groups {
+		group := g.(tstruct)
+		groupRows := int(group[3].(int64))
+		chunks := group[1].([]any)
+		values := make([][]any, len(chunks))
+		for c, chunk := range chunks {
+			chunkMeta := chunk.(tstruct)[3].(tstruct)
+			offset := int(chunkMeta[9].(int64))
+			size := int(chunkMeta[6].(int64))
+			if chunkMeta[5] != int64(groupRows) || chunkMeta[3].([]any)[0] != names[c] {
+				t.Fatalf("unexpected column chunk metadata: %v", chunkMeta)
+			}
+
+			page := &thriftReader{buf: data[offset : offset+size]}
+			header := page.readStruct()
+			pageSize := int(header[3].(int32))
+			if header[1] != int32(pageTypeDataPage) || page.pos+pageSize != size ||
+				header[5].(tstruct)[1] != int32(groupRows) {
+				t.Fatalf("unexpected page header: %v", header)
+			}
+
+			pageData := data[offset+page.pos : offset+size]
+			for len(pageData) > 0 {
+				if names[c] == "name" {
+					length := int(binary.LittleEndian.Uint32(pageData))
+					values[c] = append(values[c], string(pageData[4:4+length]))
+					pageData = pageData[4+length:]
+				} else {
+					values[c] = append(values[c], int64(binary.LittleEndian.Uint64(pageData)))
+					pageData = pageData[8:]
+				}
+			}
+		}
+
+		for i := 0; i < groupRows; i++ {
+			flag := int64(0)
+			if row%2 == 0 {
+				flag = 1
+			}
+			if values[0][i] != int64(row*row) || values[1][i] != strings.Repeat("x", row%20) || values[2][i] != flag {
+				t.Fatalf("unexpected values in row %d: %v %v %v", row, values[0][i], values[1][i], values[2][i])
+			}
+			row++
+		}
+	}
+	if row != rows {
+		t.Fatalf("decoded %d rows, expected %d", row, rows)
+	}
+}
+
+func TestReferenceReader(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	if exec.Command(python, "-c", "import pyarrow.parquet").Run() != nil {
+		t.Skip("pyarrow is not available")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.parquet")
+	writeTestFile(t, path, rowGroupSize+100)
+
+	script := "import sys, pyarrow.parquet as pq\n" +
+		"t = pq.read_table(sys.argv[1])\n" +
+		"print(t.num_rows, sum(t.column('flag').to_pylist()), t.column('id')[40000], t.column('name')[39])\n"
+	out, err := exec.Command(python, "-c", script, path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pyarrow failed: %v: %s", err, out)
+	}
+	expected := fmt.Sprintf("%d %d %d %s\n", rowGroupSize+100, (rowGroupSize+100+1)/2, 40000*40000,
+		strings.Repeat("x", 39%20))
+	if string(out) != expected {
+		t.Fatalf("unexpected results:\n%s\nexpected:\n%s", out, expected)
+	}
+}
@@ -0,0 +1,107 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package parquet
+
+import "encoding/binary"
+
+// Types of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the metadata of the file with the Thrift compact
+// protocol, in which field identifiers are stored as the difference from
+// the previous field of the same struct.
+type thriftWriter struct {
+	buf []byte
+	// Last field identifier of each struct being written.
+	ids []int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, fieldType byte) {
+	if len(t.ids) == 0 {
+		t.ids = append(t.ids, 0)
+	}
+	last := &t.ids[len(t.ids)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|fieldType)
+	} else {
+		t.buf = append(t.buf, fieldType)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.listBinary(v)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.ids = append(t.ids, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.ids = t.ids[:len(t.ids)-1]
+}
+
+// stop ends the top-level struct, or the current struct element of a list.
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+	if len(t.ids) > 0 {
+		t.ids[len(t.ids)-1] = 0
+	}
+}
+
+// beginList starts a list field. Struct elements are written as a sequence
+// of fields, each followed by stop.
+func (t *thriftWriter) beginList(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.varint(uint64(size))
+	}
+	t.ids = append(t.ids, 0)
+}
+
+func (t *thriftWriter) endList() {
+	t.ids = t.ids[:len(t.ids)-1]
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
@@ -0,0 +1,322 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package selftest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mvt-project/androidqf/acquisition"
+	"github.com/mvt-project/androidqf/adb"
+	"github.com/mvt-project/androidqf/config"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// ReportFile is written in the folder of the acquisition made by the self
+// test.
+const ReportFile = "selftest.json"
+
+// Marker is a benign trace planted on the test device, which the module
+// must capture in its output.
+type Marker struct {
+	Module string `json:"module"`
+	// How the marker was planted on the device.
+	Planted string `json:"planted"`
+	Value   string `json:"value"`
+	cleanup []string
+}
+
+// Result tells whether the module captured its marker.
+type Result struct {
+	Marker
+	Captured bool   `json:"captured"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Report is the outcome of the self test.
+type Report struct {
+	Started time.Time                `json:"started"`
+	Folder  string                   `json:"folder"`
+	Package string                   `json:"package,omitempty"`
+	Results []Result                 `json:"results"`
+	Issues  []acquisition.CheckIssue `json:"issues"`
+	Passed  bool                     `json:"passed"`
+}
+
+// SelfTest plants markers on a test device, to verify that a full
+// acquisition captures all of them.
+type SelfTest struct {
+	ID      string
+	Markers []*Marker
+	// Package installed from the benign APK, if any.
+	Package string
+	Started time.Time
+}
+
+// answers are the answers to the questions asked during the acquisition
+// made by the self test, which must run unattended.
+var answers = map[string]string{
+	"backup":                  "No backup",
+	"download_apks":           "Only non-system packages",
+	"remove_trusted_apks":     "No",
+	"preflight":               "Proceed with all modules",
+	"retry_failed":            "no",
+	"consent_backup":          "yes",
+	"consent_bugreport":       "yes",
+	"consent_logcat":          "yes",
+	"consent_network_capture": "yes",
+	"consent_sdcard":          "yes",
+}
+
+// SearchString returns the string the on-device search must find.
+func (s *SelfTest) SearchString() string {
+	return "androidqf_selftest_" + s.ID
+}
+
+func thirdPartyPackages() (map[string]bool, error) {
+	out, err := adb.Client.Shell("pm", "list", "packages", "-3")
+	if err != nil {
+		return nil, err
+	}
+	packages := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "package:"); ok {
+			packages[name] = true
+		}
+	}
+	return packages, nil
+}
+
+// Plant plants the markers on the device, and installs the benign APK at
+// apkPath, if any.
+func Plant(apkPath string) (*SelfTest, error) {
+	s := &SelfTest{ID: strings.ReplaceAll(uuid.New().String(), "-", "")[:16], Started: time.Now().UTC()}
+	value := s.SearchString()
+	fileName := value + ".txt"
+
+	s.Markers = []*Marker{
+		{
+			Module:  "getprop",
+			Planted: "setprop debug.androidqf.selftest",
+			Value:   value,
+			cleanup: []string{"setprop", "debug.androidqf.selftest", "''"},
+		},
+		{
+			Module:  "settings",
+			Planted: "settings put global androidqf_selftest",
+			Value:   value,
+			cleanup: []string{"settings", "delete", "global", "androidqf_selftest"},
+		},
+		{Module: "logcat", Planted: "log -t androidqf_selftest", Value: value},
+		{
+			Module:  "files",
+			Planted: "/sdcard/" + fileName,
+			Value:   fileName,
+			cleanup: []string{"rm", "-f", "/sdcard/" + fileName},
+		},
+		{Module: "sdcard", Planted: "/sdcard/" + fileName, Value: value},
+		{
+			Module:  "temp",
+			Planted: "/data/local/tmp/" + fileName,
+			Value:   value,
+			cleanup: []string{"rm", "-f", "/data/local/tmp/" + fileName},
+		},
+		{Module: "search", Planted: "/sdcard/" + fileName, Value: value},
+	}
+
+	commands := [][]string{
+		{"setprop", "debug.androidqf.selftest", value},
+		{"settings", "put", "global", "androidqf_selftest", value},
+		{"log", "-t", "androidqf_selftest", value},
+		{"echo", value, ">", "/sdcard/" + fileName},
+		{"echo", value, ">", "/data/local/tmp/" + fileName},
+	}
+	for _, command := range commands {
+		_, err := adb.Client.Shell(command...)
+		if err != nil {
+			s.Cleanup()
+			return nil, fmt.Errorf("failed to plant marker with `%s`: %v", strings.Join(command, " "), err)
+		}
+	}
+
+	if apkPath != "" {
+		before, err := thirdPartyPackages()
+		if err != nil {
+			s.Cleanup()
+			return nil, err
+		}
+		out, err := adb.Client.Exec("install", "-r", apkPath)
+		if err != nil {
+			s.Cleanup()
+			return nil, fmt.Errorf("failed to install %s: %v: %s", apkPath, err, strings.TrimSpace(string(out)))
+		}
+		after, err := thirdPartyPackages()
+		if err != nil {
+			s.Cleanup()
+			return nil, err
+		}
+		for name := range after {
+			if !before[name] {
+				s.Package = name
+			}
+		}
+		if s.Package == "" {
+			s.Cleanup()
+			return nil, fmt.Errorf("%s is already installed on the test device, uninstall it first", apkPath)
+		}
+		s.Markers = append(s.Markers, &Marker{
+			Module:  "packages",
+			Planted: "adb install " + filepath.Base(apkPath),
+			Value:   s.Package,
+			cleanup: []string{"pm", "uninstall", s.Package},
+		})
+	}
+
+	return s, nil
+}
+
+// Cleanup removes the markers from the device.
+func (s *SelfTest) Cleanup() {
+	for _, marker := range s.Markers {
+		if len(marker.cleanup) == 0 {
+			continue
+		}
+		_, err := adb.Client.Shell(marker.cleanup...)
+		if err != nil {
+			log.Warningf("Failed to remove the marker of module %s: %v", marker.Module, err)
+		}
+	}
+}
+
+// Acquire runs a full acquisition of the test device with the androidqf
+// executable, stored in folder. The configuration is cfg, with the options
+// needed to capture the markers, and without uploads. args are passed on to
+// the acquisition.
+func (s *SelfTest) Acquire(cfg *config.Config, folder string, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "androidqf_selftest_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testCfg := *cfg
+	testCfg.Profile = "full"
+	testCfg.CopySdCard = true
+	testCfg.SearchStrings = append(append([]string{}, cfg.SearchStrings...), s.SearchString())
+	testCfg.Upload = nil
+
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	answersPath := filepath.Join(tmpDir, "answers.json")
+	for path, value := range map[string]any{cfgPath: &testCfg, answersPath: answers} {
+		data, err := json.MarshalIndent(value, "", "    ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path, data, 0o600)
+		if err != nil {
+			return err
+		}
+	}
+
+	args = append([]string{
+		"-profile", "full", "-config", cfgPath, "-answers", answersPath, "-no-preflight", "-o", folder,
+	}, args...)
+	cmd := exec.Command(executable, args...)
+	// The acquisition waits for Enter before exiting.
+	cmd.Stdin = strings.NewReader("\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("the acquisition failed: %v", err)
+	}
+	return nil
+}
+
+// moduleContains returns the first file of the module output containing
+// value, relative to the acquisition folder.
+func moduleContains(folder, module, value string) (string, error) {
+	found := ""
+	err := filepath.Walk(filepath.Join(folder, module), func(path string, info os.FileInfo, err error) error {
+		if err != nil || found != "" || info.IsDir() || info.Name() == "manifest.json" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, []byte(value)) {
+			found, _ = filepath.Rel(folder, path)
+		}
+		return nil
+	})
+	return filepath.ToSlash(found), err
+}
+
+// Verify checks that the acquisition stored in folder is complete, and that
+// every module captured its marker.
+func (s *SelfTest) Verify(folder string) (*Report, error) {
+	report := &Report{
+		Started: s.Started,
+		Folder:  folder,
+		Package: s.Package,
+		Results: []Result{},
+		Passed:  true,
+	}
+
+	check, err := acquisition.Check(folder)
+	if err != nil {
+		return nil, err
+	}
+	report.Issues = check.Issues
+	if check.Errors() > 0 {
+		report.Passed = false
+	}
+
+	for _, marker := range s.Markers {
+		result := Result{Marker: *marker}
+		file, err := moduleContains(folder, marker.Module, marker.Value)
+		switch {
+		case os.IsNotExist(err):
+			result.Detail = "the module has no output"
+		case err != nil:
+			result.Detail = err.Error()
+		case file == "":
+			result.Detail = "the marker is missing from the output of the module"
+		default:
+			result.Captured = true
+			result.Detail = file
+		}
+		report.Passed = report.Passed && result.Captured
+		report.Results = append(report.Results, result)
+	}
+	sort.SliceStable(report.Results, func(i, j int) bool {
+		return report.Results[i].Module < report.Results[j].Module
+	})
+
+	return report, nil
+}
+
+// Store writes the report in the acquisition folder.
+func (r *Report) Store() error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json marshal the self test report: %v", err)
+	}
+	return os.WriteFile(filepath.Join(r.Folder, ReportFile), data, 0o644)
+}
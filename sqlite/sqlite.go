@@ -0,0 +1,463 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+// Package sqlite writes SQLite database files without cgo or external
+// dependencies. It only supports creating a new database with plain tables
+// which are filled one after the other, which is all androidqf needs to
+// store its results.
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	pageSize = 4096
+	// Maximum payload stored in a table leaf cell before spilling to
+	// overflow pages, as defined by the file format.
+	maxLocal = pageSize - 35
+	minLocal = (pageSize-12)*32/255 - 23
+
+	pageTypeLeaf     = 0x0d
+	pageTypeInterior = 0x05
+
+	// The page containing the byte at offset 1GiB is reserved for the
+	// locks of the database and must never be used.
+	lockBytePage = 0x40000000/pageSize + 1
+)
+
+// Column is a column of a table.
+type Column struct {
+	Name string
+	Type string
+}
+
+type childPage struct {
+	page   uint32
+	maxKey int64
+}
+
+// Writer creates a SQLite database file.
+type Writer struct {
+	file     *os.File
+	nextPage uint32
+	tables   []*Table
+	current  *Table
+}
+
+// Table is a table being filled.
+type Table struct {
+	w        *Writer
+	name     string
+	columns  []Column
+	rowid    int64
+	root     uint32
+	leaf     *page
+	children []childPage
+}
+
+// page is a b-tree page being filled. Cells are stored from the end of the
+// page, while the array of pointers to them grows after the header.
+type page struct {
+	data    []byte
+	offset  int
+	header  int
+	cells   int
+	content int
+	maxKey  int64
+}
+
+func newPage(pageType byte, offset int) *page {
+	p := &page{
+		data:    make([]byte, pageSize),
+		offset:  offset,
+		content: pageSize,
+	}
+	p.data[offset] = pageType
+	p.header = 8
+	if pageType == pageTypeInterior {
+		p.header = 12
+	}
+	return p
+}
+
+func (p *page) free() int {
+	return p.content - (p.offset + p.header + p.cells*2)
+}
+
+func (p *page) addCell(cell []byte, key int64) bool {
+	if len(cell)+2 > p.free() {
+		return false
+	}
+	p.content -= len(cell)
+	copy(p.data[p.content:], cell)
+	binary.BigEndian.PutUint16(p.data[p.offset+p.header+p.cells*2:], uint16(p.content))
+	p.cells++
+	p.maxKey = key
+	return true
+}
+
+func (p *page) finish() []byte {
+	binary.BigEndian.PutUint16(p.data[p.offset+3:], uint16(p.cells))
+	binary.BigEndian.PutUint16(p.data[p.offset+5:], uint16(p.content))
+	return p.data
+}
+
+// Create creates a new database at the given path, replacing any existing
+// file.
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Page 1 contains the header and the schema, and is written last.
+	return &Writer{file: file, nextPage: 2}, nil
+}
+
+func (w *Writer) writePage(data []byte) (uint32, error) {
+	if w.nextPage == lockBytePage {
+		w.nextPage++
+	}
+	number := w.nextPage
+	w.nextPage++
+	_, err := w.file.WriteAt(data, int64(number-1)*pageSize)
+	return number, err
+}
+
+// CreateTable starts a new table. Rows must be inserted in a table before
+// the next one is created.
+func (w *Writer) CreateTable(name string, columns []Column) (*Table, error) {
+	if w.current != nil {
+		err := w.current.finish()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := &Table{
+		w:       w,
+		name:    name,
+		columns: columns,
+		leaf:    newPage(pageTypeLeaf, 0),
+	}
+	w.tables = append(w.tables, t)
+	w.current = t
+
+	return t, nil
+}
+
+// Insert adds a row to the table. Values can be nil, bool, integers,
+// float64, string or []byte.
+func (t *Table) Insert(values ...any) error {
+	if t.w.current != t {
+		return fmt.Errorf("table %s is already complete", t.name)
+	}
+	if len(values) != len(t.columns) {
+		return fmt.Errorf("table %s has %d columns, got %d values", t.name, len(t.columns), len(values))
+	}
+
+	record, err := encodeRecord(values)
+	if err != nil {
+		return err
+	}
+
+	t.rowid++
+	cell, err := t.w.leafCell(t.rowid, record)
+	if err != nil {
+		return err
+	}
+
+	if !t.leaf.addCell(cell, t.rowid) {
+		err = t.flushLeaf()
+		if err != nil {
+			return err
+		}
+		t.leaf.addCell(cell, t.rowid)
+	}
+
+	return nil
+}
+
+func (t *Table) flushLeaf() error {
+	number, err := t.w.writePage(t.leaf.finish())
+	if err != nil {
+		return err
+	}
+	t.children = append(t.children, childPage{page: number, maxKey: t.leaf.maxKey})
+	t.leaf = newPage(pageTypeLeaf, 0)
+	return nil
+}
+
+// finish writes the remaining rows and the interior pages of the table.
+func (t *Table) finish() error {
+	if t.leaf.cells > 0 || len(t.children) == 0 {
+		err := t.flushLeaf()
+		if err != nil {
+			return err
+		}
+	}
+
+	children := t.children
+	for len(children) > 1 {
+		parents := []childPage{}
+		for _, group := range groupChildren(children) {
+			interior := newPage(pageTypeInterior, 0)
+			for _, child := range group[:len(group)-1] {
+				cell := binary.BigEndian.AppendUint32(nil, child.page)
+				cell = appendVarint(cell, uint64(child.maxKey))
+				interior.addCell(cell, child.maxKey)
+			}
+			// The last child of each interior page is its right-most
+			// pointer, which has no cell.
+			last := group[len(group)-1]
+			binary.BigEndian.PutUint32(interior.data[8:], last.page)
+			number, err := t.w.writePage(interior.finish())
+			if err != nil {
+				return err
+			}
+			parents = append(parents, childPage{page: number, maxKey: last.maxKey})
+		}
+		children = parents
+	}
+
+	t.root = children[0].page
+	t.w.current = nil
+	return nil
+}
+
+// groupChildren splits the children of a level of the b-tree in groups which
+// fit in an interior page, each with at least two children.
+func groupChildren(children []childPage) [][]childPage {
+	// An interior cell takes at most 13 bytes, plus its pointer.
+	size := (pageSize-12)/15 + 1
+
+	groups := [][]childPage{}
+	for len(children) > 0 {
+		n := size
+		if n > len(children) {
+			n = len(children)
+		}
+		groups = append(groups, children[:n])
+		children = children[n:]
+	}
+
+	last := len(groups) - 1
+	if last > 0 && len(groups[last]) == 1 {
+		prev := groups[last-1]
+		groups[last] = append([]childPage{prev[len(prev)-1]}, groups[last]...)
+		groups[last-1] = prev[:len(prev)-1]
+	}
+
+	return groups
+}
+
+// leafCell encodes a table leaf cell, writing overflow pages if the record
+// does not fit in the page.
+func (w *Writer) leafCell(rowid int64, record []byte) ([]byte, error) {
+	cell := appendVarint(nil, uint64(len(record)))
+	cell = appendVarint(cell, uint64(rowid))
+
+	if len(record) <= maxLocal {
+		return append(cell, record...), nil
+	}
+
+	local := minLocal + (len(record)-minLocal)%(pageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	// Overflow pages are chained, so they are written from the last one.
+	chunks := [][]byte{}
+	for rest := record[local:]; len(rest) > 0; {
+		n := pageSize - 4
+		if n > len(rest) {
+			n = len(rest)
+		}
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	next := uint32(0)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		data := make([]byte, pageSize)
+		binary.BigEndian.PutUint32(data, next)
+		copy(data[4:], chunks[i])
+		number, err := w.writePage(data)
+		if err != nil {
+			return nil, err
+		}
+		next = number
+	}
+
+	cell = append(cell, record[:local]...)
+	return binary.BigEndian.AppendUint32(cell, next), nil
+}
+
+// Close writes the schema and the header of the database.
+func (w *Writer) Close() error {
+	defer w.file.Close()
+
+	if w.current != nil {
+		err := w.current.finish()
+		if err != nil {
+			return err
+		}
+	}
+
+	schema := newPage(pageTypeLeaf, 100)
+	for i, t := range w.tables {
+		columns := make([]string, len(t.columns))
+		for j, column := range t.columns {
+			columns[j] = fmt.Sprintf("%s %s", column.Name, column.Type)
+		}
+		sql := fmt.Sprintf("CREATE TABLE %s (%s)", t.name, strings.Join(columns, ", "))
+
+		record, err := encodeRecord([]any{"table", t.name, t.name, int64(t.root), sql})
+		if err != nil {
+			return err
+		}
+		cell := appendVarint(nil, uint64(len(record)))
+		cell = appendVarint(cell, uint64(i+1))
+		cell = append(cell, record...)
+		if !schema.addCell(cell, int64(i+1)) {
+			return errors.New("too many tables for the schema page")
+		}
+	}
+
+	data := schema.finish()
+	copy(data, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(data[16:], pageSize)
+	data[18] = 1
+	data[19] = 1
+	data[21] = 64
+	data[22] = 32
+	data[23] = 32
+	// File change counter, size of the database in pages, schema cookie,
+	// schema format, text encoding (UTF-8), and version-valid-for number.
+	binary.BigEndian.PutUint32(data[24:], 1)
+	binary.BigEndian.PutUint32(data[28:], w.nextPage-1)
+	binary.BigEndian.PutUint32(data[40:], 1)
+	binary.BigEndian.PutUint32(data[44:], 4)
+	binary.BigEndian.PutUint32(data[56:], 1)
+	binary.BigEndian.PutUint32(data[92:], 1)
+	binary.BigEndian.PutUint32(data[96:], 3040001)
+
+	_, err := w.file.WriteAt(data, 0)
+	if err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		if i > 0 {
+			b = append(b, buf[i]|0x80)
+		} else {
+			b = append(b, buf[i])
+		}
+	}
+	return b
+}
+
+func encodeInt(v int64) (uint64, []byte) {
+	switch {
+	case v == 0:
+		return 8, nil
+	case v == 1:
+		return 9, nil
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, []byte{byte(v)}
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, binary.BigEndian.AppendUint16(nil, uint16(v))
+	case v >= -(1<<23) && v < 1<<23:
+		return 3, []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, binary.BigEndian.AppendUint32(nil, uint32(v))
+	case v >= -(1<<47) && v < 1<<47:
+		return 5, binary.BigEndian.AppendUint64(nil, uint64(v))[2:]
+	}
+	return 6, binary.BigEndian.AppendUint64(nil, uint64(v))
+}
+
+// encodeRecord encodes values in the record format, with a header listing
+// the serial types of the values followed by the values themselves.
+func encodeRecord(values []any) ([]byte, error) {
+	types := []byte{}
+	body := []byte{}
+
+	for _, value := range values {
+		var serial uint64
+		var data []byte
+
+		switch v := value.(type) {
+		case nil:
+			serial = 0
+		case bool:
+			serial = 8
+			if v {
+				serial = 9
+			}
+		case int:
+			serial, data = encodeInt(int64(v))
+		case int32:
+			serial, data = encodeInt(int64(v))
+		case int64:
+			serial, data = encodeInt(v)
+		case uint32:
+			serial, data = encodeInt(int64(v))
+		case float64:
+			serial = 7
+			data = binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+		case string:
+			serial = uint64(len(v))*2 + 13
+			data = []byte(v)
+		case []byte:
+			serial = uint64(len(v))*2 + 12
+			data = v
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", value)
+		}
+
+		types = appendVarint(types, serial)
+		body = append(body, data...)
+	}
+
+	// The size of the header includes the varint encoding it.
+	headerSize := len(types) + 1
+	if len(appendVarint(nil, uint64(headerSize))) > 1 {
+		headerSize++
+	}
+	record := appendVarint(nil, uint64(headerSize))
+	record = append(record, types...)
+	return append(record, body...), nil
+}
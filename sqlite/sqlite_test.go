@@ -0,0 +1,111 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package sqlite
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestDatabase(t *testing.T, path string) {
+	db, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.CreateTable("rows", []Column{
+		{Name: "id", Type: "INTEGER"},
+		{Name: "name", Type: "TEXT"},
+		{Name: "flag", Type: "INTEGER"},
+		{Name: "ratio", Type: "REAL"},
+		{Name: "data", Type: "BLOB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50000; i++ {
+		err = rows.Insert(int64(i)*int64(i), strings.Repeat("x", i%200), i%2 == 0, float64(i)/3, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	large, err := db.CreateTable("large", []Column{{Name: "value", Type: "TEXT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{4000, 4061, 4062, 10000, 100000} {
+		err = large.Insert(strings.Repeat("a", size))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.CreateTable("empty", []Column{{Name: "value", Type: "TEXT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not available")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	writeTestDatabase(t, path)
+
+	query := strings.Join([]string{
+		"PRAGMA integrity_check",
+		"SELECT count(*), sum(flag), max(length(name)) FROM rows",
+		"SELECT id, name, ratio FROM rows WHERE rowid = 40000",
+		"SELECT group_concat(length(value)) FROM large",
+		"SELECT count(*) FROM empty",
+	}, ";")
+	out, err := exec.Command(sqlite3, path, query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %v: %s", err, out)
+	}
+
+	expected := "ok\n" +
+		"50000|25000|199\n" +
+		"1599920001|" + strings.Repeat("x", 39999%200) + "|13333.0\n" +
+		"4000,4061,4062,10000,100000\n" +
+		"0\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected query results:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestLockBytePage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	defer db.file.Close()
+
+	db.nextPage = lockBytePage - 1
+	for _, expected := range []uint32{lockBytePage - 1, lockBytePage + 1} {
+		number, err := db.writePage(make([]byte, pageSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number != expected {
+			t.Fatalf("wrote page %d, expected %d", number, expected)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package timesketch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client uploads timelines to a Timesketch server through its API.
+type Client struct {
+	URL   string
+	Token string
+	http  *http.Client
+}
+
+type sketch struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type sketchesResponse struct {
+	Objects []sketch `json:"objects"`
+}
+
+// New returns a client for the Timesketch server at the given URL. Only
+// HTTPS URLs are accepted, as the API token is sent with every request.
+func New(serverURL, token string) (*Client, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Timesketch URL: %v", err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("refusing to send the Timesketch token to %s: the URL must use https", serverURL)
+	}
+
+	return &Client{
+		URL:   strings.TrimSuffix(serverURL, "/"),
+		Token: token,
+		http:  &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (c *Client) do(method, path, contentType string, body io.Reader, v any) error {
+	req, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Timesketch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("request to %s failed: unexpected status %s", path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Sketch returns the ID of the sketch with the given name, creating it if it
+// does not exist yet.
+func (c *Client) Sketch(name string) (int, error) {
+	var sketches sketchesResponse
+	err := c.do(http.MethodGet, "/api/v1/sketches/", "", nil, &sketches)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range sketches.Objects {
+		if s.Name == name {
+			return s.ID, nil
+		}
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"name":        name,
+		"description": "Created by androidqf",
+	})
+	if err != nil {
+		return 0, err
+	}
+	var created sketchesResponse
+	err = c.do(http.MethodPost, "/api/v1/sketches/", "application/json", bytes.NewReader(body), &created)
+	if err != nil {
+		return 0, err
+	}
+	if len(created.Objects) == 0 {
+		return 0, fmt.Errorf("Timesketch did not return the new sketch")
+	}
+
+	return created.Objects[0].ID, nil
+}
+
+// Upload adds the JSONL timeline at path to the sketch with the given ID.
+func (c *Client) Upload(sketchID int, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The form is streamed, so that large timelines are not held in memory.
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploadForm(form, file, sketchID, name, stat.Size()))
+	}()
+	defer body.Close()
+
+	return c.do(http.MethodPost, "/api/v1/upload/", form.FormDataContentType(), body, nil)
+}
+
+func writeUploadForm(form *multipart.Writer, file *os.File, sketchID int, name string, size int64) error {
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"name", name},
+		{"sketch_id", strconv.Itoa(sketchID)},
+		{"provider", "androidqf"},
+		{"data_label", "androidqf"},
+		{"total_file_size", strconv.FormatInt(size, 10)},
+	}
+	for _, field := range fields {
+		err := form.WriteField(field.key, field.value)
+		if err != nil {
+			return err
+		}
+	}
+	part, err := form.CreateFormFile("file", filepath.Base(file.Name()))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return fmt.Errorf("failed to read timeline: %v", err)
+	}
+	return form.Close()
+}
@@ -0,0 +1,84 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package upload
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/botherder/go-savetime/hashes"
+)
+
+// Client uploads encrypted acquisitions to a server, which stores the body
+// of PUT requests to <URL>/<file name>.
+type Client struct {
+	URL   string
+	Token string
+	http  *http.Client
+}
+
+// New returns a client for the upload server at the given URL. Only HTTPS
+// URLs are accepted, as the token is sent with every request.
+func New(serverURL, token string) (*Client, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload URL: %v", err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("refusing to upload acquisitions to %s: the URL must use https", serverURL)
+	}
+
+	return &Client{
+		URL:   strings.TrimSuffix(serverURL, "/"),
+		Token: token,
+		http:  &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+// Upload sends the file at path, with its SHA256 for the server to check
+// that it was received entirely.
+func (c *Client) Upload(path string) error {
+	sha256, err := hashes.FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.URL+"/"+url.PathEscape(filepath.Base(path)), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Content-SHA256", sha256)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the upload server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload of %s failed: unexpected status %s", filepath.Base(path), resp.Status)
+	}
+	return nil
+}
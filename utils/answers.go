@@ -0,0 +1,84 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/mvt-project/androidqf/log"
+)
+
+// Answers maps the questions asked to the operator to predefined responses.
+// Questions without an answer are still asked interactively.
+type Answers map[string]string
+
+var answers Answers
+
+// LoadAnswers reads the answers file at the given path. Its keys are the
+// names of the questions, and its values one of the choices offered.
+func LoadAnswers(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read answers file: %v", err)
+	}
+	loaded := Answers{}
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return fmt.Errorf("failed to parse answers file: %v", err)
+	}
+	answers = loaded
+	return nil
+}
+
+// answer returns the predefined answer to the given question, if any.
+func answer(question string) (string, bool) {
+	value, ok := answers[question]
+	return strings.TrimSpace(value), ok
+}
+
+// Select asks the operator to pick one of the items, unless the answers file
+// has a valid answer to the question.
+func Select(question, label string, items []string) (string, error) {
+	if value, ok := answer(question); ok {
+		for _, item := range items {
+			if strings.EqualFold(item, value) {
+				log.Infof("%s: %s (from answers file)", label, item)
+				return item, nil
+			}
+		}
+		log.Warningf("Ignoring invalid answer %q to question %s, expected one of: %s", value, question,
+			strings.Join(items, ", "))
+	}
+
+	prompt := promptui.Select{
+		Label: label,
+		Items: items,
+	}
+	_, choice, err := prompt.Run()
+	return choice, err
+}
+
+// Confirm asks the operator a yes or no question, unless the answers file
+// has a valid answer to it.
+func Confirm(question, s string) bool {
+	if value, ok := answer(question); ok {
+		switch strings.ToLower(value) {
+		case "y", "yes":
+			log.Infof("%s yes (from answers file)", s)
+			return true
+		case "n", "no":
+			log.Infof("%s no (from answers file)", s)
+			return false
+		}
+		log.Warningf("Ignoring invalid answer %q to question %s, expected yes or no", value, question)
+	}
+
+	return AskForConfirmation(s)
+}
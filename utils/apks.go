@@ -6,8 +6,13 @@
 package utils
 
 import (
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"path"
+	"strings"
 
+	"github.com/avast/apkparser"
 	"github.com/avast/apkverifier"
 )
 
@@ -315,3 +320,66 @@ func VerifyCertificate(path string) (bool, *apkverifier.CertInfo, error) {
 	}
 	return true, cert, nil
 }
+
+// applicationEncoder captures the label and icon of the application element
+// of the manifest.
+type applicationEncoder struct {
+	label string
+	icon  string
+}
+
+func (e *applicationEncoder) EncodeToken(t xml.Token) error {
+	start, ok := t.(xml.StartElement)
+	if !ok || start.Name.Local != "application" {
+		return nil
+	}
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "label":
+			e.label = attr.Value
+		case "icon":
+			e.icon = attr.Value
+		}
+	}
+	return apkparser.ErrEndParsing
+}
+
+func (e *applicationEncoder) Flush() error {
+	return nil
+}
+
+// AppLabel returns the display label of the app in the APK at apkPath, the
+// name of its launcher icon in the APK, and the icon itself if it is a
+// bitmap. Adaptive icons defined in XML are not rendered.
+func AppLabel(apkPath string) (string, string, []byte, error) {
+	zip, err := apkparser.OpenZip(LongPath(apkPath))
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer zip.Close()
+
+	enc := &applicationEncoder{}
+	_, err = apkparser.ParseApkWithZip(zip, enc)
+	if err != nil && err != apkparser.ErrEndParsing {
+		return "", "", nil, fmt.Errorf("failed to parse the manifest: %v", err)
+	}
+	// Unresolved references are reported as @<resource id>.
+	label, icon := enc.label, enc.icon
+	if strings.HasPrefix(label, "@") {
+		label = ""
+	}
+	if strings.HasPrefix(icon, "@") {
+		icon = ""
+	}
+
+	ext := strings.ToLower(path.Ext(icon))
+	file := zip.File[icon]
+	if file == nil || (ext != ".png" && ext != ".webp" && ext != ".jpg") {
+		return label, icon, nil, nil
+	}
+	data, err := file.ReadAll(4 * 1024 * 1024)
+	if err != nil {
+		return label, icon, nil, err
+	}
+	return label, icon, data, nil
+}
@@ -0,0 +1,40 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Download retrieves the content at the given HTTP(S) URL.
+func Download(url string) ([]byte, error) {
+	client := http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: unexpected status %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// ReadLocation returns the content of either a URL or a local file.
+func ReadLocation(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		return Download(location)
+	}
+
+	return os.ReadFile(location)
+}
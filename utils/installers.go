@@ -0,0 +1,57 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+const (
+	InstallSourcePlayStore  = "play_store"
+	InstallSourceOEM        = "oem"
+	InstallSourceSideloaded = "sideloaded"
+)
+
+func PlayStoreInstallers() []string {
+	return []string{
+		"com.android.vending",
+		"com.google.android.feedback",
+	}
+}
+
+func OEMInstallers() []string {
+	return []string{
+		"com.sec.android.app.samsungapps", // Samsung Galaxy Store
+		"com.huawei.appmarket",            // Huawei AppGallery
+		"com.xiaomi.market",               // Xiaomi GetApps
+		"com.xiaomi.mipicks",              // Xiaomi GetApps
+		"com.oppo.market",                 // Oppo App Market
+		"com.heytap.market",               // Oppo/Realme App Market
+		"com.bbk.appstore",                // Vivo App Store
+		"com.oneplus.market",              // OnePlus
+		"com.lenovo.leos.appstore",        // Lenovo
+		"com.amazon.venezia",              // Amazon Appstore (Fire OS)
+	}
+}
+
+// InstallSource classifies a package by the way it was installed on the
+// device: from the Play Store, by the manufacturer (either preinstalled or
+// through an OEM store) or from any other source.
+func InstallSource(installer string, system bool) string {
+	for _, i := range PlayStoreInstallers() {
+		if i == installer {
+			return InstallSourcePlayStore
+		}
+	}
+
+	if system {
+		return InstallSourceOEM
+	}
+
+	for _, i := range OEMInstallers() {
+		if i == installer {
+			return InstallSourceOEM
+		}
+	}
+
+	return InstallSourceSideloaded
+}
@@ -0,0 +1,14 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+//go:build !windows
+
+package utils
+
+// LongPath returns the path unchanged, as only Windows limits the length of
+// paths.
+func LongPath(path string) string {
+	return path
+}
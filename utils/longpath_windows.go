@@ -0,0 +1,30 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Paths longer than this need the extended-length prefix on Windows.
+const maxPathLength = 248
+
+// LongPath returns an absolute path which can be opened on Windows even if it
+// is longer than MAX_PATH, using the extended-length prefix.
+func LongPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil || len(abs) < maxPathLength {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,147 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/botherder/go-savetime/hashes"
+)
+
+// OutputEntry describes a file written to an OutputSink.
+type OutputEntry struct {
+	Size    int64
+	SHA256  string
+	ModTime time.Time
+}
+
+// OutputSink receives the files produced by the acquisition instead of the
+// local disk, for example to encrypt them as they are written.
+type OutputSink interface {
+	// Create returns a writer for the file at the given path. Buffered files
+	// can stay open while other files are written.
+	Create(path string, buffered bool) (io.WriteCloser, error)
+	// Open opens a file previously written for reading, if the sink kept
+	// it available.
+	Open(path string) (io.ReadCloser, error)
+	// Entries returns all the files written so far, indexed by path.
+	Entries() map[string]OutputEntry
+}
+
+var outputSink OutputSink
+
+// SetOutputSink redirects all outputs to the given sink.
+func SetOutputSink(sink OutputSink) {
+	outputSink = sink
+}
+
+// OutputSinkEnabled returns true if outputs are not written to disk.
+func OutputSinkEnabled() bool {
+	return outputSink != nil
+}
+
+// StreamOutputs returns true if outputs must be written through
+// CreateOutput, rather than by external tools writing straight to disk.
+func StreamOutputs() bool {
+	return outputSink != nil || pseudonymizer != nil
+}
+
+func createOutput(path string, buffered bool) (io.WriteCloser, error) {
+	var w io.WriteCloser
+	var err error
+	if outputSink == nil {
+		w, err = os.Create(LongPath(path))
+	} else {
+		w, err = outputSink.Create(path, buffered)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filterOutput(path, w), nil
+}
+
+// CreateOutput creates an output file, on disk or in the output sink.
+func CreateOutput(path string) (io.WriteCloser, error) {
+	return createOutput(path, false)
+}
+
+// CreateBufferedOutput creates an output file which can be kept open while
+// other outputs are written, such as a log file.
+func CreateBufferedOutput(path string) (io.WriteCloser, error) {
+	return createOutput(path, true)
+}
+
+// AppendOutput opens an output file on disk for appending, such as a log
+// file written again when an acquisition is resumed.
+func AppendOutput(path string) (io.WriteCloser, error) {
+	if outputSink != nil {
+		return nil, fmt.Errorf("cannot append to %s in the output sink", path)
+	}
+	w, err := os.OpenFile(LongPath(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	return filterOutput(path, w), nil
+}
+
+// WriteOutput writes data to an output file.
+func WriteOutput(path string, data []byte) error {
+	file, err := CreateOutput(path)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadOutput reads an output file previously written.
+func ReadOutput(path string) ([]byte, error) {
+	if outputSink == nil {
+		return os.ReadFile(LongPath(path))
+	}
+	file, err := outputSink.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// OpenOutput opens an output file previously written for reading.
+func OpenOutput(path string) (io.ReadCloser, error) {
+	if outputSink == nil {
+		return os.Open(LongPath(path))
+	}
+	return outputSink.Open(path)
+}
+
+// OutputSHA256 returns the SHA256 of an output file previously written.
+func OutputSHA256(path string) (string, error) {
+	if outputSink == nil {
+		return hashes.FileSHA256(path)
+	}
+	entry, ok := outputSink.Entries()[path]
+	if !ok {
+		return "", fmt.Errorf("output file %s not found", path)
+	}
+	return entry.SHA256, nil
+}
+
+// OutputEntries returns the files written to the output sink, or nil if
+// outputs are written to disk.
+func OutputEntries() map[string]OutputEntry {
+	if outputSink == nil {
+		return nil
+	}
+	return outputSink.Entries()
+}
@@ -0,0 +1,108 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Longest name of a file or folder created from a name on the device. Most
+// file systems allow 255 bytes, which leaves room for a suffix.
+const maxFileNameLength = 200
+
+// Names which cannot be used for files on Windows, whatever the extension.
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+var (
+	renamedMu sync.Mutex
+	// Original paths on the device of the files stored under another name,
+	// by local path.
+	renamed = map[string]string{}
+)
+
+// SanitizeFileName returns a name which can be used for a file on any
+// operating system: characters not allowed on Windows and invalid UTF-8 are
+// replaced, reserved names are prefixed, and long names are shortened with a
+// hash of the original name to keep them unique.
+func SanitizeFileName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToValidUTF8(name, "_") {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			b.WriteRune('_')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	clean := strings.TrimRight(b.String(), ". ")
+	if clean == "" {
+		clean = "_"
+	}
+	if base, _, _ := strings.Cut(clean, "."); reservedFileNames[strings.ToUpper(base)] {
+		clean = "_" + clean
+	}
+
+	if len(clean) > maxFileNameLength {
+		ext := filepath.Ext(clean)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		hash := sha256.Sum256([]byte(name))
+		suffix := "_" + hex.EncodeToString(hash[:4]) + ext
+		prefix := clean[:maxFileNameLength-len(suffix)]
+		// Do not cut a multi-byte character in half.
+		for !utf8.ValidString(prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+		clean = prefix + suffix
+	}
+	return clean
+}
+
+// LocalPath returns the path in localFolder at which to store the file with
+// the given path relative to a folder on the device, with each element
+// sanitized. Renamed files are recorded, see RenamedFiles.
+func LocalPath(localFolder, remotePath string) string {
+	elements := []string{localFolder}
+	changed := false
+	for _, element := range strings.Split(remotePath, "/") {
+		if element == "" {
+			continue
+		}
+		clean := SanitizeFileName(element)
+		changed = changed || clean != element
+		elements = append(elements, clean)
+	}
+	localPath := filepath.Join(elements...)
+	if changed {
+		renamedMu.Lock()
+		renamed[localPath] = remotePath
+		renamedMu.Unlock()
+	}
+	return localPath
+}
+
+// RenamedFiles returns the files stored under a sanitized name, mapping
+// their local path to their original path.
+func RenamedFiles() map[string]string {
+	renamedMu.Lock()
+	defer renamedMu.Unlock()
+	files := make(map[string]string, len(renamed))
+	for localPath, remotePath := range renamed {
+		files[localPath] = remotePath
+	}
+	return files
+}
@@ -0,0 +1,142 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Identifiers shorter than this are not replaced, as they would match
+// unrelated text.
+const pseudonymMinLength = 4
+
+// Pseudonymizer replaces device identifiers with stable pseudonyms derived
+// from a secret salt, so that acquisitions of the same device can be
+// correlated without revealing the identifiers.
+type Pseudonymizer struct {
+	salt     []byte
+	values   map[string]string
+	replacer *strings.Replacer
+}
+
+func NewPseudonymizer(salt []byte) *Pseudonymizer {
+	return &Pseudonymizer{
+		salt:     salt,
+		values:   map[string]string{},
+		replacer: strings.NewReplacer(),
+	}
+}
+
+// Pseudonym returns the pseudonym of the value of the given kind (e.g.
+// "serial" or "imei").
+func (p *Pseudonymizer) Pseudonym(kind, value string) string {
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(kind + ":" + value))
+	return kind + "-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Add registers an identifier to be replaced in all outputs.
+func (p *Pseudonymizer) Add(kind, value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < pseudonymMinLength {
+		return
+	}
+	if _, ok := p.values[value]; ok {
+		return
+	}
+	p.values[value] = p.Pseudonym(kind, value)
+
+	pairs := make([]string, 0, len(p.values)*2)
+	for value, pseudonym := range p.values {
+		pairs = append(pairs, value, pseudonym)
+	}
+	p.replacer = strings.NewReplacer(pairs...)
+}
+
+// Count returns the number of identifiers registered.
+func (p *Pseudonymizer) Count() int {
+	return len(p.values)
+}
+
+// Replace replaces all the registered identifiers in s.
+func (p *Pseudonymizer) Replace(s string) string {
+	return p.replacer.Replace(s)
+}
+
+var pseudonymizer *Pseudonymizer
+
+// SetPseudonymizer enables the replacement of identifiers in text outputs.
+func SetPseudonymizer(p *Pseudonymizer) {
+	pseudonymizer = p
+}
+
+// Anonymize replaces the registered identifiers in s, if enabled.
+func Anonymize(s string) string {
+	if pseudonymizer == nil {
+		return s
+	}
+	return pseudonymizer.Replace(s)
+}
+
+// isTextOutput returns true for outputs in which identifiers are replaced.
+// Binary files such as APKs and archives are stored untouched.
+func isTextOutput(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt", ".json", ".jsonl", ".csv", ".log", ".sh", ".xml", ".prop", "":
+		return true
+	}
+	return false
+}
+
+// pseudonymWriter replaces identifiers in the text written to it, one line
+// at a time so that identifiers are never split across writes.
+type pseudonymWriter struct {
+	w       io.WriteCloser
+	p       *Pseudonymizer
+	pending []byte
+}
+
+func (w *pseudonymWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+
+	idx := bytes.LastIndexByte(w.pending, '\n')
+	if idx < 0 {
+		return len(data), nil
+	}
+
+	_, err := io.WriteString(w.w, w.p.Replace(string(w.pending[:idx+1])))
+	if err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[idx+1:]...)
+
+	return len(data), nil
+}
+
+func (w *pseudonymWriter) Close() error {
+	if len(w.pending) > 0 {
+		_, err := io.WriteString(w.w, w.p.Replace(string(w.pending)))
+		if err != nil {
+			w.w.Close()
+			return err
+		}
+		w.pending = nil
+	}
+	return w.w.Close()
+}
+
+func filterOutput(path string, w io.WriteCloser) io.WriteCloser {
+	if pseudonymizer == nil || !isTextOutput(path) {
+		return w
+	}
+	return &pseudonymWriter{w: w, p: pseudonymizer}
+}
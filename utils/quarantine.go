@@ -0,0 +1,157 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/rand"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// QuarantinePassword is the conventional password used to share malware
+// samples, which antivirus products do not try to open.
+const QuarantinePassword = "infected"
+
+var crcTable = crc32.MakeTable(crc32.IEEE)
+
+// zipCrypto implements the traditional PKWARE encryption, which is the only
+// one supported by all common archive tools.
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+func newZipCrypto(password string) *zipCrypto {
+	z := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	return z
+}
+
+func crcUpdate(crc uint32, b byte) uint32 {
+	return crcTable[byte(crc)^b] ^ (crc >> 8)
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.keys[0] = crcUpdate(z.keys[0], b)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crcUpdate(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func (z *zipCrypto) encrypt(b byte) byte {
+	temp := uint16(z.keys[2] | 2)
+	c := b ^ byte((temp*(temp^1))>>8)
+	z.update(b)
+	return c
+}
+
+type zipCryptoWriter struct {
+	w      io.Writer
+	crypto *zipCrypto
+	// The encryption header is only written with the first chunk of data,
+	// because the compressor is created before the local file header is.
+	header []byte
+}
+
+func (z *zipCryptoWriter) encrypt(p []byte) []byte {
+	buf := make([]byte, len(p))
+	for i, b := range p {
+		buf[i] = z.crypto.encrypt(b)
+	}
+	return buf
+}
+
+func (z *zipCryptoWriter) Write(p []byte) (int, error) {
+	if z.header != nil {
+		_, err := z.w.Write(z.encrypt(z.header))
+		if err != nil {
+			return 0, err
+		}
+		z.header = nil
+	}
+	return z.w.Write(z.encrypt(p))
+}
+
+// QuarantineZip is a password-protected zip archive used to store suspicious
+// files so that they are not deleted by antivirus products.
+type QuarantineZip struct {
+	file     *os.File
+	writer   *zip.Writer
+	password string
+}
+
+func NewQuarantineZip(path, password string) (*QuarantineZip, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuarantineZip{
+		file:     file,
+		writer:   zip.NewWriter(file),
+		password: password,
+	}, nil
+}
+
+// Add stores the file at srcPath in the archive with the given name.
+func (q *QuarantineZip) Add(name, srcPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+		// Bit 0 marks the entry as encrypted.
+		Flags: 0x1,
+	}
+	header.ModifiedDate, header.ModifiedTime = msDosTime(time.Now())
+
+	// As the archive uses data descriptors, the last byte of the encryption
+	// header is checked against the high byte of the modification time.
+	checkByte := byte(header.ModifiedTime >> 8)
+	password := q.password
+	q.writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		encHeader := make([]byte, 12)
+		_, err := rand.Read(encHeader[:11])
+		if err != nil {
+			return nil, err
+		}
+		encHeader[11] = checkByte
+		crypto := &zipCryptoWriter{w: out, crypto: newZipCrypto(password), header: encHeader}
+
+		return flate.NewWriter(crypto, flate.DefaultCompression)
+	})
+
+	w, err := q.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, src)
+	return err
+}
+
+func (q *QuarantineZip) Close() error {
+	err := q.writer.Close()
+	if err != nil {
+		q.file.Close()
+		return err
+	}
+	return q.file.Close()
+}
+
+func msDosTime(t time.Time) (uint16, uint16) {
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
@@ -0,0 +1,51 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseByteSize parses sizes such as "500K", "2M" or "1G" into bytes.
+func ParseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	value = strings.TrimRight(value, "KMG")
+
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+
+	return int64(size * float64(multiplier)), nil
+}
+
+// FormatByteSize returns a human-readable representation of a size in bytes.
+func FormatByteSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,358 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DEX files larger than this are extracted but their strings are not
+	// read, to bound the memory used.
+	maxDexSize = 64 * 1024 * 1024
+	// At most this many URLs and strings of interest are reported.
+	maxStaticStrings = 500
+)
+
+// DexFile is a DEX file found in an APK.
+type DexFile struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Version string `json:"version"`
+	Strings int    `json:"strings"`
+	Error   string `json:"error,omitempty"`
+}
+
+// InterestingString is a string of a DEX file which hints at a capability
+// commonly abused by spyware.
+type InterestingString struct {
+	Category string `json:"category"`
+	Value    string `json:"value"`
+	File     string `json:"file"`
+}
+
+// EmbeddedCertificate is a certificate shipped in the APK besides its
+// signature, e.g. to pin or intercept TLS connections.
+type EmbeddedCertificate struct {
+	File    string `json:"file"`
+	Subject string `json:"subject,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	SHA256  string `json:"sha256"`
+	Error   string `json:"error,omitempty"`
+}
+
+// StaticReport is a quick static feature report of an APK, to escalate a
+// flagged package with more evidence than its hash.
+type StaticReport struct {
+	APK                  string                `json:"apk"`
+	DexFiles             []DexFile             `json:"dex_files"`
+	URLs                 []string              `json:"urls"`
+	IPAddresses          []string              `json:"ip_addresses"`
+	InterestingStrings   []InterestingString   `json:"interesting_strings"`
+	EmbeddedCertificates []EmbeddedCertificate `json:"embedded_certificates"`
+	PackerIndicators     []string              `json:"packer_indicators"`
+	Truncated            bool                  `json:"truncated"`
+}
+
+var (
+	urlRegexp = regexp.MustCompile(`(?i)\b(?:https?|wss?|ftp)://[^\s"'<>\\]+`)
+	ipRegexp  = regexp.MustCompile(`^(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)(?::\d{1,5})?$`)
+)
+
+// interestingStrings maps substrings of DEX strings to the capability they
+// hint at.
+var interestingStrings = []struct {
+	substring string
+	category  string
+}{
+	{"Ldalvik/system/DexClassLoader;", "dynamic_code_loading"},
+	{"Ldalvik/system/InMemoryDexClassLoader;", "dynamic_code_loading"},
+	{"Ldalvik/system/PathClassLoader;", "dynamic_code_loading"},
+	{"Ljava/lang/Runtime;", "command_execution"},
+	{"Ljava/lang/ProcessBuilder;", "command_execution"},
+	{"/system/bin/su", "root"},
+	{"/system/xbin/su", "root"},
+	{"/sbin/su", "root"},
+	{"magisk", "root"},
+	{"busybox", "root"},
+	{"Landroid/accessibilityservice/AccessibilityService;", "accessibility"},
+	{"Landroid/app/admin/DeviceAdminReceiver;", "device_admin"},
+	{"Landroid/app/admin/DevicePolicyManager;", "device_admin"},
+	{"Landroid/telephony/SmsManager;", "sms"},
+	{"content://sms", "sms"},
+	{"content://call_log", "call_log"},
+	{"content://contacts", "contacts"},
+	{"Landroid/media/MediaRecorder;", "recording"},
+	{"Landroid/media/AudioRecord;", "recording"},
+	{"Landroid/media/projection/MediaProjection;", "screen_capture"},
+	{"Landroid/hardware/Camera;", "camera"},
+	{"Landroid/hardware/camera2/CameraDevice;", "camera"},
+	{"getLastKnownLocation", "location"},
+	{"requestLocationUpdates", "location"},
+	{"getDeviceId", "device_identifiers"},
+	{"getSubscriberId", "device_identifiers"},
+	{"getSimSerialNumber", "device_identifiers"},
+	{"Landroid/service/notification/NotificationListenerService;", "notifications"},
+	{"Landroid/content/ClipboardManager;", "clipboard"},
+	{"Ljavax/crypto/Cipher;", "cryptography"},
+	{"setComponentEnabledSetting", "hiding"},
+	{"/proc/self/maps", "anti_analysis"},
+	{"TracerPid", "anti_analysis"},
+	{"ro.kernel.qemu", "anti_analysis"},
+	{"goldfish", "anti_analysis"},
+	{"frida", "anti_analysis"},
+}
+
+// packerLibraries are native libraries shipped by commercial packers, which
+// encrypt the original DEX code.
+var packerLibraries = map[string]string{
+	"libjiagu.so":        "Qihoo 360 Jiagu",
+	"libjiagu_x86.so":    "Qihoo 360 Jiagu",
+	"libjiagu_a64.so":    "Qihoo 360 Jiagu",
+	"libsecexe.so":       "Bangcle",
+	"libsecmain.so":      "Bangcle",
+	"libDexHelper.so":    "SecNeo",
+	"libshella.so":       "Tencent Legu",
+	"libshellx.so":       "Tencent Legu",
+	"libexec.so":         "Ijiami",
+	"libexecmain.so":     "Ijiami",
+	"libbaiduprotect.so": "Baidu",
+	"libmobisec.so":      "Alibaba",
+	"libAPKProtect.so":   "APKProtect",
+	"libnqshield.so":     "NQ Shield",
+	"libkwscmm.so":       "Kiwisec",
+}
+
+// certificateExtensions are the extensions of certificate and key store
+// files.
+var certificateExtensions = map[string]bool{
+	".cer": true, ".crt": true, ".pem": true, ".der": true,
+	".p12": true, ".pfx": true, ".bks": true, ".jks": true, ".keystore": true,
+}
+
+// readUleb128 decodes an unsigned LEB128 value, returning the number of bytes
+// it took.
+func readUleb128(data []byte) (uint32, int) {
+	var value uint32
+	for i := 0; i < len(data) && i < 5; i++ {
+		value |= uint32(data[i]&0x7f) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// dexStrings returns the strings of the string table of a DEX file. They are
+// MUTF-8 encoded, which is the same as UTF-8 for the strings of interest.
+func dexStrings(data []byte) (string, []string, error) {
+	if len(data) < 0x70 || !bytes.HasPrefix(data, []byte("dex\n")) {
+		return "", nil, fmt.Errorf("not a DEX file")
+	}
+	version := strings.TrimRight(string(data[4:7]), "\x00")
+	size := binary.LittleEndian.Uint32(data[0x38:])
+	offset := binary.LittleEndian.Uint32(data[0x3c:])
+	if uint64(offset)+uint64(size)*4 > uint64(len(data)) {
+		return version, nil, fmt.Errorf("string table out of bounds")
+	}
+
+	values := make([]string, 0, size)
+	for i := uint32(0); i < size; i++ {
+		dataOffset := binary.LittleEndian.Uint32(data[offset+i*4:])
+		if int(dataOffset) >= len(data) {
+			continue
+		}
+		_, n := readUleb128(data[dataOffset:])
+		if n == 0 {
+			continue
+		}
+		start := int(dataOffset) + n
+		end := bytes.IndexByte(data[start:], 0)
+		if end < 0 {
+			continue
+		}
+		values = append(values, string(data[start:start+end]))
+	}
+	return version, values, nil
+}
+
+func (r *StaticReport) addString(file, value string, urls, ips, seen map[string]bool) {
+	for _, match := range urlRegexp.FindAllString(value, -1) {
+		if !urls[match] {
+			urls[match] = true
+			r.URLs = append(r.URLs, match)
+		}
+	}
+	if ipRegexp.MatchString(value) && !ips[value] {
+		ips[value] = true
+		r.IPAddresses = append(r.IPAddresses, value)
+	}
+	if strings.Contains(value, "-----BEGIN CERTIFICATE-----") {
+		r.addCertificate(file, []byte(value))
+	}
+	for _, s := range interestingStrings {
+		if !strings.Contains(value, s.substring) {
+			continue
+		}
+		key := s.category + "\x00" + value
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if len(r.InterestingStrings) >= maxStaticStrings {
+			r.Truncated = true
+			return
+		}
+		r.InterestingStrings = append(r.InterestingStrings, InterestingString{
+			Category: s.category,
+			Value:    value,
+			File:     file,
+		})
+	}
+}
+
+func (r *StaticReport) addCertificate(file string, data []byte) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	}
+	sum := sha256.Sum256(der)
+	embedded := EmbeddedCertificate{File: file, SHA256: hex.EncodeToString(sum[:])}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		// Key stores cannot be opened without their password.
+		embedded.Error = err.Error()
+	} else {
+		embedded.Subject = cert.Subject.String()
+		embedded.Issuer = cert.Issuer.String()
+	}
+	r.EmbeddedCertificates = append(r.EmbeddedCertificates, embedded)
+}
+
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, limit))
+}
+
+// AnalyzeAPK produces a static feature report of the APK at apkPath. The
+// DEX files it contains are copied with extract, if not nil, under their name
+// in the APK.
+func AnalyzeAPK(apkPath string, extract func(name string, data []byte) error) (*StaticReport, error) {
+	archive, err := zip.OpenReader(LongPath(apkPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", apkPath, err)
+	}
+	defer archive.Close()
+
+	report := &StaticReport{
+		APK:                  path.Base(apkPath),
+		DexFiles:             []DexFile{},
+		URLs:                 []string{},
+		IPAddresses:          []string{},
+		InterestingStrings:   []InterestingString{},
+		EmbeddedCertificates: []EmbeddedCertificate{},
+		PackerIndicators:     []string{},
+	}
+	urls := map[string]bool{}
+	ips := map[string]bool{}
+	seen := map[string]bool{}
+	packers := map[string]bool{}
+	var mainDexSize int64
+
+	for _, file := range archive.File {
+		name := file.Name
+		base := path.Base(name)
+		ext := strings.ToLower(path.Ext(name))
+
+		if packer, ok := packerLibraries[base]; ok && strings.HasPrefix(name, "lib/") && !packers[packer] {
+			packers[packer] = true
+			report.PackerIndicators = append(report.PackerIndicators,
+				fmt.Sprintf("native library %s of the %s packer", base, packer))
+		}
+		if strings.HasPrefix(name, "assets/") && (ext == ".dex" || ext == ".jar") {
+			report.PackerIndicators = append(report.PackerIndicators,
+				fmt.Sprintf("code shipped as an asset (%s), possibly loaded at runtime", name))
+		}
+		if certificateExtensions[ext] && !strings.HasPrefix(name, "META-INF/") {
+			data, err := readZipFile(file, 1024*1024)
+			if err != nil {
+				report.EmbeddedCertificates = append(report.EmbeddedCertificates,
+					EmbeddedCertificate{File: name, Error: err.Error()})
+			} else {
+				report.addCertificate(name, data)
+			}
+		}
+
+		if !strings.Contains(name, "/") && strings.HasPrefix(name, "classes") && ext == ".dex" {
+			dex := DexFile{Name: name, Size: int64(file.UncompressedSize64)}
+			if name == "classes.dex" {
+				mainDexSize = dex.Size
+			}
+			data, err := readZipFile(file, maxDexSize)
+			if err != nil {
+				dex.Error = err.Error()
+				report.DexFiles = append(report.DexFiles, dex)
+				continue
+			}
+			sum := sha256.Sum256(data)
+			dex.SHA256 = hex.EncodeToString(sum[:])
+			if extract != nil {
+				err = extract(name, data)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if dex.Size > maxDexSize {
+				dex.Error = "too large to read its strings"
+				report.DexFiles = append(report.DexFiles, dex)
+				continue
+			}
+
+			version, values, err := dexStrings(data)
+			dex.Version = version
+			dex.Strings = len(values)
+			if err != nil {
+				dex.Error = err.Error()
+			}
+			for _, value := range values {
+				report.addString(name, value, urls, ips, seen)
+			}
+			report.DexFiles = append(report.DexFiles, dex)
+		}
+	}
+
+	if mainDexSize == 0 {
+		report.PackerIndicators = append(report.PackerIndicators, "no classes.dex")
+	} else if mainDexSize < 64*1024 && len(report.DexFiles) == 1 && len(report.PackerIndicators) > 0 {
+		report.PackerIndicators = append(report.PackerIndicators,
+			fmt.Sprintf("classes.dex is only %d bytes, a stub loading the real code", mainDexSize))
+	}
+	if len(report.URLs) > maxStaticStrings {
+		report.URLs = report.URLs[:maxStaticStrings]
+		report.Truncated = true
+	}
+	sort.Strings(report.URLs)
+	sort.Strings(report.IPAddresses)
+
+	return report, nil
+}
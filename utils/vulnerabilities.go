@@ -0,0 +1,42 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package utils
+
+// Parts of the system patched by the different patch levels.
+const (
+	PatchPlatform = "platform"
+	PatchVendor   = "vendor"
+	PatchKernel   = "kernel"
+)
+
+type Vulnerability struct {
+	CVE       string `json:"cve"`
+	Bulletin  string `json:"bulletin"`
+	Component string `json:"component"`
+	// Part of the system which needs to be updated to fix it.
+	Patch string `json:"patch"`
+}
+
+// ExploitedVulnerabilities returns a list of Android vulnerabilities known to
+// have been exploited in the wild, along with the date of the Android
+// Security Bulletin patch level fixing them.
+func ExploitedVulnerabilities() []Vulnerability {
+	return []Vulnerability{
+		{CVE: "CVE-2019-2215", Bulletin: "2019-10-01", Component: "Kernel (binder)", Patch: PatchKernel},
+		{CVE: "CVE-2020-0041", Bulletin: "2020-03-01", Component: "Kernel (binder)", Patch: PatchKernel},
+		{CVE: "CVE-2021-0920", Bulletin: "2021-11-01", Component: "Kernel (unix sockets)", Patch: PatchKernel},
+		{CVE: "CVE-2021-1048", Bulletin: "2021-11-01", Component: "Kernel (epoll)", Patch: PatchKernel},
+		{CVE: "CVE-2023-35674", Bulletin: "2023-09-01", Component: "Framework", Patch: PatchPlatform},
+		{CVE: "CVE-2023-4863", Bulletin: "2023-10-01", Component: "System (libwebp)", Patch: PatchPlatform},
+		{CVE: "CVE-2023-4211", Bulletin: "2023-10-01", Component: "Arm Mali GPU", Patch: PatchVendor},
+		{CVE: "CVE-2024-36971", Bulletin: "2024-08-01", Component: "Kernel (network)", Patch: PatchKernel},
+		{CVE: "CVE-2024-43093", Bulletin: "2024-11-01", Component: "Framework", Patch: PatchPlatform},
+		{CVE: "CVE-2024-43047", Bulletin: "2024-11-01", Component: "Qualcomm DSP", Patch: PatchVendor},
+		{CVE: "CVE-2024-53104", Bulletin: "2025-02-01", Component: "Kernel (USB video)", Patch: PatchKernel},
+		{CVE: "CVE-2024-53150", Bulletin: "2025-04-01", Component: "Kernel (USB audio)", Patch: PatchKernel},
+		{CVE: "CVE-2024-53197", Bulletin: "2025-04-01", Component: "Kernel (USB audio)", Patch: PatchKernel},
+	}
+}
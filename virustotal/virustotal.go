@@ -0,0 +1,97 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package virustotal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const apiURL = "https://www.virustotal.com/api/v3/files/"
+
+// ErrQuotaExceeded is returned when the API key ran out of requests.
+var ErrQuotaExceeded = errors.New("VirusTotal quota exceeded")
+
+// Stats counts the verdicts of the antivirus engines for a file.
+type Stats struct {
+	Malicious  int `json:"malicious"`
+	Suspicious int `json:"suspicious"`
+	Undetected int `json:"undetected"`
+	Harmless   int `json:"harmless"`
+}
+
+// FileReport is the last analysis of a file by VirusTotal.
+type FileReport struct {
+	SHA256 string `json:"sha256"`
+	Stats  Stats  `json:"last_analysis_stats"`
+}
+
+// Client looks up file hashes through the VirusTotal API, waiting between
+// requests to stay within the rate limit of the API key.
+type Client struct {
+	APIKey   string
+	interval time.Duration
+	last     time.Time
+	http     *http.Client
+}
+
+// New returns a client performing at most requestsPerMinute lookups per
+// minute. The public API allows 4.
+func New(apiKey string, requestsPerMinute int) *Client {
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = 4
+	}
+	return &Client{
+		APIKey:   apiKey,
+		interval: time.Minute / time.Duration(requestsPerMinute),
+		http:     &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// FileReport returns the report of the file with the given hash, or nil if
+// VirusTotal has never seen it.
+func (c *Client) FileReport(sha256 string) (*FileReport, error) {
+	if wait := c.interval - time.Since(c.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	c.last = time.Now()
+
+	req, err := http.NewRequest(http.MethodGet, apiURL+sha256, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-apikey", c.APIKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to VirusTotal: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusTooManyRequests:
+		return nil, ErrQuotaExceeded
+	default:
+		return nil, fmt.Errorf("VirusTotal lookup failed: unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		Data struct {
+			Attributes FileReport `json:"attributes"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VirusTotal response: %v", err)
+	}
+	return &result.Data.Attributes, nil
+}
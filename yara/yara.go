@@ -0,0 +1,58 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+package yara
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type MatchString struct {
+	Name   string `json:"name"`
+	Offset uint64 `json:"offset"`
+}
+
+type Match struct {
+	Rule      string        `json:"rule"`
+	Namespace string        `json:"namespace"`
+	Tags      []string      `json:"tags"`
+	File      string        `json:"file"`
+	Strings   []MatchString `json:"strings"`
+}
+
+// ruleFiles expands the configured rule locations, which can be either
+// single rule files or folders containing .yar/.yara files.
+func ruleFiles(locations []string) ([]string, error) {
+	files := []string{}
+	for _, location := range locations {
+		stat, err := os.Stat(location)
+		if err != nil {
+			return nil, err
+		}
+
+		if !stat.IsDir() {
+			files = append(files, location)
+			continue
+		}
+
+		err = filepath.Walk(location, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			if !info.IsDir() && (ext == ".yar" || ext == ".yara") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
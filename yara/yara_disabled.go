@@ -0,0 +1,20 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+//go:build !yara
+
+package yara
+
+import "errors"
+
+// Enabled reports whether androidqf was built with YARA support.
+func Enabled() bool {
+	return false
+}
+
+// ScanFolder is not available unless androidqf is built with the yara tag.
+func ScanFolder(locations []string, folder string) ([]Match, error) {
+	return []Match{}, errors.New("androidqf was built without YARA support")
+}
@@ -0,0 +1,101 @@
+// androidqf - Android Quick Forensics
+// Copyright (c) 2021-2023 Claudio Guarnieri.
+// Use of this software is governed by the MVT License 1.1 that can be found at
+//   https://license.mvt.re/1.1/
+
+//go:build yara
+
+package yara
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	goyara "github.com/hillu/go-yara/v4"
+	"github.com/mvt-project/androidqf/log"
+)
+
+const scanTimeout = 60 * time.Second
+
+// Enabled reports whether androidqf was built with YARA support.
+func Enabled() bool {
+	return true
+}
+
+func compile(locations []string) (*goyara.Rules, error) {
+	files, err := ruleFiles(locations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find YARA rules: %v", err)
+	}
+
+	compiler, err := goyara.NewCompiler()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		namespace := filepath.Base(file)
+		err = compiler.AddFile(f, namespace)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile YARA rules %s: %v", file, err)
+		}
+	}
+
+	return compiler.GetRules()
+}
+
+// ScanFolder scans all files in the folder with the rules found at the given
+// locations. File paths in the matches are relative to the folder.
+func ScanFolder(locations []string, folder string) ([]Match, error) {
+	matches := []Match{}
+
+	rules, err := compile(locations)
+	if err != nil {
+		return matches, err
+	}
+	defer rules.Destroy()
+
+	err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		var results goyara.MatchRules
+		err = rules.ScanFile(path, 0, scanTimeout, &results)
+		if err != nil {
+			log.Debugf("Failed to scan %s with YARA: %v", path, err)
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(folder, path)
+		for _, result := range results {
+			match := Match{
+				Rule:      result.Rule,
+				Namespace: result.Namespace,
+				Tags:      result.Tags,
+				File:      filepath.ToSlash(relPath),
+				Strings:   []MatchString{},
+			}
+			for _, s := range result.Strings {
+				match.Strings = append(match.Strings, MatchString{
+					Name:   s.Name,
+					Offset: s.Base + s.Offset,
+				})
+			}
+			matches = append(matches, match)
+		}
+		return nil
+	})
+
+	return matches, err
+}